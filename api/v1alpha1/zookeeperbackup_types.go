@@ -0,0 +1,135 @@
+/*
+Copyright 2024 zncdatadev.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	s3v1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/s3/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	DefaultBackupMaxBackups = 7
+
+	// BackupConditionSucceeded reports whether the last finished backup succeeded
+	BackupConditionSucceeded = "Succeeded"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=zookeeperbackups,scope=Namespaced,shortName=zkbackup;zkbackups,singular=zookeeperbackup
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Cluster",type="string",JSONPath=".spec.clusterName"
+// +kubebuilder:printcolumn:name="Schedule",type="string",JSONPath=".spec.schedule"
+// +kubebuilder:printcolumn:name="Last Backup",type="date",JSONPath=".status.lastBackup.time"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ZookeeperBackup backs up the data of a ZookeeperCluster into an S3 bucket, once or on a schedule.
+//
+// A backup runs as a Job next to a server, i.e. on the same node, and mounts the data volume of the server read only.
+// It archives the latest snapshot and the transaction logs from that snapshot on into `<prefix><time>-<zxid>.tar.gz`,
+// uploads the archive and deletes the backups beyond the retention rules under the prefix.
+type ZookeeperBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ZookeeperBackupSpec   `json:"spec,omitempty"`
+	Status ZookeeperBackupStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ZookeeperBackupList contains a list of ZookeeperBackup
+type ZookeeperBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ZookeeperBackup `json:"items"`
+}
+
+type ZookeeperBackupSpec struct {
+	// ClusterName is the ZookeeperCluster to back up. It has to be in the namespace of the backup.
+	// +kubebuilder:validation:Required
+	ClusterName string `json:"clusterName"`
+
+	// Member is the server pod whose data is backed up. Defaults to the first server of the first role group,
+	// `<cluster name>-server-<role group>-0`.
+	// +kubebuilder:validation:Optional
+	Member string `json:"member,omitempty"`
+
+	// Schedule of the backups in cron format, e.g. `0 3 * * *`. A single backup is taken if empty.
+	// +kubebuilder:validation:Optional
+	Schedule string `json:"schedule,omitempty"`
+
+	// Bucket the backups are uploaded to. The connection either references an S3Connection in the namespace
+	// of the backup or is given inline; its credentials SecretClass has to provide `ACCESS_KEY` and `SECRET_KEY`.
+	// +kubebuilder:validation:Required
+	Bucket *s3v1alpha1.S3BucketSpec `json:"bucket"`
+
+	// Prefix of the object keys of the backups. Defaults to `<namespace>/<cluster name>/`.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9._/-]*$`
+	Prefix string `json:"prefix,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Retention *BackupRetentionSpec `json:"retention,omitempty"`
+}
+
+// BackupRetentionSpec selects the backups that are kept under the prefix. It is applied after every backup,
+// and the newest backup is always kept.
+type BackupRetentionSpec struct {
+	// MaxBackups is the number of backups to keep. Defaults to 7.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=7
+	MaxBackups int32 `json:"maxBackups,omitempty"`
+
+	// MaxAge deletes backups older than the duration, e.g. `720h`.
+	// +kubebuilder:validation:Optional
+	MaxAge string `json:"maxAge,omitempty"`
+}
+
+type ZookeeperBackupStatus struct {
+	// +kubebuilder:validation:Optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// LastBackup is the newest successful backup.
+	// +kubebuilder:validation:Optional
+	LastBackup *BackupRecord `json:"lastBackup,omitempty"`
+
+	// Backups are the successful backups, newest first, limited to `retention.maxBackups`.
+	// +kubebuilder:validation:Optional
+	Backups []BackupRecord `json:"backups,omitempty"`
+}
+
+// BackupRecord describes a backup archive in the bucket.
+type BackupRecord struct {
+	// Job that took the backup.
+	Job string `json:"job"`
+	// Member is the server pod whose data was backed up.
+	Member string `json:"member"`
+	// Bucket and Key locate the archive.
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	// Zxid of the snapshot in the archive, as hex. The transaction logs in the archive continue from it.
+	Zxid string `json:"zxid"`
+	// Size of the archive in bytes.
+	Size int64 `json:"size"`
+	// Time the backup was taken.
+	Time metav1.Time `json:"time"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ZookeeperBackup{}, &ZookeeperBackupList{})
+}
@@ -17,8 +17,15 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"time"
+
 	commonsv1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/commons/v1alpha1"
+	s3v1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/s3/v1alpha1"
 	"github.com/zncdatadev/operator-go/pkg/constants"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -35,11 +42,13 @@ const (
 )
 
 const (
-	ClientPortName     = "client"
-	SecurityClientName = "secureClient"
-	LeaderPortName     = "leader"
-	ElectionPortName   = "election"
-	MetricsPortName    = "metrics"
+	ClientPortName       = "client"
+	SecurityClientName   = "secureClient"
+	SecureClientPortName = "secure-client"
+	LeaderPortName       = "leader"
+	ElectionPortName     = "election"
+	MetricsPortName      = "metrics"
+	AdminPortName        = "admin"
 
 	ClientPort       = 2181
 	SecureClientPort = 2282
@@ -47,6 +56,23 @@ const (
 	ElectionPort     = 3888
 	MetricsPort      = 9505
 
+	DefaultCertificateRestartBuffer = time.Hour
+
+	// ConditionRestored reports whether every server was restored from the same backup, see RestoreSpec
+	ConditionRestored = "Restored"
+	// ConditionDiskPressure reports whether the data of a server nears the capacity of its volumes
+	ConditionDiskPressure = "DiskPressure"
+	// ConditionResizingVolumes reports whether the storage provider is expanding the volumes of the servers after
+	// their capacity was raised
+	ConditionResizingVolumes = "ResizingVolumes"
+	// ConditionUpgrading reports whether the servers are being upgraded to another ZooKeeper version
+	ConditionUpgrading = "Upgrading"
+	// ConditionMigrating reports the progress of adopting an external ensemble, see MigrationSpec
+	ConditionMigrating = "Migrating"
+	// ConditionQuorumHealthy reports whether the servers agree on one leader with every follower in sync, as last
+	// checked by the health monitor of the operator
+	ConditionQuorumHealthy = "QuorumHealthy"
+
 	AdminPort                 = 8080
 	NativeMetricsProviderPort = 7000
 )
@@ -54,6 +80,7 @@ const (
 // volume name
 const (
 	DataDirName      = "data"
+	DataLogDirName   = "txnlog"
 	LogDirName       = "log"
 	ConfigDirName    = "config"
 	LogConfigDirName = "log-config"
@@ -64,6 +91,7 @@ type ListenerClass string
 const (
 	ClusterInternal  ListenerClass = "cluster-internal"
 	ExternalUnstable ListenerClass = "external-unstable"
+	ExternalStable   ListenerClass = "external-stable"
 )
 
 // +kubebuilder:object:root=true
@@ -85,6 +113,31 @@ type ZookeeperClusterStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 	// +kubebuilder:validation:Optional
 	ClientConnections map[string]string `json:"clientConnections"`
+	// QuorumSaslStage is the quorum SASL stage that is rolled out to all servers.
+	// +kubebuilder:validation:Optional
+	QuorumSaslStage QuorumSaslStage `json:"quorumSaslStage,omitempty"`
+	// QuorumSaslKerberosSecretClass is the Kerberos SecretClass the servers authenticate each other with while
+	// quorum SASL is on, empty for digest.
+	// +kubebuilder:validation:Optional
+	QuorumSaslKerberosSecretClass string `json:"quorumSaslKerberosSecretClass,omitempty"`
+	// ClientTlsPhase is the client TLS phase that is rolled out to all servers.
+	// +kubebuilder:validation:Optional
+	ClientTlsPhase ClientTlsPhase `json:"clientTlsPhase,omitempty"`
+	// NextCertificateRotation is when the next server is restarted because its certificates expire.
+	// +kubebuilder:validation:Optional
+	NextCertificateRotation *metav1.Time `json:"nextCertificateRotation,omitempty"`
+	// CurrentVersion is the ZooKeeper version all servers run.
+	// +kubebuilder:validation:Optional
+	CurrentVersion string `json:"currentVersion,omitempty"`
+	// TargetVersion is the ZooKeeper version the servers are being upgraded to, empty when no upgrade is in progress.
+	// +kubebuilder:validation:Optional
+	TargetVersion string `json:"targetVersion,omitempty"`
+	// Migration is the progress of adopting an external ensemble, see MigrationSpec.
+	// +kubebuilder:validation:Optional
+	Migration *MigrationStatus `json:"migration,omitempty"`
+	// Restore is the archive the servers are restored from, see RestoreSpec.
+	// +kubebuilder:validation:Optional
+	Restore *RestoreStatus `json:"restore,omitempty"`
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 }
@@ -110,20 +163,73 @@ type ZookeeperClusterSpec struct {
 	ClusterConfig *ClusterConfigSpec `json:"clusterConfig,omitempty"`
 	// +kubebuilder:validation:Required
 	Servers *ServerSpec `json:"servers"`
+	// RestoreFrom fills the empty data volumes of the servers with a backup before ZooKeeper first starts on them.
+	// Volumes that already hold data are left untouched, so setting it on a running cluster has no effect.
+	// The archive is resolved once and recorded in status.restore. Once every server restored it, see the Restored
+	// condition, the servers are restarted once without the restore and the ZookeeperBackup is no longer needed.
+	// +kubebuilder:validation:Optional
+	RestoreFrom *RestoreSpec `json:"restoreFrom,omitempty"`
+}
+
+// RestoreSpec locates a backup archive written by a ZookeeperBackup, either through the backup or directly in a bucket.
+// +kubebuilder:validation:XValidation:rule="has(self.backup) != has(self.bucket)",message="exactly one of backup and bucket is required"
+// +kubebuilder:validation:XValidation:rule="!has(self.bucket) || has(self.key)",message="key is required with bucket"
+type RestoreSpec struct {
+	// Backup is a ZookeeperBackup in the namespace of the cluster. Its last backup is restored unless a key is given,
+	// later backups are not restored.
+	// +kubebuilder:validation:Optional
+	Backup string `json:"backup,omitempty"`
+
+	// Bucket holding the archive, for backups whose ZookeeperBackup is gone, e.g. in another Kubernetes cluster.
+	// +kubebuilder:validation:Optional
+	Bucket *s3v1alpha1.S3BucketSpec `json:"bucket,omitempty"`
+
+	// Key of the archive, `<prefix><time>-<zxid>.tar.gz`.
+	// +kubebuilder:validation:Optional
+	Key string `json:"key,omitempty"`
 }
 
 type ClusterConfigSpec struct {
 	// Which type of service to use for the Zookeeper cluster.
 	//  - cluster-internal: use ClusterIP service
 	//  - external-unstable: use NodePort service
+	//  - external-stable: use one LoadBalancer or NodePort service per server, see `externalStable`, the cluster service stays ClusterIP
 	// +kubebuilder:validation:optional
-	// +kubebuilder:validation:Enum="cluster-internal";"external-unstable"
+	// +kubebuilder:validation:Enum="cluster-internal";"external-unstable";"external-stable"
 	// +kubebuilder:default="cluster-internal"
 	ListenerClass constants.ListenerClass `json:"listenerClass"`
 
+	// ExternalStable configures the per server Services of the `external-stable` listener class.
 	// +kubebuilder:validation:Optional
-	// +kubebuilder:default:=1
-	MinServerId int32 `json:"minServerId,omitempty"`
+	ExternalStable *ExternalStableListenerSpec `json:"externalStable,omitempty"`
+
+	// NodeAddress selects the node address published in discovery for servers exposed through NodePort services,
+	// i.e. the `external-unstable` listener class and `external-stable` with NodePort services.
+	// +kubebuilder:validation:Optional
+	NodeAddress *NodeAddressSpec `json:"nodeAddress,omitempty"`
+
+	// StaleDataPolicy decides what a server does with data that belongs to another ensemble member, e.g. a volume
+	// retained from a deleted cluster of the same name or a role group that was removed and added again with other ids.
+	// Servers record their cluster and id on the data volume to recognize it.
+	//  - Refuse: the server does not start until the volume is cleaned up
+	//  - Wipe: the data is deleted and the server syncs from the ensemble
+	//  - Adopt: data of the same id, e.g. of a deleted cluster of the same name, is kept and recorded as the data of
+	//    this cluster; data of another id is refused
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=Refuse
+	StaleDataPolicy StaleDataPolicy `json:"staleDataPolicy,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Upgrade *UpgradeSpec `json:"upgrade,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Migration *MigrationSpec `json:"migration,omitempty"`
+
+	// ClusterDomain is the DNS domain of the Kubernetes cluster, used for the server addresses in `zoo.cfg` and discovery.
+	// Defaults to the domain of the operator, which is detected from `/etc/resolv.conf` unless set with
+	// the `--cluster-domain` flag or the `KUBERNETES_CLUSTER_DOMAIN` environment variable.
+	// +kubebuilder:validation:Optional
+	ClusterDomain string `json:"clusterDomain,omitempty"`
 
 	// +kubebuilder:validation:Optional
 	// +default:value=[]
@@ -133,6 +239,24 @@ type ClusterConfigSpec struct {
 	// +default:value={"quorumSecretClass": "tls", "serverSecretClass": "tls"}
 	Tls *ZookeeperTls `json:"tls,omitempty"`
 
+	// +kubebuilder:validation:Optional
+	QuorumAuthentication *QuorumAuthenticationSpec `json:"quorumAuthentication,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Admin *AdminSpec `json:"admin,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	NetworkPolicy *NetworkPolicySpec `json:"networkPolicy,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Discovery *DiscoverySpec `json:"discovery,omitempty"`
+
+	// DiscoveryProfiles are additional discovery ConfigMaps in product specific formats,
+	// rendered next to the generic discovery ConfigMap of the cluster, see DiscoveryProfile.
+	// +kubebuilder:validation:Optional
+	// +listType=set
+	DiscoveryProfiles []DiscoveryProfile `json:"discoveryProfiles,omitempty"`
+
 	// Name of the Vector aggregator [discovery ConfigMap].
 	// It must contain the key `ADDRESS` with the address of the Vector aggregator.
 	// Follow the [logging tutorial](DOCS_BASE_URL_PLACEHOLDER/tutorials/logging-vector-aggregator)
@@ -144,10 +268,13 @@ type ClusterConfigSpec struct {
 
 type AuthenticationSpec struct {
 	// Only affects client connections. This setting controls:
-	// - If clients need to authenticate themselves against the server via TLS
+	// - If clients need to authenticate themselves against the server via TLS, Kerberos or digest (static) credentials
 	// - Which ca.crt to use when validating the provided client certs
 	//
-	// This will override the server TLS settings (if set) in `spec.clusterConfig.tls.serverSecretClass`.
+	// Several classes can be listed at once, e.g. during a migration. The CAs of all TLS classes are merged into
+	// one truststore, and clients may use any of the listed mechanisms. At most one Kerberos class is supported.
+	//
+	// A TLS class will override the server TLS settings (if set) in `spec.clusterConfig.tls.serverSecretClass`.
 	// +kubebuilder:validation:Required
 	AuthenticationClass string `json:"authenticationClass"`
 }
@@ -171,8 +298,491 @@ type ZookeeperTls struct {
 	// Defaults to `tls`.
 	// +kubebuilder:validation:Optional
 	ServerSecretClass string `json:"serverSecretClass,omitempty"`
+
+	// ClientPortMode controls which client ports listen once client TLS is enabled:
+	//  - Transition: the plaintext port 2181 keeps listening next to the TLS port 2282, and discovery publishes both,
+	//    so clients can move to TLS one by one
+	//  - Tls: only the TLS port 2282 listens, the plaintext port 2181 only on the loopback interface of the servers
+	//    for their probes. The operator connects over TLS, with the CA and client certificate it is mounted with,
+	//    see the `clientTls` values of its Helm chart
+	// To migrate a running cluster without downtime, enable TLS in Transition mode first,
+	// move all clients to the TLS entries of the discovery ConfigMap, then switch to Tls. The TLS entries
+	// (`ZOOKEEPER_SECURE*`) stay published in Tls mode.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Transition;Tls
+	// +kubebuilder:default=Tls
+	ClientPortMode ClientPortMode `json:"clientPortMode,omitempty"`
+
+	// CertificateRestartBuffer is how long before the certificates and keytabs mounted by the secret-operator expire
+	// a server is restarted to pick up new ones. Servers are restarted one at a time, and only while all others are ready,
+	// the leader after the other servers that are due.
+	// Defaults to `1h`.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="1h"
+	CertificateRestartBuffer string `json:"certificateRestartBuffer,omitempty"`
+
+	// Preset selects the enabled protocols and cipher suites:
+	//  - Default: the defaults of the JVM
+	//  - Modern: TLSv1.3 only
+	//  - Fips: TLSv1.3 and TLSv1.2 with FIPS 140 approved AES-GCM cipher suites only, and ZooKeeper FIPS mode
+	// Protocols and CipherSuites take precedence over the preset.
+	// The settings apply to client, quorum and AdminServer TLS alike.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Default;Modern;Fips
+	// +kubebuilder:default=Default
+	Preset TlsPreset `json:"preset,omitempty"`
+
+	// Protocols are the enabled TLS protocols, e.g. `TLSv1.3`, `TLSv1.2`.
+	// +kubebuilder:validation:Optional
+	Protocols []string `json:"protocols,omitempty"`
+
+	// CipherSuites are the enabled cipher suites, in the JSSE naming, e.g. `TLS_AES_256_GCM_SHA384`.
+	// +kubebuilder:validation:Optional
+	CipherSuites []string `json:"cipherSuites,omitempty"`
+
+	// ClientAuth controls whether clients must present a certificate on the client port:
+	//  - None: no certificate is requested
+	//  - Want: a certificate is requested, but not required
+	//  - Need: a certificate is required
+	// If unset, it is derived from the authentication classes. A TLS authentication class cannot be combined with None,
+	// and a Need conflicts with other authentication mechanisms.
+	// Servers always authenticate each other with certificates on the quorum ports.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=None;Want;Need
+	ClientAuth TlsClientAuth `json:"clientAuth,omitempty"`
+
+	// HostnameVerification controls whether the peer certificate must match the host name,
+	// for client, quorum and AdminServer TLS. Defaults to `true`.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=true
+	HostnameVerification *bool `json:"hostnameVerification,omitempty"`
+}
+
+type TlsPreset string
+
+const (
+	TlsPresetDefault TlsPreset = "Default"
+	TlsPresetModern  TlsPreset = "Modern"
+	TlsPresetFips    TlsPreset = "Fips"
+)
+
+type TlsClientAuth string
+
+const (
+	TlsClientAuthNone TlsClientAuth = "None"
+	TlsClientAuthWant TlsClientAuth = "Want"
+	TlsClientAuthNeed TlsClientAuth = "Need"
+)
+
+type ClientPortMode string
+
+const (
+	ClientPortModeTransition ClientPortMode = "Transition"
+	ClientPortModeTls        ClientPortMode = "Tls"
+)
+
+// ClientTlsPhase is the client TLS phase all servers are running with.
+type ClientTlsPhase string
+
+const (
+	// ClientTlsPhasePlaintext: clients connect to the plaintext port only
+	ClientTlsPhasePlaintext ClientTlsPhase = "Plaintext"
+	// ClientTlsPhaseTransition: the plaintext and the TLS port both listen
+	ClientTlsPhaseTransition ClientTlsPhase = "Transition"
+	// ClientTlsPhaseTls: clients connect to the TLS port only
+	ClientTlsPhaseTls ClientTlsPhase = "Tls"
+)
+
+type QuorumSaslStage string
+
+const (
+	QuorumSaslDisabled        QuorumSaslStage = "Disabled"
+	QuorumSaslEnabled         QuorumSaslStage = "Enabled"
+	QuorumSaslLearnerRequired QuorumSaslStage = "LearnerRequired"
+	QuorumSaslRequired        QuorumSaslStage = "Required"
+)
+
+// QuorumAuthenticationSpec defines SASL authentication between ZooKeeper servers,
+// in addition to the quorum TLS configured in `spec.clusterConfig.tls.quorumSecretClass`.
+type QuorumAuthenticationSpec struct {
+	// KerberosSecretClass is the secret class that provides the keytab of the `zookeeper/<pod fqdn>` principal.
+	// If empty, digest credentials generated by the operator are used.
+	// Servers of different mechanisms can not form a quorum, so switching between Kerberos and digest, or to another
+	// SecretClass, is refused until the Disabled stage is rolled out.
+	// +kubebuilder:validation:Optional
+	KerberosSecretClass string `json:"kerberosSecretClass,omitempty"`
+
+	// Stage controls how strictly quorum SASL is enforced:
+	//  - Disabled: quorum SASL is off
+	//  - Enabled: servers authenticate, but neither learners nor servers require it (`quorum.auth.enableSasl`)
+	//  - LearnerRequired: additionally sets `quorum.auth.learnerRequireSasl`
+	//  - Required: additionally sets `quorum.auth.serverRequireSasl`
+	// On a running ensemble the operator moves through the stages one at a time and waits for every
+	// server to be rolled before it takes the next step, so quorum SASL can be turned on or off without downtime.
+	// Removing this section turns it off the same way, with the mechanism the servers authenticate with.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Disabled;Enabled;LearnerRequired;Required
+	// +kubebuilder:default=Required
+	Stage QuorumSaslStage `json:"stage,omitempty"`
+}
+
+// AdminSpec configures the AdminServer and the four letter word commands of the client port.
+type AdminSpec struct {
+	// Enabled controls whether the AdminServer is started on port 8080. Defaults to `true`.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=true
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// Tls serves the AdminServer over HTTPS only. It requires `spec.clusterConfig.tls.quorumSecretClass`:
+	// ZooKeeper has no keystore setting for the AdminServer and always loads the quorum keystore, so the
+	// certificate is issued by the quorum SecretClass, not `serverSecretClass`, and clients of the AdminServer have
+	// to trust its CA. The TLS settings of `spec.clusterConfig.tls` apply.
+	// +kubebuilder:validation:Optional
+	Tls bool `json:"tls,omitempty"`
+
+	// Service exposes the AdminServer through a Service named `<cluster name>-admin`.
+	// +kubebuilder:validation:Optional
+	Service *AdminServiceSpec `json:"service,omitempty"`
+
+	// FourLetterWords are the four letter word commands allowed on the client port.
+	// `srvr`, `mntr` and `ruok` are always allowed, since the readiness probe and the operator rely on them.
+	// Defaults to `srvr`, `mntr`, `conf` and `ruok`.
+	// +kubebuilder:validation:Optional
+	// +listType=set
+	FourLetterWords []FourLetterWord `json:"fourLetterWords,omitempty"`
+}
+
+type AdminServiceSpec struct {
+	// +kubebuilder:validation:Optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Which type of service to use for the AdminServer.
+	//  - cluster-internal: use ClusterIP service
+	//  - external-unstable: use NodePort service
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum="cluster-internal";"external-unstable"
+	// +kubebuilder:default="cluster-internal"
+	ListenerClass constants.ListenerClass `json:"listenerClass,omitempty"`
+}
+
+// AutopurgeSpec configures the purge of old snapshots and transaction logs, `autopurge.*` in `zoo.cfg`.
+type AutopurgeSpec struct {
+	// SnapRetainCount is the number of recent snapshots to keep, with the transaction logs they need.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=3
+	// +kubebuilder:default=3
+	SnapRetainCount int32 `json:"snapRetainCount,omitempty"`
+
+	// PurgeInterval is the number of hours between purges, 0 disables the purge.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=1
+	PurgeInterval *int32 `json:"purgeInterval,omitempty"`
+}
+
+// SnapshotCompression is the compression method of snapshots, see ConfigSpec.SnapshotCompression.
+type SnapshotCompression string
+
+const (
+	SnapshotCompressionNone   SnapshotCompression = "none"
+	SnapshotCompressionGzip   SnapshotCompression = "gz"
+	SnapshotCompressionSnappy SnapshotCompression = "snappy"
+)
+
+// UpgradeSpec configures the upgrades of the servers to another ZooKeeper version, i.e. changes of `image.productVersion`.
+//
+// Upgrades are rolled out one role group at a time, and one server at a time within a role group, from the highest
+// ordinal down. Each server is only upgraded once all servers are ready and the quorum has a leader with all followers
+// in sync. Downgrades and upgrades ZooKeeper does not support
+// in a rolling restart, e.g. skipping a minor version, are refused and the servers keep their version.
+type UpgradeSpec struct {
+	// Backup names a ZookeeperBackup of the cluster. Before an upgrade starts, a single backup is taken
+	// with its bucket, prefix and member, as ZookeeperBackup `<cluster name>-upgrade-<version>`.
+	// +kubebuilder:validation:Optional
+	Backup string `json:"backup,omitempty"`
 }
 
+// MigrationSpec adopts an existing ensemble that runs outside of the operator, by joining the servers of the cluster
+// to it and retiring the external servers afterwards, without downtime.
+//
+// The external servers are static peers in `zoo.cfg` of the servers of the cluster, and the external servers need
+// the servers of the cluster in their `zoo.cfg` as well, see MigrationStatus.ServerConfig. The migration goes through
+// these steps, each one rolled out by restarting the servers of the cluster one at a time:
+//  1. Observe: the servers join as observers, they sync the data but do not vote
+//  2. Participate: the servers become participants once every one of them serves as observer
+//  3. the external servers are retired one at a time with `retire`, each once the quorum is healthy
+//
+// The promotion and every retirement wait, with the Migrating condition reason WaitingForExternalServers, until
+// every remaining external server was restarted with MigrationStatus.ServerConfig and reports it with `conf`, so
+// that the external servers and the servers of the cluster never count votes of different memberships. Until the
+// servers are promoted, the external servers then need a majority of the new membership to elect a leader, so join
+// fewer servers than there are external servers and scale the cluster up once the migration is done.
+//
+// The quorum is healthy when every server answers `mntr` in plaintext, the external servers on their client port,
+// and they agree on one leader with all followers in sync. No server is retired while a server does not answer.
+// The external servers have to allow `mntr` and `conf` in `4lw.commands.whitelist`.
+//
+// The cluster needs a single role group whose ids, see ConfigSpec.MyidOffset, differ from the ids of the external servers.
+// The network policy only admits the servers of the cluster to the quorum ports, so it has to stay disabled until
+// the external servers are retired. Remove the migration once every external server is retired.
+type MigrationSpec struct {
+	// Phase is the requested step of the migration. Going back to Observe is refused once a server is retired.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Observe;Participate
+	// +kubebuilder:default=Observe
+	Phase MigrationPhase `json:"phase,omitempty"`
+
+	// ExternalServers are the members of the external ensemble.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+	// +listType=map
+	// +listMapKey=id
+	ExternalServers []ExternalServerSpec `json:"externalServers"`
+}
+
+type MigrationPhase string
+
+const (
+	MigrationObserve     MigrationPhase = "Observe"
+	MigrationParticipate MigrationPhase = "Participate"
+)
+
+// ExternalServerSpec is a member of an external ensemble, see MigrationSpec.
+type ExternalServerSpec struct {
+	// ID is the `myid` of the server.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	ID int32 `json:"id"`
+
+	// Host is the address the servers of the cluster reach the server at.
+	// +kubebuilder:validation:Required
+	Host string `json:"host"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=2888
+	QuorumPort int32 `json:"quorumPort,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=3888
+	LeaderElectionPort int32 `json:"leaderElectionPort,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=2181
+	ClientPort int32 `json:"clientPort,omitempty"`
+
+	// Retire removes the server from the ensemble. Drop it from `zoo.cfg` of the other external servers as shown by
+	// MigrationStatus.ServerConfig, and stop it once it is listed in MigrationStatus.RetiredServers and the Migrating
+	// condition no longer reports it retiring.
+	// +kubebuilder:validation:Optional
+	Retire bool `json:"retire,omitempty"`
+}
+
+// RestoreStatus is the archive the servers are restored from, resolved from RestoreSpec when the cluster is created.
+type RestoreStatus struct {
+	// Key of the archive.
+	Key string `json:"key"`
+	// Zxid of the snapshot in the archive, as hex.
+	Zxid string `json:"zxid"`
+}
+
+// MigrationStatus is the step of the migration the servers of the cluster were rolled out with.
+type MigrationStatus struct {
+	// Phase is the step the servers are configured for.
+	// +kubebuilder:validation:Optional
+	Phase MigrationPhase `json:"phase,omitempty"`
+
+	// RetiredServers are the ids of the external servers that were removed from the ensemble.
+	// +kubebuilder:validation:Optional
+	RetiredServers []int32 `json:"retiredServers,omitempty"`
+
+	// ServerConfig are the `server.<id>` lines of the ensemble, which the external servers need in their `zoo.cfg`.
+	// While the next step waits for the external servers, these are the lines of that step.
+	// +kubebuilder:validation:Optional
+	ServerConfig []string `json:"serverConfig,omitempty"`
+}
+
+// ProbesSpec tunes the probes of the servers. The defaults of the startup and liveness probes are derived from
+// `tickTime` and `initLimit`.
+type ProbesSpec struct {
+	// Startup checks with `ruok` that a server started and answers, which it does before its data is loaded.
+	// Liveness and readiness are only checked once it succeeds, the readiness probe waits for the data to be loaded.
+	// Defaults to a period of 5s and 10 × initLimit × tickTime, at least 60s.
+	// +kubebuilder:validation:Optional
+	Startup *ProbeSpec `json:"startup,omitempty"`
+
+	// Liveness checks with `ruok` that a server answers, and restarts a server that stopped answering.
+	// Defaults to a period of 10s and restarting after 2 × initLimit × tickTime, at least 3 failures.
+	// +kubebuilder:validation:Optional
+	Liveness *ProbeSpec `json:"liveness,omitempty"`
+
+	// Readiness checks with `srvr` that a server is part of the quorum.
+	// Defaults to a period and timeout of 1s and 3 failures.
+	// +kubebuilder:validation:Optional
+	Readiness *ProbeSpec `json:"readiness,omitempty"`
+}
+
+// ProbeSpec overrides the timing of a probe, see ProbesSpec for the defaults.
+type ProbeSpec struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	PeriodSeconds *int32 `json:"periodSeconds,omitempty"`
+
+	// TimeoutSeconds defaults to the tickTime for the startup and liveness probes, at least 1s.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	FailureThreshold *int32 `json:"failureThreshold,omitempty"`
+}
+
+// StaleDataPolicy decides what a server does with the data of another ensemble member, see ClusterConfigSpec.StaleDataPolicy.
+// +kubebuilder:validation:Enum=Refuse;Wipe;Adopt
+type StaleDataPolicy string
+
+const (
+	StaleDataRefuse StaleDataPolicy = "Refuse"
+	StaleDataWipe   StaleDataPolicy = "Wipe"
+	StaleDataAdopt  StaleDataPolicy = "Adopt"
+)
+
+// PersistentVolumeClaimRetentionPolicySpec decides whether the volumes of the servers of a role group are deleted
+// with them. Retained volumes are reused by servers of the same name. The servers of a cluster that is deleted and
+// created again with the same name refuse the retained data of the deleted one, unless
+// ClusterConfigSpec.StaleDataPolicy is Adopt, which keeps it, or Wipe, which starts them empty.
+type PersistentVolumeClaimRetentionPolicySpec struct {
+	// WhenDeleted applies when the role group is removed from the cluster, or the cluster is deleted.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Retain;Delete
+	// +kubebuilder:default=Retain
+	WhenDeleted appsv1.PersistentVolumeClaimRetentionPolicyType `json:"whenDeleted,omitempty"`
+
+	// WhenScaled applies to the servers removed when the role group is scaled down.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Retain;Delete
+	// +kubebuilder:default=Retain
+	WhenScaled appsv1.PersistentVolumeClaimRetentionPolicyType `json:"whenScaled,omitempty"`
+}
+
+// DiscoveryMode selects which servers are listed in the discovery ConfigMaps.
+// +kubebuilder:validation:Enum=Static;Ready
+type DiscoveryMode string
+
+const (
+	// DiscoveryModeStatic lists every configured server, whether its pod exists or not
+	DiscoveryModeStatic DiscoveryMode = "Static"
+	// DiscoveryModeReady lists the servers whose endpoints are ready
+	DiscoveryModeReady DiscoveryMode = "Ready"
+
+	DefaultDiscoveryDebounce = 30 * time.Second
+)
+
+// DiscoverySpec configures the server list of the discovery ConfigMaps of the cluster and its znodes.
+type DiscoverySpec struct {
+	// Mode of the server list:
+	//  - Static: every server from ordinal 0 to replicas-1 of every role group
+	//  - Ready: only the servers whose endpoints are ready, in the same order.
+	//    If no server is ready, e.g. while the cluster is created, every server is listed.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=Static
+	Mode DiscoveryMode `json:"mode,omitempty"`
+
+	// Debounce is how long a server has to be ready before it is added in the Ready mode, so a flapping server
+	// does not churn the discovery ConfigMaps. A server that is no longer ready is removed right away.
+	// A duration such as `30s` or `1m30s`, defaults to `30s`.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^(0|([0-9]+(\.[0-9]+)?(ns|us|ms|s|m|h))+)$`
+	// +kubebuilder:default="30s"
+	Debounce string `json:"debounce,omitempty"`
+}
+
+// NodeAddressType is the kind of node address clients connect to NodePort services with.
+// +kubebuilder:validation:Enum=InternalIP;ExternalIP;InternalDNS;Annotation
+type NodeAddressType string
+
+const (
+	NodeAddressInternalIP  NodeAddressType = "InternalIP"
+	NodeAddressExternalIP  NodeAddressType = "ExternalIP"
+	NodeAddressInternalDNS NodeAddressType = "InternalDNS"
+	// NodeAddressAnnotation takes the address from the node annotation named in NodeAddressSpec.Annotation
+	NodeAddressAnnotation NodeAddressType = "Annotation"
+)
+
+// NodeAddressSpec selects the address of a node. Nodes without the requested address fall back to their
+// InternalIP, so a node is only left out of discovery if it has no address at all.
+type NodeAddressSpec struct {
+	// Type of the published node address.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=InternalIP
+	Type NodeAddressType `json:"type,omitempty"`
+
+	// Annotation holds the address of a node when the type is `Annotation`, e.g. `example.com/public-ip`.
+	// +kubebuilder:validation:Optional
+	Annotation string `json:"annotation,omitempty"`
+}
+
+// ExternalStableListenerSpec configures the Services that expose every server on a stable address.
+// The Services are named like the pods, and the `<cluster name>-external` discovery ConfigMap lists their addresses.
+type ExternalStableListenerSpec struct {
+	// ServiceType of the per server Services:
+	//  - LoadBalancer: servers are reached through the load balancer ingress address
+	//  - NodePort: servers are reached through the node they run on, the addresses are updated when a server moves
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=LoadBalancer;NodePort
+	// +kubebuilder:default=LoadBalancer
+	ServiceType corev1.ServiceType `json:"serviceType,omitempty"`
+
+	// Annotations are added to the per server Services, e.g. to configure the load balancer.
+	// +kubebuilder:validation:Optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// DiscoveryProfile is a product specific discovery format. Each profile is rendered into its own ConfigMap
+// named `<discovery ConfigMap>-<suffix>`:
+//   - Kafka (suffix `kafka`): `zookeeper.connect`, and the `zookeeper.ssl.*` client settings when TLS is required
+//   - HBase (suffix `hbase`): `hbase.zookeeper.quorum`, `hbase.zookeeper.property.clientPort` and `zookeeper.znode.parent`.
+//     Hosts of the quorum reached on another port than the client port, e.g. node ports, keep their port
+//   - HadoopHA (suffix `hadoop-ha`): `ha.zookeeper.quorum` and `ha.zookeeper.parent-znode`
+//   - ClientProperties (suffix `client-properties`): a `zookeeper-client.properties` file for the ZooKeeper client
+//   - Jaas (suffix `jaas`): a `jaas.conf` file with the `Client` login context for SASL. The credentials are left as
+//     `${ZOOKEEPER_CLIENT_PRINCIPAL}` and `${ZOOKEEPER_CLIENT_KEYTAB}` for Kerberos, or
+//     `${ZOOKEEPER_CLIENT_USERNAME}` and `${ZOOKEEPER_CLIENT_PASSWORD}` for digest authentication. It is refused for
+//     clusters without a Kerberos or static AuthenticationClass.
+//
+// +kubebuilder:validation:Enum=Kafka;HBase;HadoopHA;ClientProperties;Jaas
+type DiscoveryProfile string
+
+const (
+	DiscoveryProfileKafka            DiscoveryProfile = "Kafka"
+	DiscoveryProfileHBase            DiscoveryProfile = "HBase"
+	DiscoveryProfileHadoopHA         DiscoveryProfile = "HadoopHA"
+	DiscoveryProfileClientProperties DiscoveryProfile = "ClientProperties"
+	DiscoveryProfileJaas             DiscoveryProfile = "Jaas"
+)
+
+// NetworkPolicySpec restricts the traffic to the servers with NetworkPolicies generated per role group.
+// Quorum and election traffic is only allowed between the servers of the cluster.
+type NetworkPolicySpec struct {
+	// +kubebuilder:validation:Optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Clients are the peers allowed to connect to the client ports and the AdminServer.
+	// The operator is always allowed, since it manages the znodes.
+	// If empty, all pods in the namespace of the cluster are allowed.
+	// +kubebuilder:validation:Optional
+	Clients []networkingv1.NetworkPolicyPeer `json:"clients,omitempty"`
+
+	// MonitoringNamespace is the namespace whose pods are allowed to scrape the metrics ports.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=monitoring
+	MonitoringNamespace string `json:"monitoringNamespace,omitempty"`
+}
+
+// FourLetterWord is a ZooKeeper four letter word command.
+// +kubebuilder:validation:Enum=conf;cons;crst;dirs;dump;envi;hash;isro;mntr;ruok;srst;srvr;stat;wchc;wchp;wchs
+type FourLetterWord string
+
 type ServerSpec struct {
 	*commonsv1alpha1.OverridesSpec `json:",inline"`
 
@@ -220,21 +830,67 @@ type RoleGroupSpec struct {
 type ConfigSpec struct {
 	*commonsv1alpha1.RoleGroupConfigSpec `json:",inline"`
 
+	// MyidOffset is the `myid` of the first server of the role group, the others count up from it. Set in a role
+	// group it takes precedence over the role, unset or 0 the ids start at 1. Keep the ids of role groups apart and
+	// clear of the servers of an external ensemble, see MigrationSpec. It must not change once the servers exist, as
+	// they refuse data of another id, see ClusterConfigSpec.StaleDataPolicy.
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:validation:Minimum=0.0
 	MyidOffset int16 `json:"myidOffset,omitempty"`
 
+	// InitLimit is the number of ticks a follower may take to connect and sync to the leader, `initLimit` in `zoo.cfg`.
+	// Defaults to 5.
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:validation:Minimum=0.0
 	InitLimit int32 `json:"initLimit,omitempty"`
 
+	// SyncLimit is the number of ticks a follower may lag behind the leader, `syncLimit` in `zoo.cfg`. Defaults to 2.
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:validation:Minimum=0.0
 	SyncLimit int32 `json:"syncLimit,omitempty"`
 
+	// TickTime is the basic time unit of ZooKeeper in milliseconds, `tickTime` in `zoo.cfg`. Defaults to 3000.
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:validation:Minimum=0.0
 	TickTime int32 `json:"tickTime,omitempty"`
+
+	// Probes tunes the startup, liveness and readiness probes of the servers.
+	// +kubebuilder:validation:Optional
+	Probes *ProbesSpec `json:"probes,omitempty"`
+
+	// DataLogStorage adds a volume for the transaction logs, `dataLogDir`, next to the snapshots on the data volume.
+	// ZooKeeper syncs every write to the transaction log, so a dedicated fast device lowers the write latency.
+	// Servers move their existing transaction logs to the volume on their next start. Once added, the volume is
+	// kept even if this is removed again.
+	// +kubebuilder:validation:Optional
+	DataLogStorage *commonsv1alpha1.StorageResource `json:"dataLogStorage,omitempty"`
+
+	// Autopurge deletes the snapshots and transaction logs that are no longer needed.
+	// Defaults to keeping 3 snapshots and purging every hour.
+	// +kubebuilder:validation:Optional
+	Autopurge *AutopurgeSpec `json:"autopurge,omitempty"`
+
+	// SnapCount is the number of transactions logged between snapshots, `snapCount` in `zoo.cfg`.
+	// ZooKeeper defaults to 100000.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=2
+	SnapCount *int32 `json:"snapCount,omitempty"`
+
+	// SnapshotCompression is the compression of new snapshots, `snapshot.compression.method` in `zoo.cfg`.
+	// Servers read snapshots of any compression, so it can be changed at any time. Defaults to none.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=none;gz;snappy
+	SnapshotCompression SnapshotCompression `json:"snapshotCompression,omitempty"`
+
+	// PreAllocSize is the block size transaction log files grow by, `preAllocSize` in `zoo.cfg`.
+	// ZooKeeper defaults to 64Mi, a smaller size saves space on small volumes.
+	// +kubebuilder:validation:Optional
+	PreAllocSize *resource.Quantity `json:"preAllocSize,omitempty"`
+
+	// PersistentVolumeClaimRetentionPolicy decides whether the volumes of the servers are deleted with them.
+	// Defaults to retaining them.
+	// +kubebuilder:validation:Optional
+	PersistentVolumeClaimRetentionPolicy *PersistentVolumeClaimRetentionPolicySpec `json:"persistentVolumeClaimRetentionPolicy,omitempty"`
 }
 
 func init() {
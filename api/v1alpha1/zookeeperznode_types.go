@@ -49,6 +49,12 @@ type ZookeeperZnodeList struct {
 type ZookeeperZnodeSpec struct {
 	// +kubebuilder:validation:Required
 	ClusterRef *ClusterRefSpec `json:"clusterRef"`
+
+	// DiscoveryProfiles are additional discovery ConfigMaps of the znode in product specific formats,
+	// rendered next to the generic discovery ConfigMap, see DiscoveryProfile.
+	// +kubebuilder:validation:Optional
+	// +listType=set
+	DiscoveryProfiles []DiscoveryProfile `json:"discoveryProfiles,omitempty"`
 }
 
 type ClusterRefSpec struct {
@@ -21,11 +21,14 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	authv1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/authentication/v1alpha1"
+	s3v1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/s3/v1alpha1"
 	zookeeperv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -37,7 +40,11 @@ import (
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	"github.com/zncdatadev/zookeeper-operator/internal/backupcontroller"
 	"github.com/zncdatadev/zookeeper-operator/internal/clustercontroller"
+	"github.com/zncdatadev/zookeeper-operator/internal/clustercontroller/health"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+	"github.com/zncdatadev/zookeeper-operator/internal/util"
 	"github.com/zncdatadev/zookeeper-operator/internal/util/version"
 	"github.com/zncdatadev/zookeeper-operator/internal/znodecontroller"
 	// +kubebuilder:scaffold:imports
@@ -52,6 +59,8 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(zookeeperv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(authv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(s3v1alpha1.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -64,6 +73,9 @@ func main() {
 	var secureMetrics bool
 	var enableHTTP2 bool
 	var showVersion bool
+	var clusterDomain string
+	var healthCheckInterval time.Duration
+	var clientTLSDir string
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -83,6 +95,14 @@ func main() {
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
 	flag.BoolVar(&showVersion, "version", false, "Print version information and exit.")
+	flag.StringVar(&clusterDomain, "cluster-domain", os.Getenv(util.ClusterDomainEnv),
+		"The DNS domain of the Kubernetes cluster. If empty, it is detected from /etc/resolv.conf. "+
+			"Defaults to the "+util.ClusterDomainEnv+" environment variable.")
+	flag.DurationVar(&healthCheckInterval, "health-check-interval", health.DefaultInterval,
+		"The interval at which the servers of every ZookeeperCluster are checked for the quorum health.")
+	flag.StringVar(&clientTLSDir, "client-tls-dir", "/kubedoop/client-tls",
+		"The directory of the CA (ca.crt), and of the client certificate (tls.crt, tls.key) if the servers require one, "+
+			"that the operator connects with to servers which only accept TLS, as mounted by the secret-operator.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -96,6 +116,8 @@ func main() {
 	}
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	util.SetClusterDomain(clusterDomain)
+	setupLog.Info("Using cluster domain", "clusterDomain", util.ClusterDomain())
 
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
@@ -188,20 +210,47 @@ func main() {
 		os.Exit(1)
 	}
 
+	clientTLS, err := common.LoadClientTLSConfig(clientTLSDir)
+	if err != nil {
+		setupLog.Error(err, "unable to load the client TLS settings", "dir", clientTLSDir)
+		os.Exit(1)
+	}
+	if clientTLS == nil {
+		setupLog.Info("No client TLS settings, servers that only accept TLS can not be reached", "dir", clientTLSDir)
+	}
+	fourLetterWord := common.NewFourLetterWord(clientTLS)
+
+	healthMonitor := health.NewMonitor(mgr.GetClient(), healthCheckInterval, fourLetterWord)
+	if err := mgr.Add(healthMonitor); err != nil {
+		setupLog.Error(err, "unable to add the health monitor")
+		os.Exit(1)
+	}
 	if err = (&clustercontroller.ZookeeperClusterReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-		Log:    setupLog,
+		Client:         mgr.GetClient(),
+		Scheme:         mgr.GetScheme(),
+		Log:            setupLog,
+		Health:         healthMonitor,
+		FourLetterWord: fourLetterWord,
+		Recorder:       mgr.GetEventRecorder("zookeeper-operator"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ZookeeperCluster")
 		os.Exit(1)
 	}
 	if err = (&znodecontroller.ZookeeperZnodeReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Log:       setupLog,
+		ClientTLS: clientTLS,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ZookeeperZnode")
+		os.Exit(1)
+	}
+	if err = (&backupcontroller.ZookeeperBackupReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 		Log:    setupLog,
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "ZookeeperZnode")
+		setupLog.Error(err, "unable to create controller", "controller", "ZookeeperBackup")
 		os.Exit(1)
 	}
 
@@ -0,0 +1,85 @@
+# Archives the latest snapshot of a server with the transaction logs from that snapshot on,
+# uploads the archive to S3 and deletes the backups beyond the retention rules.
+# The record of the backup is written to the termination log, where the operator picks it up.
+# It runs after the helpers of common.S3Script. The transaction logs are read from LOG_DIR if the server
+# has a volume for them, the archive holds all files at its root either way.
+
+log_dir="${LOG_DIR:-${DATA_DIR}}"
+cd "${DATA_DIR}"
+snapshot=""
+for file in snapshot.*; do
+  [ -e "${file}" ] || continue
+  if [ -z "${snapshot}" ] || [ "$(zxid_of "${file}")" -gt "$(zxid_of "${snapshot}")" ]; then
+    snapshot=${file}
+  fi
+done
+if [ -z "${snapshot}" ]; then
+  echo "no snapshot in ${DATA_DIR}" >&2
+  exit 1
+fi
+snapshot_zxid=$(zxid_of "${snapshot}")
+
+# the snapshot is fuzzy, so the log it started in is needed as well as all later ones
+first_log=""
+for file in "${log_dir}"/log.*; do
+  [ -e "${file}" ] || continue
+  file=$(basename "${file}")
+  zxid=$(zxid_of "${file}")
+  if [ "${zxid}" -le "${snapshot_zxid}" ] && { [ -z "${first_log}" ] || [ "${zxid}" -gt "$(zxid_of "${first_log}")" ]; }; then
+    first_log=${file}
+  fi
+done
+files=("${snapshot}")
+for file in acceptedEpoch currentEpoch; do
+  [ -e "${file}" ] || continue
+  files+=("${file}")
+done
+logs=()
+for file in "${log_dir}"/log.*; do
+  [ -e "${file}" ] || continue
+  file=$(basename "${file}")
+  if [ "${file}" = "${first_log}" ] || [ "$(zxid_of "${file}")" -gt "${snapshot_zxid}" ]; then
+    logs+=("${file}")
+  fi
+done
+
+time=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+zxid=$(printf '%x' "${snapshot_zxid}")
+key="${BACKUP_PREFIX}$(date -u -d "${time}" +%Y%m%dT%H%M%SZ)-${zxid}.tar.gz"
+archive=/tmp/backup.tar.gz
+echo "archiving ${files[*]} ${logs[*]}"
+tar_args=(-C "${DATA_DIR}" "${files[@]}")
+if [ "${#logs[@]}" -gt 0 ]; then
+  tar_args+=(-C "${log_dir}" "${logs[@]}")
+fi
+# the server keeps appending to the newest transaction log, which GNU tar reports with exit code 1, "file changed as
+# we read it". The archive holds the log up to where it was read, replayed like the log of a crashed server.
+tar --warning=no-file-changed -czf "${archive}" "${tar_args[@]}" || [ $? -eq 1 ]
+size=$(stat -c %s "${archive}")
+
+curl "${curl_opts[@]}" -H "x-amz-content-sha256: $(sha256sum "${archive}" | cut -d ' ' -f 1)" \
+  --upload-file "${archive}" "$(object_url "${key}")"
+echo "uploaded ${key} with ${size} bytes to bucket ${S3_BUCKET}"
+
+# backup keys sort by time, so the newest come first in reverse order
+key_pattern="^${BACKUP_PREFIX//./\\.}[0-9]{8}T[0-9]{6}Z-[0-9a-f]+\.tar\.gz$"
+backups=$(curl "${curl_opts[@]}" -H "x-amz-content-sha256: ${empty_sha256}" \
+  "$(object_url "")?list-type=2&prefix=${BACKUP_PREFIX}" |
+  grep -o '<Key>[^<]*</Key>' | sed -e 's/^<Key>//' -e 's/<\/Key>$//' | grep -E "${key_pattern}" | sort -r || true)
+cutoff=""
+if [ "${BACKUP_MAX_AGE_SECONDS}" -gt 0 ]; then
+  cutoff=$(date -u -d "@$(($(date +%s) - BACKUP_MAX_AGE_SECONDS))" +%Y%m%dT%H%M%SZ)
+fi
+index=0
+for backup in ${backups}; do
+  index=$((index + 1))
+  [ "${backup}" != "${key}" ] || continue
+  stamp=${backup#"${BACKUP_PREFIX}"}
+  stamp=${stamp%%-*}
+  if [ "${index}" -gt "${BACKUP_MAX_BACKUPS}" ] || { [ -n "${cutoff}" ] && [[ "${stamp}" < "${cutoff}" ]]; }; then
+    curl "${curl_opts[@]}" -H "x-amz-content-sha256: ${empty_sha256}" -X DELETE "$(object_url "${backup}")"
+    echo "deleted ${backup}"
+  fi
+done
+
+printf '{"key":"%s","zxid":"%s","size":%d,"time":"%s"}' "${key}" "${zxid}" "${size}" "${time}" >/dev/termination-log
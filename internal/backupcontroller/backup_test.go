@@ -0,0 +1,234 @@
+package backupcontroller_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	commonsv1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/commons/v1alpha1"
+	s3v1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/s3/v1alpha1"
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/backupcontroller"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+func envValue(container corev1.Container, name string) string {
+	for _, env := range container.Env {
+		if env.Name == name {
+			return env.Value
+		}
+	}
+	return ""
+}
+
+func newBackup() *zkv1alpha1.ZookeeperBackup {
+	return &zkv1alpha1.ZookeeperBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "default", Generation: 1},
+		Spec: zkv1alpha1.ZookeeperBackupSpec{
+			ClusterName: "simple",
+			Bucket: &s3v1alpha1.S3BucketSpec{
+				BucketName: "backups",
+				Connection: &s3v1alpha1.S3BucketConnectionSpec{Reference: "minio"},
+			},
+			Retention: &zkv1alpha1.BackupRetentionSpec{MaxBackups: 2, MaxAge: "24h"},
+		},
+	}
+}
+
+var _ = Describe("Backup job", func() {
+	var zkCluster *zkv1alpha1.ZookeeperCluster
+	var s3Connection *common.S3Connection
+
+	BeforeEach(func() {
+		zkCluster = &zkv1alpha1.ZookeeperCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default"},
+			Spec: zkv1alpha1.ZookeeperClusterSpec{
+				Image: &zkv1alpha1.ImageSpec{Repo: "quay.io/zncdatadev", PullPolicy: ptr.To(corev1.PullIfNotPresent)},
+				Servers: &zkv1alpha1.ServerSpec{RoleGroups: map[string]zkv1alpha1.RoleGroupSpec{
+					"secondary": {Replicas: 1},
+					"default":   {Replicas: 3},
+				}},
+			},
+		}
+		s3Connection = &common.S3Connection{
+			Bucket: "backups",
+			S3ConnectionSpec: &s3v1alpha1.S3ConnectionSpec{
+				Host:        "minio",
+				Port:        9000,
+				PathStyle:   true,
+				Credentials: &commonsv1alpha1.Credentials{SecretClass: "s3-credentials"},
+			},
+		}
+	})
+
+	It("defaults the member to the first server of the first role group", func() {
+		member, err := backupcontroller.DefaultMember(zkCluster)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(member).To(Equal("simple-server-default-0"))
+	})
+
+	It("runs next to the member and mounts its data volume read only", func() {
+		jobSpec, err := backupcontroller.NewJobSpec(newBackup(), zkCluster, "simple-server-default-1", false, s3Connection)
+		Expect(err).NotTo(HaveOccurred())
+
+		podSpec := jobSpec.Template.Spec
+		Expect(jobSpec.Template.Labels).To(HaveKeyWithValue(backupcontroller.BackupLabel, "nightly"))
+		// finished backup pods must not be taken for servers of the cluster
+		Expect(jobSpec.Template.Labels).NotTo(HaveKey(constants.LabelKubernetesInstance))
+		Expect(jobSpec.Template.Labels).To(HaveKeyWithValue(constants.LabelKubernetesComponent, backupcontroller.BackupComponent))
+		Expect(jobSpec.Template.Annotations).To(HaveKeyWithValue(backupcontroller.MemberAnnotation, "simple-server-default-1"))
+		Expect(podSpec.RestartPolicy).To(Equal(corev1.RestartPolicyNever))
+		Expect(podSpec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution).To(ConsistOf(corev1.PodAffinityTerm{
+			LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{appsv1.StatefulSetPodNameLabel: "simple-server-default-1"}},
+			TopologyKey:   corev1.LabelHostname,
+		}))
+
+		var claim *corev1.PersistentVolumeClaimVolumeSource
+		for _, volume := range podSpec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				claim = volume.PersistentVolumeClaim
+			}
+		}
+		Expect(claim).NotTo(BeNil())
+		Expect(claim.ClaimName).To(Equal("data-simple-server-default-1"))
+		Expect(claim.ReadOnly).To(BeTrue())
+
+		Expect(podSpec.Containers).To(HaveLen(1))
+		container := podSpec.Containers[0]
+		Expect(envValue(container, "S3_ENDPOINT")).To(Equal("http://minio:9000"))
+		Expect(envValue(container, "S3_BUCKET")).To(Equal("backups"))
+		Expect(envValue(container, "S3_PATH_STYLE")).To(Equal("true"))
+		Expect(envValue(container, "BACKUP_PREFIX")).To(Equal("default/simple/"))
+		Expect(envValue(container, "BACKUP_MAX_BACKUPS")).To(Equal("2"))
+		Expect(envValue(container, "BACKUP_MAX_AGE_SECONDS")).To(Equal("86400"))
+	})
+
+	It("reads the transaction logs from their own volume", func() {
+		jobSpec, err := backupcontroller.NewJobSpec(newBackup(), zkCluster, "simple-server-default-1", true, s3Connection)
+		Expect(err).NotTo(HaveOccurred())
+
+		podSpec := jobSpec.Template.Spec
+		Expect(podSpec.Volumes).To(ContainElement(HaveField("VolumeSource.PersistentVolumeClaim", HaveValue(Equal(
+			corev1.PersistentVolumeClaimVolumeSource{ClaimName: "txnlog-simple-server-default-1", ReadOnly: true},
+		)))))
+		container := podSpec.Containers[0]
+		Expect(envValue(container, "LOG_DIR")).To(Equal("/kubedoop/txnlog/version-2"))
+		Expect(container.VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "txnlog", MountPath: "/kubedoop/txnlog/", ReadOnly: true}))
+	})
+
+	It("trusts the CA of a TLS connection", func() {
+		s3Connection.Tls = &s3v1alpha1.Tls{Verification: &commonsv1alpha1.TLSVerificationSpec{
+			Server: &commonsv1alpha1.ServerVerification{CACert: &commonsv1alpha1.CACert{SecretClass: "tls"}},
+		}}
+		jobSpec, err := backupcontroller.NewJobSpec(newBackup(), zkCluster, "simple-server-default-0", false, s3Connection)
+		Expect(err).NotTo(HaveOccurred())
+
+		container := jobSpec.Template.Spec.Containers[0]
+		Expect(envValue(container, "S3_ENDPOINT")).To(Equal("https://minio:9000"))
+		Expect(envValue(container, "S3_CA_CERT")).NotTo(BeEmpty())
+		Expect(jobSpec.Template.Spec.Volumes).To(ContainElement(HaveField("Name", "s3-tls")))
+	})
+
+	It("rejects an invalid maxAge", func() {
+		backup := newBackup()
+		backup.Spec.Retention.MaxAge = "a month"
+		_, err := backupcontroller.NewJobSpec(backup, zkCluster, "simple-server-default-0", false, s3Connection)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Backup status", func() {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	job := func(name string, jobType batchv1.JobConditionType, finishedAt time.Time) batchv1.Job {
+		return batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       batchv1.JobSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"job-name": name}}},
+			Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{{
+				Type:               jobType,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.NewTime(finishedAt),
+			}}},
+		}
+	}
+	pod := func(jobName, message string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        jobName + "-abcde",
+				Namespace:   "default",
+				Labels:      map[string]string{"job-name": jobName},
+				Annotations: map[string]string{backupcontroller.MemberAnnotation: "simple-server-default-0"},
+			},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodSucceeded,
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "backup",
+					State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: message}},
+				}},
+			},
+		}
+	}
+
+	It("records the successful backups, newest first", func() {
+		client := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+			pod("nightly-1", `{"key":"default/simple/a.tar.gz","zxid":"100000002","size":10,"time":"2026-10-01T03:00:00Z"}`),
+			pod("nightly-2", `{"key":"default/simple/b.tar.gz","zxid":"100000005","size":12,"time":"2026-10-02T03:00:00Z"}`),
+			pod("nightly-3", `{"key":"default/simple/c.tar.gz","zxid":"100000009","size":14,"time":"2026-10-03T03:00:00Z"}`),
+		).Build()
+		backup := newBackup()
+		jobs := []batchv1.Job{
+			job("nightly-1", batchv1.JobComplete, now.Add(-3*time.Hour)),
+			job("nightly-2", batchv1.JobComplete, now.Add(-2*time.Hour)),
+			job("nightly-3", batchv1.JobComplete, now.Add(-time.Hour)),
+		}
+
+		changed, err := backupcontroller.UpdateStatus(ctx, client, backup, jobs)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		// retention keeps two backups
+		Expect(backup.Status.Backups).To(HaveLen(2))
+		Expect(backup.Status.Backups[0].Key).To(Equal("default/simple/c.tar.gz"))
+		Expect(backup.Status.Backups[1].Key).To(Equal("default/simple/b.tar.gz"))
+		Expect(backup.Status.LastBackup.Zxid).To(Equal("100000009"))
+		Expect(backup.Status.LastBackup.Member).To(Equal("simple-server-default-0"))
+		Expect(backup.Status.LastBackup.Bucket).To(Equal("backups"))
+		Expect(apimeta.IsStatusConditionTrue(backup.Status.Conditions, zkv1alpha1.BackupConditionSucceeded)).To(BeTrue())
+
+		// the job of the trimmed backup is still there
+		changed, err = backupcontroller.UpdateStatus(ctx, client, backup, jobs)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(backup.Status.Backups).To(HaveLen(2))
+	})
+
+	It("reports a failed backup", func() {
+		client := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+			pod("nightly-1", `{"key":"default/simple/a.tar.gz","zxid":"100000002","size":10,"time":"2026-10-01T03:00:00Z"}`),
+		).Build()
+		backup := newBackup()
+		jobs := []batchv1.Job{
+			job("nightly-1", batchv1.JobComplete, now.Add(-2*time.Hour)),
+			job("nightly-2", batchv1.JobFailed, now.Add(-time.Hour)),
+		}
+
+		_, err := backupcontroller.UpdateStatus(ctx, client, backup, jobs)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(backup.Status.LastBackup.Job).To(Equal("nightly-1"))
+		condition := apimeta.FindStatusCondition(backup.Status.Conditions, zkv1alpha1.BackupConditionSucceeded)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal("BackupFailed"))
+	})
+})
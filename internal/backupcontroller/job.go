@@ -0,0 +1,172 @@
+package backupcontroller
+
+import (
+	_ "embed"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/zncdatadev/operator-go/pkg/builder"
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+const (
+	// BackupLabel names the ZookeeperBackup of a backup job
+	BackupLabel = "zookeeper.kubedoop.dev/backup"
+	// BackupComponent is the `app.kubernetes.io/component` label of backup jobs
+	BackupComponent = "backup"
+	// MemberAnnotation names the server pod whose data a backup pod archives
+	MemberAnnotation = "zookeeper.kubedoop.dev/backup-member"
+
+	backupContainerName = "backup"
+)
+
+//go:embed backup.sh
+var backupScript string
+
+// DefaultMember returns the first server of the first role group, `<cluster name>-server-<role group>-0`.
+func DefaultMember(zkCluster *zkv1alpha1.ZookeeperCluster) (string, error) {
+	if zkCluster.Spec.Servers == nil || len(zkCluster.Spec.Servers.RoleGroups) == 0 {
+		return "", fmt.Errorf("cluster %s has no servers", zkCluster.Name)
+	}
+	roleGroup := slices.Sorted(maps.Keys(zkCluster.Spec.Servers.RoleGroups))[0]
+	return fmt.Sprintf("%s-%s-%s-0", zkCluster.Name, common.Server, roleGroup), nil
+}
+
+// BackupPrefix returns the prefix of the object keys, `<namespace>/<cluster name>/` by default.
+func BackupPrefix(backup *zkv1alpha1.ZookeeperBackup) string {
+	if backup.Spec.Prefix != "" {
+		return backup.Spec.Prefix
+	}
+	return fmt.Sprintf("%s/%s/", backup.Namespace, backup.Spec.ClusterName)
+}
+
+// MaxBackups returns the number of backups to keep.
+func MaxBackups(retention *zkv1alpha1.BackupRetentionSpec) int32 {
+	if retention == nil || retention.MaxBackups < 1 {
+		return zkv1alpha1.DefaultBackupMaxBackups
+	}
+	return retention.MaxBackups
+}
+
+func maxAge(retention *zkv1alpha1.BackupRetentionSpec) (time.Duration, error) {
+	if retention == nil || retention.MaxAge == "" {
+		return 0, nil
+	}
+	age, err := time.ParseDuration(retention.MaxAge)
+	if err != nil {
+		return 0, fmt.Errorf("invalid retention maxAge %q: %w", retention.MaxAge, err)
+	}
+	return age, nil
+}
+
+// JobLabels returns the labels of the jobs of a backup. They do not carry the instance label of the cluster, as the
+// finished pods of the jobs would otherwise be taken for servers of the cluster that never become ready.
+func JobLabels(backup *zkv1alpha1.ZookeeperBackup) map[string]string {
+	return map[string]string{
+		constants.LabelKubernetesComponent: BackupComponent,
+		constants.LabelKubernetesManagedBy: zkv1alpha1.DefaultProductName + "-operator",
+		BackupLabel:                        backup.Name,
+	}
+}
+
+// NewJobSpec returns the spec of a backup job, which runs on the node of the member to mount its data volume.
+// With dataLog the transaction logs are read from the transaction log volume of the member, see ConfigSpec.DataLogStorage.
+func NewJobSpec(
+	backup *zkv1alpha1.ZookeeperBackup,
+	zkCluster *zkv1alpha1.ZookeeperCluster,
+	member string,
+	dataLog bool,
+	s3Connection *common.S3Connection,
+) (*batchv1.JobSpec, error) {
+	age, err := maxAge(backup.Spec.Retention)
+	if err != nil {
+		return nil, err
+	}
+	image := common.ClusterImage(zkCluster.Spec.Image)
+
+	env := append(s3Connection.EnvVars(),
+		corev1.EnvVar{Name: "DATA_DIR", Value: path.Join(constants.KubedoopDataDir, "version-2")},
+		corev1.EnvVar{Name: "BACKUP_PREFIX", Value: BackupPrefix(backup)},
+		corev1.EnvVar{Name: "BACKUP_MAX_BACKUPS", Value: strconv.Itoa(int(MaxBackups(backup.Spec.Retention)))},
+		corev1.EnvVar{Name: "BACKUP_MAX_AGE_SECONDS", Value: strconv.Itoa(int(age.Seconds()))},
+	)
+	volumeMounts := append([]corev1.VolumeMount{
+		{Name: zkv1alpha1.DataDirName, MountPath: constants.KubedoopDataDir, ReadOnly: true},
+	}, s3Connection.VolumeMounts()...)
+	volumes := append([]corev1.Volume{
+		{
+			Name: zkv1alpha1.DataDirName,
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: zkv1alpha1.DataDirName + "-" + member,
+				ReadOnly:  true,
+			}},
+		},
+	}, s3Connection.Volumes()...)
+	if dataLog {
+		env = append(env, corev1.EnvVar{Name: "LOG_DIR", Value: path.Join(common.DataLogDir, "version-2")})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{Name: zkv1alpha1.DataLogDirName, MountPath: common.DataLogDir, ReadOnly: true})
+		volumes = append(volumes, corev1.Volume{
+			Name: zkv1alpha1.DataLogDirName,
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: zkv1alpha1.DataLogDirName + "-" + member,
+				ReadOnly:  true,
+			}},
+		})
+	}
+
+	container := builder.NewContainer(backupContainerName, image).
+		SetImagePullPolicy(image.GetPullPolicy()).
+		SetCommand([]string{"/bin/bash", "-euo", "pipefail", "-c"}).
+		SetArgs([]string{common.S3Script + "\n" + backupScript}).
+		AddEnvVars(env).
+		AddVolumeMounts(volumeMounts).
+		Build()
+
+	userId := int64(1001)
+	userGroup := int64(0)
+	fsGroup := int64(1001)
+	podSpec := corev1.PodSpec{
+		Containers:         []corev1.Container{*container},
+		Volumes:            volumes,
+		RestartPolicy:      corev1.RestartPolicyNever,
+		EnableServiceLinks: ptr.To(false),
+		SecurityContext: &corev1.PodSecurityContext{
+			RunAsUser:  &userId,
+			RunAsGroup: &userGroup,
+			FSGroup:    &fsGroup,
+		},
+		// the data volume of the member can only be mounted on its node
+		Affinity: &corev1.Affinity{PodAffinity: &corev1.PodAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+				LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{appsv1.StatefulSetPodNameLabel: member}},
+				TopologyKey:   corev1.LabelHostname,
+			}},
+		}},
+	}
+	if pullSecret := zkCluster.Spec.Image.PullSecretName; pullSecret != "" {
+		podSpec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: pullSecret}}
+	}
+
+	return &batchv1.JobSpec{
+		BackoffLimit: ptr.To(int32(2)),
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels:      JobLabels(backup),
+				Annotations: map[string]string{MemberAnnotation: member},
+			},
+			Spec: podSpec,
+		},
+	}, nil
+}
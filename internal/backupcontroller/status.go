@@ -0,0 +1,148 @@
+package backupcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+)
+
+// terminationRecord is written to the termination log by the backup script
+type terminationRecord struct {
+	Key  string      `json:"key"`
+	Zxid string      `json:"zxid"`
+	Size int64       `json:"size"`
+	Time metav1.Time `json:"time"`
+}
+
+// jobFinished returns the finish time of a job and whether it succeeded, or false if it is still running.
+func jobFinished(job *batchv1.Job) (finished bool, succeeded bool, at time.Time) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return true, true, condition.LastTransitionTime.Time
+		case batchv1.JobFailed:
+			return true, false, condition.LastTransitionTime.Time
+		}
+	}
+	return false, false, time.Time{}
+}
+
+// UpdateStatus records the successful backups of the finished jobs and reports the outcome of the last one.
+// It returns whether the status changed.
+func UpdateStatus(ctx context.Context, client ctrlclient.Client, backup *zkv1alpha1.ZookeeperBackup, jobs []batchv1.Job) (bool, error) {
+	status := &backup.Status
+	changed := false
+	maxBackups := int(MaxBackups(backup.Spec.Retention))
+
+	var last *batchv1.Job
+	var lastAt time.Time
+	for i := range jobs {
+		job := &jobs[i]
+		finished, succeeded, at := jobFinished(job)
+		if !finished {
+			continue
+		}
+		if last == nil || at.After(lastAt) {
+			last, lastAt = job, at
+		}
+		if !succeeded || slices.ContainsFunc(status.Backups, func(record zkv1alpha1.BackupRecord) bool { return record.Job == job.Name }) {
+			continue
+		}
+		record, err := jobRecord(ctx, client, backup, job)
+		if err != nil {
+			return false, err
+		}
+		if record == nil {
+			logger.Info("Backup job succeeded without a record", "namespace", job.Namespace, "job", job.Name)
+			continue
+		}
+		// the backups of jobs that are kept longer than the records in status were trimmed already
+		if len(status.Backups) >= maxBackups && !record.Time.After(oldestBackup(status.Backups).Time.Time) {
+			continue
+		}
+		status.Backups = append(status.Backups, *record)
+		changed = true
+	}
+
+	if changed {
+		slices.SortFunc(status.Backups, func(a, b zkv1alpha1.BackupRecord) int { return b.Time.Compare(a.Time.Time) })
+		if len(status.Backups) > maxBackups {
+			status.Backups = status.Backups[:maxBackups]
+		}
+		status.LastBackup = status.Backups[0].DeepCopy()
+	}
+
+	if last != nil {
+		condition := metav1.Condition{
+			Type:               zkv1alpha1.BackupConditionSucceeded,
+			Status:             metav1.ConditionTrue,
+			Reason:             "BackupSucceeded",
+			Message:            fmt.Sprintf("job %s backed up the cluster", last.Name),
+			ObservedGeneration: backup.Generation,
+		}
+		if _, succeeded, _ := jobFinished(last); !succeeded {
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "BackupFailed"
+			condition.Message = fmt.Sprintf("job %s failed, see the logs of its pods", last.Name)
+		}
+		if apimeta.SetStatusCondition(&status.Conditions, condition) {
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+func oldestBackup(backups []zkv1alpha1.BackupRecord) zkv1alpha1.BackupRecord {
+	return slices.MinFunc(backups, func(a, b zkv1alpha1.BackupRecord) int { return a.Time.Compare(b.Time.Time) })
+}
+
+// jobRecord reads the record of a successful backup from the termination message of its pod, or nil if no pod has it.
+func jobRecord(ctx context.Context, client ctrlclient.Client, backup *zkv1alpha1.ZookeeperBackup, job *batchv1.Job) (*zkv1alpha1.BackupRecord, error) {
+	selector, err := metav1.LabelSelectorAsSelector(job.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("selector of job %s/%s: %w", job.Namespace, job.Name, err)
+	}
+	pods := &corev1.PodList{}
+	if err := client.List(ctx, pods, ctrlclient.InNamespace(job.Namespace), ctrlclient.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, fmt.Errorf("list pods of job %s/%s: %w", job.Namespace, job.Name, err)
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			terminated := containerStatus.State.Terminated
+			if containerStatus.Name != backupContainerName || terminated == nil || terminated.Message == "" {
+				continue
+			}
+			var termination terminationRecord
+			if err := json.Unmarshal([]byte(terminated.Message), &termination); err != nil {
+				return nil, fmt.Errorf("termination message of pod %s/%s: %w", pod.Namespace, pod.Name, err)
+			}
+			return &zkv1alpha1.BackupRecord{
+				Job:    job.Name,
+				Member: pod.Annotations[MemberAnnotation],
+				Bucket: backup.Spec.Bucket.BucketName,
+				Key:    termination.Key,
+				Zxid:   termination.Zxid,
+				Size:   termination.Size,
+				Time:   termination.Time,
+			}, nil
+		}
+	}
+	// the pods may be gone already, e.g. deleted by hand
+	return nil, nil
+}
@@ -0,0 +1,13 @@
+package backupcontroller_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestBackupController(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Backup Controller Suite")
+}
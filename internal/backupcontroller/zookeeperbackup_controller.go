@@ -0,0 +1,214 @@
+/*
+Copyright 2024 zncdatadev.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backupcontroller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+var logger = ctrl.Log.WithName("backup-controller")
+
+// ZookeeperBackupReconciler reconciles a ZookeeperBackup object
+type ZookeeperBackupReconciler struct {
+	ctrlclient.Client
+	Scheme *runtime.Scheme
+	Log    logr.Logger
+}
+
+// +kubebuilder:rbac:groups=zookeeper.kubedoop.dev,resources=zookeeperbackups,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=zookeeper.kubedoop.dev,resources=zookeeperbackups/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=zookeeper.kubedoop.dev,resources=zookeeperbackups/finalizers,verbs=update
+// +kubebuilder:rbac:groups=zookeeper.kubedoop.dev,resources=zookeeperclusters,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch
+// +kubebuilder:rbac:groups=s3.kubedoop.dev,resources=s3connections,verbs=get;list;watch
+
+// Reconcile runs the jobs of a backup, a CronJob for scheduled backups or a single Job otherwise,
+// and records the backups they take in the status.
+func (r *ZookeeperBackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	r.Log.Info("Reconciling zookeeper backup instance")
+
+	backup := &zkv1alpha1.ZookeeperBackup{}
+	if err := r.Get(ctx, req.NamespacedName, backup); err != nil {
+		if ctrlclient.IgnoreNotFound(err) != nil {
+			r.Log.Error(err, "unable to fetch ZookeeperBackup")
+			return ctrl.Result{}, err
+		}
+		r.Log.Info("Zookeeper-backup resource not found. Ignoring since object must be deleted")
+		return ctrl.Result{}, nil
+	}
+
+	zkCluster := &zkv1alpha1.ZookeeperCluster{}
+	if err := r.Get(ctx, ctrlclient.ObjectKey{Namespace: backup.Namespace, Name: backup.Spec.ClusterName}, zkCluster); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.Log.Info("Zookeeper cluster of the backup not found, retrying", "namespace", backup.Namespace, "cluster", backup.Spec.ClusterName)
+			return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileJobs(ctx, backup, zkCluster); err != nil {
+		if statusErr := r.setFailed(ctx, backup, "InvalidBackup", err); statusErr != nil {
+			return ctrl.Result{}, statusErr
+		}
+		return ctrl.Result{}, err
+	}
+
+	jobs := &batchv1.JobList{}
+	if err := r.List(ctx, jobs, ctrlclient.InNamespace(backup.Namespace), ctrlclient.MatchingLabels{BackupLabel: backup.Name}); err != nil {
+		return ctrl.Result{}, fmt.Errorf("list jobs of backup %s/%s: %w", backup.Namespace, backup.Name, err)
+	}
+	changed, err := UpdateStatus(ctx, r.Client, backup, jobs.Items)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if changed {
+		if err := r.Status().Update(ctx, backup); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	r.Log.Info("Reconcile successfully ", "Name", backup.Name)
+	return ctrl.Result{}, nil
+}
+
+// reconcileJobs creates the CronJob of a scheduled backup, or the Job of a single backup.
+// The Job of a single backup is never updated, as it records a backup already taken.
+func (r *ZookeeperBackupReconciler) reconcileJobs(ctx context.Context, backup *zkv1alpha1.ZookeeperBackup, zkCluster *zkv1alpha1.ZookeeperCluster) error {
+	s3Connection, err := common.ResolveS3Connection(ctx, r.Client, backup.Namespace, backup.Spec.Bucket)
+	if err != nil {
+		return err
+	}
+	member := backup.Spec.Member
+	if member == "" {
+		if member, err = DefaultMember(zkCluster); err != nil {
+			return err
+		}
+	}
+	dataLog, err := r.hasDataLogVolume(ctx, backup.Namespace, member)
+	if err != nil {
+		return err
+	}
+	jobSpec, err := NewJobSpec(backup, zkCluster, member, dataLog, s3Connection)
+	if err != nil {
+		return err
+	}
+
+	cronJob := &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Namespace: backup.Namespace, Name: backup.Name}}
+	if backup.Spec.Schedule == "" {
+		if err := r.Delete(ctx, cronJob); ctrlclient.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("delete cronjob %s/%s: %w", cronJob.Namespace, cronJob.Name, err)
+		}
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Namespace: backup.Namespace, Name: backup.Name, Labels: JobLabels(backup)},
+			Spec:       *jobSpec,
+		}
+		if err := controllerutil.SetControllerReference(backup, job, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, job); err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("create job %s/%s: %w", job.Namespace, job.Name, err)
+		}
+		return nil
+	}
+
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, cronJob, func() error {
+		cronJob.Labels = JobLabels(backup)
+		cronJob.Spec.Schedule = backup.Spec.Schedule
+		cronJob.Spec.ConcurrencyPolicy = batchv1.ForbidConcurrent
+		cronJob.Spec.SuccessfulJobsHistoryLimit = ptr.To(int32(3))
+		cronJob.Spec.FailedJobsHistoryLimit = ptr.To(int32(1))
+		cronJob.Spec.JobTemplate = batchv1.JobTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: JobLabels(backup)},
+			Spec:       *jobSpec,
+		}
+		return controllerutil.SetControllerReference(backup, cronJob, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("create or update cronjob %s/%s: %w", cronJob.Namespace, cronJob.Name, err)
+	}
+	return nil
+}
+
+// hasDataLogVolume tells whether the member keeps its transaction logs on a volume of their own.
+func (r *ZookeeperBackupReconciler) hasDataLogVolume(ctx context.Context, namespace, member string) (bool, error) {
+	claim := &corev1.PersistentVolumeClaim{}
+	key := ctrlclient.ObjectKey{Namespace: namespace, Name: zkv1alpha1.DataLogDirName + "-" + member}
+	if err := r.Get(ctx, key, claim); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("get pvc %s/%s: %w", key.Namespace, key.Name, err)
+	}
+	return true, nil
+}
+
+// setFailed reports a backup that cannot run in the Succeeded condition.
+func (r *ZookeeperBackupReconciler) setFailed(ctx context.Context, backup *zkv1alpha1.ZookeeperBackup, reason string, err error) error {
+	if !apimeta.SetStatusCondition(&backup.Status.Conditions, metav1.Condition{
+		Type:               zkv1alpha1.BackupConditionSucceeded,
+		Status:             metav1.ConditionFalse,
+		Reason:             reason,
+		Message:            err.Error(),
+		ObservedGeneration: backup.Generation,
+	}) {
+		return nil
+	}
+	return r.Status().Update(ctx, backup)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ZookeeperBackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// the jobs of a CronJob are owned by the CronJob, they are found by their labels
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&zkv1alpha1.ZookeeperBackup{}).
+		Owns(&batchv1.CronJob{}).
+		Watches(
+			&batchv1.Job{},
+			handler.EnqueueRequestsFromMapFunc(jobToBackup),
+		).
+		Complete(r)
+}
+
+// jobToBackup maps a backup job to its ZookeeperBackup.
+func jobToBackup(_ context.Context, obj ctrlclient.Object) []reconcile.Request {
+	name, ok := obj.GetLabels()[BackupLabel]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: ctrlclient.ObjectKey{Namespace: obj.GetNamespace(), Name: name}}}
+}
@@ -5,15 +5,16 @@ import (
 
 	"github.com/zncdatadev/operator-go/pkg/builder"
 	"github.com/zncdatadev/operator-go/pkg/client"
+	"github.com/zncdatadev/operator-go/pkg/constants"
 	"github.com/zncdatadev/operator-go/pkg/reconciler"
 	"github.com/zncdatadev/operator-go/pkg/util"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
 	"github.com/zncdatadev/zookeeper-operator/internal/clustercontroller/server"
 	"github.com/zncdatadev/zookeeper-operator/internal/common"
 	"github.com/zncdatadev/zookeeper-operator/internal/security"
-	zkversion "github.com/zncdatadev/zookeeper-operator/internal/util/version"
 )
 
 var _ reconciler.Reconciler = &Reconciler{}
@@ -22,12 +23,24 @@ type Reconciler struct {
 	reconciler.BaseCluster[*zkv1alpha1.ZookeeperClusterSpec]
 	ClusterConfig *zkv1alpha1.ClusterConfigSpec
 
-	cluster *zkv1alpha1.ZookeeperCluster
+	cluster    *zkv1alpha1.ZookeeperCluster
+	restore    *common.RestoreSource
+	versions   common.ProductVersions
+	partitions common.UpgradePartitions
+	migration  *common.Migration
 }
 
+// NewClusterReconciler returns the reconciler of a cluster. Restore is the archive the servers are restored from, see
+// common.ResolveRestoreSource. Versions pins role groups to the version they run
+// while an upgrade is rolled out, and partitions roll the upgraded role group one server at a time, see VersionUpgrade. Migration is the step the servers join an external ensemble
+// with, see EnsembleMigration.
 func NewClusterReconciler(
 	client *client.Client,
 	cluster *zkv1alpha1.ZookeeperCluster,
+	restore *common.RestoreSource,
+	versions common.ProductVersions,
+	partitions common.UpgradePartitions,
+	migration *common.Migration,
 ) *Reconciler {
 	gvk := cluster.GetObjectKind().GroupVersionKind()
 
@@ -48,52 +61,43 @@ func NewClusterReconciler(
 		),
 		ClusterConfig: cluster.Spec.ClusterConfig,
 
-		cluster: cluster,
+		cluster:    cluster,
+		restore:    restore,
+		versions:   versions,
+		partitions: partitions,
+		migration:  migration,
 	}
 }
 
 func (r *Reconciler) GetImage() *util.Image {
-	productVersion := zkv1alpha1.DefaultProductVersion
-	if r.Spec.Image.ProductVersion != "" {
-		productVersion = r.Spec.Image.ProductVersion
-	}
-
-	image := util.NewImage(
-		zkv1alpha1.DefaultProductName,
-		zkversion.BuildVersion,
-		productVersion,
-		func(options *util.ImageOptions) {
-			options.Custom = r.Spec.Image.Custom
-			options.Repo = r.Spec.Image.Repo
-			options.PullPolicy = *r.Spec.Image.PullPolicy
-		},
-	)
-
-	if r.Spec.Image.KubedoopVersion != "" {
-		image.KubedoopVersion = r.Spec.Image.KubedoopVersion
-	}
-
-	return image
+	return common.ClusterImage(r.Spec.Image)
 }
 
 func (r *Reconciler) RegisterResources(ctx context.Context) error {
 	client := r.GetClient()
 	clusterLables := r.ClusterInfo.GetLabels()
 	annotations := r.ClusterInfo.GetAnnotations()
-	zkSecurity, err := security.NewZookeeperSecurity(ctx, r.Client.Client, r.ClusterConfig)
+	zkSecurity, err := security.NewZookeeperSecurity(ctx, r.Client.Client, r.ClusterInfo.ClusterName, r.ClusterConfig)
 	if err != nil {
 		return err
 	}
+	if err := common.ValidateDiscoveryProfiles(r.ClusterConfig.DiscoveryProfiles, zkSecurity); err != nil {
+		return err
+	}
 	// rbac
 	sa := NewServiceAccountReconciler(*r.Client, clusterLables)
 	r.AddResource(sa)
+
+	if zkSecurity.QuorumDigestEnabled() {
+		r.AddResource(NewQuorumDigestSecretReconciler(r.Client, r.ClusterInfo))
+	}
 	// rb := NewClusterRoleBindingReconciler(*r.Client, clusterLables)
 	// r.AddResource(rb)
 
 	// role
 	// zkServerRole :
 	roleInfo := reconciler.RoleInfo{ClusterInfo: r.ClusterInfo, RoleName: string(common.Server)}
-	zkServerRole := server.NewReconciler(client, roleInfo, r.ClusterOperation, r.ClusterConfig, r.GetImage(), r.Spec.Servers)
+	zkServerRole := server.NewReconciler(client, roleInfo, r.ClusterOperation, r.ClusterConfig, r.GetImage(), r.Spec.Servers, r.restore, r.versions, r.partitions, r.migration)
 	if err := zkServerRole.RegisterResources(ctx); err != nil {
 		return err
 	}
@@ -101,16 +105,30 @@ func (r *Reconciler) RegisterResources(ctx context.Context) error {
 
 	// cluster svc
 	listenerClass := r.ClusterConfig.ListenerClass
+	if common.ExternalStableEnabled(r.ClusterConfig) {
+		// servers are exposed by their own services, the cluster service stays internal
+		listenerClass = constants.ClusterInternal
+	}
 	svc := NewClusterServiceReconciler(r.Client, r.ClusterInfo, listenerClass, zkSecurity)
 	r.AddResource(svc)
 
+	if admin := r.ClusterConfig.Admin; common.AdminServiceEnabled(admin) {
+		r.AddResource(NewAdminServiceReconciler(r.Client, r.ClusterInfo, admin.Service.ListenerClass))
+	} else {
+		r.AddResource(common.NewObsoleteResourceReconciler(r.Client, &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: common.AdminServiceName(r.ClusterInfo.ClusterName)},
+		}))
+	}
+
 	// Add znode root to discovery
 	znodeInfo := &common.ZNodeInfo{
 		Name:      r.cluster.Name,
 		Namespace: r.cluster.Namespace,
 		ZNodePath: "/",
+
+		DiscoveryProfiles: r.ClusterConfig.DiscoveryProfiles,
 	}
-	discoveryReconcilers := common.NewDiscoveryReconcilers(
+	discoveryReconcilers, err := common.NewDiscoveryReconcilers(
 		ctx,
 		client,
 		r.cluster,
@@ -121,6 +139,9 @@ func (r *Reconciler) RegisterResources(ctx context.Context) error {
 			o.Annotations = annotations
 		},
 	)
+	if err != nil {
+		return err
+	}
 	if len(discoveryReconcilers) != 0 {
 		for _, d := range discoveryReconcilers {
 			r.AddResource(d)
@@ -0,0 +1,168 @@
+package cluster
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+// DiskPressureThreshold is the share of a volume the data of a server may use before the cluster reports disk pressure
+const DiskPressureThreshold = 0.8
+
+// diskUsage is the share of a volume used by the data of a server
+type diskUsage struct {
+	server   string
+	volume   string
+	used     int64
+	capacity resource.Quantity
+}
+
+func (u diskUsage) ratio() float64 {
+	return float64(u.used) / float64(u.capacity.Value())
+}
+
+func (u diskUsage) String() string {
+	return fmt.Sprintf("%s %s volume %.0f%% of %s", u.server, u.volume, u.ratio()*100, u.capacity.String())
+}
+
+// DiskPressureCondition reports whether the data of a ready server nears the capacity of its volumes.
+// The sizes of the snapshot and transaction log directories are read from the mntr command of each server
+// on the client port of the client TLS phase the servers were rolled out with, the capacities from its volumes.
+func DiskPressureCondition(
+	ctx context.Context,
+	k8sClient ctrlclient.Client,
+	zkCluster *zkv1alpha1.ZookeeperCluster,
+	clientTlsPhase zkv1alpha1.ClientTlsPhase,
+	fourLetterWord common.FourLetterWordFunc,
+) (*metav1.Condition, error) {
+	condition := &metav1.Condition{
+		Type:               zkv1alpha1.ConditionDiskPressure,
+		Status:             metav1.ConditionUnknown,
+		Reason:             "MetricsUnavailable",
+		ObservedGeneration: zkCluster.Generation,
+	}
+	pods := &corev1.PodList{}
+	if err := k8sClient.List(ctx, pods,
+		ctrlclient.InNamespace(zkCluster.Namespace),
+		ctrlclient.MatchingLabels{
+			constants.LabelKubernetesInstance:  zkCluster.Name,
+			constants.LabelKubernetesComponent: string(common.Server),
+		},
+	); err != nil {
+		return nil, err
+	}
+	clusterDomain := common.ClusterDomain(zkCluster.Spec.ClusterConfig)
+
+	var usages []diskUsage
+	var unavailable []string
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !podReady(pod) {
+			continue
+		}
+		address := common.ClientAddress(common.PodFQDN(pod.Name, pod.Spec.Subdomain, pod.Namespace, clusterDomain), clientTlsPhase)
+		out, err := fourLetterWord(ctx, address, "mntr")
+		if err != nil {
+			unavailable = append(unavailable, pod.Name)
+			continue
+		}
+		serverUsages, err := podDiskUsage(ctx, k8sClient, pod, common.ParseMntr(out))
+		if err != nil {
+			return nil, err
+		}
+		if serverUsages == nil {
+			unavailable = append(unavailable, pod.Name)
+			continue
+		}
+		usages = append(usages, serverUsages...)
+	}
+	if len(usages) == 0 {
+		condition.Message = "no server reported its disk usage"
+		if len(unavailable) != 0 {
+			condition.Message += ", unavailable: " + strings.Join(unavailable, ", ")
+		}
+		return condition, nil
+	}
+
+	slices.SortFunc(usages, func(a, b diskUsage) int {
+		return cmp.Or(strings.Compare(a.server, b.server), strings.Compare(a.volume, b.volume))
+	})
+	var pressured []string
+	highest := usages[0]
+	for _, usage := range usages {
+		if usage.ratio() >= DiskPressureThreshold {
+			pressured = append(pressured, usage.String())
+		}
+		if usage.ratio() > highest.ratio() {
+			highest = usage
+		}
+	}
+	if len(pressured) != 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "DiskUsageHigh"
+		condition.Message = fmt.Sprintf("data nears the volume capacity: %s", strings.Join(pressured, ", "))
+		return condition, nil
+	}
+	condition.Status = metav1.ConditionFalse
+	condition.Reason = "DiskUsageNormal"
+	condition.Message = fmt.Sprintf("highest usage: %s", highest.String())
+	return condition, nil
+}
+
+// podDiskUsage returns the usage of the data volumes of a server, nil if mntr does not report the directory sizes.
+// Without a transaction log volume both directories are the same, and so are their sizes.
+func podDiskUsage(ctx context.Context, k8sClient ctrlclient.Client, pod *corev1.Pod, metrics map[string]string) ([]diskUsage, error) {
+	dataSize, err := strconv.ParseInt(metrics["zk_data_dir_size"], 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+	logSize, err := strconv.ParseInt(metrics["zk_log_dir_size"], 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+
+	dataCapacity, err := volumeCapacity(ctx, k8sClient, pod, zkv1alpha1.DataDirName)
+	if err != nil || dataCapacity == nil {
+		return nil, err
+	}
+	logCapacity, err := volumeCapacity(ctx, k8sClient, pod, zkv1alpha1.DataLogDirName)
+	if err != nil {
+		return nil, err
+	}
+	if logCapacity == nil {
+		return []diskUsage{{server: pod.Name, volume: zkv1alpha1.DataDirName, used: max(dataSize, logSize), capacity: *dataCapacity}}, nil
+	}
+	return []diskUsage{
+		{server: pod.Name, volume: zkv1alpha1.DataDirName, used: dataSize, capacity: *dataCapacity},
+		{server: pod.Name, volume: zkv1alpha1.DataLogDirName, used: logSize, capacity: *logCapacity},
+	}, nil
+}
+
+// volumeCapacity returns the capacity of a volume of a server, nil if it has no such volume.
+func volumeCapacity(ctx context.Context, k8sClient ctrlclient.Client, pod *corev1.Pod, claimName string) (*resource.Quantity, error) {
+	pvc := &corev1.PersistentVolumeClaim{}
+	key := ctrlclient.ObjectKey{Namespace: pod.Namespace, Name: claimName + "-" + pod.Name}
+	if err := k8sClient.Get(ctx, key, pvc); err != nil {
+		return nil, ctrlclient.IgnoreNotFound(err)
+	}
+	capacity := pvc.Status.Capacity.Storage()
+	if capacity.IsZero() {
+		capacity = pvc.Spec.Resources.Requests.Storage()
+	}
+	if capacity.IsZero() {
+		return nil, nil
+	}
+	return capacity, nil
+}
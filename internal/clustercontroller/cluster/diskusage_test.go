@@ -0,0 +1,118 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+var _ = Describe("Disk pressure", func() {
+	ctx := context.Background()
+	zkCluster := &zkv1alpha1.ZookeeperCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default", Generation: 2},
+		Spec:       zkv1alpha1.ZookeeperClusterSpec{ClusterConfig: &zkv1alpha1.ClusterConfigSpec{ClusterDomain: "cluster.local"}},
+	}
+	pod := func(name string, ready bool) *corev1.Pod {
+		status := corev1.ConditionFalse
+		if ready {
+			status = corev1.ConditionTrue
+		}
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Labels: map[string]string{
+					constants.LabelKubernetesInstance:  "simple",
+					constants.LabelKubernetesComponent: "server",
+				},
+			},
+			Spec:   corev1.PodSpec{Subdomain: "simple-server-default"},
+			Status: corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}},
+		}
+	}
+	pvc := func(name, capacity string) *corev1.PersistentVolumeClaim {
+		claim := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+		claim.Status.Capacity = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(capacity)}
+		return claim
+	}
+	// mntr reports the sizes of the data and log directories per pod on the client port of the phase
+	mntr := func(phase zkv1alpha1.ClientTlsPhase, sizes map[string][2]int64) func(context.Context, string, string) (string, error) {
+		return func(_ context.Context, address, word string) (string, error) {
+			Expect(word).To(Equal("mntr"))
+			name, _, _ := strings.Cut(strings.TrimPrefix(address, "tls://"), ".")
+			Expect(address).To(Equal(common.ClientAddress(
+				fmt.Sprintf("%s.simple-server-default.default.svc.cluster.local", name), phase)))
+			size, ok := sizes[name]
+			if !ok {
+				return "", errors.New("connection refused")
+			}
+			return fmt.Sprintf("zk_version\t3.9.2\nzk_data_dir_size\t%d\nzk_log_dir_size\t%d\n", size[0], size[1]), nil
+		}
+	}
+
+	It("reports the highest usage of ready servers", func() {
+		c := newClient(
+			pod("simple-server-default-0", true),
+			pod("simple-server-default-1", false),
+			pvc("data-simple-server-default-0", "1Gi"),
+			pvc("data-simple-server-default-1", "1Gi"),
+		)
+		condition, err := DiskPressureCondition(ctx, c, zkCluster, zkv1alpha1.ClientTlsPhasePlaintext, mntr(zkv1alpha1.ClientTlsPhasePlaintext, map[string][2]int64{
+			"simple-server-default-0": {100 << 20, 300 << 20},
+			"simple-server-default-1": {1 << 30, 1 << 30},
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(condition.Type).To(Equal(zkv1alpha1.ConditionDiskPressure))
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.ObservedGeneration).To(Equal(int64(2)))
+		Expect(condition.Message).To(Equal("highest usage: simple-server-default-0 data volume 29% of 1Gi"))
+	})
+
+	It("warns when the data nears the capacity of a volume", func() {
+		c := newClient(
+			pod("simple-server-default-0", true),
+			pod("simple-server-default-1", true),
+			pvc("data-simple-server-default-0", "1Gi"),
+			pvc("txnlog-simple-server-default-0", "1Gi"),
+			pvc("data-simple-server-default-1", "1Gi"),
+			pvc("txnlog-simple-server-default-1", "1Gi"),
+		)
+		condition, err := DiskPressureCondition(ctx, c, zkCluster, zkv1alpha1.ClientTlsPhasePlaintext, mntr(zkv1alpha1.ClientTlsPhasePlaintext, map[string][2]int64{
+			"simple-server-default-0": {100 << 20, 900 << 20},
+			"simple-server-default-1": {100 << 20, 100 << 20},
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(condition.Reason).To(Equal("DiskUsageHigh"))
+		Expect(condition.Message).To(Equal("data nears the volume capacity: simple-server-default-0 txnlog volume 88% of 1Gi"))
+	})
+
+	It("does not know the usage when no server reports it", func() {
+		c := newClient(pod("simple-server-default-0", true), pvc("data-simple-server-default-0", "1Gi"))
+		condition, err := DiskPressureCondition(ctx, c, zkCluster, zkv1alpha1.ClientTlsPhasePlaintext, mntr(zkv1alpha1.ClientTlsPhasePlaintext, nil))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(condition.Status).To(Equal(metav1.ConditionUnknown))
+		Expect(condition.Message).To(ContainSubstring("unavailable: simple-server-default-0"))
+
+	})
+
+	It("reads the usage of servers that only serve TLS on the TLS client port", func() {
+		c := newClient(pod("simple-server-default-0", true), pvc("data-simple-server-default-0", "1Gi"))
+		condition, err := DiskPressureCondition(ctx, c, zkCluster, zkv1alpha1.ClientTlsPhaseTls, mntr(zkv1alpha1.ClientTlsPhaseTls,
+			map[string][2]int64{"simple-server-default-0": {900 << 20, 900 << 20}}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(condition.Message).To(Equal("data nears the volume capacity: simple-server-default-0 data volume 88% of 1Gi"))
+	})
+})
@@ -0,0 +1,336 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/zncdatadev/operator-go/pkg/reconciler"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+	"github.com/zncdatadev/zookeeper-operator/internal/security"
+)
+
+var migrationLogger = ctrl.Log.WithName("ensemble-migration")
+
+// MigrationPlan is the step of a migration the servers are configured for.
+type MigrationPlan struct {
+	// Migration is the step the servers are configured for, nil without a migration.
+	Migration *common.Migration
+	// Status records the step, nil without a migration.
+	Status *zkv1alpha1.MigrationStatus
+	// Condition reports the progress of the migration, nil if the cluster was never migrated.
+	Condition *metav1.Condition
+}
+
+// InProgress tells whether the servers are still being moved to the requested step.
+func (p *MigrationPlan) InProgress() bool {
+	return p.Condition != nil && p.Condition.Status == metav1.ConditionTrue
+}
+
+// EnsembleMigration joins the servers to an external ensemble and retires the external servers, see MigrationSpec.
+// Every step changes the `zoo.cfg` of the servers, which restarts them one at a time. The next step is only taken
+// once the servers rolled out: the servers are promoted once every observer serves, and an external server is only
+// retired while the ensemble has a leader with all followers in sync.
+//
+// The promotion and every retirement are also held until the external servers report the membership of the step
+// with `conf`. Were the servers to take the step first, the external servers would still count the votes of the old
+// membership, and a majority of each could elect its own leader.
+type EnsembleMigration struct {
+	client         ctrlclient.Client
+	zkCluster      *zkv1alpha1.ZookeeperCluster
+	clientTlsPhase zkv1alpha1.ClientTlsPhase
+	fourLetterWord common.FourLetterWordFunc
+}
+
+func NewEnsembleMigration(
+	client ctrlclient.Client,
+	zkCluster *zkv1alpha1.ZookeeperCluster,
+	clientTlsPhase zkv1alpha1.ClientTlsPhase,
+	fourLetterWord common.FourLetterWordFunc,
+) *EnsembleMigration {
+	return &EnsembleMigration{
+		client:         client,
+		zkCluster:      zkCluster,
+		clientTlsPhase: clientTlsPhase,
+		fourLetterWord: fourLetterWord,
+	}
+}
+
+// Plan decides the step of the migration the servers are configured for, and takes the next step when it is safe.
+func (m *EnsembleMigration) Plan(ctx context.Context) (*MigrationPlan, error) {
+	clusterConfig := m.zkCluster.Spec.ClusterConfig
+	observed := m.zkCluster.Status.Migration
+	if clusterConfig == nil || clusterConfig.Migration == nil {
+		if observed == nil {
+			return &MigrationPlan{}, nil
+		}
+		return &MigrationPlan{Condition: m.condition(metav1.ConditionFalse, "Migrated", "servers run without the external ensemble")}, nil
+	}
+	if err := common.ValidateMigration(clusterConfig, m.zkCluster.Spec.Servers); err != nil {
+		return nil, err
+	}
+	spec := clusterConfig.Migration
+
+	requested := spec.Phase
+	if requested == "" {
+		requested = zkv1alpha1.MigrationObserve
+	}
+	current := zkv1alpha1.MigrationObserve
+	var retired []int32
+	if observed != nil {
+		if observed.Phase != "" {
+			current = observed.Phase
+		}
+		// servers taken back from retirement rejoin the ensemble right away
+		for _, server := range spec.ExternalServers {
+			if server.Retire && slices.Contains(observed.RetiredServers, server.ID) {
+				retired = append(retired, server.ID)
+			}
+		}
+	}
+
+	rolledOut, err := m.rolledOut(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	phase := current
+	var condition *metav1.Condition
+	// the external servers are shown the membership of a step that waits for them
+	var serverConfig []string
+	switch {
+	case requested == current:
+	case requested == zkv1alpha1.MigrationObserve && len(retired) > 0:
+		condition = m.condition(metav1.ConditionFalse, "UnsupportedStep",
+			fmt.Sprintf("servers stay participants, external servers %v are retired", retired))
+	case requested == zkv1alpha1.MigrationObserve:
+		phase = requested
+		condition = m.condition(metav1.ConditionTrue, "Demoting", "servers become observers again")
+	case observed == nil || !rolledOut:
+		condition = m.condition(metav1.ConditionTrue, "WaitingForObservers", "waiting for every observer to roll out and serve")
+	default:
+		next := m.migration(requested, retired)
+		if synced, reason := m.externalMembership(ctx, next, next.ExternalServers); !synced {
+			condition = m.condition(metav1.ConditionTrue, "WaitingForExternalServers",
+				"promoting the servers, waiting for the external servers to have MigrationStatus.ServerConfig: "+reason)
+			serverConfig = m.serverConfig(next)
+			break
+		}
+		phase = requested
+		condition = m.condition(metav1.ConditionTrue, "Promoting", "servers become participants")
+		migrationLogger.Info("Promoting the observers to participants", "namespace", m.zkCluster.Namespace, "name", m.zkCluster.Name)
+	}
+
+	// external servers are retired one at a time, once the servers participate in the ensemble
+	var pending []int32
+	for _, server := range spec.ExternalServers {
+		if server.Retire && !slices.Contains(retired, server.ID) {
+			pending = append(pending, server.ID)
+		}
+	}
+	slices.Sort(pending)
+	if condition == nil && len(pending) > 0 {
+		switch {
+		case current != zkv1alpha1.MigrationParticipate:
+			condition = m.condition(metav1.ConditionFalse, "Observing",
+				fmt.Sprintf("external servers %v are retired once the servers participate", pending))
+		case !rolledOut:
+			condition = m.condition(metav1.ConditionTrue, "RollingOut", "waiting for the servers to roll out")
+		default:
+			healthy, reason := m.ensembleHealthy(ctx, spec.ExternalServers, retired)
+			if !healthy {
+				condition = m.condition(metav1.ConditionTrue, "WaitingForQuorum",
+					fmt.Sprintf("retiring external server %d, waiting for a healthy quorum: %s", pending[0], reason))
+				break
+			}
+			next := m.migration(current, append(slices.Clone(retired), pending[0]))
+			if synced, reason := m.externalMembership(ctx, next, next.ExternalServers); !synced {
+				condition = m.condition(metav1.ConditionTrue, "WaitingForExternalServers",
+					fmt.Sprintf("retiring external server %d, waiting for the other external servers to have "+
+						"MigrationStatus.ServerConfig: %s", pending[0], reason))
+				serverConfig = m.serverConfig(next)
+				break
+			}
+			retired = append(retired, pending[0])
+			slices.Sort(retired)
+			condition = m.condition(metav1.ConditionTrue, "Retiring", fmt.Sprintf("retiring external server %d", pending[0]))
+			migrationLogger.Info("Retiring external server", "namespace", m.zkCluster.Namespace, "name", m.zkCluster.Name, "id", pending[0])
+		}
+	}
+
+	migration := m.migration(phase, retired)
+	if condition == nil {
+		condition = m.settledCondition(migration, retired, rolledOut)
+	}
+	if serverConfig == nil {
+		serverConfig = m.serverConfig(migration)
+	}
+	return &MigrationPlan{
+		Migration: migration,
+		Status: &zkv1alpha1.MigrationStatus{
+			Phase:          phase,
+			RetiredServers: retired,
+			ServerConfig:   serverConfig,
+		},
+		Condition: condition,
+	}, nil
+}
+
+// migration returns the step of the given phase without the retired external servers.
+func (m *EnsembleMigration) migration(phase zkv1alpha1.MigrationPhase, retired []int32) *common.Migration {
+	migration := &common.Migration{Phase: phase}
+	for _, server := range m.zkCluster.Spec.ClusterConfig.Migration.ExternalServers {
+		if !slices.Contains(retired, server.ID) {
+			migration.ExternalServers = append(migration.ExternalServers, server)
+		}
+	}
+	return migration
+}
+
+// rolledOut checks that the servers exist and run the latest pod template.
+func (m *EnsembleMigration) rolledOut(ctx context.Context) (bool, error) {
+	statefulSets, err := listStatefulSets(ctx, m.client, m.zkCluster)
+	if err != nil || len(statefulSets) == 0 {
+		return false, err
+	}
+	return StatefulSetsRolledOut(ctx, m.client, m.zkCluster)
+}
+
+// settledCondition reports the step the servers are in when no step is pending.
+func (m *EnsembleMigration) settledCondition(migration *common.Migration, retired []int32, rolledOut bool) *metav1.Condition {
+	switch {
+	case !rolledOut:
+		return m.condition(metav1.ConditionTrue, "RollingOut", "waiting for the servers to roll out")
+	case migration.Observing():
+		return m.condition(metav1.ConditionFalse, "Observing", "servers observe the external ensemble")
+	case len(migration.ExternalServers) == 0:
+		return m.condition(metav1.ConditionFalse, "Migrated", "every external server is retired, remove the migration from the cluster")
+	case len(retired) > 0:
+		return m.condition(metav1.ConditionFalse, "Participating",
+			fmt.Sprintf("servers participate in the external ensemble, external servers %v are retired", retired))
+	default:
+		return m.condition(metav1.ConditionFalse, "Participating", "servers participate in the external ensemble")
+	}
+}
+
+func (m *EnsembleMigration) condition(status metav1.ConditionStatus, reason, message string) *metav1.Condition {
+	return &metav1.Condition{
+		Type:               zkv1alpha1.ConditionMigrating,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: m.zkCluster.Generation,
+	}
+}
+
+// ensembleHealthy checks that the servers of the cluster and the remaining external servers agree on one leader with
+// all followers in sync. The servers of the cluster are asked on the client port of their client TLS phase, and are
+// ready, as they rolled out. A server that does not answer makes the ensemble unhealthy, as its state is not known.
+func (m *EnsembleMigration) ensembleHealthy(ctx context.Context, externalServers []zkv1alpha1.ExternalServerSpec, retired []int32) (bool, string) {
+	servers := map[string]string{}
+	for _, server := range m.servers() {
+		servers[server.name] = common.ClientAddress(server.host, m.clientTlsPhase)
+	}
+	for _, server := range externalServers {
+		if slices.Contains(retired, server.ID) {
+			continue
+		}
+		servers[fmt.Sprintf("external-%d", server.ID)] = externalAddress(server)
+	}
+	return leaderInSync(ctx, servers, m.fourLetterWord)
+}
+
+// externalMembership asks the external servers with `conf` whether they run with the membership of a step: the same
+// servers, each one a participant or an observer as in the step. It returns the external servers that do not.
+func (m *EnsembleMigration) externalMembership(
+	ctx context.Context,
+	migration *common.Migration,
+	externalServers []zkv1alpha1.ExternalServerSpec,
+) (bool, string) {
+	expected := map[string]string{}
+	for _, server := range migration.ExternalServers {
+		expected[common.ZooServerKey(server.ID)] = "participant"
+	}
+	for _, server := range m.servers() {
+		expected[common.ZooServerKey(server.id)] = "participant"
+		if migration.Observing() {
+			expected[common.ZooServerKey(server.id)] = "observer"
+		}
+	}
+	var outdated []string
+	for _, server := range externalServers {
+		name := fmt.Sprintf("external-%d", server.ID)
+		out, err := m.fourLetterWord(ctx, externalAddress(server), "conf")
+		if err != nil {
+			outdated = append(outdated, fmt.Sprintf("%s does not answer", name))
+			continue
+		}
+		if !maps.Equal(common.ParseConfMembership(out), expected) {
+			outdated = append(outdated, name)
+		}
+	}
+	if len(outdated) > 0 {
+		return false, strings.Join(outdated, ", ")
+	}
+	return true, ""
+}
+
+// externalAddress returns the plaintext client address of an external server.
+func externalAddress(server zkv1alpha1.ExternalServerSpec) string {
+	clientPort := server.ClientPort
+	if clientPort == 0 {
+		clientPort = zkv1alpha1.ClientPort
+	}
+	return fmt.Sprintf("%s:%d", server.Host, clientPort)
+}
+
+type ensembleServer struct {
+	id   int32
+	name string
+	host string
+}
+
+// servers returns the servers of the single role group of a migrating cluster, see ValidateMigration.
+func (m *EnsembleMigration) servers() []ensembleServer {
+	var servers []ensembleServer
+	clusterConfig := m.zkCluster.Spec.ClusterConfig
+	for name, roleGroup := range m.zkCluster.Spec.Servers.RoleGroups {
+		info := &reconciler.RoleGroupInfo{
+			RoleInfo:      reconciler.RoleInfo{ClusterInfo: reconciler.ClusterInfo{ClusterName: m.zkCluster.Name}, RoleName: string(common.Server)},
+			RoleGroupName: name,
+		}
+		for i := range roleGroup.Replicas {
+			podName := fmt.Sprintf("%s-%d", common.StatefulsetName(info), i)
+			host := common.PodFQDN(podName, common.RoleGroupServiceName(info), m.zkCluster.Namespace, common.ClusterDomain(clusterConfig))
+			servers = append(servers, ensembleServer{id: common.MyidOffset(m.zkCluster.Spec.Servers, name) + i, name: podName, host: host})
+		}
+	}
+	return servers
+}
+
+// serverConfig returns the `server.<id>` lines of the ensemble the servers are configured with, ordered by id.
+func (m *EnsembleMigration) serverConfig(migration *common.Migration) []string {
+	servers := migration.ZooServers()
+	ids := make([]int32, 0, len(servers))
+	for _, server := range migration.ExternalServers {
+		ids = append(ids, server.ID)
+	}
+	clientPort := security.ZooServerClientPortOf(m.clientTlsPhase)
+	for _, server := range m.servers() {
+		servers[common.ZooServerKey(server.id)] = common.ZooServer(server.host, zkv1alpha1.LeaderPort, zkv1alpha1.ElectionPort, migration.Observing(), clientPort)
+		ids = append(ids, server.id)
+	}
+	slices.Sort(ids)
+	lines := make([]string, 0, len(ids))
+	for _, id := range ids {
+		lines = append(lines, common.ZooServerKey(id)+"="+servers[common.ZooServerKey(id)])
+	}
+	return lines
+}
@@ -0,0 +1,209 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	appv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+var _ = Describe("Ensemble migration", func() {
+	ctx := context.Background()
+	var zkCluster *zkv1alpha1.ZookeeperCluster
+	// membership are the `server.<id>` lines the external servers run with
+	var membership []string
+	BeforeEach(func() {
+		membership = nil
+		zkCluster = &zkv1alpha1.ZookeeperCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default"},
+			Spec: zkv1alpha1.ZookeeperClusterSpec{
+				ClusterConfig: &zkv1alpha1.ClusterConfigSpec{
+					ClusterDomain: "cluster.local",
+					Migration: &zkv1alpha1.MigrationSpec{ExternalServers: []zkv1alpha1.ExternalServerSpec{
+						{ID: 1, Host: "zk-1.example.com"},
+						{ID: 2, Host: "zk-2.example.com"},
+					}},
+				},
+				Servers: &zkv1alpha1.ServerSpec{
+					Config:     &zkv1alpha1.ConfigSpec{MyidOffset: 4},
+					RoleGroups: map[string]zkv1alpha1.RoleGroupSpec{"default": {Replicas: 1}},
+				},
+			},
+		}
+	})
+	statefulSet := func(rolledOut bool) *appv1.StatefulSet {
+		sts := &appv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{
+			Name:      "simple-server-default",
+			Namespace: "default",
+			Labels:    map[string]string{constants.LabelKubernetesInstance: "simple", constants.LabelKubernetesComponent: "server"},
+		}}
+		sts.Spec.Replicas = ptr.To(int32(1))
+		sts.Status = appv1.StatefulSetStatus{CurrentRevision: "1", UpdateRevision: "1", UpdatedReplicas: 1, ReadyReplicas: 1}
+		if !rolledOut {
+			sts.Status.UpdateRevision = "2"
+		}
+		return sts
+	}
+	// mntr answers with zk-1 leading the given number of synced followers, and conf with the membership
+	mntr := func(syncedFollowers int) common.FourLetterWordFunc {
+		leader := leaderMntr("zk-1", syncedFollowers)
+		return func(ctx context.Context, address, word string) (string, error) {
+			if word == "conf" {
+				return "membership: \n" + strings.Join(membership, "\n") + "\nversion=0\n", nil
+			}
+			return leader(ctx, address, word)
+		}
+	}
+	plan := func(k8sClient ctrlclient.Client, syncedFollowers int) *MigrationPlan {
+		plan, err := NewEnsembleMigration(k8sClient, zkCluster, zkv1alpha1.ClientTlsPhasePlaintext, mntr(syncedFollowers)).Plan(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		zkCluster.Status.Migration = plan.Status
+		return plan
+	}
+
+	It("does nothing without a migration", func() {
+		zkCluster.Spec.ClusterConfig.Migration = nil
+		p := plan(newClient(), 0)
+		Expect(p.Migration).To(BeNil())
+		Expect(p.Condition).To(BeNil())
+	})
+
+	It("joins the servers as observers and promotes them once they serve", func() {
+		p := plan(newClient(), 0)
+		Expect(p.Migration.Phase).To(Equal(zkv1alpha1.MigrationObserve))
+		Expect(p.Migration.ExternalServers).To(HaveLen(2))
+		Expect(p.Status.ServerConfig).To(Equal([]string{
+			"server.1=zk-1.example.com:2888:3888;2181",
+			"server.2=zk-2.example.com:2888:3888;2181",
+			"server.4=simple-server-default-0.simple-server-default.default.svc.cluster.local:2888:3888:observer;2181",
+		}))
+
+		zkCluster.Spec.ClusterConfig.Migration.Phase = zkv1alpha1.MigrationParticipate
+		p = plan(newClient(statefulSet(false)), 0)
+		Expect(p.Migration.Phase).To(Equal(zkv1alpha1.MigrationObserve))
+		Expect(p.Condition.Reason).To(Equal("WaitingForObservers"))
+		Expect(p.InProgress()).To(BeTrue())
+
+		// the external servers still count the servers as observers
+		membership = p.Status.ServerConfig
+		p = plan(newClient(statefulSet(true)), 0)
+		Expect(p.Migration.Phase).To(Equal(zkv1alpha1.MigrationObserve))
+		Expect(p.Condition.Reason).To(Equal("WaitingForExternalServers"))
+		Expect(p.Condition.Message).To(ContainSubstring("external-1, external-2"))
+		Expect(p.Status.Phase).To(Equal(zkv1alpha1.MigrationObserve))
+		Expect(p.Status.ServerConfig[2]).To(HaveSuffix(":2888:3888;2181"))
+
+		membership = p.Status.ServerConfig
+		p = plan(newClient(statefulSet(true)), 0)
+		Expect(p.Migration.Phase).To(Equal(zkv1alpha1.MigrationParticipate))
+		Expect(p.Condition.Reason).To(Equal("Promoting"))
+		Expect(p.Status.ServerConfig[2]).To(HaveSuffix(":2888:3888;2181"))
+
+		p = plan(newClient(statefulSet(true)), 0)
+		Expect(p.Condition.Reason).To(Equal("Participating"))
+		Expect(p.InProgress()).To(BeFalse())
+	})
+
+	It("does not promote servers that never observed", func() {
+		zkCluster.Spec.ClusterConfig.Migration.Phase = zkv1alpha1.MigrationParticipate
+		p := plan(newClient(statefulSet(true)), 0)
+		Expect(p.Migration.Phase).To(Equal(zkv1alpha1.MigrationObserve))
+		Expect(p.Condition.Reason).To(Equal("WaitingForObservers"))
+	})
+
+	It("retires the external servers one at a time while the quorum is healthy", func() {
+		zkCluster.Status.Migration = &zkv1alpha1.MigrationStatus{Phase: zkv1alpha1.MigrationParticipate}
+		zkCluster.Spec.ClusterConfig.Migration.Phase = zkv1alpha1.MigrationParticipate
+		zkCluster.Spec.ClusterConfig.Migration.ExternalServers[0].Retire = true
+		zkCluster.Spec.ClusterConfig.Migration.ExternalServers[1].Retire = true
+
+		p := plan(newClient(statefulSet(true)), 1)
+		Expect(p.Condition.Reason).To(Equal("WaitingForQuorum"))
+		Expect(p.Condition.Message).To(ContainSubstring("1 of 2 followers in sync"))
+		Expect(p.Status.RetiredServers).To(BeEmpty())
+
+		p = plan(newClient(statefulSet(true)), 2)
+		Expect(p.Condition.Reason).To(Equal("WaitingForExternalServers"))
+		Expect(p.Condition.Message).To(ContainSubstring("external-2"))
+		Expect(p.Condition.Message).NotTo(ContainSubstring("external-1"))
+		Expect(p.Status.RetiredServers).To(BeEmpty())
+		Expect(p.Status.ServerConfig).NotTo(ContainElement(HavePrefix("server.1=")))
+
+		membership = p.Status.ServerConfig
+		p = plan(newClient(statefulSet(true)), 2)
+		Expect(p.Condition.Reason).To(Equal("Retiring"))
+		Expect(p.Status.RetiredServers).To(Equal([]int32{1}))
+		Expect(p.Migration.ExternalServers).To(ConsistOf(HaveField("ID", int32(2))))
+
+		p = plan(newClient(statefulSet(false)), 2)
+		Expect(p.Condition.Reason).To(Equal("RollingOut"))
+		Expect(p.Status.RetiredServers).To(Equal([]int32{1}))
+
+		// the leader is gone with zk-1, zk-2 and the server follow one leader among them
+		p = plan(newClient(statefulSet(true)), 0)
+		Expect(p.Condition.Reason).To(Equal("WaitingForQuorum"))
+		Expect(p.Condition.Message).To(ContainSubstring("0 leaders"))
+	})
+
+	It("retires no external server while the servers of a TLS cluster do not answer", func() {
+		zkCluster.Status.Migration = &zkv1alpha1.MigrationStatus{Phase: zkv1alpha1.MigrationParticipate}
+		zkCluster.Spec.ClusterConfig.Migration.Phase = zkv1alpha1.MigrationParticipate
+		zkCluster.Spec.ClusterConfig.Migration.ExternalServers[0].Retire = true
+		membership = []string{
+			"server.2=zk-2.example.com:2888:3888:participant;0.0.0.0:2181",
+			"server.4=simple-server-default-0.simple-server-default.default.svc.cluster.local:2888:3888:participant;0.0.0.0:2282",
+		}
+		secureServer := fmt.Sprintf("tls://simple-server-default-0.simple-server-default.default.svc.cluster.local:%d", zkv1alpha1.SecureClientPort)
+		answering := true
+		fourLetterWord := func(ctx context.Context, address, word string) (string, error) {
+			if strings.HasPrefix(address, "tls://") {
+				Expect(address).To(Equal(secureServer))
+				if !answering {
+					return "", errors.New("connection refused")
+				}
+			}
+			return mntr(2)(ctx, address, word)
+		}
+
+		answering = false
+		p, err := NewEnsembleMigration(newClient(statefulSet(true)), zkCluster, zkv1alpha1.ClientTlsPhaseTls, fourLetterWord).Plan(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.Condition.Reason).To(Equal("WaitingForQuorum"))
+		Expect(p.Condition.Message).To(ContainSubstring("simple-server-default-0 does not answer"))
+		Expect(p.Status.RetiredServers).To(BeEmpty())
+
+		answering = true
+		p, err = NewEnsembleMigration(newClient(statefulSet(true)), zkCluster, zkv1alpha1.ClientTlsPhaseTls, fourLetterWord).Plan(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.Condition.Reason).To(Equal("Retiring"))
+		Expect(p.Status.RetiredServers).To(Equal([]int32{1}))
+	})
+
+	It("reports the migration as done once every external server is retired", func() {
+		zkCluster.Status.Migration = &zkv1alpha1.MigrationStatus{Phase: zkv1alpha1.MigrationParticipate, RetiredServers: []int32{1, 2}}
+		zkCluster.Spec.ClusterConfig.Migration.Phase = zkv1alpha1.MigrationParticipate
+		zkCluster.Spec.ClusterConfig.Migration.ExternalServers[0].Retire = true
+		zkCluster.Spec.ClusterConfig.Migration.ExternalServers[1].Retire = true
+
+		p := plan(newClient(statefulSet(true)), 0)
+		Expect(p.Migration.ExternalServers).To(BeEmpty())
+		Expect(p.Condition.Reason).To(Equal("Migrated"))
+		Expect(p.Status.ServerConfig).To(HaveLen(1))
+
+		zkCluster.Spec.ClusterConfig.Migration.Phase = zkv1alpha1.MigrationObserve
+		p = plan(newClient(statefulSet(true)), 0)
+		Expect(p.Migration.Phase).To(Equal(zkv1alpha1.MigrationParticipate))
+		Expect(p.Condition.Reason).To(Equal("UnsupportedStep"))
+	})
+})
@@ -0,0 +1,209 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/zncdatadev/operator-go/pkg/builder"
+	"github.com/zncdatadev/operator-go/pkg/client"
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	"github.com/zncdatadev/operator-go/pkg/reconciler"
+	"github.com/zncdatadev/operator-go/pkg/util"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+	"github.com/zncdatadev/zookeeper-operator/internal/security"
+)
+
+// quorumSaslStages is the order in which quorum SASL is rolled out, and reversed, rolled back.
+var quorumSaslStages = []zkv1alpha1.QuorumSaslStage{
+	zkv1alpha1.QuorumSaslDisabled,
+	zkv1alpha1.QuorumSaslEnabled,
+	zkv1alpha1.QuorumSaslLearnerRequired,
+	zkv1alpha1.QuorumSaslRequired,
+}
+
+// RequestedQuorumSaslStage returns the quorum SASL stage requested in the spec.
+func RequestedQuorumSaslStage(clusterConfig *zkv1alpha1.ClusterConfigSpec) zkv1alpha1.QuorumSaslStage {
+	if clusterConfig == nil || clusterConfig.QuorumAuthentication == nil {
+		return zkv1alpha1.QuorumSaslDisabled
+	}
+	if clusterConfig.QuorumAuthentication.Stage == "" {
+		return zkv1alpha1.QuorumSaslRequired
+	}
+	return clusterConfig.QuorumAuthentication.Stage
+}
+
+// NextQuorumSaslStage returns the stage one step from observed towards requested.
+// Servers of adjacent stages can still form a quorum, so the ensemble stays available during the rolling restart.
+func NextQuorumSaslStage(observed, requested zkv1alpha1.QuorumSaslStage) zkv1alpha1.QuorumSaslStage {
+	from := slices.Index(quorumSaslStages, observed)
+	to := slices.Index(quorumSaslStages, requested)
+	switch {
+	case from < 0 || to < 0 || from == to:
+		return requested
+	case from < to:
+		return quorumSaslStages[from+1]
+	default:
+		return quorumSaslStages[from-1]
+	}
+}
+
+// ObservedQuorumSaslStage returns the quorum SASL stage the servers are running with.
+// Clusters without a recorded stage are running without quorum SASL if their statefulsets already exist,
+// new clusters can start with the requested stage right away.
+func ObservedQuorumSaslStage(
+	ctx context.Context,
+	k8sClient ctrlclient.Client,
+	zkCluster *zkv1alpha1.ZookeeperCluster,
+) (zkv1alpha1.QuorumSaslStage, error) {
+	if zkCluster.Status.QuorumSaslStage != "" {
+		return zkCluster.Status.QuorumSaslStage, nil
+	}
+	statefulSets, err := listStatefulSets(ctx, k8sClient, zkCluster)
+	if err != nil {
+		return "", err
+	}
+	if len(statefulSets) > 0 {
+		return zkv1alpha1.QuorumSaslDisabled, nil
+	}
+	return RequestedQuorumSaslStage(zkCluster.Spec.ClusterConfig), nil
+}
+
+// NextQuorumAuthentication returns the quorum authentication the servers are rolled with next, one stage from observed
+// towards the requested stage, see NextQuorumSaslStage. The servers keep authenticating with the Kerberos SecretClass
+// recorded in status, or digest, until they run without quorum SASL, also when the section is removed. A change of the
+// mechanism is refused before, as the rolled servers could no longer authenticate to the others.
+func NextQuorumAuthentication(
+	zkCluster *zkv1alpha1.ZookeeperCluster,
+	observedStage zkv1alpha1.QuorumSaslStage,
+) (*zkv1alpha1.QuorumAuthenticationSpec, error) {
+	var requested *zkv1alpha1.QuorumAuthenticationSpec
+	if zkCluster.Spec.ClusterConfig != nil {
+		requested = zkCluster.Spec.ClusterConfig.QuorumAuthentication
+	}
+	requestedStage := RequestedQuorumSaslStage(zkCluster.Spec.ClusterConfig)
+	stage := NextQuorumSaslStage(observedStage, requestedStage)
+
+	kerberosSecretClass := ""
+	if requested != nil {
+		kerberosSecretClass = requested.KerberosSecretClass
+	}
+	// clusters without a recorded stage are new or run without quorum SASL
+	if observedStage != zkv1alpha1.QuorumSaslDisabled && zkCluster.Status.QuorumSaslStage != "" {
+		observedClass := zkCluster.Status.QuorumSaslKerberosSecretClass
+		if requestedStage != zkv1alpha1.QuorumSaslDisabled && kerberosSecretClass != observedClass {
+			return nil, fmt.Errorf("quorum SASL of cluster %s authenticates with %s, set the stage to Disabled "+
+				"and wait for it to be rolled out before switching to %s",
+				zkCluster.Name, quorumSaslMechanism(observedClass), quorumSaslMechanism(kerberosSecretClass))
+		}
+		kerberosSecretClass = observedClass
+	}
+
+	if requested == nil && stage == zkv1alpha1.QuorumSaslDisabled {
+		return nil, nil
+	}
+	quorumAuthentication := &zkv1alpha1.QuorumAuthenticationSpec{}
+	if requested != nil {
+		*quorumAuthentication = *requested
+	}
+	quorumAuthentication.Stage = stage
+	quorumAuthentication.KerberosSecretClass = kerberosSecretClass
+	return quorumAuthentication, nil
+}
+
+func quorumSaslMechanism(kerberosSecretClass string) string {
+	if kerberosSecretClass == "" {
+		return "digest"
+	}
+	return fmt.Sprintf("Kerberos SecretClass %s", kerberosSecretClass)
+}
+
+// StatefulSetsRolledOut checks that every server runs the latest pod template and is ready.
+func StatefulSetsRolledOut(
+	ctx context.Context,
+	k8sClient ctrlclient.Client,
+	zkCluster *zkv1alpha1.ZookeeperCluster,
+) (bool, error) {
+	statefulSets, err := listStatefulSets(ctx, k8sClient, zkCluster)
+	if err != nil {
+		return false, err
+	}
+	for _, sts := range statefulSets {
+		replicas := int32(1)
+		if sts.Spec.Replicas != nil {
+			replicas = *sts.Spec.Replicas
+		}
+		if sts.Status.ObservedGeneration < sts.Generation ||
+			sts.Status.UpdateRevision != sts.Status.CurrentRevision ||
+			sts.Status.UpdatedReplicas != replicas ||
+			sts.Status.ReadyReplicas != replicas {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func listStatefulSets(
+	ctx context.Context,
+	k8sClient ctrlclient.Client,
+	zkCluster *zkv1alpha1.ZookeeperCluster,
+) ([]appv1.StatefulSet, error) {
+	statefulSets := &appv1.StatefulSetList{}
+	if err := k8sClient.List(ctx, statefulSets,
+		ctrlclient.InNamespace(zkCluster.Namespace),
+		ctrlclient.MatchingLabels{
+			constants.LabelKubernetesInstance:  zkCluster.Name,
+			constants.LabelKubernetesComponent: string(common.Server),
+		},
+	); err != nil {
+		return nil, err
+	}
+	return statefulSets.Items, nil
+}
+
+func NewQuorumDigestSecretReconciler(
+	client *client.Client,
+	clusterInfo reconciler.ClusterInfo,
+) reconciler.ResourceReconciler[builder.ConfigBuilder] {
+	secretBuilder := &QuorumDigestSecretBuilder{
+		SecretBuilder: *builder.NewSecretBuilder(
+			client,
+			security.QuorumDigestSecretName(clusterInfo.ClusterName),
+			func(o *builder.Options) {
+				o.Labels = clusterInfo.GetLabels()
+				o.Annotations = clusterInfo.GetAnnotations()
+			},
+		),
+	}
+	return reconciler.NewGenericResourceReconciler[builder.ConfigBuilder](client, secretBuilder)
+}
+
+var _ builder.ConfigBuilder = &QuorumDigestSecretBuilder{}
+
+// QuorumDigestSecretBuilder builds the secret with the password the servers authenticate each other with.
+// The password is generated once and kept for the lifetime of the cluster.
+type QuorumDigestSecretBuilder struct {
+	builder.SecretBuilder
+}
+
+func (b *QuorumDigestSecretBuilder) Build(ctx context.Context) (ctrlclient.Object, error) {
+	password := util.GenerateRandomStr(32, true, false, true)
+
+	existing := &corev1.Secret{}
+	if err := b.GetClient().GetWithOwnerNamespace(ctx, b.GetName(), existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+	} else if existingPassword, ok := existing.Data[security.QuorumDigestPasswordKey]; ok && len(existingPassword) > 0 {
+		password = string(existingPassword)
+	}
+
+	b.AddItem(security.QuorumDigestPasswordKey, password)
+	return b.SecretBuilder.Build(ctx)
+}
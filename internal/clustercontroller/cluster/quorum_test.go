@@ -0,0 +1,76 @@
+package cluster
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+)
+
+var _ = Describe("NextQuorumAuthentication", func() {
+	var zkCluster *zkv1alpha1.ZookeeperCluster
+
+	BeforeEach(func() {
+		zkCluster = &zkv1alpha1.ZookeeperCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default"},
+			Spec:       zkv1alpha1.ZookeeperClusterSpec{ClusterConfig: &zkv1alpha1.ClusterConfigSpec{}},
+			Status: zkv1alpha1.ZookeeperClusterStatus{
+				QuorumSaslStage:               zkv1alpha1.QuorumSaslLearnerRequired,
+				QuorumSaslKerberosSecretClass: "kerberos",
+			},
+		}
+	})
+
+	It("steps down with the recorded Kerberos SecretClass when the section is removed", func() {
+		quorumAuthentication, err := NextQuorumAuthentication(zkCluster, zkv1alpha1.QuorumSaslLearnerRequired)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quorumAuthentication).To(Equal(&zkv1alpha1.QuorumAuthenticationSpec{
+			Stage:               zkv1alpha1.QuorumSaslEnabled,
+			KerberosSecretClass: "kerberos",
+		}))
+
+		zkCluster.Status.QuorumSaslStage = zkv1alpha1.QuorumSaslEnabled
+		quorumAuthentication, err = NextQuorumAuthentication(zkCluster, zkv1alpha1.QuorumSaslEnabled)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quorumAuthentication).To(BeNil())
+	})
+
+	It("steps down with the recorded mechanism when the stage is set to Disabled with another one", func() {
+		zkCluster.Spec.ClusterConfig.QuorumAuthentication = &zkv1alpha1.QuorumAuthenticationSpec{Stage: zkv1alpha1.QuorumSaslDisabled}
+		quorumAuthentication, err := NextQuorumAuthentication(zkCluster, zkv1alpha1.QuorumSaslLearnerRequired)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quorumAuthentication.Stage).To(Equal(zkv1alpha1.QuorumSaslEnabled))
+		Expect(quorumAuthentication.KerberosSecretClass).To(Equal("kerberos"))
+	})
+
+	It("refuses to switch from Kerberos to digest while quorum SASL is on", func() {
+		zkCluster.Spec.ClusterConfig.QuorumAuthentication = &zkv1alpha1.QuorumAuthenticationSpec{Stage: zkv1alpha1.QuorumSaslRequired}
+		_, err := NextQuorumAuthentication(zkCluster, zkv1alpha1.QuorumSaslLearnerRequired)
+		Expect(err).To(MatchError(ContainSubstring("before switching to digest")))
+	})
+
+	It("switches the mechanism once quorum SASL is disabled", func() {
+		zkCluster.Status = zkv1alpha1.ZookeeperClusterStatus{QuorumSaslStage: zkv1alpha1.QuorumSaslDisabled}
+		zkCluster.Spec.ClusterConfig.QuorumAuthentication = &zkv1alpha1.QuorumAuthenticationSpec{
+			Stage:               zkv1alpha1.QuorumSaslRequired,
+			KerberosSecretClass: "kerberos-2",
+		}
+		quorumAuthentication, err := NextQuorumAuthentication(zkCluster, zkv1alpha1.QuorumSaslDisabled)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quorumAuthentication.Stage).To(Equal(zkv1alpha1.QuorumSaslEnabled))
+		Expect(quorumAuthentication.KerberosSecretClass).To(Equal("kerberos-2"))
+	})
+
+	It("starts new clusters with the requested mechanism", func() {
+		zkCluster.Status = zkv1alpha1.ZookeeperClusterStatus{}
+		zkCluster.Spec.ClusterConfig.QuorumAuthentication = &zkv1alpha1.QuorumAuthenticationSpec{
+			Stage:               zkv1alpha1.QuorumSaslRequired,
+			KerberosSecretClass: "kerberos",
+		}
+		quorumAuthentication, err := NextQuorumAuthentication(zkCluster, zkv1alpha1.QuorumSaslRequired)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quorumAuthentication.Stage).To(Equal(zkv1alpha1.QuorumSaslRequired))
+		Expect(quorumAuthentication.KerberosSecretClass).To(Equal("kerberos"))
+	})
+})
@@ -0,0 +1,112 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+// RestoreCondition checks that every server restored the snapshot of the restore source, reading the records
+// their restore containers write to the termination log. It returns nil while servers are still restoring.
+func RestoreCondition(
+	ctx context.Context,
+	k8sClient ctrlclient.Client,
+	zkCluster *zkv1alpha1.ZookeeperCluster,
+	restore *common.RestoreSource,
+) (*metav1.Condition, error) {
+	pods := &corev1.PodList{}
+	if err := k8sClient.List(ctx, pods,
+		ctrlclient.InNamespace(zkCluster.Namespace),
+		ctrlclient.MatchingLabels{
+			constants.LabelKubernetesInstance:  zkCluster.Name,
+			constants.LabelKubernetesComponent: string(common.Server),
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	condition := &metav1.Condition{Type: zkv1alpha1.ConditionRestored, ObservedGeneration: zkCluster.Generation}
+	records := make(map[string]*common.RestoreRecord)
+	for _, pod := range pods.Items {
+		status, ok := restoreContainerStatus(&pod)
+		if !ok {
+			continue
+		}
+		if terminated := status.State.Terminated; terminated != nil && terminated.ExitCode == 0 {
+			record, err := common.ParseRestoreRecord(terminated.Message)
+			if err != nil {
+				return nil, fmt.Errorf("restore record of pod %s/%s: %w", pod.Namespace, pod.Name, err)
+			}
+			records[pod.Name] = record
+			continue
+		}
+		for _, terminated := range []*corev1.ContainerStateTerminated{status.State.Terminated, status.LastTerminationState.Terminated} {
+			if terminated != nil && terminated.ExitCode != 0 {
+				condition.Status = metav1.ConditionFalse
+				condition.Reason = "RestoreFailed"
+				condition.Message = fmt.Sprintf("server %s failed to restore %s: %s", pod.Name, restore.Key, strings.TrimSpace(terminated.Message))
+				return condition, nil
+			}
+		}
+	}
+	if len(records) < int(serverReplicas(zkCluster)) {
+		return nil, nil
+	}
+
+	diverged := make([]string, 0)
+	untouched := 0
+	for pod, record := range records {
+		switch {
+		case !record.Restored:
+			untouched++
+			diverged = append(diverged, pod)
+		case record.Key != restore.Key || record.Zxid != restore.Zxid:
+			diverged = append(diverged, pod)
+		}
+	}
+	slices.Sort(diverged)
+	switch {
+	case len(diverged) == 0:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Restored"
+		condition.Message = fmt.Sprintf("%d servers restored zxid %s from %s", len(records), restore.Zxid, restore.Key)
+	case untouched == len(records):
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "DataExists"
+		condition.Message = "the data volumes of the servers already held data, nothing was restored"
+	default:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Inconsistent"
+		condition.Message = fmt.Sprintf("servers %s did not restore zxid %s from %s, their data diverges from the other servers",
+			strings.Join(diverged, ", "), restore.Zxid, restore.Key)
+	}
+	return condition, nil
+}
+
+func restoreContainerStatus(pod *corev1.Pod) (*corev1.ContainerStatus, bool) {
+	for i := range pod.Status.InitContainerStatuses {
+		if status := &pod.Status.InitContainerStatuses[i]; status.Name == common.RestoreContainerName {
+			return status, true
+		}
+	}
+	return nil, false
+}
+
+func serverReplicas(zkCluster *zkv1alpha1.ZookeeperCluster) int32 {
+	var replicas int32
+	if zkCluster.Spec.Servers != nil {
+		for _, roleGroup := range zkCluster.Spec.Servers.RoleGroups {
+			replicas += roleGroup.Replicas
+		}
+	}
+	return replicas
+}
@@ -0,0 +1,100 @@
+package cluster
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+func restoredPod(name string, state corev1.ContainerState) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{constants.LabelKubernetesInstance: "simple", constants.LabelKubernetesComponent: "server"},
+		},
+		Status: corev1.PodStatus{InitContainerStatuses: []corev1.ContainerStatus{{Name: common.RestoreContainerName, State: state}}},
+	}
+}
+
+func restoreRecord(message string) corev1.ContainerState {
+	return corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: message}}
+}
+
+var _ = Describe("Restore condition", func() {
+	const restored = `{"restored":true,"key":"default/simple/20261001T030000Z-100000009.tar.gz","zxid":"100000009"}`
+
+	ctx := context.Background()
+	zkCluster := &zkv1alpha1.ZookeeperCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default", Generation: 2},
+		Spec: zkv1alpha1.ZookeeperClusterSpec{
+			Servers: &zkv1alpha1.ServerSpec{RoleGroups: map[string]zkv1alpha1.RoleGroupSpec{"default": {Replicas: 2}}},
+		},
+	}
+	restore := &common.RestoreSource{Key: "default/simple/20261001T030000Z-100000009.tar.gz", Zxid: "100000009"}
+
+	condition := func(pods ...ctrlclient.Object) *metav1.Condition {
+		k8sClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(pods...).Build()
+		condition, err := RestoreCondition(ctx, k8sClient, zkCluster, restore)
+		Expect(err).NotTo(HaveOccurred())
+		return condition
+	}
+
+	It("is true once every server restored the snapshot", func() {
+		c := condition(
+			restoredPod("simple-server-default-0", restoreRecord(restored)),
+			restoredPod("simple-server-default-1", restoreRecord(restored)),
+		)
+		Expect(c).NotTo(BeNil())
+		Expect(c.Status).To(Equal(metav1.ConditionTrue))
+		Expect(c.ObservedGeneration).To(Equal(int64(2)))
+	})
+
+	It("waits for servers that are still restoring", func() {
+		Expect(condition(
+			restoredPod("simple-server-default-0", restoreRecord(restored)),
+			restoredPod("simple-server-default-1", corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}),
+		)).To(BeNil())
+	})
+
+	It("reports a failed restore", func() {
+		failed := restoredPod("simple-server-default-1", corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}})
+		failed.Status.InitContainerStatuses[0].LastTerminationState = corev1.ContainerState{
+			Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Message: "expected the snapshot of zxid 100000009"},
+		}
+		c := condition(restoredPod("simple-server-default-0", restoreRecord(restored)), failed)
+		Expect(c.Status).To(Equal(metav1.ConditionFalse))
+		Expect(c.Reason).To(Equal("RestoreFailed"))
+		Expect(c.Message).To(ContainSubstring("simple-server-default-1"))
+	})
+
+	It("reports servers whose data diverges", func() {
+		c := condition(
+			restoredPod("simple-server-default-0", restoreRecord(restored)),
+			restoredPod("simple-server-default-1", restoreRecord(`{"restored":false}`)),
+		)
+		Expect(c.Status).To(Equal(metav1.ConditionFalse))
+		Expect(c.Reason).To(Equal("Inconsistent"))
+		Expect(c.Message).To(ContainSubstring("simple-server-default-1"))
+		Expect(c.Message).NotTo(ContainSubstring("simple-server-default-0"))
+	})
+
+	It("reports that existing data was kept", func() {
+		c := condition(
+			restoredPod("simple-server-default-0", restoreRecord(`{"restored":false}`)),
+			restoredPod("simple-server-default-1", restoreRecord(`{"restored":false}`)),
+		)
+		Expect(c.Status).To(Equal(metav1.ConditionFalse))
+		Expect(c.Reason).To(Equal("DataExists"))
+	})
+})
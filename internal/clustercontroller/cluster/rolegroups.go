@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+// DeleteRemovedRoleGroups deletes the resources of the server role groups removed from the cluster and returns their
+// kinds and names: the statefulsets, the config maps, the headless, metrics and per-pod services, and the
+// NetworkPolicies. The volumes are deleted with the statefulsets if the retention policy of the role group says so,
+// see PersistentVolumeClaimRetentionPolicySpec.
+func DeleteRemovedRoleGroups(
+	ctx context.Context,
+	k8sClient ctrlclient.Client,
+	zkCluster *zkv1alpha1.ZookeeperCluster,
+) ([]string, error) {
+	var deleted []string
+	for _, list := range []ctrlclient.ObjectList{
+		&appv1.StatefulSetList{},
+		&corev1.ConfigMapList{},
+		&corev1.ServiceList{},
+		&networkingv1.NetworkPolicyList{},
+	} {
+		if err := k8sClient.List(ctx, list,
+			ctrlclient.InNamespace(zkCluster.Namespace),
+			ctrlclient.MatchingLabels{
+				constants.LabelKubernetesInstance:  zkCluster.Name,
+				constants.LabelKubernetesComponent: string(common.Server),
+			},
+		); err != nil {
+			return deleted, err
+		}
+		objects, err := apimeta.ExtractList(list)
+		if err != nil {
+			return deleted, err
+		}
+		for _, item := range objects {
+			obj := item.(ctrlclient.Object)
+			if !metav1.IsControlledBy(obj, zkCluster) || obj.GetDeletionTimestamp() != nil {
+				continue
+			}
+			roleGroup, ok := obj.GetLabels()[constants.LabelKubernetesRoleGroup]
+			if !ok {
+				continue
+			}
+			if servers := zkCluster.Spec.Servers; servers != nil {
+				if _, ok := servers.RoleGroups[roleGroup]; ok {
+					continue
+				}
+			}
+			kind := strings.TrimSuffix(reflect.TypeOf(list).Elem().Name(), "List")
+			if err := k8sClient.Delete(ctx, obj); ctrlclient.IgnoreNotFound(err) != nil {
+				return deleted, fmt.Errorf("delete %s %s/%s of removed role group %s: %w", kind, obj.GetNamespace(), obj.GetName(), roleGroup, err)
+			}
+			deleted = append(deleted, kind+"/"+obj.GetName())
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,99 @@
+package cluster
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+)
+
+var _ = Describe("Removed role groups", func() {
+	ctx := context.Background()
+	zkCluster := &zkv1alpha1.ZookeeperCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default", UID: types.UID("uid-1")},
+		Spec: zkv1alpha1.ZookeeperClusterSpec{
+			Servers: &zkv1alpha1.ServerSpec{RoleGroups: map[string]zkv1alpha1.RoleGroupSpec{"default": {Replicas: 3}}},
+		},
+	}
+	objectMeta := func(name, roleGroup string, controller *zkv1alpha1.ZookeeperCluster) metav1.ObjectMeta {
+		objectMeta := metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels: map[string]string{
+				constants.LabelKubernetesInstance:  "simple",
+				constants.LabelKubernetesComponent: "server",
+				constants.LabelKubernetesRoleGroup: roleGroup,
+			},
+		}
+		if controller != nil {
+			objectMeta.OwnerReferences = []metav1.OwnerReference{{
+				APIVersion: zkv1alpha1.GroupVersion.String(),
+				Kind:       "ZookeeperCluster",
+				Name:       controller.Name,
+				UID:        controller.UID,
+				Controller: ptr.To(true),
+			}}
+		}
+		return objectMeta
+	}
+	statefulSet := func(roleGroup string, controller *zkv1alpha1.ZookeeperCluster) *appv1.StatefulSet {
+		return &appv1.StatefulSet{ObjectMeta: objectMeta("simple-server-"+roleGroup, roleGroup, controller)}
+	}
+
+	It("deletes the statefulsets of removed role groups", func() {
+		other := zkCluster.DeepCopy()
+		other.UID = types.UID("uid-0")
+		k8sClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+			statefulSet("default", zkCluster),
+			statefulSet("secondary", zkCluster),
+			statefulSet("foreign", other),
+		).Build()
+
+		deleted, err := DeleteRemovedRoleGroups(ctx, k8sClient, zkCluster)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deleted).To(ConsistOf("StatefulSet/simple-server-secondary"))
+
+		statefulSets := &appv1.StatefulSetList{}
+		Expect(k8sClient.List(ctx, statefulSets, ctrlclient.InNamespace("default"))).To(Succeed())
+		Expect(statefulSets.Items).To(HaveLen(2))
+	})
+
+	It("deletes the config map, services and NetworkPolicies of removed role groups", func() {
+		k8sClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+			statefulSet("default", zkCluster),
+			&corev1.ConfigMap{ObjectMeta: objectMeta("simple-server-default", "default", zkCluster)},
+			&corev1.ConfigMap{ObjectMeta: objectMeta("simple-server-secondary", "secondary", zkCluster)},
+			&corev1.Service{ObjectMeta: objectMeta("simple-server-secondary", "secondary", zkCluster)},
+			&corev1.Service{ObjectMeta: objectMeta("simple-server-secondary-metrics", "secondary", zkCluster)},
+			&corev1.Service{ObjectMeta: objectMeta("simple-server-secondary-0", "secondary", zkCluster)},
+			&networkingv1.NetworkPolicy{ObjectMeta: objectMeta("simple-server-secondary-quorum", "secondary", zkCluster)},
+		).Build()
+
+		deleted, err := DeleteRemovedRoleGroups(ctx, k8sClient, zkCluster)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deleted).To(ConsistOf(
+			"ConfigMap/simple-server-secondary",
+			"Service/simple-server-secondary",
+			"Service/simple-server-secondary-metrics",
+			"Service/simple-server-secondary-0",
+			"NetworkPolicy/simple-server-secondary-quorum",
+		))
+
+		configMaps := &corev1.ConfigMapList{}
+		Expect(k8sClient.List(ctx, configMaps, ctrlclient.InNamespace("default"))).To(Succeed())
+		Expect(configMaps.Items).To(HaveLen(1))
+		Expect(configMaps.Items[0].Name).To(Equal("simple-server-default"))
+	})
+})
@@ -0,0 +1,165 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+var rotationLogger = ctrl.Log.WithName("certificate-rotation")
+
+// CertificateRestartBuffer returns how long before expiry a server is restarted.
+func CertificateRestartBuffer(clusterConfig *zkv1alpha1.ClusterConfigSpec) (time.Duration, error) {
+	if clusterConfig == nil || clusterConfig.Tls == nil || clusterConfig.Tls.CertificateRestartBuffer == "" {
+		return zkv1alpha1.DefaultCertificateRestartBuffer, nil
+	}
+	buffer, err := time.ParseDuration(clusterConfig.Tls.CertificateRestartBuffer)
+	if err != nil {
+		return 0, fmt.Errorf("invalid certificateRestartBuffer %q: %w", clusterConfig.Tls.CertificateRestartBuffer, err)
+	}
+	return buffer, nil
+}
+
+// PodSecretsExpiry returns the earliest expiry of the secrets mounted into the pod by the secret-operator.
+// The secret-operator records the expiry of every volume as pod annotation `restarter.kubedoop.dev/expires-at.<RFC3339>`.
+func PodSecretsExpiry(pod *corev1.Pod) (time.Time, bool) {
+	var expiry time.Time
+	for key := range pod.Annotations {
+		value, ok := strings.CutPrefix(key, constants.PrefixLabelRestarterExpiresAt)
+		if !ok {
+			continue
+		}
+		expiresAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			rotationLogger.V(1).Info("ignoring invalid expiry annotation", "pod", pod.Name, "annotation", key)
+			continue
+		}
+		if expiry.IsZero() || expiresAt.Before(expiry) {
+			expiry = expiresAt
+		}
+	}
+	return expiry, !expiry.IsZero()
+}
+
+// CertificateRotation restarts servers whose secrets are about to expire.
+type CertificateRotation struct {
+	client    ctrlclient.Client
+	zkCluster *zkv1alpha1.ZookeeperCluster
+	buffer    time.Duration
+	leader    string
+	now       func() time.Time
+}
+
+// NewCertificateRotation returns the rotation of the servers of a cluster. Leader is the pod of the server that
+// leads the ensemble, empty if unknown; it is restarted last of the servers that are due.
+func NewCertificateRotation(
+	client ctrlclient.Client,
+	zkCluster *zkv1alpha1.ZookeeperCluster,
+	buffer time.Duration,
+	leader string,
+) *CertificateRotation {
+	return &CertificateRotation{
+		client:    client,
+		zkCluster: zkCluster,
+		buffer:    buffer,
+		leader:    leader,
+		now:       time.Now,
+	}
+}
+
+// Rotate restarts at most one server that is due, and returns when the next server is due.
+// A server is only restarted while all servers are ready, so the quorum survives the restart. The leader is
+// restarted after the other servers that are due, so the ensemble elects a new leader once instead of with every
+// restart of a server that took over.
+// The returned time is nil if no mounted secret expires.
+func (c *CertificateRotation) Rotate(ctx context.Context) (*time.Time, error) {
+	pods := &corev1.PodList{}
+	if err := c.client.List(ctx, pods,
+		ctrlclient.InNamespace(c.zkCluster.Namespace),
+		ctrlclient.MatchingLabels{
+			constants.LabelKubernetesInstance:  c.zkCluster.Name,
+			constants.LabelKubernetesComponent: string(common.Server),
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	type podRotation struct {
+		pod     *corev1.Pod
+		restart time.Time
+	}
+	rotations := make([]podRotation, 0, len(pods.Items))
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if expiry, ok := PodSecretsExpiry(pod); ok {
+			rotations = append(rotations, podRotation{pod: pod, restart: expiry.Add(-c.buffer)})
+		}
+	}
+	if len(rotations) == 0 {
+		return nil, nil
+	}
+	sort.Slice(rotations, func(i, j int) bool {
+		if rotations[i].restart.Equal(rotations[j].restart) {
+			return rotations[i].pod.Name < rotations[j].pod.Name
+		}
+		return rotations[i].restart.Before(rotations[j].restart)
+	})
+
+	next := rotations[0]
+	if next.restart.After(c.now()) {
+		return &next.restart, nil
+	}
+	for _, rotation := range rotations {
+		if rotation.restart.After(c.now()) {
+			break
+		}
+		if rotation.pod.Name != c.leader {
+			next = rotation
+			break
+		}
+	}
+
+	rolledOut, err := StatefulSetsRolledOut(ctx, c.client, c.zkCluster)
+	if err != nil {
+		return nil, err
+	}
+	if !rolledOut || !c.quorumHealthy(pods.Items) {
+		rotationLogger.Info("Postponing restart of server with expiring secrets until all servers are ready", "pod", next.pod.Name)
+		return &next.restart, nil
+	}
+
+	rotationLogger.Info("Restarting server to rotate its secrets", "pod", next.pod.Name, "restartAt", next.restart)
+	if err := c.client.Delete(ctx, next.pod); ctrlclient.IgnoreNotFound(err) != nil {
+		return nil, err
+	}
+	return &next.restart, nil
+}
+
+// quorumHealthy checks that no server is restarting and all servers are ready.
+func (c *CertificateRotation) quorumHealthy(pods []corev1.Pod) bool {
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || !podReady(&pod) {
+			return false
+		}
+	}
+	return true
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
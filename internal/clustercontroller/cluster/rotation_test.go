@@ -0,0 +1,147 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+)
+
+func serverPod(name string, ready bool, expiresAt time.Time) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{constants.LabelKubernetesInstance: "simple", constants.LabelKubernetesComponent: "server"},
+			Annotations: map[string]string{
+				constants.PrefixLabelRestarterExpiresAt + expiresAt.UTC().Format(time.RFC3339): "server-tls",
+			},
+		},
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+var _ = Describe("CertificateRotation", func() {
+	var (
+		ctx       = context.Background()
+		now       = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+		zkCluster = &zkv1alpha1.ZookeeperCluster{ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default"}}
+	)
+
+	newRotation := func(objs ...ctrlclient.Object) (*CertificateRotation, ctrlclient.Client) {
+		k8sClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objs...).Build()
+		rotation := NewCertificateRotation(k8sClient, zkCluster, time.Hour, "")
+		rotation.now = func() time.Time { return now }
+		return rotation, k8sClient
+	}
+
+	It("should report the earliest restart without restarting early", func() {
+		rotation, k8sClient := newRotation(
+			serverPod("simple-server-default-0", true, now.Add(5*time.Hour)),
+			serverPod("simple-server-default-1", true, now.Add(3*time.Hour)),
+		)
+
+		next, err := rotation.Rotate(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*next).To(BeTemporally("==", now.Add(2*time.Hour)))
+
+		pods := &corev1.PodList{}
+		Expect(k8sClient.List(ctx, pods)).To(Succeed())
+		Expect(pods.Items).To(HaveLen(2))
+	})
+
+	It("should restart the server that is due while all servers are ready", func() {
+		rotation, k8sClient := newRotation(
+			serverPod("simple-server-default-0", true, now.Add(30*time.Minute)),
+			serverPod("simple-server-default-1", true, now.Add(40*time.Minute)),
+		)
+
+		_, err := rotation.Rotate(ctx)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = k8sClient.Get(ctx, ctrlclient.ObjectKey{Namespace: "default", Name: "simple-server-default-0"}, &corev1.Pod{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(k8sClient.Get(ctx, ctrlclient.ObjectKey{Namespace: "default", Name: "simple-server-default-1"}, &corev1.Pod{})).To(Succeed())
+	})
+
+	It("should restart the leader after the other servers that are due", func() {
+		rotation, k8sClient := newRotation(
+			serverPod("simple-server-default-0", true, now.Add(30*time.Minute)),
+			serverPod("simple-server-default-1", true, now.Add(40*time.Minute)),
+			serverPod("simple-server-default-2", true, now.Add(10*time.Hour)),
+		)
+		rotation.leader = "simple-server-default-0"
+
+		next, err := rotation.Rotate(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*next).To(BeTemporally("==", now.Add(-20*time.Minute)))
+		err = k8sClient.Get(ctx, ctrlclient.ObjectKey{Namespace: "default", Name: "simple-server-default-1"}, &corev1.Pod{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(k8sClient.Get(ctx, ctrlclient.ObjectKey{Namespace: "default", Name: "simple-server-default-0"}, &corev1.Pod{})).To(Succeed())
+
+		// once the leader is the only server that is due, it is restarted
+		rotation, k8sClient = newRotation(
+			serverPod("simple-server-default-0", true, now.Add(30*time.Minute)),
+			serverPod("simple-server-default-2", true, now.Add(10*time.Hour)),
+		)
+		rotation.leader = "simple-server-default-0"
+		_, err = rotation.Rotate(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		err = k8sClient.Get(ctx, ctrlclient.ObjectKey{Namespace: "default", Name: "simple-server-default-0"}, &corev1.Pod{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should not wait for the finished pods of backups", func() {
+		// backup pods created before they lost the instance label of the cluster
+		backupPod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "nightly-29000000-abcde",
+				Namespace: "default",
+				Labels:    map[string]string{constants.LabelKubernetesInstance: "simple", constants.LabelKubernetesComponent: "backup"},
+			},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodSucceeded,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse, Reason: "PodCompleted"}},
+			},
+		}
+		rotation, k8sClient := newRotation(
+			serverPod("simple-server-default-0", true, now.Add(30*time.Minute)),
+			serverPod("simple-server-default-1", true, now.Add(40*time.Minute)),
+			backupPod,
+		)
+
+		_, err := rotation.Rotate(ctx)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = k8sClient.Get(ctx, ctrlclient.ObjectKey{Namespace: "default", Name: "simple-server-default-0"}, &corev1.Pod{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(k8sClient.Get(ctx, ctrlclient.ObjectKeyFromObject(backupPod), &corev1.Pod{})).To(Succeed())
+	})
+
+	It("should wait while another server is not ready", func() {
+		rotation, k8sClient := newRotation(
+			serverPod("simple-server-default-0", true, now.Add(30*time.Minute)),
+			serverPod("simple-server-default-1", false, now.Add(10*time.Hour)),
+		)
+
+		_, err := rotation.Rotate(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8sClient.Get(ctx, ctrlclient.ObjectKey{Namespace: "default", Name: "simple-server-default-0"}, &corev1.Pod{})).To(Succeed())
+	})
+})
@@ -0,0 +1,41 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+func TestCluster(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cluster Suite")
+}
+
+// newClient returns a fake client serving the objects, with the ZookeeperCluster API registered.
+func newClient(objs ...ctrlclient.Object) ctrlclient.Client {
+	s := runtime.NewScheme()
+	Expect(scheme.AddToScheme(s)).To(Succeed())
+	Expect(zkv1alpha1.AddToScheme(s)).To(Succeed())
+	return fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).WithStatusSubresource(&zkv1alpha1.ZookeeperBackup{}).Build()
+}
+
+// leaderMntr answers `mntr` with the given host leading the number of synced followers, and every other server following.
+func leaderMntr(leader string, syncedFollowers int) common.FourLetterWordFunc {
+	return func(_ context.Context, address, _ string) (string, error) {
+		if strings.HasPrefix(address, leader+".") {
+			return fmt.Sprintf("zk_server_state\tleader\nzk_synced_followers\t%d\n", syncedFollowers), nil
+		}
+		return "zk_server_state\tfollower\n", nil
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/zncdatadev/operator-go/pkg/constants"
 	"github.com/zncdatadev/operator-go/pkg/reconciler"
 	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
 	"github.com/zncdatadev/zookeeper-operator/internal/security"
 	corev1 "k8s.io/api/core/v1"
 )
@@ -16,16 +17,47 @@ func NewClusterServiceReconciler(
 	listenerClass constants.ListenerClass,
 	zkSecurity *security.ZookeeperSecurity,
 ) *reconciler.Service {
+	ports := zkSecurity.ClientContainerPorts()
+
+	svcBuilder := builder.NewServiceBuilder(
+		client,
+		option.GetFullName(),
+		ports,
+		func(sbo *builder.ServiceBuilderOptions) {
+			sbo.ListenerClass = listenerClass
+			sbo.Headless = false
+			sbo.Labels = option.GetLabels()
+			sbo.Annotations = option.GetAnnotations()
+		},
+	)
+
+	return &reconciler.Service{
+		GenericResourceReconciler: *reconciler.NewGenericResourceReconciler[builder.ServiceBuilder](
+			client,
+			svcBuilder,
+		),
+	}
+}
+
+// NewAdminServiceReconciler exposes the AdminServer of all servers, see AdminSpec.Service
+func NewAdminServiceReconciler(
+	client *client.Client,
+	option reconciler.ClusterInfo,
+	listenerClass constants.ListenerClass,
+) *reconciler.Service {
+	if listenerClass == "" {
+		listenerClass = constants.ClusterInternal
+	}
 	ports := []corev1.ContainerPort{
 		{
-			Name:          zkv1alpha1.ClientPortName,
-			ContainerPort: int32(zkSecurity.ClientPort()),
+			Name:          zkv1alpha1.AdminPortName,
+			ContainerPort: int32(zkv1alpha1.AdminPort),
 		},
 	}
 
 	svcBuilder := builder.NewServiceBuilder(
 		client,
-		option.GetFullName(),
+		common.AdminServiceName(option.ClusterName),
 		ports,
 		func(sbo *builder.ServiceBuilderOptions) {
 			sbo.ListenerClass = listenerClass
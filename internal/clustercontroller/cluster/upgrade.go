@@ -0,0 +1,408 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+var upgradeLogger = ctrl.Log.WithName("version-upgrade")
+
+// UpgradePlan is the state of the servers towards the requested ZooKeeper version.
+type UpgradePlan struct {
+	// CurrentVersion is the version all servers run.
+	CurrentVersion string
+	// TargetVersion is the version the servers are upgraded to, empty when no upgrade is in progress.
+	TargetVersion string
+	// Versions pins the role groups that keep their version for now.
+	Versions common.ProductVersions
+	// Partitions roll the servers of the upgraded role group one at a time.
+	Partitions common.UpgradePartitions
+	// Condition reports the progress of the upgrade.
+	Condition metav1.Condition
+}
+
+// InProgress tells whether the servers are still being upgraded.
+func (p *UpgradePlan) InProgress() bool {
+	return p.TargetVersion != ""
+}
+
+// VersionUpgrade rolls the requested ZooKeeper version out to the servers, see UpgradeSpec.
+// The role groups are upgraded in the order of their names, and the servers of a role group from the highest ordinal
+// down, with the partition of its rolling update. The next server is only upgraded once every server is ready and the
+// quorum is healthy, so that at most one server is restarting at any time.
+type VersionUpgrade struct {
+	client         ctrlclient.Client
+	zkCluster      *zkv1alpha1.ZookeeperCluster
+	clientTlsPhase zkv1alpha1.ClientTlsPhase
+	fourLetterWord common.FourLetterWordFunc
+}
+
+func NewVersionUpgrade(
+	client ctrlclient.Client,
+	zkCluster *zkv1alpha1.ZookeeperCluster,
+	clientTlsPhase zkv1alpha1.ClientTlsPhase,
+	fourLetterWord common.FourLetterWordFunc,
+) *VersionUpgrade {
+	return &VersionUpgrade{
+		client:         client,
+		zkCluster:      zkCluster,
+		clientTlsPhase: clientTlsPhase,
+		fourLetterWord: fourLetterWord,
+	}
+}
+
+// Plan decides which version every role group runs, and starts the upgrade of the next role group when it is safe.
+func (u *VersionUpgrade) Plan(ctx context.Context) (*UpgradePlan, error) {
+	statefulSets, err := listStatefulSets(ctx, u.client, u.zkCluster)
+	if err != nil {
+		return nil, err
+	}
+	observed := make(map[string]string, len(statefulSets))
+	byRoleGroup := make(map[string]*appv1.StatefulSet, len(statefulSets))
+	for i := range statefulSets {
+		sts := &statefulSets[i]
+		observed[sts.Labels[constants.LabelKubernetesRoleGroup]] = statefulSetProductVersion(sts)
+		byRoleGroup[sts.Labels[constants.LabelKubernetesRoleGroup]] = sts
+	}
+
+	status := &u.zkCluster.Status
+	requested := common.ProductVersion(u.zkCluster.Spec.Image)
+	current := status.CurrentVersion
+	if current == "" {
+		current = oldestProductVersion(observed, requested)
+	}
+	plan := &UpgradePlan{
+		CurrentVersion: current,
+		Condition: metav1.Condition{
+			Type:               zkv1alpha1.ConditionUpgrading,
+			Status:             metav1.ConditionFalse,
+			Reason:             "UpToDate",
+			Message:            fmt.Sprintf("servers run version %s", current),
+			ObservedGeneration: u.zkCluster.Generation,
+		},
+	}
+
+	target := requested
+	if status.TargetVersion != "" && target != current && target != status.TargetVersion {
+		upgradeLogger.Info("Upgrade in progress, the requested version is rolled out once it completes",
+			"namespace", u.zkCluster.Namespace, "name", u.zkCluster.Name, "targetVersion", status.TargetVersion, "requested", requested)
+		target = status.TargetVersion
+	}
+	// reverting an upgrade in progress brings the upgraded role groups back to the version the others still run
+	if target == current {
+		return plan, nil
+	}
+
+	roleGroups := u.roleGroups()
+	if err := common.ValidateUpgrade(current, target); err != nil {
+		plan.Versions = make(common.ProductVersions, len(roleGroups))
+		for _, roleGroup := range roleGroups {
+			plan.Versions[roleGroup] = current
+		}
+		plan.Condition.Reason = "UnsupportedUpgrade"
+		plan.Condition.Message = fmt.Sprintf("servers keep version %s: %s", current, err.Error())
+		return plan, nil
+	}
+
+	plan.TargetVersion = target
+	plan.Condition.Status = metav1.ConditionTrue
+	plan.Condition.Reason = "RollingUpgrade"
+	rolledOut, err := StatefulSetsRolledOut(ctx, u.client, u.zkCluster)
+	if err != nil {
+		return nil, err
+	}
+	// the version of custom images is not known, they are rolled out to all role groups at once
+	customImage := u.zkCluster.Spec.Image != nil && u.zkCluster.Spec.Image.Custom != ""
+
+	plan.Versions = common.ProductVersions{}
+	var pending []string
+	for _, roleGroup := range roleGroups {
+		version, ok := observed[roleGroup]
+		// role groups added during the upgrade start with the target version
+		if !ok || version == target || customImage {
+			continue
+		}
+		plan.Versions[roleGroup] = current
+		pending = append(pending, roleGroup)
+	}
+
+	// the servers of an upgraded role group are rolled one at a time
+	if !customImage {
+		for _, roleGroup := range roleGroups {
+			sts, ok := byRoleGroup[roleGroup]
+			if !ok || slices.Contains(pending, roleGroup) || rollingPartition(sts) == 0 {
+				continue
+			}
+			return u.stepPartition(ctx, plan, roleGroup, sts, current, target)
+		}
+	}
+
+	switch {
+	case len(pending) == 0 && rolledOut:
+		plan.CurrentVersion = target
+		plan.TargetVersion = ""
+		plan.Versions = nil
+		plan.Condition.Status = metav1.ConditionFalse
+		plan.Condition.Reason = "Upgraded"
+		plan.Condition.Message = fmt.Sprintf("servers upgraded from %s to %s", current, target)
+		return plan, nil
+	case !rolledOut:
+		plan.Condition.Message = fmt.Sprintf("upgrading from %s to %s, waiting for the servers to roll out", current, target)
+		return plan, nil
+	}
+
+	backedUp, err := u.backUp(ctx, plan, target)
+	if err != nil || !backedUp {
+		return plan, err
+	}
+	healthy, reason, err := quorumHealthy(ctx, u.client, u.zkCluster, u.clientTlsPhase, u.fourLetterWord)
+	if err != nil {
+		return nil, err
+	}
+	if !healthy {
+		plan.Condition.Reason = "WaitingForQuorum"
+		plan.Condition.Message = fmt.Sprintf("upgrading from %s to %s, waiting for a healthy quorum: %s", current, target, reason)
+		return plan, nil
+	}
+
+	next := pending[0]
+	delete(plan.Versions, next)
+	// no server runs the new version until the partition steps down
+	plan.Partitions = common.UpgradePartitions{next: ptr.Deref(byRoleGroup[next].Spec.Replicas, 1)}
+	plan.Condition.Message = fmt.Sprintf("upgrading role group %s from %s to %s", next, current, target)
+	upgradeLogger.Info("Upgrading role group", "namespace", u.zkCluster.Namespace, "name", u.zkCluster.Name,
+		"roleGroup", next, "from", current, "to", target)
+	return plan, nil
+}
+
+// stepPartition upgrades the next server of a role group once the server upgraded before rolled out and the quorum
+// is healthy, by lowering the partition of the rolling update to its ordinal.
+func (u *VersionUpgrade) stepPartition(
+	ctx context.Context,
+	plan *UpgradePlan,
+	roleGroup string,
+	sts *appv1.StatefulSet,
+	current, target string,
+) (*UpgradePlan, error) {
+	replicas := ptr.Deref(sts.Spec.Replicas, 1)
+	partition := min(rollingPartition(sts), replicas)
+	plan.Partitions = common.UpgradePartitions{roleGroup: partition}
+	if sts.Status.ObservedGeneration < sts.Generation || sts.Status.UpdatedReplicas < replicas-partition {
+		plan.Condition.Message = fmt.Sprintf("upgrading role group %s from %s to %s, waiting for server %s-%d to roll out",
+			roleGroup, current, target, sts.Name, partition)
+		return plan, nil
+	}
+	healthy, reason, err := quorumHealthy(ctx, u.client, u.zkCluster, u.clientTlsPhase, u.fourLetterWord)
+	if err != nil {
+		return nil, err
+	}
+	if !healthy {
+		plan.Condition.Reason = "WaitingForQuorum"
+		plan.Condition.Message = fmt.Sprintf("upgrading role group %s from %s to %s, waiting for a healthy quorum: %s",
+			roleGroup, current, target, reason)
+		return plan, nil
+	}
+
+	partition--
+	plan.Partitions[roleGroup] = partition
+	server := fmt.Sprintf("%s-%d", sts.Name, partition)
+	plan.Condition.Message = fmt.Sprintf("upgrading server %s from %s to %s", server, current, target)
+	upgradeLogger.Info("Upgrading server", "namespace", u.zkCluster.Namespace, "name", u.zkCluster.Name,
+		"server", server, "from", current, "to", target)
+	return plan, nil
+}
+
+// rollingPartition returns the partition of the rolling update of a statefulset, 0 without one.
+func rollingPartition(sts *appv1.StatefulSet) int32 {
+	if rollingUpdate := sts.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil {
+		return ptr.Deref(rollingUpdate.Partition, 0)
+	}
+	return 0
+}
+
+// roleGroups returns the names of the server role groups in the order they are upgraded.
+func (u *VersionUpgrade) roleGroups() []string {
+	var roleGroups []string
+	if u.zkCluster.Spec.Servers != nil {
+		for name := range u.zkCluster.Spec.Servers.RoleGroups {
+			roleGroups = append(roleGroups, name)
+		}
+	}
+	slices.Sort(roleGroups)
+	return roleGroups
+}
+
+// UpgradeBackupName returns the name of the ZookeeperBackup taken before the upgrade to a version.
+func UpgradeBackupName(clusterName, version string) string {
+	return fmt.Sprintf("%s-upgrade-%s", clusterName, strings.ReplaceAll(version, ".", "-"))
+}
+
+// backUp takes the backup configured in UpgradeSpec before the upgrade starts, and tells whether it succeeded.
+// The backup is a copy of the configured ZookeeperBackup without its schedule, owned by the cluster.
+func (u *VersionUpgrade) backUp(ctx context.Context, plan *UpgradePlan, target string) (bool, error) {
+	clusterConfig := u.zkCluster.Spec.ClusterConfig
+	if clusterConfig == nil || clusterConfig.Upgrade == nil || clusterConfig.Upgrade.Backup == "" {
+		return true, nil
+	}
+
+	backup := &zkv1alpha1.ZookeeperBackup{}
+	key := ctrlclient.ObjectKey{Namespace: u.zkCluster.Namespace, Name: UpgradeBackupName(u.zkCluster.Name, target)}
+	if err := u.client.Get(ctx, key, backup); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return false, err
+		}
+		return false, u.createBackup(ctx, plan, key, clusterConfig.Upgrade.Backup)
+	}
+
+	if backup.Status.LastBackup != nil {
+		return true, nil
+	}
+	plan.Condition.Reason = "BackingUp"
+	plan.Condition.Message = fmt.Sprintf("upgrading to %s, waiting for backup %s", target, backup.Name)
+	if condition := apimeta.FindStatusCondition(backup.Status.Conditions, zkv1alpha1.BackupConditionSucceeded); condition != nil &&
+		condition.Status == metav1.ConditionFalse {
+		plan.Condition.Reason = "BackupFailed"
+		plan.Condition.Message = fmt.Sprintf("upgrading to %s, backup %s failed: %s", target, backup.Name, condition.Message)
+	}
+	return false, nil
+}
+
+func (u *VersionUpgrade) createBackup(ctx context.Context, plan *UpgradePlan, key ctrlclient.ObjectKey, templateName string) error {
+	template := &zkv1alpha1.ZookeeperBackup{}
+	if err := u.client.Get(ctx, ctrlclient.ObjectKey{Namespace: key.Namespace, Name: templateName}, template); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		plan.Condition.Reason = "BackupFailed"
+		plan.Condition.Message = fmt.Sprintf("backup %s of the upgrade not found", templateName)
+		return nil
+	}
+
+	backup := &zkv1alpha1.ZookeeperBackup{
+		ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+		Spec:       *template.Spec.DeepCopy(),
+	}
+	backup.Spec.ClusterName = u.zkCluster.Name
+	backup.Spec.Schedule = ""
+	if err := controllerutil.SetControllerReference(u.zkCluster, backup, u.client.Scheme()); err != nil {
+		return err
+	}
+	if err := u.client.Create(ctx, backup); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("create backup %s/%s: %w", backup.Namespace, backup.Name, err)
+	}
+	upgradeLogger.Info("Backing up before the upgrade", "namespace", backup.Namespace, "backup", backup.Name)
+	plan.Condition.Reason = "BackingUp"
+	plan.Condition.Message = fmt.Sprintf("upgrading to %s, waiting for backup %s", plan.TargetVersion, backup.Name)
+	return nil
+}
+
+// quorumHealthy checks that every server is ready, and that the servers agree on one leader which has all followers
+// in sync. The servers are asked on the client port of the client TLS phase they were rolled out with.
+// It returns why the quorum is not healthy.
+func quorumHealthy(
+	ctx context.Context,
+	k8sClient ctrlclient.Client,
+	zkCluster *zkv1alpha1.ZookeeperCluster,
+	clientTlsPhase zkv1alpha1.ClientTlsPhase,
+	fourLetterWord common.FourLetterWordFunc,
+) (bool, string, error) {
+	pods := &corev1.PodList{}
+	if err := k8sClient.List(ctx, pods,
+		ctrlclient.InNamespace(zkCluster.Namespace),
+		ctrlclient.MatchingLabels{
+			constants.LabelKubernetesInstance:  zkCluster.Name,
+			constants.LabelKubernetesComponent: string(common.Server),
+		},
+	); err != nil {
+		return false, "", err
+	}
+	if len(pods.Items) < int(serverReplicas(zkCluster)) {
+		return false, fmt.Sprintf("%d of %d servers exist", len(pods.Items), serverReplicas(zkCluster)), nil
+	}
+	for i := range pods.Items {
+		if pod := &pods.Items[i]; pod.DeletionTimestamp != nil || !podReady(pod) {
+			return false, fmt.Sprintf("server %s is not ready", pod.Name), nil
+		}
+	}
+	clusterDomain := common.ClusterDomain(zkCluster.Spec.ClusterConfig)
+	servers := make(map[string]string, len(pods.Items))
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		servers[pod.Name] = common.ClientAddress(common.PodFQDN(pod.Name, pod.Spec.Subdomain, pod.Namespace, clusterDomain), clientTlsPhase)
+	}
+	healthy, reason := leaderInSync(ctx, servers, fourLetterWord)
+	return healthy, reason, nil
+}
+
+// leaderInSync asks the servers, by name, at their addresses whether they agree on one leader which has all
+// followers in sync. It returns why they do not.
+func leaderInSync(ctx context.Context, servers map[string]string, fourLetterWord common.FourLetterWordFunc) (bool, string) {
+	names := slices.Sorted(maps.Keys(servers))
+	var leaders []string
+	followers, syncedFollowers := 0, 0
+	for _, name := range names {
+		out, err := fourLetterWord(ctx, servers[name], "mntr")
+		if err != nil {
+			return false, fmt.Sprintf("server %s does not answer: %s", name, err.Error())
+		}
+		metrics := common.ParseMntr(out)
+		switch metrics["zk_server_state"] {
+		case "leader":
+			leaders = append(leaders, name)
+			syncedFollowers, _ = strconv.Atoi(metrics["zk_synced_followers"])
+		case "follower":
+			followers++
+		}
+	}
+	switch {
+	case len(leaders) != 1:
+		return false, fmt.Sprintf("%d leaders %v", len(leaders), leaders)
+	case syncedFollowers < followers:
+		return false, fmt.Sprintf("%d of %d followers in sync with leader %s", syncedFollowers, followers, leaders[0])
+	}
+	return true, ""
+}
+
+// statefulSetProductVersion returns the ZooKeeper version of the servers of a statefulset, empty if it is not known.
+func statefulSetProductVersion(sts *appv1.StatefulSet) string {
+	for _, container := range sts.Spec.Template.Spec.Containers {
+		if container.Name == common.ZkServerContainerName {
+			return common.ImageProductVersion(container.Image)
+		}
+	}
+	return ""
+}
+
+// oldestProductVersion returns the oldest known version the statefulsets run, or the requested version
+// for new clusters and clusters whose version is not known.
+func oldestProductVersion(observed map[string]string, requested string) string {
+	var versions []string
+	for _, version := range observed {
+		if version != "" {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) == 0 {
+		return requested
+	}
+	slices.SortFunc(versions, common.CompareProductVersions)
+	return versions[0]
+}
@@ -0,0 +1,237 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+var _ = Describe("Version upgrade", func() {
+	ctx := context.Background()
+	var zkCluster *zkv1alpha1.ZookeeperCluster
+	BeforeEach(func() {
+		zkCluster = &zkv1alpha1.ZookeeperCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default", UID: types.UID("uid-1")},
+			Spec: zkv1alpha1.ZookeeperClusterSpec{
+				Image: &zkv1alpha1.ImageSpec{ProductVersion: "3.9.3"},
+				Servers: &zkv1alpha1.ServerSpec{RoleGroups: map[string]zkv1alpha1.RoleGroupSpec{
+					"default":   {Replicas: 1},
+					"secondary": {Replicas: 1},
+				}},
+			},
+		}
+	})
+	statefulSet := func(roleGroup, version string) *appv1.StatefulSet {
+		sts := &appv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{
+			Name:      "simple-server-" + roleGroup,
+			Namespace: "default",
+			Labels: map[string]string{
+				constants.LabelKubernetesInstance:  "simple",
+				constants.LabelKubernetesComponent: "server",
+				constants.LabelKubernetesRoleGroup: roleGroup,
+			},
+		}}
+		sts.Spec.Replicas = ptr.To(int32(1))
+		sts.Spec.Template.Spec.Containers = []corev1.Container{{
+			Name:  common.ZkServerContainerName,
+			Image: fmt.Sprintf("quay.io/zncdatadev/zookeeper:%s-kubedoop0.0.0-dev", version),
+		}}
+		sts.Status = appv1.StatefulSetStatus{CurrentRevision: "1", UpdateRevision: "1", UpdatedReplicas: 1, ReadyReplicas: 1}
+		return sts
+	}
+	pod := func(roleGroup string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "simple-server-" + roleGroup + "-0",
+				Namespace: "default",
+				Labels: map[string]string{
+					constants.LabelKubernetesInstance:  "simple",
+					constants.LabelKubernetesComponent: "server",
+				},
+			},
+			Spec:   corev1.PodSpec{Subdomain: "simple-server-" + roleGroup},
+			Status: corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}},
+		}
+	}
+	// mntr answers with the state of the servers, default-0 leads with the given number of synced followers
+	mntr := func(syncedFollowers int) common.FourLetterWordFunc {
+		return leaderMntr("simple-server-default-0", syncedFollowers)
+	}
+	healthyCluster := func(defaultVersion, secondaryVersion string) ctrlclient.Client {
+		return newClient(statefulSet("default", defaultVersion), statefulSet("secondary", secondaryVersion), pod("default"), pod("secondary"))
+	}
+
+	It("starts new clusters with the requested version", func() {
+		plan, err := NewVersionUpgrade(newClient(), zkCluster, "", mntr(1)).Plan(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.CurrentVersion).To(Equal("3.9.3"))
+		Expect(plan.InProgress()).To(BeFalse())
+		Expect(plan.Versions).To(BeEmpty())
+		Expect(plan.Condition.Reason).To(Equal("UpToDate"))
+	})
+
+	It("upgrades one role group at a time", func() {
+		plan, err := NewVersionUpgrade(healthyCluster("3.9.2", "3.9.2"), zkCluster, "", mntr(1)).Plan(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.CurrentVersion).To(Equal("3.9.2"))
+		Expect(plan.TargetVersion).To(Equal("3.9.3"))
+		Expect(plan.Versions).To(Equal(common.ProductVersions{"secondary": "3.9.2"}))
+		Expect(plan.Partitions).To(Equal(common.UpgradePartitions{"default": 1}))
+		Expect(plan.Condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(plan.Condition.Message).To(Equal("upgrading role group default from 3.9.2 to 3.9.3"))
+
+		zkCluster.Status.CurrentVersion = plan.CurrentVersion
+		zkCluster.Status.TargetVersion = plan.TargetVersion
+		plan, err = NewVersionUpgrade(healthyCluster("3.9.3", "3.9.2"), zkCluster, "", mntr(1)).Plan(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.Versions).To(BeEmpty())
+		Expect(plan.Condition.Message).To(Equal("upgrading role group secondary from 3.9.2 to 3.9.3"))
+
+		plan, err = NewVersionUpgrade(healthyCluster("3.9.3", "3.9.3"), zkCluster, "", mntr(1)).Plan(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.CurrentVersion).To(Equal("3.9.3"))
+		Expect(plan.InProgress()).To(BeFalse())
+		Expect(plan.Condition.Reason).To(Equal("Upgraded"))
+	})
+
+	It("waits for the servers to roll out and the quorum to be healthy", func() {
+		zkCluster.Status.CurrentVersion = "3.9.2"
+		zkCluster.Status.TargetVersion = "3.9.3"
+		rolling := statefulSet("default", "3.9.3")
+		rolling.Status.UpdateRevision = "2"
+		k8sClient := newClient(rolling, statefulSet("secondary", "3.9.2"), pod("default"), pod("secondary"))
+		plan, err := NewVersionUpgrade(k8sClient, zkCluster, "", mntr(1)).Plan(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.Versions).To(Equal(common.ProductVersions{"secondary": "3.9.2"}))
+		Expect(plan.Condition.Message).To(ContainSubstring("waiting for the servers to roll out"))
+
+		plan, err = NewVersionUpgrade(healthyCluster("3.9.3", "3.9.2"), zkCluster, "", mntr(0)).Plan(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.Versions).To(Equal(common.ProductVersions{"secondary": "3.9.2"}))
+		Expect(plan.Condition.Reason).To(Equal("WaitingForQuorum"))
+		Expect(plan.Condition.Message).To(ContainSubstring("0 of 1 followers in sync"))
+	})
+
+	Context("with one role group", func() {
+		BeforeEach(func() {
+			zkCluster.Spec.Servers.RoleGroups = map[string]zkv1alpha1.RoleGroupSpec{"default": {Replicas: 3}}
+			zkCluster.Status.CurrentVersion = "3.9.2"
+			zkCluster.Status.TargetVersion = "3.9.3"
+		})
+		// the statefulset of the upgraded role group, with the servers from the partition on updated
+		upgrading := func(partition, updated int32) *appv1.StatefulSet {
+			sts := statefulSet("default", "3.9.3")
+			sts.Spec.Replicas = ptr.To(int32(3))
+			sts.Spec.UpdateStrategy.RollingUpdate = &appv1.RollingUpdateStatefulSetStrategy{Partition: ptr.To(partition)}
+			sts.Status = appv1.StatefulSetStatus{CurrentRevision: "1", UpdateRevision: "2", UpdatedReplicas: updated, ReadyReplicas: 3}
+			return sts
+		}
+		servers := func() []ctrlclient.Object {
+			var pods []ctrlclient.Object
+			for i := range 3 {
+				p := pod("default")
+				p.Name = fmt.Sprintf("simple-server-default-%d", i)
+				pods = append(pods, p)
+			}
+			return pods
+		}
+		plan := func(sts *appv1.StatefulSet, fourLetterWord common.FourLetterWordFunc) *UpgradePlan {
+			plan, err := NewVersionUpgrade(newClient(append(servers(), sts)...), zkCluster, "", fourLetterWord).Plan(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			return plan
+		}
+
+		It("upgrades one server at a time while the quorum is healthy", func() {
+			zkCluster.Status.TargetVersion = ""
+			sts := statefulSet("default", "3.9.2")
+			sts.Spec.Replicas = ptr.To(int32(3))
+			sts.Status = appv1.StatefulSetStatus{CurrentRevision: "1", UpdateRevision: "1", UpdatedReplicas: 3, ReadyReplicas: 3}
+			p := plan(sts, mntr(2))
+			Expect(p.Versions).To(BeEmpty())
+			Expect(p.Partitions).To(Equal(common.UpgradePartitions{"default": 3}))
+
+			zkCluster.Status.TargetVersion = "3.9.3"
+			p = plan(upgrading(3, 0), mntr(2))
+			Expect(p.Partitions).To(Equal(common.UpgradePartitions{"default": 2}))
+			Expect(p.Condition.Message).To(Equal("upgrading server simple-server-default-2 from 3.9.2 to 3.9.3"))
+
+			p = plan(upgrading(2, 0), mntr(2))
+			Expect(p.Partitions).To(Equal(common.UpgradePartitions{"default": 2}))
+			Expect(p.Condition.Message).To(ContainSubstring("waiting for server simple-server-default-2 to roll out"))
+
+			p = plan(upgrading(2, 1), mntr(1))
+			Expect(p.Partitions).To(Equal(common.UpgradePartitions{"default": 2}))
+			Expect(p.Condition.Reason).To(Equal("WaitingForQuorum"))
+			Expect(p.Condition.Message).To(ContainSubstring("1 of 2 followers in sync"))
+
+			p = plan(upgrading(1, 2), mntr(2))
+			Expect(p.Partitions).To(Equal(common.UpgradePartitions{"default": 0}))
+			Expect(p.Condition.Message).To(Equal("upgrading server simple-server-default-0 from 3.9.2 to 3.9.3"))
+
+			p = plan(upgrading(0, 2), mntr(2))
+			Expect(p.Partitions).To(BeEmpty())
+			Expect(p.Condition.Message).To(ContainSubstring("waiting for the servers to roll out"))
+		})
+
+		It("asks the servers of TLS clusters on the TLS client port", func() {
+			var addresses []string
+			p, err := NewVersionUpgrade(newClient(append(servers(), upgrading(2, 1))...), zkCluster, zkv1alpha1.ClientTlsPhaseTls,
+				func(ctx context.Context, address, word string) (string, error) {
+					addresses = append(addresses, address)
+					return mntr(1)(ctx, address, word)
+				}).Plan(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p.Condition.Reason).To(Equal("WaitingForQuorum"))
+			Expect(addresses).To(HaveEach(SatisfyAll(HavePrefix("tls://"), HaveSuffix(fmt.Sprintf(":%d", zkv1alpha1.SecureClientPort)))))
+		})
+	})
+
+	It("refuses downgrades", func() {
+		zkCluster.Spec.Image.ProductVersion = "3.9.2"
+		zkCluster.Status.CurrentVersion = "3.9.3"
+		plan, err := NewVersionUpgrade(healthyCluster("3.9.3", "3.9.3"), zkCluster, "", mntr(1)).Plan(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.CurrentVersion).To(Equal("3.9.3"))
+		Expect(plan.InProgress()).To(BeFalse())
+		Expect(plan.Versions).To(Equal(common.ProductVersions{"default": "3.9.3", "secondary": "3.9.3"}))
+		Expect(plan.Condition.Reason).To(Equal("UnsupportedUpgrade"))
+	})
+
+	It("backs up the data before the upgrade", func() {
+		zkCluster.Spec.ClusterConfig = &zkv1alpha1.ClusterConfigSpec{Upgrade: &zkv1alpha1.UpgradeSpec{Backup: "nightly"}}
+		template := &zkv1alpha1.ZookeeperBackup{
+			ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "default"},
+			Spec:       zkv1alpha1.ZookeeperBackupSpec{ClusterName: "simple", Schedule: "0 3 * * *", Prefix: "backups/"},
+		}
+		k8sClient := newClient(template, statefulSet("default", "3.9.2"), statefulSet("secondary", "3.9.2"), pod("default"), pod("secondary"))
+		upgrade := NewVersionUpgrade(k8sClient, zkCluster, "", mntr(1))
+
+		plan, err := upgrade.Plan(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.Versions).To(HaveLen(2))
+		Expect(plan.Condition.Reason).To(Equal("BackingUp"))
+		backup := &zkv1alpha1.ZookeeperBackup{}
+		Expect(k8sClient.Get(ctx, ctrlclient.ObjectKey{Namespace: "default", Name: "simple-upgrade-3-9-3"}, backup)).To(Succeed())
+		Expect(backup.Spec.Schedule).To(BeEmpty())
+		Expect(backup.Spec.Prefix).To(Equal("backups/"))
+		Expect(metav1.IsControlledBy(backup, zkCluster)).To(BeTrue())
+
+		backup.Status.LastBackup = &zkv1alpha1.BackupRecord{Job: backup.Name}
+		Expect(k8sClient.Status().Update(ctx, backup)).To(Succeed())
+		plan, err = upgrade.Plan(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.Versions).To(Equal(common.ProductVersions{"secondary": "3.9.2"}))
+	})
+})
@@ -0,0 +1,178 @@
+package health
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+// ClusterHealth is the state of the servers of a cluster at the last check of the monitor.
+type ClusterHealth struct {
+	// CheckedAt is the time the servers were last checked.
+	CheckedAt time.Time
+	// External tells whether the servers join an external ensemble, whose leader the monitor does not check.
+	External bool
+	// Servers are ordered by name.
+	Servers []ServerHealth
+}
+
+// ServerHealth is the state of a server reported by its srvr and mntr commands.
+type ServerHealth struct {
+	Name    string
+	Address string
+	// Err tells why the server did not answer, empty if it did.
+	Err string
+	// Mode is leader, follower, observer or standalone.
+	Mode string
+	Zxid int64
+	// Lag is the number of transactions the server is behind the leader of the cluster, 0 for the leader and
+	// without a leader. Transactions of earlier epochs count 2^32 each.
+	Lag                 int64
+	OutstandingRequests int64
+	AvgLatency          float64
+	MaxLatency          int64
+	Connections         int64
+	// SyncedFollowers is reported by the leader only.
+	SyncedFollowers int64
+
+	// responses are the raw outputs of the commands by word
+	responses map[string]string
+}
+
+// Available tells whether the server answered the last check.
+func (s *ServerHealth) Available() bool {
+	return s.Err == ""
+}
+
+// newServerHealth reads the health of a server from the outputs of its srvr and mntr commands.
+func newServerHealth(name, address, srvr, mntr string) ServerHealth {
+	metrics := common.ParseMntr(mntr)
+	server := ServerHealth{
+		Name:                name,
+		Address:             address,
+		Mode:                metrics["zk_server_state"],
+		OutstandingRequests: parseInt(metrics["zk_outstanding_requests"]),
+		MaxLatency:          parseInt(metrics["zk_max_latency"]),
+		Connections:         parseInt(metrics["zk_num_alive_connections"]),
+		SyncedFollowers:     parseInt(metrics["zk_synced_followers"]),
+		responses:           map[string]string{"srvr": srvr, "mntr": mntr},
+	}
+	server.AvgLatency, _ = strconv.ParseFloat(metrics["zk_avg_latency"], 64)
+	for line := range strings.Lines(srvr) {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ": ")
+		switch {
+		case !ok:
+		case key == "Mode" && server.Mode == "":
+			server.Mode = value
+		case key == "Zxid":
+			server.Zxid, _ = strconv.ParseInt(strings.TrimPrefix(value, "0x"), 16, 64)
+		}
+	}
+	// servers without a quorum answer that they do not serve requests
+	if server.Mode == "" {
+		server.Err = "the server does not serve requests"
+	}
+	return server
+}
+
+func parseInt(value string) int64 {
+	i, _ := strconv.ParseInt(value, 10, 64)
+	return i
+}
+
+// newClusterHealth orders the servers by name and computes their lag behind the leader.
+func newClusterHealth(checkedAt time.Time, external bool, servers []ServerHealth) *ClusterHealth {
+	slices.SortFunc(servers, func(a, b ServerHealth) int { return strings.Compare(a.Name, b.Name) })
+	health := &ClusterHealth{CheckedAt: checkedAt, External: external, Servers: servers}
+	if leader := health.Leader(); leader != nil {
+		for i := range health.Servers {
+			server := &health.Servers[i]
+			if server.Available() {
+				server.Lag = max(leader.Zxid-server.Zxid, 0)
+			}
+		}
+	}
+	return health
+}
+
+// Leader returns the only server that leads, nil if none or more than one do.
+func (h *ClusterHealth) Leader() *ServerHealth {
+	var leader *ServerHealth
+	for i := range h.Servers {
+		server := &h.Servers[i]
+		if server.Mode != "leader" && server.Mode != "standalone" {
+			continue
+		}
+		if leader != nil {
+			return nil
+		}
+		leader = server
+	}
+	return leader
+}
+
+// Condition reports in the QuorumHealthy condition whether the servers agree on one leader with every follower in
+// sync. Nil health, before the first check of the cluster, is reported as unknown.
+func (h *ClusterHealth) Condition(generation int64) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               zkv1alpha1.ConditionQuorumHealthy,
+		Status:             metav1.ConditionUnknown,
+		Reason:             "NotChecked",
+		ObservedGeneration: generation,
+	}
+	switch {
+	case h == nil:
+		condition.Message = "the servers were not checked yet"
+		return condition
+	case len(h.Servers) == 0:
+		condition.Message = "the cluster has no servers"
+		return condition
+	}
+
+	var leaders, unavailable []string
+	followers, observers := 0, 0
+	for _, server := range h.Servers {
+		switch {
+		case !server.Available():
+			unavailable = append(unavailable, server.Name)
+		case server.Mode == "leader" || server.Mode == "standalone":
+			leaders = append(leaders, server.Name)
+		case server.Mode == "follower":
+			followers++
+		case server.Mode == "observer":
+			observers++
+		}
+	}
+	condition.Status = metav1.ConditionFalse
+	switch {
+	case len(leaders) == 0 && h.External && len(unavailable) == 0:
+		// the leader is one of the external servers
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Healthy"
+		condition.Message = fmt.Sprintf("%d followers and %d observers join the external ensemble", followers, observers)
+	case len(leaders) > 1 || len(leaders) == 0 && !h.External:
+		condition.Reason = "NoQuorum"
+		condition.Message = fmt.Sprintf("%d leaders %v", len(leaders), leaders)
+		if len(unavailable) != 0 {
+			condition.Message += ", unavailable: " + strings.Join(unavailable, ", ")
+		}
+	case len(unavailable) != 0:
+		condition.Reason = "ServersUnavailable"
+		condition.Message = "unavailable: " + strings.Join(unavailable, ", ")
+	case h.Leader().SyncedFollowers < int64(followers):
+		condition.Reason = "FollowersNotSynced"
+		condition.Message = fmt.Sprintf("%d of %d followers in sync with leader %s", h.Leader().SyncedFollowers, followers, leaders[0])
+	default:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Healthy"
+		condition.Message = fmt.Sprintf("leader %s with %d followers and %d observers", leaders[0], followers, observers)
+	}
+	return condition
+}
@@ -0,0 +1,101 @@
+package health
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsSubsystem = "zookeeper_cluster"
+
+var (
+	clusterLabels = []string{"namespace", "cluster"}
+	serverLabels  = []string{"namespace", "cluster", "server"}
+
+	quorumHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: metricsSubsystem,
+		Name:      "quorum_healthy",
+		Help:      "Whether the servers agree on one leader with every follower in sync, see the QuorumHealthy condition.",
+	}, clusterLabels)
+	serverUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: metricsSubsystem,
+		Name:      "server_up",
+		Help:      "Whether the server answered the last check.",
+	}, serverLabels)
+	serverLeader = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: metricsSubsystem,
+		Name:      "server_leader",
+		Help:      "Whether the server leads the ensemble.",
+	}, serverLabels)
+	serverLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: metricsSubsystem,
+		Name:      "server_zxid_lag",
+		Help:      "Number of transactions the server is behind the leader.",
+	}, serverLabels)
+	serverOutstandingRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: metricsSubsystem,
+		Name:      "server_outstanding_requests",
+		Help:      "Number of requests queued by the server.",
+	}, serverLabels)
+	serverAvgLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: metricsSubsystem,
+		Name:      "server_avg_latency_milliseconds",
+		Help:      "Average request latency of the server.",
+	}, serverLabels)
+	serverMaxLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: metricsSubsystem,
+		Name:      "server_max_latency_milliseconds",
+		Help:      "Maximum request latency of the server.",
+	}, serverLabels)
+	serverConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: metricsSubsystem,
+		Name:      "server_connections",
+		Help:      "Number of client connections to the server.",
+	}, serverLabels)
+
+	serverGauges = []*prometheus.GaugeVec{
+		serverUp, serverLeader, serverLag, serverOutstandingRequests, serverAvgLatency, serverMaxLatency, serverConnections,
+	}
+)
+
+func init() {
+	metrics.Registry.MustRegister(quorumHealthy)
+	for _, gauge := range serverGauges {
+		metrics.Registry.MustRegister(gauge)
+	}
+}
+
+// recordMetrics replaces the metrics of a cluster with its health, nil health removes them.
+func recordMetrics(key types.NamespacedName, health *ClusterHealth, condition metav1.Condition) {
+	labels := prometheus.Labels{"namespace": key.Namespace, "cluster": key.Name}
+	quorumHealthy.DeletePartialMatch(labels)
+	for _, gauge := range serverGauges {
+		gauge.DeletePartialMatch(labels)
+	}
+	if health == nil {
+		return
+	}
+
+	quorumHealthy.WithLabelValues(key.Namespace, key.Name).Set(boolValue(condition.Status == metav1.ConditionTrue))
+	for _, server := range health.Servers {
+		values := []string{key.Namespace, key.Name, server.Name}
+		serverUp.WithLabelValues(values...).Set(boolValue(server.Available()))
+		if !server.Available() {
+			continue
+		}
+		serverLeader.WithLabelValues(values...).Set(boolValue(server.Mode == "leader" || server.Mode == "standalone"))
+		serverLag.WithLabelValues(values...).Set(float64(server.Lag))
+		serverOutstandingRequests.WithLabelValues(values...).Set(float64(server.OutstandingRequests))
+		serverAvgLatency.WithLabelValues(values...).Set(server.AvgLatency)
+		serverMaxLatency.WithLabelValues(values...).Set(float64(server.MaxLatency))
+		serverConnections.WithLabelValues(values...).Set(float64(server.Connections))
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,205 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+var logger = ctrl.Log.WithName("health-monitor")
+
+// DefaultInterval is the time between two checks of the servers
+const DefaultInterval = 30 * time.Second
+
+// Monitor checks the servers of every ZookeeperCluster with the srvr and mntr commands in the background and keeps
+// their latest health, so that reconciles read it instead of asking the servers. Every cluster whose QuorumHealthy
+// condition changes is sent to Changes to be reconciled.
+// The servers are asked on the client port of the client TLS phase they were rolled out with, over TLS once the port
+// only accepts TLS, see common.ClientAddress.
+type Monitor struct {
+	client         ctrlclient.Client
+	interval       time.Duration
+	fourLetterWord common.FourLetterWordFunc
+
+	mu       sync.RWMutex
+	clusters map[types.NamespacedName]*ClusterHealth
+	changes  chan event.GenericEvent
+}
+
+var _ manager.LeaderElectionRunnable = &Monitor{}
+
+func NewMonitor(client ctrlclient.Client, interval time.Duration, fourLetterWord common.FourLetterWordFunc) *Monitor {
+	return &Monitor{
+		client:         client,
+		interval:       interval,
+		fourLetterWord: fourLetterWord,
+		clusters:       map[types.NamespacedName]*ClusterHealth{},
+		changes:        make(chan event.GenericEvent, 16),
+	}
+}
+
+// Start checks the servers every interval until the context is done.
+func (m *Monitor) Start(ctx context.Context) error {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		if err := m.Check(ctx); err != nil {
+			logger.Error(err, "unable to check the zookeeper clusters")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection makes only the leading operator check the servers, it is the one reconciling them.
+func (m *Monitor) NeedLeaderElection() bool {
+	return true
+}
+
+// Changes returns the clusters whose QuorumHealthy condition changed with the last check.
+func (m *Monitor) Changes() <-chan event.GenericEvent {
+	return m.changes
+}
+
+// Health returns the health of a cluster at the last check, nil if it was not checked yet.
+// The health is shared and must not be modified.
+func (m *Monitor) Health(key types.NamespacedName) *ClusterHealth {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.clusters[key]
+}
+
+// FourLetterWord answers the srvr and mntr commands from the last check of the server at address, see
+// common.FourLetterWordFunc. It fails for other commands and for servers that were not checked recently.
+func (m *Monitor) FourLetterWord(_ context.Context, address, word string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, health := range m.clusters {
+		// a check may take a while with many clusters, only checks missed more than twice are stale
+		if time.Since(health.CheckedAt) > 3*m.interval {
+			continue
+		}
+		for _, server := range health.Servers {
+			if server.Address != address {
+				continue
+			}
+			if !server.Available() {
+				return "", errors.New(server.Err)
+			}
+			if out, ok := server.responses[word]; ok {
+				return out, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%s of %s was not checked recently", word, address)
+}
+
+// Check checks the servers of every cluster once, and forgets the clusters that were deleted.
+func (m *Monitor) Check(ctx context.Context) error {
+	clusters := &zkv1alpha1.ZookeeperClusterList{}
+	if err := m.client.List(ctx, clusters); err != nil {
+		return err
+	}
+	checked := make(map[types.NamespacedName]bool, len(clusters.Items))
+	for i := range clusters.Items {
+		zkCluster := &clusters.Items[i]
+		key := ctrlclient.ObjectKeyFromObject(zkCluster)
+		checked[key] = true
+		health, err := m.checkCluster(ctx, zkCluster)
+		if err != nil {
+			logger.Error(err, "unable to check the servers", "namespace", key.Namespace, "name", key.Name)
+			continue
+		}
+
+		m.mu.Lock()
+		previous := m.clusters[key]
+		m.clusters[key] = health
+		m.mu.Unlock()
+
+		condition := health.Condition(zkCluster.Generation)
+		recordMetrics(key, health, condition)
+		if previous != nil && sameCondition(previous.Condition(zkCluster.Generation), condition) {
+			continue
+		}
+		logger.V(1).Info("Quorum health changed", "namespace", key.Namespace, "name", key.Name,
+			"reason", condition.Reason, "message", condition.Message)
+		select {
+		case m.changes <- event.GenericEvent{Object: zkCluster}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range m.clusters {
+		if !checked[key] {
+			delete(m.clusters, key)
+			recordMetrics(key, nil, metav1.Condition{})
+		}
+	}
+	return nil
+}
+
+// checkCluster asks every server of the cluster for its state, the servers at the same time.
+func (m *Monitor) checkCluster(ctx context.Context, zkCluster *zkv1alpha1.ZookeeperCluster) (*ClusterHealth, error) {
+	pods := &corev1.PodList{}
+	if err := m.client.List(ctx, pods,
+		ctrlclient.InNamespace(zkCluster.Namespace),
+		ctrlclient.MatchingLabels{
+			constants.LabelKubernetesInstance:  zkCluster.Name,
+			constants.LabelKubernetesComponent: string(common.Server),
+		},
+	); err != nil {
+		return nil, err
+	}
+	clusterConfig := zkCluster.Spec.ClusterConfig
+	clusterDomain := common.ClusterDomain(clusterConfig)
+
+	servers := make([]ServerHealth, len(pods.Items))
+	var wg sync.WaitGroup
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		address := common.ClientAddress(common.PodFQDN(pod.Name, pod.Spec.Subdomain, pod.Namespace, clusterDomain), zkCluster.Status.ClientTlsPhase)
+		wg.Go(func() {
+			servers[i] = m.checkServer(ctx, pod.Name, address)
+		})
+	}
+	wg.Wait()
+	external := clusterConfig != nil && clusterConfig.Migration != nil
+	return newClusterHealth(time.Now(), external, servers), nil
+}
+
+func (m *Monitor) checkServer(ctx context.Context, name, address string) ServerHealth {
+	srvr, err := m.fourLetterWord(ctx, address, "srvr")
+	if err != nil {
+		return ServerHealth{Name: name, Address: address, Err: err.Error()}
+	}
+	mntr, err := m.fourLetterWord(ctx, address, "mntr")
+	if err != nil {
+		return ServerHealth{Name: name, Address: address, Err: err.Error()}
+	}
+	return newServerHealth(name, address, srvr, mntr)
+}
+
+// sameCondition tells whether two conditions report the same, regardless of when.
+func sameCondition(a, b metav1.Condition) bool {
+	return a.Status == b.Status && a.Reason == b.Reason && a.Message == b.Message
+}
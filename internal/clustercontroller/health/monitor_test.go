@@ -0,0 +1,221 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+)
+
+var _ = Describe("Health monitor", func() {
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "default", Name: "simple"}
+	var zkCluster *zkv1alpha1.ZookeeperCluster
+	BeforeEach(func() {
+		zkCluster = &zkv1alpha1.ZookeeperCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default", Generation: 3},
+			Spec:       zkv1alpha1.ZookeeperClusterSpec{ClusterConfig: &zkv1alpha1.ClusterConfigSpec{ClusterDomain: "cluster.local"}},
+		}
+	})
+	pod := func(name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Labels: map[string]string{
+					constants.LabelKubernetesInstance:  "simple",
+					constants.LabelKubernetesComponent: "server",
+				},
+			},
+			Spec: corev1.PodSpec{Subdomain: "simple-server-default"},
+		}
+	}
+	newClient := func(objs ...ctrlclient.Object) ctrlclient.Client {
+		s := runtime.NewScheme()
+		Expect(scheme.AddToScheme(s)).To(Succeed())
+		Expect(zkv1alpha1.AddToScheme(s)).To(Succeed())
+		return fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).Build()
+	}
+	// state of a server by pod name: mode, zxid and synced followers; servers without a state do not answer
+	type state struct {
+		mode            string
+		zxid            int64
+		syncedFollowers int
+	}
+	fourLetterWord := func(states map[string]state) func(context.Context, string, string) (string, error) {
+		return func(_ context.Context, address, word string) (string, error) {
+			name, _, _ := strings.Cut(address, ".")
+			Expect(address).To(Equal(fmt.Sprintf("%s.simple-server-default.default.svc.cluster.local:%d", name, zkv1alpha1.ClientPort)))
+			s, ok := states[name]
+			if !ok {
+				return "", errors.New("connection refused")
+			}
+			switch word {
+			case "srvr":
+				return fmt.Sprintf("Zookeeper version: 3.9.3\nZxid: 0x%x\nMode: %s\n", s.zxid, s.mode), nil
+			case "mntr":
+				return fmt.Sprintf("zk_server_state\t%s\nzk_outstanding_requests\t2\nzk_avg_latency\t0.5\nzk_max_latency\t12\n"+
+					"zk_num_alive_connections\t4\nzk_synced_followers\t%d\n", s.mode, s.syncedFollowers), nil
+			}
+			return "", fmt.Errorf("unexpected %s", word)
+		}
+	}
+
+	It("keeps the state of every server with its lag behind the leader", func() {
+		k8sClient := newClient(zkCluster, pod("simple-server-default-0"), pod("simple-server-default-1"), pod("simple-server-default-2"))
+		monitor := NewMonitor(k8sClient, time.Minute, fourLetterWord(map[string]state{
+			"simple-server-default-0": {mode: "follower", zxid: 0x100000005},
+			"simple-server-default-1": {mode: "leader", zxid: 0x100000008, syncedFollowers: 2},
+			"simple-server-default-2": {mode: "follower", zxid: 0x100000008},
+		}))
+		Expect(monitor.Check(ctx)).To(Succeed())
+
+		health := monitor.Health(key)
+		Expect(health.Servers).To(HaveLen(3))
+		Expect(health.Leader().Name).To(Equal("simple-server-default-1"))
+		follower := health.Servers[0]
+		Expect(follower.Name).To(Equal("simple-server-default-0"))
+		Expect(follower.Lag).To(Equal(int64(3)))
+		Expect(follower.OutstandingRequests).To(Equal(int64(2)))
+		Expect(follower.AvgLatency).To(Equal(0.5))
+		Expect(follower.MaxLatency).To(Equal(int64(12)))
+		Expect(follower.Connections).To(Equal(int64(4)))
+		Expect(health.Servers[2].Lag).To(BeZero())
+
+		condition := health.Condition(zkCluster.Generation)
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(condition.Reason).To(Equal("Healthy"))
+		Expect(condition.Message).To(Equal("leader simple-server-default-1 with 2 followers and 0 observers"))
+		Expect(condition.ObservedGeneration).To(Equal(int64(3)))
+		Expect(monitor.Changes()).To(Receive(HaveField("Object.GetName()", "simple")))
+	})
+
+	It("answers mntr and srvr from the last check", func() {
+		k8sClient := newClient(zkCluster, pod("simple-server-default-0"), pod("simple-server-default-1"))
+		monitor := NewMonitor(k8sClient, time.Minute, fourLetterWord(map[string]state{
+			"simple-server-default-0": {mode: "leader", syncedFollowers: 1},
+		}))
+		Expect(monitor.Check(ctx)).To(Succeed())
+
+		address := fmt.Sprintf("simple-server-default-0.simple-server-default.default.svc.cluster.local:%d", zkv1alpha1.ClientPort)
+		out, err := monitor.FourLetterWord(ctx, address, "mntr")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(ContainSubstring("zk_server_state\tleader"))
+		_, err = monitor.FourLetterWord(ctx, address, "conf")
+		Expect(err).To(MatchError(ContainSubstring("was not checked recently")))
+		_, err = monitor.FourLetterWord(ctx, strings.Replace(address, "-0.", "-1.", 1), "mntr")
+		Expect(err).To(MatchError("connection refused"))
+	})
+
+	It("reports changes of the quorum health only", func() {
+		k8sClient := newClient(zkCluster, pod("simple-server-default-0"), pod("simple-server-default-1"))
+		states := map[string]state{
+			"simple-server-default-0": {mode: "leader", zxid: 1, syncedFollowers: 1},
+			"simple-server-default-1": {mode: "follower", zxid: 1},
+		}
+		monitor := NewMonitor(k8sClient, time.Minute, fourLetterWord(states))
+		Expect(monitor.Check(ctx)).To(Succeed())
+		Expect(monitor.Changes()).To(Receive())
+
+		states["simple-server-default-0"] = state{mode: "leader", zxid: 9, syncedFollowers: 1}
+		Expect(monitor.Check(ctx)).To(Succeed())
+		Expect(monitor.Changes()).NotTo(Receive())
+		Expect(monitor.Health(key).Servers[1].Lag).To(Equal(int64(8)))
+
+		delete(states, "simple-server-default-0")
+		Expect(monitor.Check(ctx)).To(Succeed())
+		Expect(monitor.Changes()).To(Receive())
+		condition := monitor.Health(key).Condition(zkCluster.Generation)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal("NoQuorum"))
+		Expect(condition.Message).To(Equal("0 leaders [], unavailable: simple-server-default-0"))
+	})
+
+	It("forgets deleted clusters", func() {
+		k8sClient := newClient(zkCluster, pod("simple-server-default-0"))
+		monitor := NewMonitor(k8sClient, time.Minute, fourLetterWord(map[string]state{
+			"simple-server-default-0": {mode: "standalone"},
+		}))
+		Expect(monitor.Check(ctx)).To(Succeed())
+		Expect(monitor.Health(key).Condition(zkCluster.Generation).Status).To(Equal(metav1.ConditionTrue))
+
+		Expect(k8sClient.Delete(ctx, zkCluster)).To(Succeed())
+		Expect(monitor.Check(ctx)).To(Succeed())
+		Expect(monitor.Health(key)).To(BeNil())
+		Expect(monitor.Health(key).Condition(zkCluster.Generation).Reason).To(Equal("NotChecked"))
+	})
+
+	It("asks the servers of TLS clusters on the TLS client port", func() {
+		zkCluster.Status.ClientTlsPhase = zkv1alpha1.ClientTlsPhaseTls
+		address := fmt.Sprintf("tls://simple-server-default-0.simple-server-default.default.svc.cluster.local:%d", zkv1alpha1.SecureClientPort)
+		monitor := NewMonitor(newClient(zkCluster, pod("simple-server-default-0")), time.Minute,
+			func(_ context.Context, addr, word string) (string, error) {
+				Expect(addr).To(Equal(address))
+				if word == "srvr" {
+					return "Zxid: 0x1\nMode: standalone\n", nil
+				}
+				return "zk_server_state\tstandalone\n", nil
+			})
+		Expect(monitor.Check(ctx)).To(Succeed())
+		condition := monitor.Health(key).Condition(zkCluster.Generation)
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(monitor.FourLetterWord(ctx, address, "mntr")).To(ContainSubstring("standalone"))
+	})
+})
+
+var _ = Describe("Quorum health condition", func() {
+	server := func(name, mode string, syncedFollowers int64) ServerHealth {
+		return ServerHealth{Name: name, Mode: mode, SyncedFollowers: syncedFollowers}
+	}
+
+	It("reports followers that are not in sync", func() {
+		health := newClusterHealth(time.Now(), false, []ServerHealth{
+			server("a", "leader", 1), server("b", "follower", 0), server("c", "follower", 0), server("d", "observer", 0),
+		})
+		condition := health.Condition(1)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal("FollowersNotSynced"))
+		Expect(condition.Message).To(Equal("1 of 2 followers in sync with leader a"))
+	})
+
+	It("reports unavailable servers of a quorum", func() {
+		health := newClusterHealth(time.Now(), false, []ServerHealth{
+			server("a", "leader", 1), server("b", "follower", 0), {Name: "c", Err: "connection refused"},
+		})
+		condition := health.Condition(1)
+		Expect(condition.Reason).To(Equal("ServersUnavailable"))
+		Expect(condition.Message).To(Equal("unavailable: c"))
+	})
+
+	It("reports more than one leader", func() {
+		health := newClusterHealth(time.Now(), false, []ServerHealth{server("a", "leader", 0), server("b", "leader", 0)})
+		Expect(health.Leader()).To(BeNil())
+		Expect(health.Condition(1).Reason).To(Equal("NoQuorum"))
+	})
+
+	It("accepts the leader of an external ensemble", func() {
+		health := newClusterHealth(time.Now(), true, []ServerHealth{server("a", "observer", 0), server("b", "observer", 0)})
+		condition := health.Condition(1)
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(condition.Message).To(Equal("0 followers and 2 observers join the external ensemble"))
+	})
+
+	It("treats servers that do not serve requests as unavailable", func() {
+		health := newServerHealth("a", "a:2181", "This ZooKeeper instance is not currently serving requests\n", "")
+		Expect(health.Available()).To(BeFalse())
+	})
+})
@@ -2,8 +2,12 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"slices"
 	"strconv"
+	"strings"
 
 	commonsv1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/commons/v1alpha1"
 	"github.com/zncdatadev/operator-go/pkg/builder"
@@ -22,19 +26,50 @@ import (
 const (
 	ConsoleConversionPattern = "%d{ISO8601} [myid:%X{myid}] - %-5p [%t:%C{1}@%L] - %m%n"
 	LogbackConfigFileName    = "logback.xml"
+
+	// ConfigHashAnnotation on the pod template holds the hash of the config files the servers only read at startup,
+	// so that the servers are restarted when they change, see ConfigHash
+	ConfigHashAnnotation = "zookeeper.kubedoop.dev/config-hash"
+	// logbackScanPeriod is how often logback checks its config file for changes
+	logbackScanPeriod = "30 seconds"
 )
 
+// ReloadableConfigFiles are the files of the role group ConfigMap the servers reload while running.
+// Logback scans its config file, which the servers read from the ConfigMap volume where changes show up.
+var ReloadableConfigFiles = []string{LogbackConfigFileName}
+
+// ConfigHash returns the hash of the files of the role group ConfigMap the servers need a restart for.
+// It changes the pod template when these files change, so the statefulset rolls the servers one at a time,
+// waiting for each to rejoin the quorum.
+func ConfigHash(data map[string]string) string {
+	files := make([]string, 0, len(data))
+	for file := range data {
+		if !slices.Contains(ReloadableConfigFiles, file) {
+			files = append(files, file)
+		}
+	}
+	slices.Sort(files)
+	hash := sha256.New()
+	for _, file := range files {
+		// the lengths keep the boundaries between names and contents
+		fmt.Fprintf(hash, "%d:%s%d:%s", len(file), file, len(data[file]), data[file])
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
 func NewConfigMapReconciler(
 	ctx context.Context,
 	client *client.Client,
 	repilicates *int32,
+	myidOffset int32,
 	options *reconciler.RoleGroupInfo,
 	overrides *commonsv1alpha1.OverridesSpec,
 	roleGroupSpec *commonsv1alpha1.RoleGroupConfigSpec,
 	zkSecurity *security.ZookeeperSecurity,
+	clusterConfig *zkv1alpha1.ClusterConfigSpec,
+	migration *common.Migration,
 ) reconciler.ResourceReconciler[*builder.ConfigMapBuilder] {
 
-	myidOffSet := 1
 	var zooCfgOverride, securityPropsOverride map[string]string
 	if overrides != nil {
 		configOverride := overrides.ConfigOverrides
@@ -55,11 +90,13 @@ func NewConfigMapReconciler(
 		*client,
 		namespace,
 		*repilicates,
-		uint16(myidOffSet),
+		uint16(myidOffset),
 		zooCfgOverride,
 		securityPropsOverride,
 		zkSecurity,
+		clusterConfig,
 		roleGroupSpec.Logging,
+		migration,
 	)
 	return reconciler.NewGenericResourceReconciler(client, cmBuilder)
 }
@@ -74,7 +111,9 @@ func NewConfigMapBuilder(
 	zooCfgOverride map[string]string,
 	securityPropsOverride map[string]string,
 	zkSecurity *security.ZookeeperSecurity,
+	clusterConfig *zkv1alpha1.ClusterConfigSpec,
 	loggingSpec *commonsv1alpha1.LoggingSpec,
+	migration *common.Migration,
 ) *builder.ConfigMapBuilder {
 	configGenerator := &ConfigGenerator{
 		RoleGroupInfo:         roleGroupInfo,
@@ -83,7 +122,10 @@ func NewConfigMapBuilder(
 		myidOffset:            myidOffset,
 		zooCfgOverride:        zooCfgOverride,
 		securityPropsOverride: securityPropsOverride,
+		admin:                 clusterConfig.Admin,
+		clusterDomain:         common.ClusterDomain(clusterConfig),
 		zkSecurity:            zkSecurity,
+		migration:             migration,
 	}
 	buider := builder.NewConfigMapBuilder(
 		&client,
@@ -120,8 +162,12 @@ type ConfigGenerator struct {
 	myidOffset            uint16
 	zooCfgOverride        map[string]string
 	securityPropsOverride map[string]string
+	admin                 *zkv1alpha1.AdminSpec
+	clusterDomain         string
 
 	zkSecurity *security.ZookeeperSecurity
+	// migration joins the servers to an external ensemble, see MigrationSpec
+	migration *common.Migration
 }
 
 // create zoo.cfg
@@ -129,14 +175,17 @@ func (c *ConfigGenerator) createZooCfgData() string {
 	var zooCfg = make(map[string]string)
 	// default properties
 	maps.Copy(zooCfg, map[string]string{
-		"admin.serverPort":          strconv.Itoa(zkv1alpha1.AdminPort),
-		"4lw.commands.whitelist":    "srvr, mntr, conf, ruok",
+		"4lw.commands.whitelist":    common.FourLetterWordsWhitelist(c.admin),
 		"metricsProvider.className": "org.apache.zookeeper.metrics.prometheus.PrometheusMetricsProvider",
 		"metricsProvider.httpPort":  strconv.Itoa(zkv1alpha1.NativeMetricsProviderPort),
 	})
-	if c.replicates > 1 {
+	maps.Copy(zooCfg, common.AdminConfigSettings(c.admin))
+	if c.replicates > 1 || c.migration != nil {
 		maps.Copy(zooCfg, c.createZooServers())
 	}
+	if c.migration.Observing() {
+		zooCfg["peerType"] = "observer"
+	}
 
 	maps.Copy(zooCfg, c.zkSecurity.ConfigSettings())
 	zooCfg = c.configOverrides(zooCfg)
@@ -154,16 +203,15 @@ func (c *ConfigGenerator) createSecurityPropertiesData() string {
 
 func (c *ConfigGenerator) createZooServers() map[string]string {
 	var servers = make(map[string]string)
+	// the external servers are static peers while the servers join their ensemble
+	maps.Copy(servers, c.migration.ZooServers())
 	// range repilicates
 	for i := 0; i < int(c.replicates); i++ {
 		zkMyId := i + int(c.myidOffset)
-		serverKey := fmt.Sprintf("server.%d", zkMyId)
 		podName := fmt.Sprintf("%s-%d", common.StatefulsetName(c.RoleGroupInfo), i)
-		podFQDN := common.PodFQDN(podName, common.RoleGroupServiceName(c.RoleGroupInfo), c.namespace)
-		server := fmt.Sprintf("%s:2888:3888;%d", podFQDN, c.zkSecurity.ClientPort())
-		maps.Copy(servers, map[string]string{
-			serverKey: server,
-		})
+		podFQDN := common.PodFQDN(podName, common.RoleGroupServiceName(c.RoleGroupInfo), c.namespace, c.clusterDomain)
+		servers[common.ZooServerKey(int32(zkMyId))] = common.ZooServer(
+			podFQDN, zkv1alpha1.LeaderPort, zkv1alpha1.ElectionPort, c.migration.Observing(), security.ZooServerClientPortOf(c.zkSecurity.ClientTlsPhase()))
 	}
 	return servers
 }
@@ -201,5 +249,5 @@ func createLogbackXmlConfig(loggingSpec *commonsv1alpha1.LoggingSpec) string {
 	if err != nil {
 		panic(err)
 	}
-	return xml
+	return strings.Replace(xml, "<configuration>", fmt.Sprintf(`<configuration scan="true" scanPeriod="%s">`, logbackScanPeriod), 1)
 }
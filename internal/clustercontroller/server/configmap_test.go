@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/zncdatadev/operator-go/pkg/reconciler"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+	"github.com/zncdatadev/zookeeper-operator/internal/security"
+)
+
+var _ = Describe("Config hash", func() {
+	data := func(zooCfg, logback string) map[string]string {
+		return map[string]string{
+			zkv1alpha1.ZooCfgFileName:   zooCfg,
+			zkv1alpha1.SecurityFileName: "networkaddress.cache.ttl=30\n",
+			LogbackConfigFileName:       logback,
+		}
+	}
+
+	It("changes with the files the servers read at startup", func() {
+		hash := ConfigHash(data("tickTime=3000\n", "<configuration/>"))
+		Expect(hash).To(HaveLen(16))
+		Expect(ConfigHash(data("tickTime=3000\n", "<configuration/>"))).To(Equal(hash))
+		Expect(ConfigHash(data("tickTime=2000\n", "<configuration/>"))).NotTo(Equal(hash))
+	})
+
+	It("ignores the files the servers reload", func() {
+		Expect(ConfigHash(data("tickTime=3000\n", "<configuration/>"))).To(Equal(ConfigHash(data("tickTime=3000\n", "<configuration debug=\"true\"/>"))))
+	})
+
+	It("keeps the boundaries between files", func() {
+		Expect(ConfigHash(map[string]string{"a": "bc"})).NotTo(Equal(ConfigHash(map[string]string{"ab": "c"})))
+	})
+
+	It("lets logback scan its config for changes", func() {
+		Expect(createLogbackXmlConfig(nil)).To(ContainSubstring(`<configuration scan="true" scanPeriod="30 seconds">`))
+	})
+})
+
+var _ = Describe("Migration servers", func() {
+	newGenerator := func(migration *common.Migration) *ConfigGenerator {
+		zkSecurity, err := security.NewZookeeperSecurity(context.Background(), nil, "simple", nil)
+		Expect(err).NotTo(HaveOccurred())
+		return &ConfigGenerator{
+			RoleGroupInfo: &reconciler.RoleGroupInfo{
+				RoleInfo:      reconciler.RoleInfo{ClusterInfo: reconciler.ClusterInfo{ClusterName: "simple"}, RoleName: "server"},
+				RoleGroupName: "default",
+			},
+			namespace:     "default",
+			replicates:    1,
+			myidOffset:    4,
+			clusterDomain: "cluster.local",
+			zkSecurity:    zkSecurity,
+			migration:     migration,
+		}
+	}
+	externalServers := []zkv1alpha1.ExternalServerSpec{
+		{ID: 1, Host: "zk-1.example.com"},
+		{ID: 2, Host: "zk-2.example.com", QuorumPort: 2889, LeaderElectionPort: 3889, ClientPort: 2182},
+	}
+
+	It("joins the servers to the external ensemble as observers", func() {
+		zooCfg := newGenerator(&common.Migration{Phase: zkv1alpha1.MigrationObserve, ExternalServers: externalServers}).createZooCfgData()
+
+		Expect(zooCfg).To(ContainSubstring("server.1=zk-1.example.com:2888:3888;2181\n"))
+		Expect(zooCfg).To(ContainSubstring("server.2=zk-2.example.com:2889:3889;2182\n"))
+		Expect(zooCfg).To(ContainSubstring("server.4=simple-server-default-0.simple-server-default.default.svc.cluster.local:2888:3888:observer;2181\n"))
+		Expect(zooCfg).To(ContainSubstring("peerType=observer\n"))
+	})
+
+	It("promotes the servers to participants", func() {
+		zooCfg := newGenerator(&common.Migration{Phase: zkv1alpha1.MigrationParticipate, ExternalServers: externalServers[1:]}).createZooCfgData()
+
+		Expect(zooCfg).NotTo(ContainSubstring("server.1="))
+		Expect(zooCfg).To(ContainSubstring("server.2=zk-2.example.com:2889:3889;2182\n"))
+		Expect(zooCfg).To(ContainSubstring("server.4=simple-server-default-0.simple-server-default.default.svc.cluster.local:2888:3888;2181\n"))
+		Expect(zooCfg).NotTo(ContainSubstring("peerType"))
+	})
+
+	It("runs a single server standalone without a migration", func() {
+		Expect(newGenerator(nil).createZooCfgData()).NotTo(ContainSubstring("server."))
+	})
+})
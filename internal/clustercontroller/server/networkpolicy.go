@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"slices"
+
+	"github.com/zncdatadev/operator-go/pkg/builder"
+	"github.com/zncdatadev/operator-go/pkg/client"
+	"github.com/zncdatadev/operator-go/pkg/reconciler"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+	"github.com/zncdatadev/zookeeper-operator/internal/security"
+	"github.com/zncdatadev/zookeeper-operator/internal/util"
+)
+
+const (
+	// namespaceNameLabel is set on every namespace by Kubernetes
+	namespaceNameLabel = "kubernetes.io/metadata.name"
+
+	quorumNetworkPolicySuffix  = "-quorum"
+	clientNetworkPolicySuffix  = "-client"
+	metricsNetworkPolicySuffix = "-metrics"
+
+	defaultMonitoringNamespace = "monitoring"
+)
+
+// NetworkPolicyEnabled checks if NetworkPolicies are generated for the servers.
+func NetworkPolicyEnabled(networkPolicy *zkv1alpha1.NetworkPolicySpec) bool {
+	return networkPolicy != nil && networkPolicy.Enabled
+}
+
+// NewNetworkPolicyReconcilers creates the quorum, client and metrics NetworkPolicies of a role group.
+// NetworkPolicies are additive, so each one only allows the traffic of its ports.
+func NewNetworkPolicyReconcilers(
+	client *client.Client,
+	info *reconciler.RoleGroupInfo,
+	networkPolicy *zkv1alpha1.NetworkPolicySpec,
+	admin *zkv1alpha1.AdminSpec,
+	zkSecurity *security.ZookeeperSecurity,
+) []reconciler.Reconciler {
+	// servers of all role groups form the ensemble
+	ensemble := []networkingv1.NetworkPolicyPeer{{
+		PodSelector: &metav1.LabelSelector{MatchLabels: info.ClusterInfo.GetLabels()},
+	}}
+
+	monitoringNamespace := networkPolicy.MonitoringNamespace
+	if monitoringNamespace == "" {
+		monitoringNamespace = defaultMonitoringNamespace
+	}
+
+	return []reconciler.Reconciler{
+		newNetworkPolicyReconciler(client, info, quorumNetworkPolicySuffix, networkingv1.NetworkPolicyIngressRule{
+			Ports: networkPolicyPorts(zkv1alpha1.LeaderPort, zkv1alpha1.ElectionPort),
+			From:  ensemble,
+		}),
+		newNetworkPolicyReconciler(client, info, clientNetworkPolicySuffix, networkingv1.NetworkPolicyIngressRule{
+			Ports: clientNetworkPolicyPorts(admin, zkSecurity),
+			From:  clientNetworkPolicyPeers(networkPolicy),
+		}),
+		newNetworkPolicyReconciler(client, info, metricsNetworkPolicySuffix, networkingv1.NetworkPolicyIngressRule{
+			Ports: networkPolicyPorts(zkv1alpha1.MetricsPort, zkv1alpha1.NativeMetricsProviderPort),
+			From:  []networkingv1.NetworkPolicyPeer{namespacePeer(monitoringNamespace)},
+		}),
+	}
+}
+
+// ObsoleteNetworkPolicies returns the NetworkPolicies of a role group, which are deleted when they are turned off.
+func ObsoleteNetworkPolicies(info *reconciler.RoleGroupInfo) []ctrlclient.Object {
+	objects := make([]ctrlclient.Object, 0, 3)
+	for _, suffix := range []string{quorumNetworkPolicySuffix, clientNetworkPolicySuffix, metricsNetworkPolicySuffix} {
+		objects = append(objects, &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: info.GetFullName() + suffix}})
+	}
+	return objects
+}
+
+func newNetworkPolicyReconciler(
+	client *client.Client,
+	info *reconciler.RoleGroupInfo,
+	suffix string,
+	rule networkingv1.NetworkPolicyIngressRule,
+) reconciler.Reconciler {
+	npBuilder := &NetworkPolicyBuilder{
+		ObjectMeta: *builder.NewObjectMeta(
+			client,
+			info.GetFullName()+suffix,
+			func(o *builder.Options) {
+				o.Labels = info.GetLabels()
+				o.Annotations = info.GetAnnotations()
+			},
+		),
+		podSelector: info.GetLabels(),
+		ingress:     []networkingv1.NetworkPolicyIngressRule{rule},
+	}
+	return reconciler.NewGenericResourceReconciler[builder.ObjectBuilder](client, npBuilder)
+}
+
+// clientNetworkPolicyPorts returns the client ports, and the AdminServer port if it is started.
+func clientNetworkPolicyPorts(admin *zkv1alpha1.AdminSpec, zkSecurity *security.ZookeeperSecurity) []networkingv1.NetworkPolicyPort {
+	ports := make([]int32, 0, 3)
+	for _, port := range zkSecurity.ClientContainerPorts() {
+		ports = append(ports, port.ContainerPort)
+	}
+	if common.AdminServerEnabled(admin) {
+		ports = append(ports, zkv1alpha1.AdminPort)
+	}
+	return networkPolicyPorts(ports...)
+}
+
+// clientNetworkPolicyPeers returns the configured clients, all pods of the namespace if none are configured,
+// and the operator namespace.
+func clientNetworkPolicyPeers(networkPolicy *zkv1alpha1.NetworkPolicySpec) []networkingv1.NetworkPolicyPeer {
+	peers := slices.Clone(networkPolicy.Clients)
+	if len(peers) == 0 {
+		peers = []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}}
+	}
+	if namespace, ok := util.OperatorNamespace(); ok {
+		peers = append(peers, namespacePeer(namespace))
+	} else {
+		logger.V(1).Info("operator namespace unknown, the operator is not allowed by the client NetworkPolicy")
+	}
+	return peers
+}
+
+func namespacePeer(namespace string) networkingv1.NetworkPolicyPeer {
+	return networkingv1.NetworkPolicyPeer{
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: namespace}},
+	}
+}
+
+func networkPolicyPorts(ports ...int32) []networkingv1.NetworkPolicyPort {
+	policyPorts := make([]networkingv1.NetworkPolicyPort, 0, len(ports))
+	for _, port := range ports {
+		policyPorts = append(policyPorts, networkingv1.NetworkPolicyPort{
+			Protocol: ptr.To(corev1.ProtocolTCP),
+			Port:     ptr.To(intstr.FromInt32(port)),
+		})
+	}
+	return policyPorts
+}
+
+var _ builder.ObjectBuilder = &NetworkPolicyBuilder{}
+
+// NetworkPolicyBuilder builds an ingress NetworkPolicy for the pods of a role group.
+type NetworkPolicyBuilder struct {
+	builder.ObjectMeta
+
+	podSelector map[string]string
+	ingress     []networkingv1.NetworkPolicyIngressRule
+}
+
+func (b *NetworkPolicyBuilder) Build(_ context.Context) (ctrlclient.Object, error) {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: b.GetObjectMeta(),
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: b.podSelector},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     b.ingress,
+		},
+	}, nil
+}
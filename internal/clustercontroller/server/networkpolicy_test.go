@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/zncdatadev/operator-go/pkg/builder"
+	"github.com/zncdatadev/operator-go/pkg/client"
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	"github.com/zncdatadev/operator-go/pkg/reconciler"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+var _ = Describe("NetworkPolicy", func() {
+	It("should allow all pods of the namespace if no clients are configured", func() {
+		peers := clientNetworkPolicyPeers(&zkv1alpha1.NetworkPolicySpec{Enabled: true})
+		Expect(peers[0].PodSelector).To(Equal(&metav1.LabelSelector{}))
+		Expect(peers[0].NamespaceSelector).To(BeNil())
+	})
+
+	It("should not modify the configured clients", func() {
+		clients := []networkingv1.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{namespaceNameLabel: "kafka"},
+		}}}
+		peers := clientNetworkPolicyPeers(&zkv1alpha1.NetworkPolicySpec{Enabled: true, Clients: clients})
+		Expect(peers[0]).To(Equal(clients[0]))
+		Expect(clients).To(HaveLen(1))
+	})
+
+	It("should select the pods of the role group", func() {
+		cluster := &zkv1alpha1.ZookeeperCluster{ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default"}}
+		info := &reconciler.RoleGroupInfo{
+			RoleInfo: reconciler.RoleInfo{ClusterInfo: reconciler.ClusterInfo{
+				GVK:         &metav1.GroupVersionKind{Group: zkv1alpha1.GroupVersion.Group, Kind: "ZookeeperCluster"},
+				ClusterName: "simple",
+			}, RoleName: "server"},
+			RoleGroupName: "default",
+		}
+		npBuilder := &NetworkPolicyBuilder{
+			ObjectMeta:  *builder.NewObjectMeta(client.NewClient(nil, cluster), info.GetFullName()+quorumNetworkPolicySuffix),
+			podSelector: info.GetLabels(),
+			ingress: []networkingv1.NetworkPolicyIngressRule{{
+				Ports: networkPolicyPorts(zkv1alpha1.LeaderPort, zkv1alpha1.ElectionPort),
+			}},
+		}
+
+		obj, err := npBuilder.Build(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		networkPolicy := obj.(*networkingv1.NetworkPolicy)
+		Expect(networkPolicy.Name).To(Equal("simple-server-default-quorum"))
+		Expect(networkPolicy.Namespace).To(Equal("default"))
+		Expect(networkPolicy.Spec.PodSelector.MatchLabels).To(HaveKeyWithValue(constants.LabelKubernetesRoleGroup, "default"))
+		Expect(networkPolicy.Spec.PolicyTypes).To(ConsistOf(networkingv1.PolicyTypeIngress))
+		Expect(networkPolicy.Spec.Ingress[0].Ports).To(HaveLen(2))
+		Expect(networkPolicy.Spec.Ingress[0].Ports[0].Port.IntValue()).To(Equal(zkv1alpha1.LeaderPort))
+	})
+
+	It("should delete the NetworkPolicies of a role group when they are turned off", func() {
+		cluster := &zkv1alpha1.ZookeeperCluster{ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default", UID: "simple-uid"}}
+		info := &reconciler.RoleGroupInfo{
+			RoleInfo:      reconciler.RoleInfo{ClusterInfo: reconciler.ClusterInfo{ClusterName: "simple"}, RoleName: "server"},
+			RoleGroupName: "default",
+		}
+		networkPolicy := func(name string) *networkingv1.NetworkPolicy {
+			return &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "default",
+				OwnerReferences: []metav1.OwnerReference{{Name: "simple", UID: cluster.UID, Controller: ptr.To(true)}},
+			}}
+		}
+		k8sClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+			networkPolicy("simple-server-default-quorum"),
+			networkPolicy("simple-server-default-client"),
+			networkPolicy("simple-server-other-client"),
+			// not created by the operator
+			&networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "simple-server-default-metrics", Namespace: "default"}},
+		).Build()
+
+		_, err := common.NewObsoleteResourceReconciler(client.NewClient(k8sClient, cluster), ObsoleteNetworkPolicies(info)...).Reconcile(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		networkPolicies := &networkingv1.NetworkPolicyList{}
+		Expect(k8sClient.List(context.Background(), networkPolicies)).To(Succeed())
+		var names []string
+		for _, networkPolicy := range networkPolicies.Items {
+			names = append(names, networkPolicy.Name)
+		}
+		Expect(names).To(ConsistOf("simple-server-other-client", "simple-server-default-metrics"))
+	})
+})
@@ -0,0 +1,35 @@
+# Runs before the server is stopped. ZooKeeper cannot hand its leadership over, so when this server leads it waits
+# until every learner is in sync. The remaining servers then elect the new leader from the latest state as soon as
+# the leader stops, instead of syncing with it first. It gives up after HANDOFF_TIMEOUT seconds, which leaves the
+# rest of the graceful shutdown timeout to the server to stop.
+# The output goes to the log of the container, preStop hooks have none of their own.
+exec >/proc/1/fd/1 2>&1
+
+four_letter_word() {
+  timeout 5 bash -c "exec 3<>/dev/tcp/127.0.0.1/${CLIENT_PORT} && echo $1 >&3 && cat <&3"
+}
+
+mntr_value() {
+  echo "${mntr}" | awk -v key="$1" 'BEGIN { value = 0 } $1 == key { value = $2 } END { print value }'
+}
+
+mode=$(four_letter_word srvr | sed -n 's/^Mode: //p')
+if [ "${mode}" != "leader" ]; then
+  echo "preStop: server is ${mode:-not serving}, stopping"
+  exit 0
+fi
+
+deadline=$((SECONDS + HANDOFF_TIMEOUT))
+while [ "${SECONDS}" -lt "${deadline}" ]; do
+  mntr=$(four_letter_word mntr)
+  learners=$(mntr_value zk_learners)
+  synced=$(($(mntr_value zk_synced_followers) + $(mntr_value zk_synced_non_voting_followers) + $(mntr_value zk_synced_observers)))
+  pending=$(mntr_value zk_pending_syncs)
+  if [ "${synced}" -ge "${learners}" ] && [ "${pending}" -eq 0 ]; then
+    echo "preStop: leader stops with ${synced} of ${learners} learners in sync"
+    exit 0
+  fi
+  echo "preStop: waiting for the learners to sync, ${synced} of ${learners} in sync, ${pending} pending syncs"
+  sleep 1
+done
+echo "preStop: learners did not sync within ${HANDOFF_TIMEOUT}s, stopping anyway"
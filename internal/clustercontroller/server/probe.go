@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+const (
+	startupProbePeriodSeconds  = 5
+	livenessProbePeriodSeconds = 10
+	// minStartupSeconds is the least time a server gets to start answering
+	minStartupSeconds = 60
+	// minLivenessFailures is the least number of failed liveness checks before a server is restarted
+	minLivenessFailures = 3
+)
+
+// GetStartupProbe waits for the server to answer `ruok`, which it does as soon as it listens, before its data is
+// loaded. Loading the data and syncing with the leader are covered by the readiness probe, and do not fail the
+// liveness probe as `ruok` keeps answering meanwhile.
+func (b *StatefulsetBuilder) GetStartupProbe() *corev1.Probe {
+	initLimit := time.Duration(b.zooCfgInt(common.INIT_LIMIT, common.DefaultInitLimit)) * b.tickTime()
+	probe := b.fourLetterWordProbe("ruok", "imok")
+	probe.PeriodSeconds = startupProbePeriodSeconds
+	probe.TimeoutSeconds = b.probeTimeoutSeconds()
+	probe.FailureThreshold = max(
+		ceilSeconds(10*initLimit, startupProbePeriodSeconds),
+		minStartupSeconds/startupProbePeriodSeconds,
+	)
+	return b.tuneProbe(probe, func(p *zkv1alpha1.ProbesSpec) *zkv1alpha1.ProbeSpec { return p.Startup })
+}
+
+// GetLivenessProbe restarts a server that stopped answering, e.g. a wedged JVM. A server answers `ruok` while
+// it has no quorum, so the servers are not restarted during leader elections.
+func (b *StatefulsetBuilder) GetLivenessProbe() *corev1.Probe {
+	initLimit := time.Duration(b.zooCfgInt(common.INIT_LIMIT, common.DefaultInitLimit)) * b.tickTime()
+	probe := b.fourLetterWordProbe("ruok", "imok")
+	probe.PeriodSeconds = livenessProbePeriodSeconds
+	probe.TimeoutSeconds = b.probeTimeoutSeconds()
+	probe.FailureThreshold = max(ceilSeconds(2*initLimit, livenessProbePeriodSeconds), minLivenessFailures)
+	return b.tuneProbe(probe, func(p *zkv1alpha1.ProbesSpec) *zkv1alpha1.ProbeSpec { return p.Liveness })
+}
+
+// GetReadinessProbe checks that the server is part of the quorum, as only then it answers `srvr` with its mode.
+// !!!Note !!!: if you wanner srvr command work well that you must set `publishNotReadyAddresses=true` in headless service
+func (b *StatefulsetBuilder) GetReadinessProbe() *corev1.Probe {
+	probe := b.fourLetterWordProbe("srvr", "^Mode: ")
+	probe.FailureThreshold = 3
+	probe.PeriodSeconds = 1
+	probe.TimeoutSeconds = 1
+	return b.tuneProbe(probe, func(p *zkv1alpha1.ProbesSpec) *zkv1alpha1.ProbeSpec { return p.Readiness })
+}
+
+// fourLetterWordProbe sends a four letter word to the client port and expects the response to match the pattern.
+// The plaintext client port listens in all TLS phases, once only TLS is served on the loopback interface only,
+// see security.ZookeeperSecurity.ConfigSettings.
+func (b *StatefulsetBuilder) fourLetterWordProbe(word, pattern string) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{
+				Command: []string{
+					"bash",
+					"-c",
+					fmt.Sprintf("exec 3<>/dev/tcp/127.0.0.1/%d && echo %s >&3 && grep '%s' <&3", zkv1alpha1.ClientPort, word, pattern),
+				},
+			},
+		},
+		SuccessThreshold: 1,
+	}
+}
+
+// tuneProbe applies the timing configured for the role group.
+func (b *StatefulsetBuilder) tuneProbe(probe *corev1.Probe, pick func(*zkv1alpha1.ProbesSpec) *zkv1alpha1.ProbeSpec) *corev1.Probe {
+	if b.probes == nil {
+		return probe
+	}
+	spec := pick(b.probes)
+	if spec == nil {
+		return probe
+	}
+	if spec.PeriodSeconds != nil {
+		probe.PeriodSeconds = *spec.PeriodSeconds
+	}
+	if spec.TimeoutSeconds != nil {
+		probe.TimeoutSeconds = *spec.TimeoutSeconds
+	}
+	if spec.FailureThreshold != nil {
+		probe.FailureThreshold = *spec.FailureThreshold
+	}
+	return probe
+}
+
+// probeTimeoutSeconds gives a server one tick to answer.
+func (b *StatefulsetBuilder) probeTimeoutSeconds() int32 {
+	return max(ceilSeconds(b.tickTime(), 1), 1)
+}
+
+func (b *StatefulsetBuilder) tickTime() time.Duration {
+	return time.Duration(b.zooCfgInt(common.TICK_TIME, common.DefaultTickTime)) * time.Millisecond
+}
+
+// zooCfgInt returns a setting of the rendered `zoo.cfg`, which holds the defaults, the configured and the overridden settings.
+func (b *StatefulsetBuilder) zooCfgInt(key string, defaultValue int) int {
+	if b.Overrides == nil {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(b.Overrides.ConfigOverrides[zkv1alpha1.ZooCfgFileName][key])
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// ceilSeconds returns how many periods of the given seconds cover the duration.
+func ceilSeconds(d time.Duration, periodSeconds int32) int32 {
+	period := time.Duration(periodSeconds) * time.Second
+	return int32((d + period - 1) / period)
+}
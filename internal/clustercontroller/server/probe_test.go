@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	commonsv1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/commons/v1alpha1"
+	"k8s.io/utils/ptr"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/security"
+)
+
+var _ = Describe("Probes", func() {
+	newBuilder := func(zooCfg map[string]string, probes *zkv1alpha1.ProbesSpec) *StatefulsetBuilder {
+		overrides := &commonsv1alpha1.OverridesSpec{
+			ConfigOverrides: map[string]map[string]string{zkv1alpha1.ZooCfgFileName: zooCfg},
+		}
+		zkSecurity, err := security.NewZookeeperSecurity(context.Background(), nil, "simple", nil)
+		Expect(err).NotTo(HaveOccurred())
+		return NewStatefulSetBuilder(nil, "simple-server-default", nil, nil, nil, nil, 1, zkSecurity, overrides, nil, nil, nil, probes, nil, "")
+	}
+
+	It("checks that the servers are ok and serving", func() {
+		b := newBuilder(nil, nil)
+
+		Expect(b.GetLivenessProbe().Exec.Command).To(ContainElement("exec 3<>/dev/tcp/127.0.0.1/2181 && echo ruok >&3 && grep 'imok' <&3"))
+		Expect(b.GetStartupProbe().Exec.Command).To(ContainElement("exec 3<>/dev/tcp/127.0.0.1/2181 && echo ruok >&3 && grep 'imok' <&3"))
+		Expect(b.GetReadinessProbe().Exec.Command).To(ContainElement("exec 3<>/dev/tcp/127.0.0.1/2181 && echo srvr >&3 && grep '^Mode: ' <&3"))
+	})
+
+	It("scales the defaults with tickTime and initLimit", func() {
+		b := newBuilder(map[string]string{"tickTime": "3000", "initLimit": "5"}, nil)
+
+		liveness := b.GetLivenessProbe()
+		Expect(liveness.PeriodSeconds).To(Equal(int32(10)))
+		Expect(liveness.TimeoutSeconds).To(Equal(int32(3)))
+		Expect(liveness.FailureThreshold).To(Equal(int32(3)))
+
+		startup := b.GetStartupProbe()
+		Expect(startup.PeriodSeconds).To(Equal(int32(5)))
+		Expect(startup.TimeoutSeconds).To(Equal(int32(3)))
+		Expect(startup.FailureThreshold).To(Equal(int32(30)))
+
+		b = newBuilder(map[string]string{"tickTime": "2000", "initLimit": "30"}, nil)
+		Expect(b.GetLivenessProbe().FailureThreshold).To(Equal(int32(12)))
+		Expect(b.GetLivenessProbe().TimeoutSeconds).To(Equal(int32(2)))
+		Expect(b.GetStartupProbe().FailureThreshold).To(Equal(int32(120)))
+	})
+
+	It("gives small clusters enough time to start", func() {
+		b := newBuilder(map[string]string{"tickTime": "500", "initLimit": "2"}, nil)
+
+		Expect(b.GetStartupProbe().FailureThreshold).To(Equal(int32(12)))
+		Expect(b.GetLivenessProbe().TimeoutSeconds).To(Equal(int32(1)))
+	})
+
+	It("applies the configured timing", func() {
+		b := newBuilder(nil, &zkv1alpha1.ProbesSpec{
+			Liveness:  &zkv1alpha1.ProbeSpec{FailureThreshold: ptr.To(int32(6))},
+			Readiness: &zkv1alpha1.ProbeSpec{PeriodSeconds: ptr.To(int32(5)), TimeoutSeconds: ptr.To(int32(2))},
+		})
+
+		liveness := b.GetLivenessProbe()
+		Expect(liveness.FailureThreshold).To(Equal(int32(6)))
+		Expect(liveness.PeriodSeconds).To(Equal(int32(10)))
+		readiness := b.GetReadinessProbe()
+		Expect(readiness.PeriodSeconds).To(Equal(int32(5)))
+		Expect(readiness.TimeoutSeconds).To(Equal(int32(2)))
+		Expect(readiness.FailureThreshold).To(Equal(int32(3)))
+	})
+})
@@ -0,0 +1,46 @@
+# Restores the data of a server from a backup archive before ZooKeeper first starts on it.
+# It runs after the helpers of common.S3Script. The record of the restore is written to the termination log,
+# where the operator checks that every server restored the same snapshot.
+# Everything is restored to DATA_DIR, the prepare container moves the transaction logs to LOG_DIR if it is set.
+
+marker="${RESTORE_MARKER}"
+if [ -f "${marker}" ]; then
+  echo "data was restored before, see ${marker}"
+  cp "${marker}" /dev/termination-log
+  exit 0
+fi
+mkdir --parents "${DATA_DIR}"
+if compgen -G "${DATA_DIR}/snapshot.*" >/dev/null || compgen -G "${DATA_DIR}/log.*" >/dev/null ||
+  { [ -n "${LOG_DIR:-}" ] && compgen -G "${LOG_DIR}/log.*" >/dev/null; }; then
+  echo "${DATA_DIR} already holds data, skipping the restore"
+  printf '{"restored":false}' >/dev/termination-log
+  exit 0
+fi
+
+archive=/tmp/restore.tar.gz
+staging="${DATA_DIR}.restore"
+rm -rf "${staging}"
+mkdir --parents "${staging}"
+echo "downloading ${RESTORE_KEY} from bucket ${S3_BUCKET}"
+curl "${curl_opts[@]}" -H "x-amz-content-sha256: ${empty_sha256}" --output "${archive}" "$(object_url "${RESTORE_KEY}")"
+tar -xzf "${archive}" -C "${staging}"
+
+# every server has to restore the same snapshot, or the ensemble would diverge
+snapshot=""
+for file in "${staging}"/snapshot.*; do
+  [ -e "${file}" ] || continue
+  file=$(basename "${file}")
+  if [ -z "${snapshot}" ] || [ "$(zxid_of "${file}")" -gt "$(zxid_of "${snapshot}")" ]; then
+    snapshot=${file}
+  fi
+done
+if [ -z "${snapshot}" ] || [ "$(zxid_of "${snapshot}")" -ne "$((16#${RESTORE_ZXID}))" ]; then
+  echo "expected the snapshot of zxid ${RESTORE_ZXID} in ${RESTORE_KEY}, found '${snapshot}'" >&2
+  exit 1
+fi
+
+mv "${staging}"/* "${DATA_DIR}"
+rm -rf "${staging}" "${archive}"
+printf '{"restored":true,"key":"%s","zxid":"%s"}' "${RESTORE_KEY}" "${RESTORE_ZXID}" >"${marker}"
+cp "${marker}" /dev/termination-log
+echo "restored ${snapshot} and its transaction logs from ${RESTORE_KEY}"
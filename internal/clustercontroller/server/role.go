@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/zncdatadev/operator-go/pkg/client"
+	opconstants "github.com/zncdatadev/operator-go/pkg/constants"
 	"github.com/zncdatadev/operator-go/pkg/reconciler"
 	"github.com/zncdatadev/operator-go/pkg/util"
 	zkv1alph1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
@@ -24,6 +25,10 @@ type Reconciler struct {
 	reconciler.BaseRoleReconciler[*zkv1alph1.ServerSpec]
 	ClusterConfig *zkv1alph1.ClusterConfigSpec
 	Image         *util.Image
+	Restore       *common.RestoreSource
+	Versions      common.ProductVersions
+	Partitions    common.UpgradePartitions
+	Migration     *common.Migration
 }
 
 func NewReconciler(
@@ -33,6 +38,10 @@ func NewReconciler(
 	clusterConfig *zkv1alph1.ClusterConfigSpec,
 	image *util.Image,
 	spec *zkv1alph1.ServerSpec,
+	restore *common.RestoreSource,
+	versions common.ProductVersions,
+	partitions common.UpgradePartitions,
+	migration *common.Migration,
 ) *Reconciler {
 	clusterStopped := false
 	if clusterOperation != nil {
@@ -47,6 +56,10 @@ func NewReconciler(
 		),
 		Image:         image,
 		ClusterConfig: clusterConfig,
+		Restore:       restore,
+		Versions:      versions,
+		Partitions:    partitions,
+		Migration:     migration,
 	}
 }
 
@@ -66,6 +79,20 @@ func (r *Reconciler) RegisterResources(ctx context.Context) error {
 		if mergedConfig == nil {
 			mergedConfig = &zkv1alph1.ConfigSpec{}
 		}
+		info := &reconciler.RoleGroupInfo{
+			RoleInfo:      r.RoleInfo,
+			RoleGroupName: name,
+		}
+		if mergedConfig.DataLogStorage == nil {
+			dataLogStorage, err := ExistingDataLogStorage(ctx, r.Client, common.StatefulsetName(info))
+			if err != nil {
+				return err
+			}
+			if dataLogStorage != nil {
+				logger.Info("keeping the transaction log volume of the statefulset", "role", r.GetName(), "roleGroup", name)
+				mergedConfig.DataLogStorage = dataLogStorage
+			}
+		}
 		if overrides == nil {
 			overrides = &commonsv1alpha1.OverridesSpec{}
 		}
@@ -74,11 +101,7 @@ func (r *Reconciler) RegisterResources(ctx context.Context) error {
 			return err
 		}
 
-		info := &reconciler.RoleGroupInfo{
-			RoleInfo:      r.RoleInfo,
-			RoleGroupName: name,
-		}
-		reconcilers, err := r.RegisterResourceWithRoleGroup(ctx, info, &roleGroup.Replicas, mergedConfig.RoleGroupConfigSpec, overrides)
+		reconcilers, err := r.RegisterResourceWithRoleGroup(ctx, info, &roleGroup.Replicas, common.MyidOffset(r.Spec, name), mergedConfig.RoleGroupConfigSpec, mergedConfig.DataLogStorage, mergedConfig.PersistentVolumeClaimRetentionPolicy, mergedConfig.Probes, overrides)
 		if err != nil {
 			return err
 		}
@@ -95,46 +118,82 @@ func (r *Reconciler) RegisterResourceWithRoleGroup(
 	ctx context.Context,
 	info *reconciler.RoleGroupInfo,
 	repilicates *int32,
+	myidOffset int32,
 	mergedRoleGroupConfig *commonsv1alpha1.RoleGroupConfigSpec,
+	dataLogStorage *commonsv1alpha1.StorageResource,
+	retentionPolicy *zkv1alph1.PersistentVolumeClaimRetentionPolicySpec,
+	probes *zkv1alph1.ProbesSpec,
 	mergedOverrides *commonsv1alpha1.OverridesSpec,
 ) ([]reconciler.Reconciler, error) {
 	reconcilers := make([]reconciler.Reconciler, 0, 4)
 	// security
-	zkSecurity, err := security.NewZookeeperSecurity(ctx, r.Client.Client, r.ClusterConfig)
+	zkSecurity, err := security.NewZookeeperSecurity(ctx, r.Client.Client, r.RoleInfo.ClusterName, r.ClusterConfig)
 	if err != nil {
 		logger.V(1).Info("failed to create zookeeper security", "error", err)
 		return nil, err
 	}
+	if err := common.ValidateAdmin(r.ClusterConfig.Admin, zkSecurity); err != nil {
+		return nil, err
+	}
+
+	// 1. configmap, updated before the statefulset so that restarted servers read the new config
+	configMap := NewConfigMapReconciler(ctx, r.Client, repilicates, myidOffset, info, mergedOverrides, mergedRoleGroupConfig, zkSecurity, r.ClusterConfig, r.Migration)
+	reconcilers = append(reconcilers, configMap)
 
-	// 1. statefulset
+	// 2. statefulset
 	statefulSet, err := NewStatefulsetReconciler(
 		r.Client,
 		info,
 		r.ClusterConfig,
-		r.Image,
+		r.Versions.Image(r.Image, info.RoleGroupName),
 		repilicates,
+		r.Partitions.Partition(info.RoleGroupName),
+		myidOffset,
 		r.ClusterStopped(),
 		mergedOverrides,
 		mergedRoleGroupConfig,
-		zkSecurity)
+		dataLogStorage,
+		retentionPolicy,
+		probes,
+		zkSecurity,
+		r.Restore,
+		ConfigHash(configMap.GetBuilder().GetData()))
 	if err != nil {
 		logger.V(1).Info("failed to create statefulset reconciler", "error", err)
 		return nil, err
 	}
 	reconcilers = append(reconcilers, statefulSet)
 
-	// 2. service
+	// 3. service
 	listenerClass := r.ClusterConfig.ListenerClass
+	podServices := int32(0)
+	if common.ExternalStableEnabled(r.ClusterConfig) {
+		// servers are exposed by their own services, the headless service only provides the pod DNS names
+		listenerClass = opconstants.ClusterInternal
+		podServices = *repilicates
+		reconcilers = append(reconcilers, NewPodServiceReconcilers(
+			r.Client,
+			info,
+			*repilicates,
+			r.ClusterConfig.ExternalStable,
+			common.ExternalStableServiceType(r.ClusterConfig),
+			zkSecurity,
+		)...)
+	}
+	reconcilers = append(reconcilers, NewPodServiceCleaner(r.Client, info, podServices))
 	service := NewServiceReconciler(r.Client, info, listenerClass, zkSecurity)
 	reconcilers = append(reconcilers, service)
 
-	// 3. metrics service
+	// 4. metrics service
 	metricsService := NewRoleGroupMetricsService(r.Client, info)
 	reconcilers = append(reconcilers, metricsService)
 
-	// 4. configmap
-	configMap := NewConfigMapReconciler(ctx, r.Client, repilicates, info, mergedOverrides, mergedRoleGroupConfig, zkSecurity)
-	reconcilers = append(reconcilers, configMap)
+	// 5. network policies
+	if NetworkPolicyEnabled(r.ClusterConfig.NetworkPolicy) {
+		reconcilers = append(reconcilers, NewNetworkPolicyReconcilers(r.Client, info, r.ClusterConfig.NetworkPolicy, r.ClusterConfig.Admin, zkSecurity)...)
+	} else {
+		reconcilers = append(reconcilers, common.NewObsoleteResourceReconciler(r.Client, ObsoleteNetworkPolicies(info)...))
+	}
 
 	return reconcilers, nil
 }
@@ -2,9 +2,13 @@ package server
 
 import (
 	"context"
+	_ "embed"
 	"fmt"
+	"maps"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	commonsv1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/commons/v1alpha1"
 	"github.com/zncdatadev/operator-go/pkg/builder"
@@ -30,10 +34,17 @@ func NewStatefulsetReconciler(
 	clusterConfig *zkv1alpha1.ClusterConfigSpec,
 	image *oputil.Image,
 	repilicates *int32,
+	partition *int32,
+	myidOffset int32,
 	stopped bool,
 	overrides *commonsv1alpha1.OverridesSpec,
 	roleGroupConfig *commonsv1alpha1.RoleGroupConfigSpec,
+	dataLogStorage *commonsv1alpha1.StorageResource,
+	retentionPolicy *zkv1alpha1.PersistentVolumeClaimRetentionPolicySpec,
+	probes *zkv1alpha1.ProbesSpec,
 	zkSecurity *security.ZookeeperSecurity,
+	restore *common.RestoreSource,
+	configHash string,
 ) (reconciler.ResourceReconciler[builder.StatefulSetBuilder], error) {
 
 	stsBuilder := NewStatefulSetBuilder(
@@ -42,9 +53,16 @@ func NewStatefulsetReconciler(
 		clusterConfig,
 		image,
 		repilicates,
+		partition,
+		myidOffset,
 		zkSecurity,
 		overrides,
 		roleGroupConfig,
+		dataLogStorage,
+		retentionPolicy,
+		probes,
+		restore,
+		configHash,
 		func(o *builder.Options) {
 			o.ClusterName = roleGroupInfo.ClusterName
 			o.RoleName = roleGroupInfo.RoleName
@@ -53,11 +71,14 @@ func NewStatefulsetReconciler(
 			o.Annotations = roleGroupInfo.GetAnnotations()
 		},
 	)
-	return reconciler.NewStatefulSet(
-		client,
-		stsBuilder,
-		stopped,
-	), nil
+	return &statefulSetReconciler{
+		StatefulSet: reconciler.NewStatefulSet(
+			client,
+			stsBuilder,
+			stopped,
+		),
+		claims: stsBuilder.volumeClaimTemplates(),
+	}, nil
 }
 
 var _ builder.StatefulSetBuilder = &StatefulsetBuilder{}
@@ -68,9 +89,16 @@ func NewStatefulSetBuilder(
 	clusterConfig *zkv1alpha1.ClusterConfigSpec,
 	image *oputil.Image,
 	repilicates *int32,
+	partition *int32,
+	myidOffset int32,
 	zkSecurity *security.ZookeeperSecurity,
 	overrides *commonsv1alpha1.OverridesSpec,
 	roleGroupConfig *commonsv1alpha1.RoleGroupConfigSpec,
+	dataLogStorage *commonsv1alpha1.StorageResource,
+	retentionPolicy *zkv1alpha1.PersistentVolumeClaimRetentionPolicySpec,
+	probes *zkv1alpha1.ProbesSpec,
+	restore *common.RestoreSource,
+	configHash string,
 	options ...builder.Option,
 ) *StatefulsetBuilder {
 	opts := builder.Options{}
@@ -87,7 +115,15 @@ func NewStatefulSetBuilder(
 			roleGroupConfig,
 			options...,
 		),
-		zkSecurity: zkSecurity,
+		ClusterConfig:   clusterConfig,
+		partition:       partition,
+		myidOffset:      myidOffset,
+		zkSecurity:      zkSecurity,
+		dataLog:         dataLogStorage,
+		retentionPolicy: retentionPolicy,
+		probes:          probes,
+		restore:         restore,
+		configHash:      configHash,
 	}
 }
 
@@ -95,14 +131,35 @@ type StatefulsetBuilder struct {
 	builder.StatefulSet
 	ClusterConfig *zkv1alpha1.ClusterConfigSpec
 
-	zkSecurity *security.ZookeeperSecurity
+	// partition rolls the servers one at a time while they are upgraded, see common.UpgradePartitions
+	partition *int32
+	// myidOffset is the id of the first server, see common.MyidOffset
+	myidOffset      int32
+	zkSecurity      *security.ZookeeperSecurity
+	dataLog         *commonsv1alpha1.StorageResource
+	retentionPolicy *zkv1alpha1.PersistentVolumeClaimRetentionPolicySpec
+	probes          *zkv1alpha1.ProbesSpec
+	restore         *common.RestoreSource
+	configHash      string
 }
 
+//go:embed restore.sh
+var restoreScript string
+
+//go:embed prestop.sh
+var preStopScript string
+
 func (b *StatefulsetBuilder) Build(ctx context.Context) (ctrlClient.Object, error) {
 	b.AddContainers(b.buildContainers())
+	if b.restore != nil {
+		b.AddInitContainer(b.buildRestoreContainer())
+		b.AddVolumes(b.restore.Volumes())
+	}
 	b.AddInitContainer(b.buildInitContainer())
 	b.AddVolumes(b.getVolumes())
-	b.AddVolumeClaimTemplate(b.createVolumeClaimTemplate())
+	for _, claim := range b.volumeClaimTemplates() {
+		b.AddVolumeClaimTemplate(claim)
+	}
 	// vector
 	if IsVectorEnable(b.RoleGroupConfig.Logging) {
 		vectorFactory := GetVectorFactory(b.GetImage())
@@ -119,9 +176,28 @@ func (b *StatefulsetBuilder) Build(ctx context.Context) (ctrlClient.Object, erro
 	podTemplateSpec := &obj.Spec.Template
 	zkContainer := &podTemplateSpec.Spec.Containers[0]
 	b.zkSecurity.AddVolumeMounts(podTemplateSpec, zkContainer)
+	if annotations := b.zkSecurity.PodAnnotations(); len(annotations) > 0 {
+		if podTemplateSpec.Annotations == nil {
+			podTemplateSpec.Annotations = make(map[string]string, len(annotations))
+		}
+		maps.Copy(podTemplateSpec.Annotations, annotations)
+	}
+	if b.configHash != "" {
+		if podTemplateSpec.Annotations == nil {
+			podTemplateSpec.Annotations = make(map[string]string, 1)
+		}
+		podTemplateSpec.Annotations[ConfigHashAnnotation] = b.configHash
+	}
 
 	obj.Spec.PodManagementPolicy = appv1.ParallelPodManagement // parallel pod management
-	obj.Spec.ServiceName = b.Name                              // headless service name
+	if b.partition != nil {
+		obj.Spec.UpdateStrategy = appv1.StatefulSetUpdateStrategy{
+			Type:          appv1.RollingUpdateStatefulSetStrategyType,
+			RollingUpdate: &appv1.RollingUpdateStatefulSetStrategy{Partition: b.partition},
+		}
+	}
+	obj.Spec.PersistentVolumeClaimRetentionPolicy = b.persistentVolumeClaimRetentionPolicy()
+	obj.Spec.ServiceName = b.Name // headless service name
 	obj.Spec.Template.Spec.ServiceAccountName = zkv1alpha1.DefaultProductName
 
 	userId := int64(1001) // service account name
@@ -149,28 +225,40 @@ func (b *StatefulsetBuilder) buildContainers() []corev1.Container {
 		SetArgs(b.getMainContainerCommanArgs()).
 		AddVolumeMounts(b.getVolumeMounts()).AddEnvVars(b.getEnvVars()).
 		AddPorts(b.getPorts()).
+		SetStartupProbe(b.GetStartupProbe()).
+		SetLivenessProbe(b.GetLivenessProbe()).
 		SetReadinessProbe(b.GetReadinessProbe())
-	containers = append(containers, *mainContainerBuilder.Build())
+	mainContainer := mainContainerBuilder.Build()
+	mainContainer.Lifecycle = &corev1.Lifecycle{PreStop: b.getPreStopHandler()}
+	containers = append(containers, *mainContainer)
 	return containers
 }
 
+// getPreStopHandler lets a leader wait for its learners to sync before it stops, see prestop.sh.
+// The wait takes at most half of the graceful shutdown timeout, the server gets the other half to stop.
+func (b *StatefulsetBuilder) getPreStopHandler() *corev1.LifecycleHandler {
+	gracePeriod := common.DefaultServerGrace * time.Second
+	if timeout, err := b.GetTerminationGracePeriod(); err == nil && timeout != nil {
+		gracePeriod = *timeout
+	}
+	env := fmt.Sprintf("CLIENT_PORT=%d\nHANDOFF_TIMEOUT=%d\n", zkv1alpha1.ClientPort, int64(gracePeriod.Seconds())/2)
+	return &corev1.LifecycleHandler{
+		Exec: &corev1.ExecAction{Command: []string{"/bin/bash", "-c", env + preStopScript}},
+	}
+}
+
 // build init container
 func (b *StatefulsetBuilder) buildInitContainer() *corev1.Container {
 	image := b.GetImage()
 	prepareContainerBuilder := builder.NewContainer("prepare", image).
 		SetImagePullPolicy(b.GetImage().GetPullPolicy()).
 		SetCommand([]string{"/bin/bash", "-x", "-euo", "pipefail", "-c"}).
-		SetArgs([]string{"expr $MYID_OFFSET + $(echo $POD_NAME | sed 's/.*-//') > /kubedoop/data/myid"}).
-		AddVolumeMounts([]corev1.VolumeMount{
-			{
-				Name:      zkv1alpha1.DataDirName,
-				MountPath: constants.KubedoopDataDir,
-			},
-		}).
+		SetArgs([]string{b.getInitContainerCommandArgs()}).
+		AddVolumeMounts(b.dataVolumeMounts()).
 		AddEnvVars([]corev1.EnvVar{
 			{
 				Name:  common.MyIdOffset,
-				Value: "1",
+				Value: strconv.Itoa(int(b.myidOffset)),
 			},
 			{
 				Name:  common.ServerJvmFlags,
@@ -188,10 +276,125 @@ func (b *StatefulsetBuilder) buildInitContainer() *corev1.Container {
 				Name:  common.ZKServerHeap,
 				Value: "409",
 			},
+			{
+				Name:  "ENSEMBLE_ID",
+				Value: b.ensembleID(),
+			},
 		})
 	return prepareContainerBuilder.Build()
 }
 
+// buildRestoreContainer fills an empty data volume with the backup archive, see RestoreSpec.
+// Failures are reported from its logs, successes from the record it writes to the termination log.
+func (b *StatefulsetBuilder) buildRestoreContainer() *corev1.Container {
+	image := b.GetImage()
+	env := append(b.restore.EnvVars(),
+		corev1.EnvVar{Name: "DATA_DIR", Value: path.Join(constants.KubedoopDataDir, "version-2")},
+		corev1.EnvVar{Name: "RESTORE_MARKER", Value: path.Join(constants.KubedoopDataDir, "restore.json")},
+		corev1.EnvVar{Name: "RESTORE_KEY", Value: b.restore.Key},
+		corev1.EnvVar{Name: "RESTORE_ZXID", Value: b.restore.Zxid},
+	)
+	if b.dataLog != nil {
+		env = append(env, corev1.EnvVar{Name: "LOG_DIR", Value: path.Join(common.DataLogDir, "version-2")})
+	}
+	container := builder.NewContainer(common.RestoreContainerName, image).
+		SetImagePullPolicy(image.GetPullPolicy()).
+		SetCommand([]string{"/bin/bash", "-euo", "pipefail", "-c"}).
+		SetArgs([]string{common.S3Script + "\n" + restoreScript}).
+		AddEnvVars(env).
+		AddVolumeMounts(append(b.dataVolumeMounts(), b.restore.VolumeMounts()...)).
+		Build()
+	container.TerminationMessagePolicy = corev1.TerminationMessageFallbackToLogsOnError
+	return container
+}
+
+// init container command args. It checks that the data belongs to this server, see ClusterConfigSpec.StaleDataPolicy,
+// writes myid, and moves the transaction logs to their own volume when it is added.
+func (b *StatefulsetBuilder) getInitContainerCommandArgs() string {
+	dataDirs := "${SNAP_DIR}"
+	dirs := fmt.Sprintf("SNAP_DIR=%s", path.Join(constants.KubedoopDataDir, "version-2"))
+	if b.dataLog != nil {
+		dataDirs += " ${LOG_DIR}"
+		dirs += fmt.Sprintf("\nLOG_DIR=%s", path.Join(common.DataLogDir, "version-2"))
+	}
+	staleData := `  echo "refusing to start, clean up the volume or set the stale data policy to Adopt or Wipe" >&2
+  exit 1`
+	if b.ClusterConfig != nil {
+		switch b.ClusterConfig.StaleDataPolicy {
+		case zkv1alpha1.StaleDataWipe:
+			staleData = fmt.Sprintf(`  echo "wiping the stale data"
+  rm -rf %s`, dataDirs)
+		case zkv1alpha1.StaleDataAdopt:
+			// only the data of the same id continues the history of this server
+			staleData = `  if [ "${RECORDED#*/}" != "${MYID}" ]; then
+    echo "refusing to adopt the data of server ${RECORDED#*/}, clean up the volume or set the stale data policy to Wipe" >&2
+    exit 1
+  fi
+  echo "adopting the data"`
+		}
+	}
+
+	// servers of clusters created before the marker only have their myid recorded
+	args := fmt.Sprintf(`%s
+MYID=$(expr $MYID_OFFSET + $(echo $POD_NAME | sed 's/.*-//'))
+MEMBER="${ENSEMBLE_ID}/${MYID}"
+MARKER=%s
+if [ -f ${MARKER} ]; then
+  RECORDED=$(cat ${MARKER})
+elif [ -f %s ] && [ -d ${SNAP_DIR} ]; then
+  RECORDED="${ENSEMBLE_ID}/$(cat %s)"
+else
+  RECORDED=${MEMBER}
+fi
+if [ "${RECORDED}" != "${MEMBER}" ]; then
+  echo "the data belongs to ensemble member ${RECORDED}, not ${MEMBER}" >&2
+%s
+fi
+echo ${MYID} > %s
+echo ${MEMBER} > ${MARKER}`,
+		dirs,
+		path.Join(constants.KubedoopDataDir, "ensemble"),
+		path.Join(constants.KubedoopDataDir, "myid"), path.Join(constants.KubedoopDataDir, "myid"),
+		staleData,
+		path.Join(constants.KubedoopDataDir, "myid"),
+	)
+	if b.dataLog != nil {
+		// zookeeper refuses to start when the snapshot directory holds transaction logs and dataLogDir is elsewhere
+		args += `
+mkdir --parents ${LOG_DIR}
+if [ -d ${SNAP_DIR} ]; then
+  find ${SNAP_DIR} -maxdepth 1 -name 'log.*' -exec mv --target-directory=${LOG_DIR} {} +
+fi`
+	}
+	return args
+}
+
+// ensembleID identifies the cluster on the data volumes of its servers, so volumes of a deleted cluster
+// of the same name are recognized.
+func (b *StatefulsetBuilder) ensembleID() string {
+	if b.Client == nil || b.Client.GetOwnerReference() == nil {
+		return ""
+	}
+	return string(b.Client.GetOwnerReference().GetUID())
+}
+
+// retention policy of the volumes, they are retained unless configured otherwise
+func (b *StatefulsetBuilder) persistentVolumeClaimRetentionPolicy() *appv1.StatefulSetPersistentVolumeClaimRetentionPolicy {
+	policy := &appv1.StatefulSetPersistentVolumeClaimRetentionPolicy{
+		WhenDeleted: appv1.RetainPersistentVolumeClaimRetentionPolicyType,
+		WhenScaled:  appv1.RetainPersistentVolumeClaimRetentionPolicyType,
+	}
+	if b.retentionPolicy != nil {
+		if b.retentionPolicy.WhenDeleted != "" {
+			policy.WhenDeleted = b.retentionPolicy.WhenDeleted
+		}
+		if b.retentionPolicy.WhenScaled != "" {
+			policy.WhenScaled = b.retentionPolicy.WhenScaled
+		}
+	}
+	return policy
+}
+
 // main container command args
 func (b *StatefulsetBuilder) getMainContainerCommanArgs() []string {
 	zkConfigPath := path.Join(constants.KubedoopConfigDir, "zoo.cfg")
@@ -204,6 +407,9 @@ mkdir --parents ${CONFIG_DIR}
 echo copying ${LOG_CONFIG_DIR_MOUNT} to ${CONFIG_DIR}, ${CONFIG_DIR_MOUNT} to ${CONFIG_DIR}
 cp -RL ${LOG_CONFIG_DIR_MOUNT}* ${CONFIG_DIR}
 cp -RL ${CONFIG_DIR_MOUNT}* ${CONFIG_DIR}`, constants.KubedoopLogDirMount, constants.KubedoopConfigDirMount, constants.KubedoopConfigDir))
+	if b.zkSecurity != nil {
+		args = append(args, b.zkSecurity.PrepareCommands()...)
+	}
 	args = append(args, oputil.CommonBashTrapFunctions)
 	args = append(args, oputil.RemoveVectorShutdownFileCommand())
 	args = append(args, oputil.InvokePrepareSignalHandlers)
@@ -217,11 +423,7 @@ cp -RL ${CONFIG_DIR_MOUNT}* ${CONFIG_DIR}`, constants.KubedoopLogDirMount, const
 
 // main container volume mounts
 func (b *StatefulsetBuilder) getVolumeMounts() []corev1.VolumeMount {
-	return []corev1.VolumeMount{
-		{
-			Name:      zkv1alpha1.DataDirName,
-			MountPath: constants.KubedoopDataDir,
-		},
+	return append(b.dataVolumeMounts(), []corev1.VolumeMount{
 		{
 			Name:      zkv1alpha1.ConfigDirName,
 			MountPath: constants.KubedoopConfigDirMount,
@@ -234,19 +436,41 @@ func (b *StatefulsetBuilder) getVolumeMounts() []corev1.VolumeMount {
 			Name:      zkv1alpha1.LogDirName,
 			MountPath: constants.KubedoopLogDir,
 		},
+	}...)
+}
+
+// data and transaction log volume mounts
+func (b *StatefulsetBuilder) dataVolumeMounts() []corev1.VolumeMount {
+	mounts := []corev1.VolumeMount{
+		{
+			Name:      zkv1alpha1.DataDirName,
+			MountPath: constants.KubedoopDataDir,
+		},
 	}
+	if b.dataLog != nil {
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      zkv1alpha1.DataLogDirName,
+			MountPath: common.DataLogDir,
+		})
+	}
+	return mounts
 }
 
 // main container env vars
 func (b *StatefulsetBuilder) getEnvVars() []corev1.EnvVar {
+	jvmFlags := append([]string{
+		util.JvmJmxOpts(zkv1alpha1.MetricsPort),
+		// the mounted file follows the ConfigMap, unlike the copy in the config dir, see ReloadableConfigFiles
+		"-Dlogback.configurationFile=" + path.Join(constants.KubedoopLogDirMount, LogbackConfigFileName),
+	}, b.zkSecurity.JvmArgs()...)
 	envs := []corev1.EnvVar{
 		{
 			Name:  common.MyIdOffset,
-			Value: "1",
+			Value: strconv.Itoa(int(b.myidOffset)),
 		},
 		{
 			Name:  common.ServerJvmFlags,
-			Value: util.JvmJmxOpts(zkv1alpha1.MetricsPort),
+			Value: strings.Join(jvmFlags, " "),
 		},
 	}
 	heapLimit := common.HeapLimit(b.RoleGroupConfig.Resources)
@@ -261,11 +485,7 @@ func (b *StatefulsetBuilder) getEnvVars() []corev1.EnvVar {
 
 // main container ports
 func (b *StatefulsetBuilder) getPorts() []corev1.ContainerPort {
-	return []corev1.ContainerPort{
-		{
-			Name:          zkv1alpha1.ClientPortName,
-			ContainerPort: int32(b.zkSecurity.ClientPort()),
-		},
+	ports := append(b.zkSecurity.ClientContainerPorts(), []corev1.ContainerPort{
 		{
 			Name:          zkv1alpha1.LeaderPortName,
 			ContainerPort: int32(zkv1alpha1.LeaderPort),
@@ -278,30 +498,14 @@ func (b *StatefulsetBuilder) getPorts() []corev1.ContainerPort {
 			Name:          zkv1alpha1.MetricsPortName,
 			ContainerPort: int32(zkv1alpha1.MetricsPort),
 		},
+	}...)
+	if b.ClusterConfig != nil && common.AdminServerEnabled(b.ClusterConfig.Admin) {
+		ports = append(ports, corev1.ContainerPort{
+			Name:          zkv1alpha1.AdminPortName,
+			ContainerPort: int32(zkv1alpha1.AdminPort),
+		})
 	}
-}
-
-func (b *StatefulsetBuilder) GetReadinessProbe() *corev1.Probe {
-	return &corev1.Probe{
-		ProbeHandler: corev1.ProbeHandler{
-			Exec: &corev1.ExecAction{
-				Command: []string{
-					"bash",
-					"-c",
-					// srvr command returns mode of the server, ruok command checks if the server is running
-					// !!!Note !!!: if you wanner srvr command work well that you must set `publishNotReadyAddresses=true` in headless service
-					fmt.Sprintf("exec 3<>/dev/tcp/127.0.0.1/%d && echo srvr >&3 && grep '^Mode: ' <&3", b.zkSecurity.ClientPort()),
-
-					// fmt.Sprintf("exec 3<>/dev/tcp/127.0.0.1/%d && echo ruok >&3 && grep 'imok' <&3", b.zkSecurity.ClientPort()),
-					// fmt.Sprintf(`exec 3<>/dev/tcp/127.0.0.1/%d && echo srvr >&3 && filename="/tmp/foo_$(date +"%%H%%M%%S%%N")" && cat <&3 > "$filename" && grep "^Mode: " "$filename"`, b.zkSecurity.ClientPort()),
-				},
-			},
-		},
-		FailureThreshold: 3,
-		PeriodSeconds:    1,
-		SuccessThreshold: 1,
-		TimeoutSeconds:   1,
-	}
+	return ports
 }
 
 // get volumes
@@ -344,7 +548,8 @@ func (b *StatefulsetBuilder) getVolumes() []corev1.Volume {
 
 // create data pvc template
 func (b *StatefulsetBuilder) createVolumeClaimTemplate() *corev1.PersistentVolumeClaim {
-	return &corev1.PersistentVolumeClaim{
+	storage := b.RoleGroupConfig.Resources.Storage
+	claim := &corev1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: zkv1alpha1.DataDirName,
 		},
@@ -353,10 +558,44 @@ func (b *StatefulsetBuilder) createVolumeClaimTemplate() *corev1.PersistentVolum
 			VolumeMode:  func() *corev1.PersistentVolumeMode { v := corev1.PersistentVolumeFilesystem; return &v }(),
 			Resources: corev1.VolumeResourceRequirements{
 				Requests: corev1.ResourceList{
-					corev1.ResourceStorage: b.RoleGroupConfig.Resources.Storage.Capacity,
+					corev1.ResourceStorage: storage.Capacity,
 				},
 			},
 		},
 	}
+	if storage.StorageClass != "" {
+		claim.Spec.StorageClassName = &storage.StorageClass
+	}
+	return claim
+}
 
+// create transaction log pvc template
+func (b *StatefulsetBuilder) createDataLogVolumeClaimTemplate() *corev1.PersistentVolumeClaim {
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: zkv1alpha1.DataLogDirName,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			VolumeMode:  func() *corev1.PersistentVolumeMode { v := corev1.PersistentVolumeFilesystem; return &v }(),
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: b.dataLog.Capacity,
+				},
+			},
+		},
+	}
+	if b.dataLog.StorageClass != "" {
+		claim.Spec.StorageClassName = &b.dataLog.StorageClass
+	}
+	return claim
+}
+
+// volume claim templates of the statefulset
+func (b *StatefulsetBuilder) volumeClaimTemplates() []*corev1.PersistentVolumeClaim {
+	claims := []*corev1.PersistentVolumeClaim{b.createVolumeClaimTemplate()}
+	if b.dataLog != nil {
+		claims = append(claims, b.createDataLogVolumeClaimTemplate())
+	}
+	return claims
 }
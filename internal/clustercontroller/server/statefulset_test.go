@@ -1,8 +1,24 @@
 package server
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	commonsv1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/commons/v1alpha1"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/security"
 )
 
 var _ = Describe("StatefulsetBuilder", func() {
@@ -20,3 +36,226 @@ var _ = Describe("StatefulsetBuilder", func() {
 		})
 	})
 })
+
+var _ = Describe("Volume claim templates", func() {
+	newBuilder := func(dataLog *commonsv1alpha1.StorageResource) *StatefulsetBuilder {
+		roleGroupConfig := &commonsv1alpha1.RoleGroupConfigSpec{
+			Resources: &commonsv1alpha1.ResourcesSpec{Storage: &commonsv1alpha1.StorageResource{
+				Capacity:     resource.MustParse("10Gi"),
+				StorageClass: "standard",
+			}},
+		}
+		return NewStatefulSetBuilder(nil, "simple-server-default", nil, nil, nil, nil, 1, nil, nil, roleGroupConfig, dataLog, nil, nil, nil, "")
+	}
+
+	It("requests the capacity of the data volume from its storage class", func() {
+		claims := newBuilder(nil).volumeClaimTemplates()
+
+		Expect(claims).To(HaveLen(1))
+		Expect(claims[0].Name).To(Equal(zkv1alpha1.DataDirName))
+		Expect(claims[0].Spec.Resources.Requests).To(HaveKeyWithValue(corev1.ResourceStorage, resource.MustParse("10Gi")))
+		Expect(claims[0].Spec.StorageClassName).To(HaveValue(Equal("standard")))
+	})
+
+	It("leaves the storage class to the cluster default if none is set", func() {
+		b := newBuilder(nil)
+		b.RoleGroupConfig.Resources.Storage.StorageClass = ""
+
+		Expect(b.volumeClaimTemplates()[0].Spec.StorageClassName).To(BeNil())
+	})
+
+	It("mounts the transaction log volume and moves the existing transaction logs to it", func() {
+		b := newBuilder(&commonsv1alpha1.StorageResource{Capacity: resource.MustParse("2Gi"), StorageClass: "fast"})
+
+		claims := b.volumeClaimTemplates()
+		Expect(claims).To(HaveLen(2))
+		Expect(claims[1].Name).To(Equal(zkv1alpha1.DataLogDirName))
+		Expect(claims[1].Spec.Resources.Requests).To(HaveKeyWithValue(corev1.ResourceStorage, resource.MustParse("2Gi")))
+		Expect(claims[1].Spec.StorageClassName).To(HaveValue(Equal("fast")))
+		Expect(b.dataVolumeMounts()).To(ContainElement(corev1.VolumeMount{Name: zkv1alpha1.DataLogDirName, MountPath: "/kubedoop/txnlog/"}))
+		Expect(b.getInitContainerCommandArgs()).To(ContainSubstring("-name 'log.*' -exec mv --target-directory=${LOG_DIR}"))
+	})
+
+	It("has no transaction log volume by default", func() {
+		b := newBuilder(nil)
+
+		Expect(b.dataVolumeMounts()).To(HaveLen(1))
+		Expect(b.getInitContainerCommandArgs()).NotTo(ContainSubstring("LOG_DIR"))
+	})
+})
+
+var _ = Describe("Stale data", func() {
+	var root string
+
+	// runs the prepare script of the server with the volumes below root
+	prepare := func(b *StatefulsetBuilder, ensembleID, podName string) error {
+		script := strings.ReplaceAll(b.getInitContainerCommandArgs(), "/kubedoop/", root+"/")
+		cmd := exec.Command("/bin/bash", "-euo", "pipefail", "-c", script)
+		cmd.Env = append(os.Environ(), "MYID_OFFSET=1", "POD_NAME="+podName, "ENSEMBLE_ID="+ensembleID)
+		out, err := cmd.CombinedOutput()
+		GinkgoWriter.Println(string(out))
+		return err
+	}
+	readFile := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		Expect(err).NotTo(HaveOccurred())
+		return strings.TrimSpace(string(data))
+	}
+	writeData := func() {
+		Expect(os.MkdirAll(filepath.Join(root, "data", "version-2"), 0o755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(root, "data", "version-2", "snapshot.100000000"), nil, 0o644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		root = GinkgoT().TempDir()
+		Expect(os.MkdirAll(filepath.Join(root, "data"), 0o755)).To(Succeed())
+	})
+
+	It("records the ensemble member on a new volume", func() {
+		Expect(prepare(&StatefulsetBuilder{}, "uid-1", "simple-server-default-2")).To(Succeed())
+
+		Expect(readFile("data/myid")).To(Equal("3"))
+		Expect(readFile("data/ensemble")).To(Equal("uid-1/3"))
+	})
+
+	It("refuses the data of another ensemble", func() {
+		writeData()
+		Expect(os.WriteFile(filepath.Join(root, "data", "ensemble"), []byte("uid-0/1\n"), 0o644)).To(Succeed())
+
+		Expect(prepare(&StatefulsetBuilder{}, "uid-1", "simple-server-default-0")).NotTo(Succeed())
+		Expect(filepath.Join(root, "data", "version-2", "snapshot.100000000")).To(BeAnExistingFile())
+		Expect(readFile("data/ensemble")).To(Equal("uid-0/1"))
+	})
+
+	It("refuses the data of another server of a cluster created before the marker", func() {
+		writeData()
+		Expect(os.WriteFile(filepath.Join(root, "data", "myid"), []byte("4\n"), 0o644)).To(Succeed())
+
+		Expect(prepare(&StatefulsetBuilder{}, "uid-1", "simple-server-default-0")).NotTo(Succeed())
+	})
+
+	It("adopts the data of the same server of a cluster created before the marker", func() {
+		writeData()
+		Expect(os.WriteFile(filepath.Join(root, "data", "myid"), []byte("1\n"), 0o644)).To(Succeed())
+
+		Expect(prepare(&StatefulsetBuilder{}, "uid-1", "simple-server-default-0")).To(Succeed())
+		Expect(readFile("data/ensemble")).To(Equal("uid-1/1"))
+	})
+
+	It("wipes the data of another ensemble if configured", func() {
+		writeData()
+		Expect(os.WriteFile(filepath.Join(root, "data", "ensemble"), []byte("uid-0/1\n"), 0o644)).To(Succeed())
+		b := &StatefulsetBuilder{ClusterConfig: &zkv1alpha1.ClusterConfigSpec{StaleDataPolicy: zkv1alpha1.StaleDataWipe}}
+
+		Expect(prepare(b, "uid-1", "simple-server-default-0")).To(Succeed())
+		Expect(filepath.Join(root, "data", "version-2")).NotTo(BeADirectory())
+		Expect(readFile("data/ensemble")).To(Equal("uid-1/1"))
+	})
+
+	It("adopts the data of the same server of another ensemble if configured", func() {
+		writeData()
+		Expect(os.WriteFile(filepath.Join(root, "data", "ensemble"), []byte("uid-0/1\n"), 0o644)).To(Succeed())
+		b := &StatefulsetBuilder{ClusterConfig: &zkv1alpha1.ClusterConfigSpec{StaleDataPolicy: zkv1alpha1.StaleDataAdopt}}
+
+		Expect(prepare(b, "uid-1", "simple-server-default-0")).To(Succeed())
+		Expect(filepath.Join(root, "data", "version-2", "snapshot.100000000")).To(BeAnExistingFile())
+		Expect(readFile("data/ensemble")).To(Equal("uid-1/1"))
+	})
+
+	It("refuses to adopt the data of another server", func() {
+		writeData()
+		Expect(os.WriteFile(filepath.Join(root, "data", "ensemble"), []byte("uid-0/2\n"), 0o644)).To(Succeed())
+		b := &StatefulsetBuilder{ClusterConfig: &zkv1alpha1.ClusterConfigSpec{StaleDataPolicy: zkv1alpha1.StaleDataAdopt}}
+
+		Expect(prepare(b, "uid-1", "simple-server-default-0")).NotTo(Succeed())
+		Expect(readFile("data/ensemble")).To(Equal("uid-0/2"))
+	})
+})
+
+var _ = Describe("Volume retention", func() {
+	It("retains the volumes by default", func() {
+		policy := (&StatefulsetBuilder{}).persistentVolumeClaimRetentionPolicy()
+
+		Expect(policy.WhenDeleted).To(Equal(appv1.RetainPersistentVolumeClaimRetentionPolicyType))
+		Expect(policy.WhenScaled).To(Equal(appv1.RetainPersistentVolumeClaimRetentionPolicyType))
+	})
+
+	It("applies the policy of the role group", func() {
+		b := &StatefulsetBuilder{retentionPolicy: &zkv1alpha1.PersistentVolumeClaimRetentionPolicySpec{
+			WhenScaled: appv1.DeletePersistentVolumeClaimRetentionPolicyType,
+		}}
+		policy := b.persistentVolumeClaimRetentionPolicy()
+
+		Expect(policy.WhenDeleted).To(Equal(appv1.RetainPersistentVolumeClaimRetentionPolicyType))
+		Expect(policy.WhenScaled).To(Equal(appv1.DeletePersistentVolumeClaimRetentionPolicyType))
+	})
+})
+
+var _ = Describe("PreStop hook", func() {
+	newBuilder := func(gracefulShutdownTimeout string) *StatefulsetBuilder {
+		zkSecurity, err := security.NewZookeeperSecurity(context.Background(), nil, "simple", nil)
+		Expect(err).NotTo(HaveOccurred())
+		roleGroupConfig := &commonsv1alpha1.RoleGroupConfigSpec{GracefulShutdownTimeout: gracefulShutdownTimeout}
+		return NewStatefulSetBuilder(nil, "simple-server-default", nil, nil, nil, nil, 1, zkSecurity, nil, roleGroupConfig, nil, nil, nil, nil, "")
+	}
+
+	It("lets the leader wait for its learners within half of the graceful shutdown timeout", func() {
+		command := newBuilder("60s").getPreStopHandler().Exec.Command
+
+		Expect(command[:2]).To(Equal([]string{"/bin/bash", "-c"}))
+		Expect(command[2]).To(HavePrefix("CLIENT_PORT=2181\nHANDOFF_TIMEOUT=30\n"))
+		Expect(command[2]).To(ContainSubstring(`if [ "${mode}" != "leader" ]; then`))
+	})
+
+	It("falls back to the default graceful shutdown timeout", func() {
+		Expect(newBuilder("").getPreStopHandler().Exec.Command[2]).To(HavePrefix("CLIENT_PORT=2181\nHANDOFF_TIMEOUT=60\n"))
+	})
+
+	// runs the hook against a server answering srvr with the mode and mntr with the number of synced followers,
+	// one more follower is in sync on every mntr
+	preStop := func(mode string, handoffTimeout int) string {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(listener.Close)
+		go func() {
+			synced := 0
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				command := make([]byte, len("srvr\n"))
+				_, _ = io.ReadFull(conn, command)
+				if string(command) == "srvr\n" {
+					_, _ = fmt.Fprintf(conn, "Zookeeper version: 3.9.3\nMode: %s\n", mode)
+				} else {
+					_, _ = fmt.Fprintf(conn, "zk_learners\t2\nzk_synced_followers\t%d\nzk_pending_syncs\t0\n", min(synced, 2))
+					synced++
+				}
+				_ = conn.Close()
+			}
+		}()
+
+		script := newBuilder("").getPreStopHandler().Exec.Command[2]
+		script = strings.Replace(script, "CLIENT_PORT=2181", fmt.Sprintf("CLIENT_PORT=%d", listener.Addr().(*net.TCPAddr).Port), 1)
+		script = strings.Replace(script, "HANDOFF_TIMEOUT=60", fmt.Sprintf("HANDOFF_TIMEOUT=%d", handoffTimeout), 1)
+		script = strings.ReplaceAll(script, "/proc/1/fd/1", "/dev/stdout")
+		out, err := exec.Command("/bin/bash", "-c", script).CombinedOutput()
+		Expect(err).NotTo(HaveOccurred(), string(out))
+		return string(out)
+	}
+
+	It("stops followers right away", func() {
+		Expect(preStop("follower", 10)).To(Equal("preStop: server is follower, stopping\n"))
+	})
+
+	It("stops the leader once its learners are in sync", func() {
+		out := preStop("leader", 10)
+		Expect(out).To(ContainSubstring("waiting for the learners to sync, 0 of 2 in sync"))
+		Expect(out).To(HaveSuffix("preStop: leader stops with 2 of 2 learners in sync\n"))
+	})
+
+	It("stops the leader when the learners do not sync in time", func() {
+		Expect(preStop("leader", 1)).To(HaveSuffix("preStop: learners did not sync within 1s, stopping anyway\n"))
+	})
+})
@@ -2,11 +2,16 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"maps"
 	"strconv"
 
 	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
 	"github.com/zncdatadev/zookeeper-operator/internal/security"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/zncdatadev/operator-go/pkg/builder"
@@ -42,16 +47,10 @@ func NewServiceReconciler(
 	listenerClass opconstants.ListenerClass,
 	zkSecurity *security.ZookeeperSecurity,
 ) *reconciler.Service {
-	ports := []corev1.ContainerPort{
-		{
-			Name:          zkv1alpha1.ClientPortName,
-			ContainerPort: int32(zkSecurity.ClientPort()),
-		},
-		{
-			Name:          zkv1alpha1.MetricsPortName,
-			ContainerPort: int32(zkv1alpha1.MetricsPort),
-		},
-	}
+	ports := append(zkSecurity.ClientContainerPorts(), corev1.ContainerPort{
+		Name:          zkv1alpha1.MetricsPortName,
+		ContainerPort: int32(zkv1alpha1.MetricsPort),
+	})
 
 	svcBuilder := &ServiceBuilder{
 		BaseServiceBuilder: *builder.NewServiceBuilder(
@@ -137,3 +136,93 @@ func NewRoleGroupMetricsService(
 func GetMetricsServiceName(roleGroupInfo *reconciler.RoleGroupInfo) string {
 	return roleGroupInfo.GetFullName() + "-metrics"
 }
+
+// NewPodServiceReconcilers creates a Service per server of the role group for the `external-stable` listener class,
+// so every server keeps its address when it is restarted or moved.
+func NewPodServiceReconcilers(
+	client *client.Client,
+	info *reconciler.RoleGroupInfo,
+	replicas int32,
+	externalStable *zkv1alpha1.ExternalStableListenerSpec,
+	serviceType corev1.ServiceType,
+	zkSecurity *security.ZookeeperSecurity,
+) []reconciler.Reconciler {
+	listenerClass := opconstants.ExternalStable
+	if serviceType == corev1.ServiceTypeNodePort {
+		listenerClass = opconstants.ExternalUnstable
+	}
+	annotations := maps.Clone(info.GetAnnotations())
+	if externalStable != nil {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		maps.Copy(annotations, externalStable.Annotations)
+	}
+
+	reconcilers := make([]reconciler.Reconciler, 0, replicas)
+	for i := int32(0); i < replicas; i++ {
+		podName := fmt.Sprintf("%s-%d", common.StatefulsetName(info), i)
+		svcBuilder := builder.NewServiceBuilder(
+			client,
+			common.PodServiceName(podName),
+			zkSecurity.ClientContainerPorts(),
+			func(sbo *builder.ServiceBuilderOptions) {
+				sbo.ListenerClass = listenerClass
+				sbo.Labels = info.GetLabels()
+				sbo.Annotations = annotations
+				sbo.MatchingLabels = map[string]string{appsv1.StatefulSetPodNameLabel: podName}
+			},
+		)
+		reconcilers = append(reconcilers, reconciler.NewGenericResourceReconciler(client, svcBuilder))
+	}
+	return reconcilers
+}
+
+// podServiceCleaner deletes the Services of the servers beyond the replicas of the role group, and of every server
+// once `external-stable` is off, so that no address points to a server that is gone.
+type podServiceCleaner struct {
+	reconciler.BaseReconciler[reconciler.AnySpec]
+	info *reconciler.RoleGroupInfo
+	// replicas is the number of servers that keep their Service
+	replicas int32
+}
+
+// NewPodServiceCleaner deletes the Services of the servers of the role group from the given replica on, see
+// NewPodServiceReconcilers.
+func NewPodServiceCleaner(client *client.Client, info *reconciler.RoleGroupInfo, replicas int32) reconciler.Reconciler {
+	return &podServiceCleaner{
+		BaseReconciler: reconciler.BaseReconciler[reconciler.AnySpec]{Client: client},
+		info:           info,
+		replicas:       replicas,
+	}
+}
+
+func (r *podServiceCleaner) Reconcile(ctx context.Context) (ctrl.Result, error) {
+	services := &corev1.ServiceList{}
+	if err := r.Client.Client.List(ctx, services,
+		ctrlclient.InNamespace(r.GetNamespace()),
+		ctrlclient.MatchingLabels(r.info.GetLabels()),
+	); err != nil {
+		return ctrl.Result{}, err
+	}
+	kept := map[string]bool{}
+	for i := int32(0); i < r.replicas; i++ {
+		kept[common.PodServiceName(fmt.Sprintf("%s-%d", common.StatefulsetName(r.info), i))] = true
+	}
+	for i := range services.Items {
+		svc := &services.Items[i]
+		// the headless and metrics services of the role group select every server
+		if _, ok := svc.Spec.Selector[appsv1.StatefulSetPodNameLabel]; !ok || kept[svc.Name] {
+			continue
+		}
+		if err := r.Client.Client.Delete(ctx, svc); ctrlclient.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, err
+		}
+		logger.Info("deleted the service of a removed server", "namespace", svc.Namespace, "name", svc.Name)
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *podServiceCleaner) Ready(context.Context) (ctrl.Result, error) {
+	return ctrl.Result{}, nil
+}
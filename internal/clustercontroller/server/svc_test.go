@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/zncdatadev/operator-go/pkg/client"
+	"github.com/zncdatadev/operator-go/pkg/reconciler"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+)
+
+var _ = Describe("Pod services", func() {
+	ctx := context.Background()
+	cluster := &zkv1alpha1.ZookeeperCluster{ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default"}}
+	info := &reconciler.RoleGroupInfo{
+		RoleInfo: reconciler.RoleInfo{ClusterInfo: reconciler.ClusterInfo{
+			GVK:         &metav1.GroupVersionKind{Group: zkv1alpha1.GroupVersion.Group, Kind: "ZookeeperCluster"},
+			ClusterName: "simple",
+		}, RoleName: "server"},
+		RoleGroupName: "default",
+	}
+	service := func(name string, selector map[string]string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: info.GetLabels()},
+			Spec:       corev1.ServiceSpec{Selector: selector},
+		}
+	}
+	podService := func(name string) *corev1.Service {
+		return service(name, map[string]string{appsv1.StatefulSetPodNameLabel: name})
+	}
+	serviceNames := func(k8sClient ctrlclient.Client) []string {
+		services := &corev1.ServiceList{}
+		Expect(k8sClient.List(ctx, services)).To(Succeed())
+		var names []string
+		for _, svc := range services.Items {
+			names = append(names, svc.Name)
+		}
+		return names
+	}
+
+	It("deletes the services of removed servers", func() {
+		k8sClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+			service("simple-server-default", info.GetLabels()),
+			podService("simple-server-default-0"),
+			podService("simple-server-default-1"),
+			podService("simple-server-default-2"),
+		).Build()
+
+		result, err := NewPodServiceCleaner(client.NewClient(k8sClient, cluster), info, 2).Reconcile(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IsZero()).To(BeTrue())
+		Expect(serviceNames(k8sClient)).To(ConsistOf("simple-server-default", "simple-server-default-0", "simple-server-default-1"))
+
+		_, err = NewPodServiceCleaner(client.NewClient(k8sClient, cluster), info, 0).Reconcile(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(serviceNames(k8sClient)).To(ConsistOf("simple-server-default"))
+	})
+})
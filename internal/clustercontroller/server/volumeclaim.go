@@ -0,0 +1,270 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	commonsv1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/commons/v1alpha1"
+	"github.com/zncdatadev/operator-go/pkg/client"
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	"github.com/zncdatadev/operator-go/pkg/reconciler"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlClient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+// statefulSetReconciler recreates the statefulset when its volume claim templates change,
+// as the API server rejects updates of them. The statefulset is deleted without its pods,
+// which the new one adopts and replaces in a rolling update.
+// When the capacity of a claim grows, its volumes are expanded first where their storage class allows it. The
+// statefulset is kept unchanged until they are resized, which VolumeResizeCondition reports, without holding up the
+// rest of the cluster.
+type statefulSetReconciler struct {
+	*reconciler.StatefulSet
+	claims []*corev1.PersistentVolumeClaim
+}
+
+func (r *statefulSetReconciler) Reconcile(ctx context.Context) (ctrl.Result, error) {
+	existing := &appv1.StatefulSet{}
+	if err := r.Client.Client.Get(ctx, r.GetObjectKey(), existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		return r.StatefulSet.Reconcile(ctx)
+	}
+	if existing.DeletionTimestamp != nil {
+		logger.Info("waiting for the statefulset to be deleted", "namespace", existing.Namespace, "name", existing.Name)
+		return ctrl.Result{RequeueAfter: time.Second}, nil
+	}
+
+	changed, grown, err := compareVolumeClaimTemplates(existing.Spec.VolumeClaimTemplates, r.claims)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("statefulset %s/%s: %w", existing.Namespace, existing.Name, err)
+	}
+	if !changed {
+		return r.StatefulSet.Reconcile(ctx)
+	}
+	if len(grown) != 0 {
+		resized, err := r.expandVolumeClaims(ctx, existing, grown)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !resized {
+			return ctrl.Result{}, nil
+		}
+	}
+
+	logger.Info("volume claim templates changed, recreating the statefulset without its pods",
+		"namespace", existing.Namespace, "name", existing.Name)
+	if err := r.Client.Client.Delete(ctx, existing, ctrlClient.PropagationPolicy(metav1.DeletePropagationOrphan)); ctrlClient.IgnoreNotFound(err) != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: time.Second}, nil
+}
+
+// compareVolumeClaimTemplates tells whether the volume claim templates changed, and returns the new capacity
+// of the claims that grew. Volumes can not shrink, so a smaller capacity is an error.
+func compareVolumeClaimTemplates(
+	existing []corev1.PersistentVolumeClaim,
+	desired []*corev1.PersistentVolumeClaim,
+) (bool, map[string]resource.Quantity, error) {
+	changed := len(existing) != len(desired)
+	grown := map[string]resource.Quantity{}
+	for i, claim := range desired {
+		if i >= len(existing) || existing[i].Name != claim.Name {
+			changed = true
+			continue
+		}
+		if ptr.Deref(existing[i].Spec.StorageClassName, "") != ptr.Deref(claim.Spec.StorageClassName, "") {
+			logger.Info("storage class of the volume claim template changed, it only applies to new volumes",
+				"claim", claim.Name, "storageClass", ptr.Deref(claim.Spec.StorageClassName, ""))
+			changed = true
+		}
+		current := existing[i].Spec.Resources.Requests[corev1.ResourceStorage]
+		capacity := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+		switch current.Cmp(capacity) {
+		case 1:
+			return false, nil, fmt.Errorf("volume claim %s can not shrink from %s to %s", claim.Name, current.String(), capacity.String())
+		case -1:
+			grown[claim.Name] = capacity
+			changed = true
+		}
+	}
+	return changed, grown, nil
+}
+
+// expandVolumeClaims requests the new capacity for the volumes of the statefulset and tells whether they are resized.
+// Volumes whose storage class does not allow expansion keep their size.
+func (r *statefulSetReconciler) expandVolumeClaims(
+	ctx context.Context,
+	sts *appv1.StatefulSet,
+	grown map[string]resource.Quantity,
+) (bool, error) {
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	if err := r.Client.Client.List(ctx, pvcs, ctrlClient.InNamespace(sts.Namespace)); err != nil {
+		return false, fmt.Errorf("list pvcs of statefulset %s/%s: %w", sts.Namespace, sts.Name, err)
+	}
+	resized := true
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		claimName := volumeClaimName(pvc.Name, sts.Name)
+		capacity, ok := grown[claimName]
+		if !ok {
+			continue
+		}
+		expandable, err := r.allowsVolumeExpansion(ctx, pvc)
+		if err != nil {
+			return false, err
+		}
+		if !expandable {
+			logger.Info("storage class of the volume does not allow expansion, keeping its size",
+				"namespace", pvc.Namespace, "name", pvc.Name, "storageClass", ptr.Deref(pvc.Spec.StorageClassName, ""))
+			continue
+		}
+		if pvc.Spec.Resources.Requests.Storage().Cmp(capacity) < 0 {
+			patch := ctrlClient.MergeFrom(pvc.DeepCopy())
+			if pvc.Spec.Resources.Requests == nil {
+				pvc.Spec.Resources.Requests = corev1.ResourceList{}
+			}
+			pvc.Spec.Resources.Requests[corev1.ResourceStorage] = capacity
+			if err := r.Client.Client.Patch(ctx, pvc, patch); err != nil {
+				return false, fmt.Errorf("expand pvc %s/%s: %w", pvc.Namespace, pvc.Name, err)
+			}
+			logger.Info("expanding volume", "namespace", pvc.Namespace, "name", pvc.Name, "capacity", capacity.String())
+			resized = false
+			continue
+		}
+		if volumeResizing(pvc) {
+			logger.Info("waiting for the volume to be resized", "namespace", pvc.Namespace, "name", pvc.Name,
+				"capacity", pvc.Status.Capacity.Storage().String(), "requested", capacity.String())
+			resized = false
+		}
+	}
+	return resized, nil
+}
+
+// volumeResizing tells whether the storage provider has yet to resize a volume to its requested capacity.
+// A volume whose file system is left to resize is done, the kubelet resizes it on the node.
+func volumeResizing(pvc *corev1.PersistentVolumeClaim) bool {
+	for _, condition := range pvc.Status.Conditions {
+		if condition.Type == corev1.PersistentVolumeClaimFileSystemResizePending && condition.Status == corev1.ConditionTrue {
+			return false
+		}
+	}
+	return pvc.Status.Capacity.Storage().Cmp(*pvc.Spec.Resources.Requests.Storage()) < 0
+}
+
+// VolumeResizeCondition reports whether the volumes of the servers are being expanded, during which their
+// statefulsets keep their volume claim templates.
+func VolumeResizeCondition(
+	ctx context.Context,
+	k8sClient ctrlClient.Client,
+	zkCluster *zkv1alpha1.ZookeeperCluster,
+) (*metav1.Condition, error) {
+	statefulSets := &appv1.StatefulSetList{}
+	if err := k8sClient.List(ctx, statefulSets,
+		ctrlClient.InNamespace(zkCluster.Namespace),
+		ctrlClient.MatchingLabels{
+			constants.LabelKubernetesInstance:  zkCluster.Name,
+			constants.LabelKubernetesComponent: string(common.Server),
+		},
+	); err != nil {
+		return nil, err
+	}
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	if err := k8sClient.List(ctx, pvcs, ctrlClient.InNamespace(zkCluster.Namespace)); err != nil {
+		return nil, err
+	}
+	var resizing []string
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if !volumeResizing(pvc) {
+			continue
+		}
+		for _, sts := range statefulSets.Items {
+			if volumeClaimName(pvc.Name, sts.Name) != "" {
+				resizing = append(resizing, fmt.Sprintf("%s %s of %s", pvc.Name,
+					pvc.Status.Capacity.Storage().String(), pvc.Spec.Resources.Requests.Storage().String()))
+				break
+			}
+		}
+	}
+	if len(resizing) == 0 {
+		return &metav1.Condition{
+			Type:               zkv1alpha1.ConditionResizingVolumes,
+			Status:             metav1.ConditionFalse,
+			Reason:             "Resized",
+			Message:            "every volume has its requested capacity",
+			ObservedGeneration: zkCluster.Generation,
+		}, nil
+	}
+	return &metav1.Condition{
+		Type:               zkv1alpha1.ConditionResizingVolumes,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Resizing",
+		Message:            "waiting for the storage provider to resize " + strings.Join(resizing, ", "),
+		ObservedGeneration: zkCluster.Generation,
+	}, nil
+}
+
+// allowsVolumeExpansion tells whether the storage class of a volume allows to expand it.
+func (r *statefulSetReconciler) allowsVolumeExpansion(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (bool, error) {
+	name := ptr.Deref(pvc.Spec.StorageClassName, "")
+	if name == "" {
+		// statically provisioned volume
+		return false, nil
+	}
+	storageClass := &storagev1.StorageClass{}
+	if err := r.Client.Client.Get(ctx, ctrlClient.ObjectKey{Name: name}, storageClass); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("get storage class %s: %w", name, err)
+	}
+	return ptr.Deref(storageClass.AllowVolumeExpansion, false), nil
+}
+
+// volumeClaimName returns the volume claim template of a pvc created by the statefulset, empty if it is not one of them.
+// The pvcs are named <claim>-<statefulset>-<ordinal>.
+func volumeClaimName(pvcName, stsName string) string {
+	prefix, ordinal, ok := strings.Cut(pvcName, "-"+stsName+"-")
+	if !ok {
+		return ""
+	}
+	if _, err := strconv.Atoi(ordinal); err != nil {
+		return ""
+	}
+	return prefix
+}
+
+// ExistingDataLogStorage returns the transaction log volume of an existing statefulset, nil if it has none.
+// The volume stays once added, so that the transaction logs on it are not lost.
+func ExistingDataLogStorage(ctx context.Context, client *client.Client, name string) (*commonsv1alpha1.StorageResource, error) {
+	existing := &appv1.StatefulSet{}
+	if err := client.Client.Get(ctx, ctrlClient.ObjectKey{Namespace: client.GetOwnerNamespace(), Name: name}, existing); err != nil {
+		return nil, ctrlClient.IgnoreNotFound(err)
+	}
+	for _, claim := range existing.Spec.VolumeClaimTemplates {
+		if claim.Name != zkv1alpha1.DataLogDirName {
+			continue
+		}
+		storage := &commonsv1alpha1.StorageResource{Capacity: claim.Spec.Resources.Requests[corev1.ResourceStorage]}
+		if claim.Spec.StorageClassName != nil {
+			storage.StorageClass = *claim.Spec.StorageClassName
+		}
+		return storage, nil
+	}
+	return nil, nil
+}
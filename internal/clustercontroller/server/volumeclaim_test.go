@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	commonsv1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/commons/v1alpha1"
+	"github.com/zncdatadev/operator-go/pkg/client"
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	"github.com/zncdatadev/operator-go/pkg/reconciler"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	ctrlClient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+)
+
+var _ = Describe("StatefulSet volume claims", func() {
+	ctx := context.Background()
+	stsKey := ctrlClient.ObjectKey{Namespace: "default", Name: "simple-server-default"}
+
+	newClaim := func(name, capacity, storageClass string) *corev1.PersistentVolumeClaim {
+		claim := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+		claim.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(capacity)}
+		claim.Spec.StorageClassName = &storageClass
+		claim.Status.Capacity = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(capacity)}
+		return claim
+	}
+	newStatefulSet := func(claims ...*corev1.PersistentVolumeClaim) *appv1.StatefulSet {
+		sts := &appv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{
+			Name:      stsKey.Name,
+			Namespace: stsKey.Namespace,
+			Labels:    map[string]string{constants.LabelKubernetesInstance: "simple", constants.LabelKubernetesComponent: "server"},
+		}}
+		for _, claim := range claims {
+			sts.Spec.VolumeClaimTemplates = append(sts.Spec.VolumeClaimTemplates, corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: claim.Name},
+				Spec:       claim.Spec,
+			})
+		}
+		return sts
+	}
+	zkCluster := &zkv1alpha1.ZookeeperCluster{ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default"}}
+	newClient := func(objs ...ctrlClient.Object) *client.Client {
+		k8sClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objs...).
+			WithStatusSubresource(&corev1.PersistentVolumeClaim{}).Build()
+		return client.NewClient(k8sClient, zkCluster)
+	}
+	newReconciler := func(c *client.Client, claims ...*corev1.PersistentVolumeClaim) *statefulSetReconciler {
+		stsBuilder := NewStatefulSetBuilder(c, stsKey.Name, nil, nil, ptr.To(int32(2)), nil, 1, nil, nil, nil, nil, nil, nil, nil, "")
+		return &statefulSetReconciler{
+			StatefulSet: reconciler.NewStatefulSet(c, stsBuilder, false),
+			claims:      claims,
+		}
+	}
+	statefulSetExists := func(c *client.Client) bool {
+		err := c.Client.Get(ctx, stsKey, &appv1.StatefulSet{})
+		if apierrors.IsNotFound(err) {
+			return false
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return true
+	}
+	expandable := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "expandable"}, AllowVolumeExpansion: ptr.To(true)}
+	fixed := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "fixed"}}
+
+	It("recreates the statefulset without its pods when a volume is added", func() {
+		c := newClient(newStatefulSet(newClaim("data", "10Gi", "")))
+		r := newReconciler(c, newClaim("data", "10Gi", ""), newClaim("txnlog", "2Gi", ""))
+
+		result, err := r.Reconcile(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+		Expect(statefulSetExists(c)).To(BeFalse())
+	})
+
+	It("expands the volumes before recreating the statefulset", func() {
+		c := newClient(
+			expandable,
+			newStatefulSet(newClaim("data", "10Gi", "expandable")),
+			newClaim("data-simple-server-default-0", "10Gi", "expandable"),
+			newClaim("data-simple-server-default-1", "10Gi", "expandable"),
+			newClaim("data-simple-server-default-2-0", "10Gi", "expandable"),
+		)
+		r := newReconciler(c, newClaim("data", "20Gi", "expandable"))
+
+		result, err := r.Reconcile(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IsZero()).To(BeTrue())
+		Expect(statefulSetExists(c)).To(BeTrue())
+		condition, err := VolumeResizeCondition(ctx, c.Client, zkCluster)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(condition.Message).To(ContainSubstring("data-simple-server-default-0 10Gi of 20Gi"))
+		Expect(condition.Message).NotTo(ContainSubstring("data-simple-server-default-2-0"))
+
+		for _, name := range []string{"data-simple-server-default-0", "data-simple-server-default-1"} {
+			pvc := &corev1.PersistentVolumeClaim{}
+			Expect(c.Client.Get(ctx, ctrlClient.ObjectKey{Namespace: "default", Name: name}, pvc)).To(Succeed())
+			Expect(pvc.Spec.Resources.Requests).To(HaveKeyWithValue(corev1.ResourceStorage, resource.MustParse("20Gi")))
+
+			// the storage provider resizes the volume, the kubelet resizes the file system of the second one
+			if name == "data-simple-server-default-0" {
+				pvc.Status.Capacity = pvc.Spec.Resources.Requests
+			} else {
+				pvc.Status.Conditions = []corev1.PersistentVolumeClaimCondition{
+					{Type: corev1.PersistentVolumeClaimFileSystemResizePending, Status: corev1.ConditionTrue},
+				}
+			}
+			Expect(c.Client.Status().Update(ctx, pvc)).To(Succeed())
+		}
+		other := &corev1.PersistentVolumeClaim{}
+		Expect(c.Client.Get(ctx, ctrlClient.ObjectKey{Namespace: "default", Name: "data-simple-server-default-2-0"}, other)).To(Succeed())
+		Expect(other.Spec.Resources.Requests).To(HaveKeyWithValue(corev1.ResourceStorage, resource.MustParse("10Gi")))
+
+		condition, err = VolumeResizeCondition(ctx, c.Client, zkCluster)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		_, err = r.Reconcile(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(statefulSetExists(c)).To(BeFalse())
+	})
+
+	It("keeps the size of volumes that can not expand", func() {
+		c := newClient(
+			fixed,
+			newStatefulSet(newClaim("data", "10Gi", "fixed")),
+			newClaim("data-simple-server-default-0", "10Gi", "fixed"),
+		)
+		r := newReconciler(c, newClaim("data", "20Gi", "fixed"))
+
+		_, err := r.Reconcile(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		pvc := &corev1.PersistentVolumeClaim{}
+		Expect(c.Client.Get(ctx, ctrlClient.ObjectKey{Namespace: "default", Name: "data-simple-server-default-0"}, pvc)).To(Succeed())
+		Expect(pvc.Spec.Resources.Requests).To(HaveKeyWithValue(corev1.ResourceStorage, resource.MustParse("10Gi")))
+		Expect(statefulSetExists(c)).To(BeFalse())
+	})
+
+	It("refuses to shrink a volume", func() {
+		c := newClient(newStatefulSet(newClaim("data", "10Gi", "")))
+		r := newReconciler(c, newClaim("data", "5Gi", ""))
+
+		_, err := r.Reconcile(ctx)
+		Expect(err).To(MatchError(ContainSubstring("can not shrink")))
+		Expect(statefulSetExists(c)).To(BeTrue())
+	})
+
+	It("recreates the statefulset when the storage class changes", func() {
+		changed, grown, err := compareVolumeClaimTemplates(
+			newStatefulSet(newClaim("data", "10Gi", "")).Spec.VolumeClaimTemplates,
+			[]*corev1.PersistentVolumeClaim{newClaim("data", "10Gi", "standard")},
+		)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(grown).To(BeEmpty())
+	})
+
+	It("keeps the transaction log volume of an existing statefulset", func() {
+		storage := &commonsv1alpha1.StorageResource{Capacity: resource.MustParse("2Gi"), StorageClass: "fast"}
+		dataLog, err := ExistingDataLogStorage(ctx, newClient(newStatefulSet(newClaim("data", "10Gi", ""), newClaim("txnlog", "2Gi", "fast"))), stsKey.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dataLog).To(Equal(storage))
+
+		dataLog, err = ExistingDataLogStorage(ctx, newClient(newStatefulSet(newClaim("data", "10Gi", ""))), stsKey.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dataLog).To(BeNil())
+
+		dataLog, err = ExistingDataLogStorage(ctx, newClient(), stsKey.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dataLog).To(BeNil())
+	})
+})
@@ -18,17 +18,33 @@ package clustercontroller
 
 import (
 	"context"
+	"time"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/zncdatadev/operator-go/pkg/client"
 	"github.com/zncdatadev/operator-go/pkg/constants"
 	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
 	"github.com/zncdatadev/zookeeper-operator/internal/clustercontroller/cluster"
+	"github.com/zncdatadev/zookeeper-operator/internal/clustercontroller/health"
+	"github.com/zncdatadev/zookeeper-operator/internal/clustercontroller/server"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+	"github.com/zncdatadev/zookeeper-operator/internal/security"
 	"github.com/zncdatadev/zookeeper-operator/internal/util"
 )
 
@@ -36,11 +52,23 @@ var (
 	logger = log.Log.WithName("controller")
 )
 
+const (
+	rolloutRequeueAfter    = 10 * time.Second
+	diskUsageCheckInterval = 5 * time.Minute
+)
+
 // ZookeeperClusterReconciler reconciles a ZookeeperCluster object
 type ZookeeperClusterReconciler struct {
 	ctrlclient.Client
 	Scheme *runtime.Scheme
 	Log    logr.Logger
+	// Health keeps the state of the servers, which is reported in status, Events and metrics
+	Health *health.Monitor
+	// FourLetterWord asks the servers directly, see common.NewFourLetterWord. The upgrade and the migration decide
+	// with it whether the next server may be restarted, which the answers of the last health check, up to a few
+	// intervals old, could still allow while a restarted server has not caught up.
+	FourLetterWord common.FourLetterWordFunc
+	Recorder       events.EventRecorder
 }
 
 // +kubebuilder:rbac:groups=zookeeper.kubedoop.dev,resources=zookeeperclusters,verbs=get;list;watch;create;update;patch;delete
@@ -54,8 +82,16 @@ type ZookeeperClusterReconciler struct {
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
-// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;delete
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch
 // +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=authentication.kubedoop.dev,resources=authenticationclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=zookeeper.kubedoop.dev,resources=zookeeperbackups,verbs=get;list;watch;create
+// +kubebuilder:rbac:groups=s3.kubedoop.dev,resources=s3connections,verbs=get;list;watch
+// +kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;patch
 
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.15.0/pkg/reconcile
 func (r *ZookeeperClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -72,16 +108,49 @@ func (r *ZookeeperClusterReconciler) Reconcile(ctx context.Context, req ctrl.Req
 	}
 	logger.V(1).Info("ZookeeperCluster found", "namespace", instance.Namespace, "name", instance.Name)
 
+	// the status is updated before the spec is defaulted below, as the update overwrites the spec with the stored one
+	upgrade, err := r.planVersionUpgrade(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	migration, err := r.planEnsembleMigration(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.updateQuorumCondition(ctx, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	restore, err := r.resolveRestoreSource(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
 	clusterConfig := instance.Spec.ClusterConfig
 	if clusterConfig == nil {
 		logger.Info("ClusterConfig is nil")
 		clusterConfig = &zkv1alpha1.ClusterConfigSpec{
 			ListenerClass: constants.ClusterInternal,
-			MinServerId:   1,
 		}
 		instance.Spec.ClusterConfig = clusterConfig
 	}
 
+	// quorum SASL is rolled out one stage at a time, see ClusterConfigSpec.QuorumAuthentication
+	observedStage, err := cluster.ObservedQuorumSaslStage(ctx, r.Client, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	requestedStage := cluster.RequestedQuorumSaslStage(clusterConfig)
+	quorumAuthentication, err := cluster.NextQuorumAuthentication(instance, observedStage)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	clusterConfig.QuorumAuthentication = quorumAuthentication
+
+	zkSecurity, err := security.NewZookeeperSecurity(ctx, r.Client, instance.Name, clusterConfig)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
 	resourceClient := &client.Client{
 		Client:         r.Client,
 		OwnerReference: instance,
@@ -90,6 +159,10 @@ func (r *ZookeeperClusterReconciler) Reconcile(ctx context.Context, req ctrl.Req
 	clusterReconciler := cluster.NewClusterReconciler(
 		resourceClient,
 		instance,
+		restore,
+		upgrade.Versions,
+		upgrade.Partitions,
+		migration.Migration,
 	)
 
 	if err := clusterReconciler.RegisterResources(ctx); err != nil {
@@ -102,19 +175,346 @@ func (r *ZookeeperClusterReconciler) Reconcile(ctx context.Context, req ctrl.Req
 
 	logger.Info("Cluster reconciled")
 
+	resizingVolumes, err := r.updateVolumeResizeCondition(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// restores are checked before the servers are ready, as a failed restore keeps them from starting
+	if err := r.updateRestoreCondition(ctx, instance, restore); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	if result, err := clusterReconciler.Ready(ctx); util.RequeueOrError(result, err) {
 		return result, err
 	}
 
+	// removed role groups are deleted once the remaining servers run without them
+	deleted, err := cluster.DeleteRemovedRoleGroups(ctx, r.Client, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(deleted) != 0 {
+		logger.Info("Deleted the resources of removed role groups", "resources", deleted)
+	}
+
+	if result, err := r.updateRolloutStatus(ctx, instance, quorumAuthentication, requestedStage, zkSecurity.ClientTlsPhase()); util.RequeueOrError(result, err) {
+		return result, err
+	}
+
+	settleAfter, err := r.settleDiscovery(ctx, resourceClient, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.updateDiskPressureCondition(ctx, instance, zkSecurity.ClientTlsPhase()); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	result, err := r.rotateCertificates(ctx, instance)
+	if err != nil {
+		return result, err
+	}
+
 	logger.V(1).Info("Reconcile finished")
 
+	// the disk usage changes without any event, so the last check of the health monitor is read periodically
+	requeueAfter := []time.Duration{result.RequeueAfter, settleAfter, diskUsageCheckInterval}
+	if upgrade.InProgress() || migration.InProgress() || resizingVolumes {
+		requeueAfter = append(requeueAfter, rolloutRequeueAfter)
+	}
+	result.RequeueAfter = diskUsageCheckInterval
+	for _, after := range requeueAfter {
+		if after > 0 {
+			result.RequeueAfter = min(result.RequeueAfter, after)
+		}
+	}
+	return result, nil
+
+}
+
+// updateRolloutStatus records the quorum SASL stage and mechanism and the client TLS phase once every server has been
+// rolled with them, and requeues until the requested quorum SASL stage is reached.
+func (r *ZookeeperClusterReconciler) updateRolloutStatus(
+	ctx context.Context,
+	instance *zkv1alpha1.ZookeeperCluster,
+	quorumAuthentication *zkv1alpha1.QuorumAuthenticationSpec,
+	requestedStage zkv1alpha1.QuorumSaslStage,
+	clientTlsPhase zkv1alpha1.ClientTlsPhase,
+) (ctrl.Result, error) {
+	quorumSaslStage, kerberosSecretClass := zkv1alpha1.QuorumSaslDisabled, ""
+	if quorumAuthentication != nil {
+		quorumSaslStage = quorumAuthentication.Stage
+	}
+	if quorumSaslStage != zkv1alpha1.QuorumSaslDisabled {
+		kerberosSecretClass = quorumAuthentication.KerberosSecretClass
+	}
+	status := &instance.Status
+	if status.QuorumSaslStage == quorumSaslStage && status.QuorumSaslKerberosSecretClass == kerberosSecretClass &&
+		status.ClientTlsPhase == clientTlsPhase && quorumSaslStage == requestedStage {
+		return ctrl.Result{}, nil
+	}
+
+	rolledOut, err := cluster.StatefulSetsRolledOut(ctx, r.Client, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !rolledOut {
+		logger.Info("Waiting for servers to roll out", "quorumSaslStage", quorumSaslStage, "clientTlsPhase", clientTlsPhase)
+		return ctrl.Result{RequeueAfter: rolloutRequeueAfter}, nil
+	}
+
+	if status.QuorumSaslStage != quorumSaslStage || status.QuorumSaslKerberosSecretClass != kerberosSecretClass ||
+		status.ClientTlsPhase != clientTlsPhase {
+		status.QuorumSaslStage = quorumSaslStage
+		status.QuorumSaslKerberosSecretClass = kerberosSecretClass
+		status.ClientTlsPhase = clientTlsPhase
+		if err := r.Status().Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+		logger.Info("Servers rolled out", "quorumSaslStage", quorumSaslStage, "requestedStage", requestedStage, "clientTlsPhase", clientTlsPhase)
+	}
+
+	if quorumSaslStage != requestedStage {
+		return ctrl.Result{RequeueAfter: time.Second}, nil
+	}
 	return ctrl.Result{}, nil
+}
+
+// planVersionUpgrade decides the ZooKeeper version of every role group, and records the progress of an upgrade in status.
+// The quorum is checked live over the client port the servers were rolled out with, see FourLetterWord.
+func (r *ZookeeperClusterReconciler) planVersionUpgrade(ctx context.Context, instance *zkv1alpha1.ZookeeperCluster) (*cluster.UpgradePlan, error) {
+	plan, err := cluster.NewVersionUpgrade(r.Client, instance, instance.Status.ClientTlsPhase, r.FourLetterWord).Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := &instance.Status
+	changed := apimeta.SetStatusCondition(&status.Conditions, plan.Condition)
+	if status.CurrentVersion != plan.CurrentVersion || status.TargetVersion != plan.TargetVersion {
+		status.CurrentVersion = plan.CurrentVersion
+		status.TargetVersion = plan.TargetVersion
+		changed = true
+	}
+	if !changed {
+		return plan, nil
+	}
+	if plan.Condition.Reason == "UnsupportedUpgrade" || plan.Condition.Reason == "BackupFailed" {
+		logger.Info("Servers are not upgraded", "reason", plan.Condition.Reason, "message", plan.Condition.Message)
+	}
+	return plan, r.Status().Update(ctx, instance)
+}
+
+// planEnsembleMigration decides the step of the migration from an external ensemble the servers are configured for,
+// and records it in status. Besides the live quorum, see FourLetterWord, it asks the external servers for their conf,
+// which the health monitor does not check.
+func (r *ZookeeperClusterReconciler) planEnsembleMigration(ctx context.Context, instance *zkv1alpha1.ZookeeperCluster) (*cluster.MigrationPlan, error) {
+	plan, err := cluster.NewEnsembleMigration(r.Client, instance, instance.Status.ClientTlsPhase, r.FourLetterWord).Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := &instance.Status
+	changed := false
+	if plan.Condition != nil {
+		changed = apimeta.SetStatusCondition(&status.Conditions, *plan.Condition)
+	}
+	if !equality.Semantic.DeepEqual(status.Migration, plan.Status) {
+		status.Migration = plan.Status
+		changed = true
+	}
+	if !changed {
+		return plan, nil
+	}
+	if plan.Condition != nil && plan.Condition.Reason == "UnsupportedStep" {
+		logger.Info("Migration step refused", "message", plan.Condition.Message)
+	}
+	return plan, r.Status().Update(ctx, instance)
+}
+
+// resolveRestoreSource resolves the archive the servers are restored from, and records it in status the first time,
+// so that the servers keep restoring the same archive when the ZookeeperBackup takes new backups.
+func (r *ZookeeperClusterReconciler) resolveRestoreSource(ctx context.Context, instance *zkv1alpha1.ZookeeperCluster) (*common.RestoreSource, error) {
+	restore, err := common.ResolveRestoreSource(ctx, r.Client, instance)
+	if err != nil || restore == nil {
+		return restore, err
+	}
+	resolved := &zkv1alpha1.RestoreStatus{Key: restore.Key, Zxid: restore.Zxid}
+	if equality.Semantic.DeepEqual(instance.Status.Restore, resolved) {
+		return restore, nil
+	}
+	instance.Status.Restore = resolved
+	logger.Info("Restoring the servers from a backup", "key", restore.Key, "zxid", restore.Zxid)
+	return restore, r.Status().Update(ctx, instance)
+}
+
+// updateRestoreCondition reports in the Restored condition whether every server restored the same snapshot.
+func (r *ZookeeperClusterReconciler) updateRestoreCondition(ctx context.Context, instance *zkv1alpha1.ZookeeperCluster, restore *common.RestoreSource) error {
+	if restore == nil {
+		return nil
+	}
+	condition, err := cluster.RestoreCondition(ctx, r.Client, instance, restore)
+	if err != nil || condition == nil {
+		return err
+	}
+	if !apimeta.SetStatusCondition(&instance.Status.Conditions, *condition) {
+		return nil
+	}
+	if condition.Status != metav1.ConditionTrue {
+		logger.Info("Servers were not restored from the backup", "reason", condition.Reason, "message", condition.Message)
+	}
+	return r.Status().Update(ctx, instance)
+}
+
+// updateQuorumCondition reports the quorum health from the last check of the health monitor, and records an Event
+// whenever it changes, e.g. when another server leads.
+func (r *ZookeeperClusterReconciler) updateQuorumCondition(ctx context.Context, instance *zkv1alpha1.ZookeeperCluster) error {
+	condition := r.Health.Health(ctrlclient.ObjectKeyFromObject(instance)).Condition(instance.Generation)
+	var previous metav1.Condition
+	if existing := apimeta.FindStatusCondition(instance.Status.Conditions, condition.Type); existing != nil {
+		previous = *existing
+	}
+	if !apimeta.SetStatusCondition(&instance.Status.Conditions, condition) {
+		return nil
+	}
+	if previous.Status != condition.Status || previous.Reason != condition.Reason || previous.Message != condition.Message {
+		eventType := corev1.EventTypeNormal
+		if condition.Status == metav1.ConditionFalse {
+			eventType = corev1.EventTypeWarning
+		}
+		r.Recorder.Eventf(instance, nil, eventType, condition.Reason, "CheckQuorum", "%s", condition.Message)
+	}
+	return r.Status().Update(ctx, instance)
+}
+
+// updateDiskPressureCondition reports servers whose data nears the capacity of their volumes.
+func (r *ZookeeperClusterReconciler) updateDiskPressureCondition(
+	ctx context.Context,
+	instance *zkv1alpha1.ZookeeperCluster,
+	clientTlsPhase zkv1alpha1.ClientTlsPhase,
+) error {
+	condition, err := cluster.DiskPressureCondition(ctx, r.Client, instance, clientTlsPhase, r.Health.FourLetterWord)
+	if err != nil {
+		return err
+	}
+	if !apimeta.SetStatusCondition(&instance.Status.Conditions, *condition) {
+		return nil
+	}
+	if condition.Status == metav1.ConditionTrue {
+		logger.Info("Servers near the capacity of their volumes, consider expanding them or purging more often", "message", condition.Message)
+	}
+	return r.Status().Update(ctx, instance)
+}
+
+// updateVolumeResizeCondition records whether the volumes of the servers are being expanded, and tells so.
+func (r *ZookeeperClusterReconciler) updateVolumeResizeCondition(ctx context.Context, instance *zkv1alpha1.ZookeeperCluster) (bool, error) {
+	condition, err := server.VolumeResizeCondition(ctx, r.Client, instance)
+	if err != nil {
+		return false, err
+	}
+	resizing := condition.Status == metav1.ConditionTrue
+	if !apimeta.SetStatusCondition(&instance.Status.Conditions, *condition) {
+		return resizing, nil
+	}
+	if resizing {
+		logger.Info("Waiting for the volumes to be resized", "message", condition.Message)
+	}
+	return resizing, r.Status().Update(ctx, instance)
+}
 
+// settleDiscovery returns when the servers that recently became ready are debounced and added to discovery,
+// 0 if none is waiting. The reconcile is requeued by then.
+func (r *ZookeeperClusterReconciler) settleDiscovery(ctx context.Context, resourceClient *client.Client, instance *zkv1alpha1.ZookeeperCluster) (time.Duration, error) {
+	settleAfter, err := common.DiscoverySettleAfter(ctx, resourceClient, instance)
+	if err != nil {
+		return 0, err
+	}
+	if settleAfter > 0 {
+		logger.V(1).Info("Waiting for servers to settle in discovery", "requeueAfter", settleAfter)
+	}
+	return settleAfter, nil
+}
+
+// rotateCertificates restarts servers before their certificates expire, the leader of the last check of the health
+// monitor last, and reports the next rotation in status.
+func (r *ZookeeperClusterReconciler) rotateCertificates(ctx context.Context, instance *zkv1alpha1.ZookeeperCluster) (ctrl.Result, error) {
+	buffer, err := cluster.CertificateRestartBuffer(instance.Spec.ClusterConfig)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	var leader string
+	if health := r.Health.Health(ctrlclient.ObjectKeyFromObject(instance)); health != nil && health.Leader() != nil {
+		leader = health.Leader().Name
+	}
+	next, err := cluster.NewCertificateRotation(r.Client, instance, buffer, leader).Rotate(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var nextRotation *metav1.Time
+	if next != nil {
+		nextRotation = &metav1.Time{Time: *next}
+	}
+	if !nextRotation.Equal(instance.Status.NextCertificateRotation) {
+		instance.Status.NextCertificateRotation = nextRotation
+		if err := r.Status().Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if next == nil {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: max(time.Until(*next), rolloutRequeueAfter)}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ZookeeperClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&zkv1alpha1.ZookeeperCluster{}).
+		// load balancer addresses of the per server services are published in discovery
+		Owns(&corev1.Service{}).
+		// node addresses of the servers are published in discovery
+		Watches(
+			&corev1.Pod{},
+			handler.EnqueueRequestsFromMapFunc(clusterResourceToCluster),
+			builder.WithPredicates(common.PodPlacementChanged),
+		).
+		// ready servers are published in discovery, see DiscoverySpec
+		Watches(
+			&discoveryv1.EndpointSlice{},
+			handler.EnqueueRequestsFromMapFunc(clusterResourceToCluster),
+			builder.WithPredicates(common.EndpointReadinessChanged),
+		).
+		Watches(
+			&corev1.Node{},
+			handler.EnqueueRequestsFromMapFunc(r.nodeToClusters),
+			builder.WithPredicates(common.NodeAddressChanged),
+		).
+		// the quorum health is reported as soon as the health monitor notices a change
+		WatchesRawSource(source.Channel(r.Health.Changes(), &handler.EnqueueRequestForObject{})).
 		Complete(r)
 }
+
+// clusterResourceToCluster maps a resource of a ZookeeperCluster, e.g. a server pod, to the cluster.
+func clusterResourceToCluster(_ context.Context, obj ctrlclient.Object) []reconcile.Request {
+	instance, ok := common.ClusterInstanceOf(obj)
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: instance}}}
+}
+
+// nodeToClusters maps a node to the ZookeeperClusters that publish node addresses in discovery.
+func (r *ZookeeperClusterReconciler) nodeToClusters(ctx context.Context, _ ctrlclient.Object) []reconcile.Request {
+	clusters := &zkv1alpha1.ZookeeperClusterList{}
+	if err := r.List(ctx, clusters); err != nil {
+		r.Log.Error(err, "unable to list zookeeper clusters")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0)
+	for _, cluster := range clusters.Items {
+		if common.NodePortsEnabled(cluster.Spec.ClusterConfig) {
+			requests = append(requests, reconcile.Request{NamespacedName: ctrlclient.ObjectKeyFromObject(&cluster)})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,88 @@
+package common
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/security"
+)
+
+var (
+	// KnownFourLetterWords are the four letter word commands ZooKeeper supports
+	KnownFourLetterWords = []zkv1alpha1.FourLetterWord{
+		"conf", "cons", "crst", "dirs", "dump", "envi", "hash", "isro",
+		"mntr", "ruok", "srst", "srvr", "stat", "wchc", "wchp", "wchs",
+	}
+	// RequiredFourLetterWords are always allowed, the readiness probe and the operator rely on them
+	RequiredFourLetterWords = []zkv1alpha1.FourLetterWord{"srvr", "mntr", "ruok"}
+	// DefaultFourLetterWords are allowed if none are configured
+	DefaultFourLetterWords = []zkv1alpha1.FourLetterWord{"srvr", "mntr", "conf", "ruok"}
+)
+
+// AdminServerEnabled checks if the AdminServer is started, which is the default.
+func AdminServerEnabled(admin *zkv1alpha1.AdminSpec) bool {
+	return admin == nil || admin.Enabled == nil || *admin.Enabled
+}
+
+// AdminServiceEnabled checks if the AdminServer is exposed through a Service.
+func AdminServiceEnabled(admin *zkv1alpha1.AdminSpec) bool {
+	return AdminServerEnabled(admin) && admin != nil && admin.Service != nil && admin.Service.Enabled
+}
+
+// AdminServiceName returns the name of the AdminServer Service of the cluster.
+func AdminServiceName(clusterName string) string {
+	return clusterName + "-admin"
+}
+
+// ValidateAdmin checks the four letter words and that AdminServer TLS has the quorum keystore it is served with.
+// ZooKeeper has no keystore setting of its own for the AdminServer, it always loads the `ssl.quorum.` keystore,
+// so the server keystore of serverSecretClass can not be used.
+func ValidateAdmin(admin *zkv1alpha1.AdminSpec, zkSecurity *security.ZookeeperSecurity) error {
+	if admin == nil {
+		return nil
+	}
+	for _, word := range admin.FourLetterWords {
+		if !slices.Contains(KnownFourLetterWords, word) {
+			return fmt.Errorf("unknown four letter word %q, supported are: %v", word, KnownFourLetterWords)
+		}
+	}
+	if AdminServerEnabled(admin) && admin.Tls && !zkSecurity.QuorumTLSEnabled() {
+		return fmt.Errorf("AdminServer TLS requires spec.clusterConfig.tls.quorumSecretClass, " +
+			"ZooKeeper serves the AdminServer with the certificate of the quorum keystore")
+	}
+	return nil
+}
+
+// FourLetterWordsWhitelist returns the `4lw.commands.whitelist` of the configured commands, including the required ones.
+func FourLetterWordsWhitelist(admin *zkv1alpha1.AdminSpec) string {
+	words := DefaultFourLetterWords
+	if admin != nil && len(admin.FourLetterWords) > 0 {
+		words = admin.FourLetterWords
+	}
+	whitelist := make([]string, 0, len(words)+len(RequiredFourLetterWords))
+	for _, word := range slices.Concat(words, RequiredFourLetterWords) {
+		if !slices.Contains(whitelist, string(word)) {
+			whitelist = append(whitelist, string(word))
+		}
+	}
+	return strings.Join(whitelist, ", ")
+}
+
+// AdminConfigSettings returns the AdminServer settings of `zoo.cfg`.
+func AdminConfigSettings(admin *zkv1alpha1.AdminSpec) map[string]string {
+	if !AdminServerEnabled(admin) {
+		return map[string]string{"admin.enableServer": "false"}
+	}
+	config := map[string]string{
+		"admin.enableServer": "true",
+		"admin.serverPort":   strconv.Itoa(zkv1alpha1.AdminPort),
+	}
+	if admin != nil && admin.Tls {
+		// HTTPS only, the AdminServer reads the keystore and TLS settings of the `ssl.quorum.` properties, see ValidateAdmin
+		config["admin.forceHttps"] = "true"
+	}
+	return config
+}
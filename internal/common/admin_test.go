@@ -0,0 +1,54 @@
+package common_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/ptr"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+	"github.com/zncdatadev/zookeeper-operator/internal/security"
+)
+
+var _ = Describe("Admin", func() {
+	It("should keep the default four letter words", func() {
+		Expect(common.FourLetterWordsWhitelist(nil)).To(Equal("srvr, mntr, conf, ruok"))
+		Expect(common.AdminConfigSettings(nil)).To(HaveKeyWithValue("admin.serverPort", "8080"))
+	})
+
+	It("should always allow the commands the probes rely on", func() {
+		admin := &zkv1alpha1.AdminSpec{FourLetterWords: []zkv1alpha1.FourLetterWord{"stat", "srvr"}}
+		Expect(common.FourLetterWordsWhitelist(admin)).To(Equal("stat, srvr, mntr, ruok"))
+	})
+
+	It("should disable the AdminServer and its Service", func() {
+		admin := &zkv1alpha1.AdminSpec{
+			Enabled: ptr.To(false),
+			Service: &zkv1alpha1.AdminServiceSpec{Enabled: true},
+		}
+		Expect(common.AdminConfigSettings(admin)).To(Equal(map[string]string{"admin.enableServer": "false"}))
+		Expect(common.AdminServiceEnabled(admin)).To(BeFalse())
+	})
+
+	It("should require the quorum keystore for AdminServer TLS", func() {
+		admin := &zkv1alpha1.AdminSpec{Tls: true}
+		zkSecurity, err := security.NewZookeeperSecurity(context.Background(), nil, "simple", &zkv1alpha1.ClusterConfigSpec{
+			Tls: &zkv1alpha1.ZookeeperTls{ServerSecretClass: "tls"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(common.ValidateAdmin(admin, zkSecurity)).To(MatchError(ContainSubstring("requires spec.clusterConfig.tls.quorumSecretClass")))
+
+		zkSecurity, err = security.NewZookeeperSecurity(context.Background(), nil, "simple", &zkv1alpha1.ClusterConfigSpec{
+			Tls: &zkv1alpha1.ZookeeperTls{QuorumSecretClass: "tls"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(common.ValidateAdmin(admin, zkSecurity)).To(Succeed())
+	})
+
+	It("should reject unknown four letter words", func() {
+		admin := &zkv1alpha1.AdminSpec{FourLetterWords: []zkv1alpha1.FourLetterWord{"kill"}}
+		Expect(common.ValidateAdmin(admin, nil)).To(MatchError(ContainSubstring("kill")))
+	})
+})
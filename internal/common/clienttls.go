@@ -0,0 +1,44 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+)
+
+// LoadClientTLSConfig loads the TLS settings the operator connects with to the client ports that only accept TLS,
+// from a SecretClass mounted by the secret-operator in the PEM format: the CAs of `ca.crt` to trust the servers, and
+// the client certificate of `tls.crt` and `tls.key` if the servers require one. The client certificate is read for
+// every connection, as the secret-operator renews it in place. It returns nil if there is no `ca.crt`.
+func LoadClientTLSConfig(dir string) (*tls.Config, error) {
+	caPEM, err := os.ReadFile(path.Join(dir, "ca.crt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no CA certificate in %s", path.Join(dir, "ca.crt"))
+	}
+	return &tls.Config{
+		RootCAs:    rootCAs,
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(path.Join(dir, "tls.crt"), path.Join(dir, "tls.key"))
+			if errors.Is(err, fs.ErrNotExist) {
+				// no certificate is sent, servers that require one refuse the connection
+				return &tls.Certificate{}, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return &cert, nil
+		},
+	}, nil
+}
@@ -6,7 +6,6 @@ import (
 	"maps"
 	"slices"
 	"sort"
-	"strconv"
 	"strings"
 
 	"github.com/zncdatadev/operator-go/pkg/builder"
@@ -14,6 +13,7 @@ import (
 	"github.com/zncdatadev/operator-go/pkg/reconciler"
 	corev1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
@@ -28,6 +28,8 @@ type ZNodeInfo struct {
 	Name      string
 	Namespace string
 	ZNodePath string
+	// DiscoveryProfiles are rendered next to the generic discovery ConfigMap
+	DiscoveryProfiles []zkv1alpha1.DiscoveryProfile
 }
 
 func NewDiscoveryReconcilers(
@@ -37,7 +39,7 @@ func NewDiscoveryReconcilers(
 	zkSecurity *security.ZookeeperSecurity,
 	znodeInfo *ZNodeInfo,
 	options ...builder.Option,
-) []reconciler.ResourceReconciler[builder.ConfigBuilder] {
+) ([]reconciler.ResourceReconciler[builder.ConfigBuilder], error) {
 	discoveries := make(map[string]Discoverer, 0)
 	// create a default cluster-internal discovery configmap
 	discovery := NewDiscoverer(client, zkCluster, zkSecurity, znodeInfo, zkv1alpha1.ClusterInternal)
@@ -47,9 +49,14 @@ func NewDiscoveryReconcilers(
 		discovery = NewDiscoverer(client, zkCluster, zkSecurity, znodeInfo, zkv1alpha1.ExternalUnstable)
 		discoveries[znodeInfo.Name+"-nodeport"] = discovery
 	}
+	if zkv1alpha1.ListenerClass(zkCluster.Spec.ClusterConfig.ListenerClass) == zkv1alpha1.ExternalStable {
+		// create a discovery configmap with the addresses of the per server services
+		discovery = NewDiscoverer(client, zkCluster, zkSecurity, znodeInfo, zkv1alpha1.ExternalStable)
+		discoveries[znodeInfo.Name+"-external"] = discovery
+	}
 
-	// create discovery configmaps of znode
-	reconcilers := make([]reconciler.ResourceReconciler[builder.ConfigBuilder], 0, len(discoveries))
+	// create discovery configmaps of znode, the generic one and one per requested profile
+	reconcilers := make([]reconciler.ResourceReconciler[builder.ConfigBuilder], 0, len(discoveries)*(1+len(znodeInfo.DiscoveryProfiles)))
 	for key, discovery := range discoveries {
 		reconcilers = append(reconcilers, reconciler.NewGenericResourceReconciler(
 			client,
@@ -57,12 +64,29 @@ func NewDiscoveryReconcilers(
 				client,
 				key,
 				discovery,
+				GenericDiscoveryFormat,
 				options...,
 			),
 		))
+		for _, profile := range znodeInfo.DiscoveryProfiles {
+			suffix, format, err := GetDiscoveryFormat(profile)
+			if err != nil {
+				return nil, err
+			}
+			reconcilers = append(reconcilers, reconciler.NewGenericResourceReconciler(
+				client,
+				NewDiscoverConfigmapBuilder(
+					client,
+					key+"-"+suffix,
+					discovery,
+					format,
+					options...,
+				),
+			))
+		}
 	}
 
-	return reconcilers
+	return reconcilers, nil
 }
 
 var _ builder.ConfigBuilder = &DiscoverConfigmapBuilder{}
@@ -71,12 +95,14 @@ type DiscoverConfigmapBuilder struct {
 	builder.ConfigMapBuilder
 
 	discovery Discoverer
+	format    DiscoveryFormat
 }
 
 func NewDiscoverConfigmapBuilder(
 	client *client.Client,
 	name string,
 	discovery Discoverer,
+	format DiscoveryFormat,
 	options ...builder.Option,
 ) builder.ConfigBuilder {
 	return &DiscoverConfigmapBuilder{
@@ -86,6 +112,7 @@ func NewDiscoverConfigmapBuilder(
 			options...,
 		),
 		discovery: discovery,
+		format:    format,
 	}
 }
 
@@ -94,10 +121,11 @@ func (dcb *DiscoverConfigmapBuilder) Build(ctx context.Context) (ctrlclient.Obje
 	if err != nil {
 		return nil, err
 	}
-	dcb.AddItem("ZOOKEEPER", zkconn.URI)
-	dcb.AddItem("ZOOKEEPER_HOSTS", strings.Join(zkconn.Hosts, ","))
-	dcb.AddItem("ZOOKEEPER_PORT", strconv.Itoa(int(zkconn.Port)))
-	dcb.AddItem("ZOOKEEPER_CHROOT", zkconn.ZNode)
+	data, err := dcb.format.Render(zkconn)
+	if err != nil {
+		return nil, err
+	}
+	dcb.AddData(data)
 
 	return dcb.ConfigMapBuilder.Build(ctx)
 }
@@ -107,6 +135,30 @@ type ZookeeperConnection struct {
 	Hosts []string
 	Port  int32
 	ZNode string
+	// SecureURI, SecureHosts and SecurePort point to the TLS only port while migrating from plaintext to TLS and
+	// once the servers only serve TLS, so clients moved to them keep working after the migration. They are empty
+	// without TLS.
+	SecureURI   string
+	SecureHosts []string
+	SecurePort  int32
+	// Tls is true if clients must connect to Hosts over TLS
+	Tls bool
+	// TlsSecretClass is the SecretClass that issues the server certificates, empty without TLS
+	TlsSecretClass string
+	// TlsClientAuth tells if the servers require (need), request (want) or ignore (none) client certificates,
+	// empty without TLS
+	TlsClientAuth string
+	// ClientCertSecretClasses are the SecretClasses whose certificates the servers accept for x509 authentication
+	ClientCertSecretClasses []string
+	// AuthenticationMechanisms are the client authentication mechanisms accepted by the servers, e.g. x509 and sasl
+	AuthenticationMechanisms []string
+	// SaslMechanisms are the SASL mechanisms accepted by the servers, GSSAPI and DIGEST-MD5
+	SaslMechanisms []string
+	// KerberosSecretClass and KerberosServicePrincipal describe the Kerberos identity of the servers, empty without Kerberos
+	KerberosSecretClass      string
+	KerberosServicePrincipal string
+	// DigestAuthenticationClasses are the static authentication classes holding the DIGEST-MD5 users
+	DigestAuthenticationClasses []string
 }
 
 type Discoverer interface {
@@ -140,18 +192,9 @@ type discovery struct {
 }
 
 func (d *discovery) GetZookeeperConnection(ctx context.Context) (*ZookeeperConnection, error) {
-	var hosts []string
-	var err error
-	if d.listenerClass == zkv1alpha1.ExternalUnstable {
-		hosts, err = d.getNodeport(ctx)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		hosts, err = d.getPodHosts()
-		if err != nil {
-			return nil, err
-		}
+	hosts, err := d.getHosts(ctx, zkv1alpha1.ClientPortName, d.zkSecurity.ClientPort())
+	if err != nil {
+		return nil, err
 	}
 
 	znodePath := d.znodeInfo.ZNodePath
@@ -170,11 +213,53 @@ func (d *discovery) GetZookeeperConnection(ctx context.Context) (*ZookeeperConne
 		Hosts: hosts,
 		Port:  int32(d.zkSecurity.ClientPort()),
 		ZNode: znodePath,
+
+		AuthenticationMechanisms:    d.zkSecurity.AuthenticationMechanisms(),
+		ClientCertSecretClasses:     d.zkSecurity.ClientTrustSecretClasses(),
+		SaslMechanisms:              d.zkSecurity.SaslMechanisms(),
+		DigestAuthenticationClasses: d.zkSecurity.DigestAuthenticationClasses(),
+	}
+	if d.zkSecurity.TLSEnabled() {
+		// while migrating to TLS, Hosts still accept plaintext connections
+		zkconn.Tls = d.zkSecurity.ClientTlsPhase() == zkv1alpha1.ClientTlsPhaseTls
+		zkconn.TlsSecretClass = d.zkSecurity.ServerSecretClass()
+		zkconn.TlsClientAuth = d.zkSecurity.ClientCertificateMode()
+	}
+	if kerberosSecretClass := d.zkSecurity.ClientKerberosSecretClass(); kerberosSecretClass != "" {
+		zkconn.KerberosSecretClass = kerberosSecretClass
+		zkconn.KerberosServicePrincipal = d.zkSecurity.KerberosServicePrincipal()
+	}
+
+	switch securePort := d.zkSecurity.SecureClientPort(); {
+	case zkconn.Tls:
+		// the client port is the TLS only port
+		zkconn.SecureURI, zkconn.SecureHosts, zkconn.SecurePort = zkconn.URI, zkconn.Hosts, zkconn.Port
+	case securePort != 0:
+		secureHosts, err := d.getHosts(ctx, zkv1alpha1.SecureClientPortName, securePort)
+		if err != nil {
+			return nil, err
+		}
+		zkconn.SecureURI = fmt.Sprintf("%s%s", strings.Join(secureHosts, ","), znodePath)
+		zkconn.SecureHosts = secureHosts
+		zkconn.SecurePort = int32(securePort)
 	}
 	return zkconn, nil
 }
 
-func (d *discovery) getPodHosts() ([]string, error) {
+// getHosts returns the `host:port` addresses of the given client port, depending on the listener class
+func (d *discovery) getHosts(ctx context.Context, portName string, port uint16) ([]string, error) {
+	switch d.listenerClass {
+	case zkv1alpha1.ExternalUnstable:
+		return d.getNodeport(ctx, portName)
+	case zkv1alpha1.ExternalStable:
+		return d.getPodServiceHosts(ctx, portName)
+	default:
+		return d.getPodHosts(ctx, port)
+	}
+}
+
+// serverPods returns the pod and role group service names of all servers, ordered by role group and ordinal
+func (d *discovery) serverPods() ([]serverPod, error) {
 	servers := d.zkCluster.Spec.Servers
 
 	gvk := d.zkCluster.GetObjectKind().GroupVersionKind()
@@ -208,8 +293,7 @@ func (d *discovery) getPodHosts() ([]string, error) {
 		})
 	}
 
-	clientPort := d.zkSecurity.ClientPort()
-	hosts := make([]string, 0)
+	pods := make([]serverPod, 0)
 	for _, rgInfo := range roleGroupsInfo {
 		rg := roleGroups[rgInfo.RoleGroupName]
 		replicas := int32(1)
@@ -218,17 +302,125 @@ func (d *discovery) getPodHosts() ([]string, error) {
 		}
 		roleGroupServiceName := rgInfo.GetFullName()
 		for i := int32(0); i < replicas; i++ {
-			podName := fmt.Sprintf("%s-%d", roleGroupServiceName, i)
-			fqdn := fmt.Sprintf("%s.%s.%s.svc.cluster.local:%d", podName, roleGroupServiceName, d.zkCluster.Namespace, clientPort)
-			hosts = append(hosts, fqdn)
+			pods = append(pods, serverPod{
+				name:        fmt.Sprintf("%s-%d", roleGroupServiceName, i),
+				serviceName: roleGroupServiceName,
+			})
 		}
 	}
+	return pods, nil
+}
+
+// listedServers returns the servers to list in discovery, only the ready ones in the Ready discovery mode
+func (d *discovery) listedServers(ctx context.Context) ([]serverPod, error) {
+	pods, err := d.serverPods()
+	if err != nil || !ReadyDiscoveryEnabled(d.zkCluster.Spec.ClusterConfig) {
+		return pods, err
+	}
+	ready, _, err := d.readyServers(ctx, pods)
+	return ready, err
+}
+
+type serverPod struct {
+	name        string
+	serviceName string
+}
+
+func (d *discovery) getPodHosts(ctx context.Context, clientPort uint16) ([]string, error) {
+	pods, err := d.listedServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterDomain := ClusterDomain(d.zkCluster.Spec.ClusterConfig)
+	hosts := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		fqdn := PodFQDN(pod.name, pod.serviceName, d.zkCluster.Namespace, clusterDomain)
+		hosts = append(hosts, fmt.Sprintf("%s:%d", fqdn, clientPort))
+	}
 
 	discoveryLogger.V(1).Info("got pod hosts", "hosts", hosts, "clientPort", clientPort)
 	return hosts, nil
 }
 
-func (d *discovery) getNodeport(ctx context.Context) ([]string, error) {
+// getPodServiceHosts returns the addresses of the per server services of the `external-stable` listener class.
+// Servers whose address is not known yet, e.g. while the load balancer is provisioned, are left out.
+func (d *discovery) getPodServiceHosts(ctx context.Context, portName string) ([]string, error) {
+	pods, err := d.listedServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := d.zkCluster.Namespace
+	hosts := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		host, err := d.getPodServiceHost(ctx, pod.name, portName)
+		if err != nil {
+			return nil, err
+		}
+		if host == "" {
+			discoveryLogger.V(1).Info("address of server not known yet", "pod", pod.name, "namespace", namespace)
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no address of the per server services of %s/%s is known yet", namespace, d.zkCluster.Name)
+	}
+
+	discoveryLogger.V(1).Info("got pod service hosts", "hosts", hosts)
+	return hosts, nil
+}
+
+// getPodServiceHost returns the `host:port` of a server, or empty if it is not known yet
+func (d *discovery) getPodServiceHost(ctx context.Context, podName, portName string) (string, error) {
+	namespace := d.zkCluster.Namespace
+	svcName := PodServiceName(podName)
+
+	var svc corev1.Service
+	if err := d.client.Get(ctx, ctrlclient.ObjectKey{Namespace: namespace, Name: svcName}, &svc); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("get service %s/%s: %w", namespace, svcName, err)
+	}
+	idx := slices.IndexFunc(svc.Spec.Ports, func(port corev1.ServicePort) bool { return port.Name == portName })
+	if idx < 0 {
+		return "", fmt.Errorf("no port '%s' in service %s/%s", portName, namespace, svcName)
+	}
+	port := svc.Spec.Ports[idx]
+
+	if svc.Spec.Type == corev1.ServiceTypeNodePort {
+		var pod corev1.Pod
+		if err := d.client.Get(ctx, ctrlclient.ObjectKey{Namespace: namespace, Name: podName}, &pod); err != nil {
+			if apierrors.IsNotFound(err) {
+				return "", nil
+			}
+			return "", fmt.Errorf("get pod %s/%s: %w", namespace, podName, err)
+		}
+		if pod.Spec.NodeName == "" || port.NodePort == 0 {
+			return "", nil
+		}
+		address, err := d.getNodeAddress(ctx, pod.Spec.NodeName)
+		if err != nil || address == "" {
+			return "", err
+		}
+		return fmt.Sprintf("%s:%d", address, port.NodePort), nil
+	}
+
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		host := ingress.IP
+		if host == "" {
+			host = ingress.Hostname
+		}
+		if host != "" {
+			return fmt.Sprintf("%s:%d", host, port.Port), nil
+		}
+	}
+	return "", nil
+}
+
+func (d *discovery) getNodeport(ctx context.Context, portName string) ([]string, error) {
 
 	svcName := d.zkCluster.Name
 	namespace := d.zkCluster.Namespace
@@ -241,14 +433,14 @@ func (d *discovery) getNodeport(ctx context.Context) ([]string, error) {
 	var nodePort int32
 	found := false
 	for _, port := range svc.Spec.Ports {
-		if port.Name == zkv1alpha1.ClientPortName {
+		if port.Name == portName {
 			nodePort = port.NodePort
 			found = true
 			break
 		}
 	}
 	if !found || nodePort == 0 {
-		return nil, fmt.Errorf("no nodePort found for port 'client' in service %s/%s", namespace, svcName)
+		return nil, fmt.Errorf("no nodePort found for port '%s' in service %s/%s", portName, namespace, svcName)
 	}
 
 	// Find EndpointSlices by label selector instead of direct name lookup
@@ -264,11 +456,19 @@ func (d *discovery) getNodeport(ctx context.Context) ([]string, error) {
 		return nil, fmt.Errorf("no endpointslices found for service %s/%s", namespace, svcName)
 	}
 
+	pods, err := d.listedServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	listed := func(endpoint discoveryv1.Endpoint) bool {
+		return endpoint.TargetRef == nil || slices.ContainsFunc(pods, func(pod serverPod) bool { return pod.name == endpoint.TargetRef.Name })
+	}
+
 	nodes := make([]string, 0)
 	// Collect unique node names from all EndpointSlices
 	for _, endpointSlice := range endpointSliceList.Items {
 		for _, endpoint := range endpointSlice.Endpoints {
-			if endpoint.NodeName != nil && *endpoint.NodeName != "" && !slices.Contains(nodes, *endpoint.NodeName) {
+			if listed(endpoint) && endpoint.NodeName != nil && *endpoint.NodeName != "" && !slices.Contains(nodes, *endpoint.NodeName) {
 				nodes = append(nodes, *endpoint.NodeName)
 			}
 		}
@@ -276,10 +476,41 @@ func (d *discovery) getNodeport(ctx context.Context) ([]string, error) {
 
 	hosts := make([]string, 0, len(nodes))
 	for _, node := range nodes {
-		hosts = append(hosts, fmt.Sprintf("%s:%d", node, nodePort))
+		address, err := d.getNodeAddress(ctx, node)
+		if err != nil {
+			return nil, err
+		}
+		if address == "" {
+			discoveryLogger.V(1).Info("node has no address", "node", node)
+			continue
+		}
+		if host := fmt.Sprintf("%s:%d", address, nodePort); !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no address of the nodes %v of service %s/%s is known", nodes, namespace, svcName)
 	}
 	sort.Strings(hosts)
 
 	discoveryLogger.V(1).Info("got nodeport hosts", "hosts", hosts, "nodePort", nodePort)
 	return hosts, nil
 }
+
+// getNodeAddress returns the address of a node as selected by `clusterConfig.nodeAddress`,
+// or empty if the node is gone or has no address
+func (d *discovery) getNodeAddress(ctx context.Context, nodeName string) (string, error) {
+	var node corev1.Node
+	if err := d.client.Get(ctx, ctrlclient.ObjectKey{Name: nodeName}, &node); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("get node %s: %w", nodeName, err)
+	}
+
+	var nodeAddress *zkv1alpha1.NodeAddressSpec
+	if clusterConfig := d.zkCluster.Spec.ClusterConfig; clusterConfig != nil {
+		nodeAddress = clusterConfig.NodeAddress
+	}
+	return NodeAddress(&node, nodeAddress), nil
+}
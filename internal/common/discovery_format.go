@@ -0,0 +1,199 @@
+package common
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/security"
+	"github.com/zncdatadev/zookeeper-operator/internal/util"
+)
+
+const (
+	ClientPropertiesFileName = "zookeeper-client.properties"
+	JaasFileName             = "jaas.conf"
+
+	// JAAS login context of the ZooKeeper client
+	clientLoginContext string = "Client"
+	// netty is required for client TLS
+	nettyClientCnxnSocket string = "org.apache.zookeeper.ClientCnxnSocketNetty"
+)
+
+// DiscoveryFormat renders a ZooKeeper connection into the data of a discovery ConfigMap.
+type DiscoveryFormat interface {
+	Render(zkconn *ZookeeperConnection) (map[string]string, error)
+}
+
+// DiscoveryFormatFunc adapts a function to a DiscoveryFormat.
+type DiscoveryFormatFunc func(zkconn *ZookeeperConnection) (map[string]string, error)
+
+func (f DiscoveryFormatFunc) Render(zkconn *ZookeeperConnection) (map[string]string, error) {
+	return f(zkconn)
+}
+
+type discoveryProfileFormat struct {
+	suffix string
+	format DiscoveryFormat
+}
+
+var discoveryFormats = map[zkv1alpha1.DiscoveryProfile]discoveryProfileFormat{}
+
+func init() {
+	RegisterDiscoveryFormat(zkv1alpha1.DiscoveryProfileKafka, "kafka", DiscoveryFormatFunc(kafkaDiscoveryFormat))
+	RegisterDiscoveryFormat(zkv1alpha1.DiscoveryProfileHBase, "hbase", DiscoveryFormatFunc(hbaseDiscoveryFormat))
+	RegisterDiscoveryFormat(zkv1alpha1.DiscoveryProfileHadoopHA, "hadoop-ha", DiscoveryFormatFunc(hadoopHADiscoveryFormat))
+	RegisterDiscoveryFormat(zkv1alpha1.DiscoveryProfileClientProperties, "client-properties", DiscoveryFormatFunc(clientPropertiesDiscoveryFormat))
+	RegisterDiscoveryFormat(zkv1alpha1.DiscoveryProfileJaas, "jaas", DiscoveryFormatFunc(jaasDiscoveryFormat))
+}
+
+// RegisterDiscoveryFormat registers the format of a discovery profile, rendered into the ConfigMap
+// `<discovery ConfigMap>-<suffix>`.
+func RegisterDiscoveryFormat(profile zkv1alpha1.DiscoveryProfile, suffix string, format DiscoveryFormat) {
+	discoveryFormats[profile] = discoveryProfileFormat{suffix: suffix, format: format}
+}
+
+// GetDiscoveryFormat returns the ConfigMap name suffix and the format of a discovery profile.
+func GetDiscoveryFormat(profile zkv1alpha1.DiscoveryProfile) (string, DiscoveryFormat, error) {
+	profileFormat, ok := discoveryFormats[profile]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown discovery profile %q, supported are: %v", profile, slices.Sorted(maps.Keys(discoveryFormats)))
+	}
+	return profileFormat.suffix, profileFormat.format, nil
+}
+
+// ValidateDiscoveryProfiles checks that the profiles are known and that the cluster has what they render.
+func ValidateDiscoveryProfiles(profiles []zkv1alpha1.DiscoveryProfile, zkSecurity *security.ZookeeperSecurity) error {
+	for _, profile := range profiles {
+		if _, _, err := GetDiscoveryFormat(profile); err != nil {
+			return err
+		}
+		if profile == zkv1alpha1.DiscoveryProfileJaas && zkSecurity.ClientKerberosSecretClass() == "" &&
+			len(zkSecurity.DigestAuthenticationClasses()) == 0 {
+			return fmt.Errorf("the Jaas discovery profile requires SASL client authentication, " +
+				"add a Kerberos or static AuthenticationClass to spec.clusterConfig.authentication")
+		}
+	}
+	return nil
+}
+
+// GenericDiscoveryFormat renders the `ZOOKEEPER_*` keys of the discovery ConfigMap every cluster and znode has.
+var GenericDiscoveryFormat DiscoveryFormat = DiscoveryFormatFunc(genericDiscoveryFormat)
+
+func genericDiscoveryFormat(zkconn *ZookeeperConnection) (map[string]string, error) {
+	data := map[string]string{
+		"ZOOKEEPER":            zkconn.URI,
+		"ZOOKEEPER_HOSTS":      strings.Join(zkconn.Hosts, ","),
+		"ZOOKEEPER_PORT":       strconv.Itoa(int(zkconn.Port)),
+		"ZOOKEEPER_CHROOT":     zkconn.ZNode,
+		"ZOOKEEPER_CLIENT_TLS": strconv.FormatBool(zkconn.Tls),
+	}
+	if zkconn.SecurePort != 0 {
+		data["ZOOKEEPER_SECURE"] = zkconn.SecureURI
+		data["ZOOKEEPER_SECURE_HOSTS"] = strings.Join(zkconn.SecureHosts, ",")
+		data["ZOOKEEPER_SECURE_PORT"] = strconv.Itoa(int(zkconn.SecurePort))
+	}
+	if zkconn.TlsSecretClass != "" {
+		data["ZOOKEEPER_CLIENT_TLS_SECRET_CLASS"] = zkconn.TlsSecretClass
+	}
+	if zkconn.TlsClientAuth != "" {
+		data["ZOOKEEPER_CLIENT_TLS_CLIENT_AUTH"] = zkconn.TlsClientAuth
+	}
+	if len(zkconn.ClientCertSecretClasses) > 0 {
+		data["ZOOKEEPER_CLIENT_TLS_CLIENT_CERT_SECRET_CLASSES"] = strings.Join(zkconn.ClientCertSecretClasses, ",")
+	}
+	if len(zkconn.AuthenticationMechanisms) > 0 {
+		data["ZOOKEEPER_CLIENT_AUTHENTICATION"] = strings.Join(zkconn.AuthenticationMechanisms, ",")
+	}
+	if len(zkconn.SaslMechanisms) > 0 {
+		data["ZOOKEEPER_SASL_MECHANISMS"] = strings.Join(zkconn.SaslMechanisms, ",")
+	}
+	if zkconn.KerberosSecretClass != "" {
+		data["ZOOKEEPER_SASL_KERBEROS_SECRET_CLASS"] = zkconn.KerberosSecretClass
+		data["ZOOKEEPER_SASL_SERVICE_PRINCIPAL"] = zkconn.KerberosServicePrincipal
+	}
+	if len(zkconn.DigestAuthenticationClasses) > 0 {
+		data["ZOOKEEPER_SASL_DIGEST_AUTHENTICATION_CLASSES"] = strings.Join(zkconn.DigestAuthenticationClasses, ",")
+	}
+	return data, nil
+}
+
+func kafkaDiscoveryFormat(zkconn *ZookeeperConnection) (map[string]string, error) {
+	data := map[string]string{
+		"zookeeper.connect": zkconn.URI,
+	}
+	if zkconn.Tls {
+		data["zookeeper.ssl.client.enable"] = security.TrueString
+		data["zookeeper.clientCnxnSocket"] = nettyClientCnxnSocket
+	}
+	return data, nil
+}
+
+func hbaseDiscoveryFormat(zkconn *ZookeeperConnection) (map[string]string, error) {
+	// HBase adds the client port to the hosts of the quorum without one, hosts reached on another port, e.g. the node
+	// ports of the servers, keep theirs
+	clientPort := strconv.Itoa(int(zkconn.Port))
+	quorum := make([]string, 0, len(zkconn.Hosts))
+	for _, host := range zkconn.Hosts {
+		i := strings.LastIndex(host, ":")
+		if host[i+1:] == clientPort {
+			host = host[:i]
+		}
+		quorum = append(quorum, host)
+	}
+	return map[string]string{
+		"hbase.zookeeper.quorum":              strings.Join(quorum, ","),
+		"hbase.zookeeper.property.clientPort": clientPort,
+		"zookeeper.znode.parent":              zkconn.ZNode,
+	}, nil
+}
+
+func hadoopHADiscoveryFormat(zkconn *ZookeeperConnection) (map[string]string, error) {
+	// the quorum of Hadoop can not have a chroot, the znode is the parent of the HA state instead
+	return map[string]string{
+		"ha.zookeeper.quorum":       strings.Join(zkconn.Hosts, ","),
+		"ha.zookeeper.parent-znode": zkconn.ZNode,
+	}, nil
+}
+
+func clientPropertiesDiscoveryFormat(zkconn *ZookeeperConnection) (map[string]string, error) {
+	properties := map[string]string{
+		"zookeeper.connect":       zkconn.URI,
+		"zookeeper.client.secure": strconv.FormatBool(zkconn.Tls),
+		"zookeeper.sasl.client":   strconv.FormatBool(len(zkconn.SaslMechanisms) > 0),
+	}
+	if zkconn.Tls {
+		properties["zookeeper.clientCnxnSocket"] = nettyClientCnxnSocket
+	}
+	if len(zkconn.SaslMechanisms) > 0 {
+		properties["zookeeper.sasl.clientconfig"] = clientLoginContext
+	}
+	if zkconn.KerberosSecretClass != "" {
+		properties["zookeeper.sasl.client.username"] = security.KerberosServiceName
+	}
+	return map[string]string{ClientPropertiesFileName: util.ToProperties(properties)}, nil
+}
+
+func jaasDiscoveryFormat(zkconn *ZookeeperConnection) (map[string]string, error) {
+	var loginModule string
+	switch {
+	case zkconn.KerberosSecretClass != "":
+		loginModule = `  com.sun.security.auth.module.Krb5LoginModule required
+  useKeyTab=true
+  keyTab="${ZOOKEEPER_CLIENT_KEYTAB}"
+  storeKey=true
+  useTicketCache=false
+  principal="${ZOOKEEPER_CLIENT_PRINCIPAL}";`
+	case len(zkconn.DigestAuthenticationClasses) > 0:
+		loginModule = `  org.apache.zookeeper.server.auth.DigestLoginModule required
+  username="${ZOOKEEPER_CLIENT_USERNAME}"
+  password="${ZOOKEEPER_CLIENT_PASSWORD}";`
+	default:
+		return nil, fmt.Errorf("the Jaas discovery profile requires a Kerberos or static authentication class")
+	}
+	return map[string]string{
+		JaasFileName: fmt.Sprintf("%s {\n%s\n};\n", clientLoginContext, loginModule),
+	}, nil
+}
@@ -0,0 +1,161 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/zncdatadev/operator-go/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+)
+
+// ReadyDiscoveryEnabled checks if the discovery ConfigMaps only list ready servers.
+func ReadyDiscoveryEnabled(clusterConfig *zkv1alpha1.ClusterConfigSpec) bool {
+	return clusterConfig != nil && clusterConfig.Discovery != nil && clusterConfig.Discovery.Mode == zkv1alpha1.DiscoveryModeReady
+}
+
+// DiscoveryDebounce returns how long a server has to be ready before it is listed in discovery, 30s by default.
+func DiscoveryDebounce(clusterConfig *zkv1alpha1.ClusterConfigSpec) (time.Duration, error) {
+	if clusterConfig == nil || clusterConfig.Discovery == nil || clusterConfig.Discovery.Debounce == "" {
+		return zkv1alpha1.DefaultDiscoveryDebounce, nil
+	}
+	debounce, err := time.ParseDuration(clusterConfig.Discovery.Debounce)
+	if err != nil {
+		return 0, fmt.Errorf("invalid discovery debounce %q: %w", clusterConfig.Discovery.Debounce, err)
+	}
+	return debounce, nil
+}
+
+// DiscoverySettleAfter returns how long until a server that recently became ready is added to discovery,
+// zero if no server is pending. Controllers requeue after it, as no event marks the end of the debounce period.
+func DiscoverySettleAfter(ctx context.Context, client *client.Client, zkCluster *zkv1alpha1.ZookeeperCluster) (time.Duration, error) {
+	if !ReadyDiscoveryEnabled(zkCluster.Spec.ClusterConfig) {
+		return 0, nil
+	}
+	d := &discovery{client: client, zkCluster: zkCluster}
+	pods, err := d.serverPods()
+	if err != nil {
+		return 0, err
+	}
+	_, settleAfter, err := d.readyServers(ctx, pods)
+	return settleAfter, err
+}
+
+// EndpointReadinessChanged filters EndpointSlice events down to the ones that change which pods are ready.
+var EndpointReadinessChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldSlice, ok := e.ObjectOld.(*discoveryv1.EndpointSlice)
+		if !ok {
+			return false
+		}
+		newSlice, ok := e.ObjectNew.(*discoveryv1.EndpointSlice)
+		if !ok {
+			return false
+		}
+		return !slices.Equal(readyEndpointPods(oldSlice), readyEndpointPods(newSlice))
+	},
+	GenericFunc: func(event.GenericEvent) bool { return false },
+}
+
+// readyEndpointPods returns the sorted names of the pods with a ready endpoint in the slice.
+func readyEndpointPods(endpointSlice *discoveryv1.EndpointSlice) []string {
+	pods := make([]string, 0, len(endpointSlice.Endpoints))
+	for _, endpoint := range endpointSlice.Endpoints {
+		if endpoint.TargetRef != nil && endpointReady(endpoint) {
+			pods = append(pods, endpoint.TargetRef.Name)
+		}
+	}
+	slices.Sort(pods)
+	return pods
+}
+
+// endpointReady checks if an endpoint serves traffic. `ready` is always true for services publishing
+// not ready addresses, so `serving` is preferred.
+func endpointReady(endpoint discoveryv1.Endpoint) bool {
+	conditions := endpoint.Conditions
+	if conditions.Terminating != nil && *conditions.Terminating {
+		return false
+	}
+	if conditions.Serving != nil {
+		return *conditions.Serving
+	}
+	return conditions.Ready == nil || *conditions.Ready
+}
+
+// readyServers filters the servers down to the ones whose endpoints have been ready for the debounce period,
+// keeping their order. If none qualifies, every server is returned so discovery is never empty.
+// Only additions are debounced: a server that is no longer ready is left out right away, so clients are not sent to
+// it, and a flapping server stays out until it has been ready for the debounce period again. It also returns how long until the next server passes the debounce period, zero if none is pending.
+func (d *discovery) readyServers(ctx context.Context, pods []serverPod) ([]serverPod, time.Duration, error) {
+	debounce, err := DiscoveryDebounce(d.zkCluster.Spec.ClusterConfig)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	namespace := d.zkCluster.Namespace
+	svcName := d.zkCluster.Name
+	var endpointSliceList discoveryv1.EndpointSliceList
+	if err := d.client.Client.List(ctx, &endpointSliceList, ctrlclient.InNamespace(namespace),
+		ctrlclient.MatchingLabels{discoveryv1.LabelServiceName: svcName}); err != nil {
+		return nil, 0, fmt.Errorf("list endpointslices for service %s/%s: %w", namespace, svcName, err)
+	}
+	readyPods := make([]string, 0)
+	for _, endpointSlice := range endpointSliceList.Items {
+		readyPods = append(readyPods, readyEndpointPods(&endpointSlice)...)
+	}
+
+	now := time.Now()
+	var settleAfter time.Duration
+	ready := make([]serverPod, 0, len(pods))
+	for _, pod := range pods {
+		if !slices.Contains(readyPods, pod.name) {
+			continue
+		}
+		readySince, err := d.podReadySince(ctx, pod.name)
+		if err != nil {
+			return nil, 0, err
+		}
+		if readySince.IsZero() {
+			continue
+		}
+		if pending := debounce - now.Sub(readySince); pending > 0 {
+			if settleAfter == 0 || pending < settleAfter {
+				settleAfter = pending
+			}
+			continue
+		}
+		ready = append(ready, pod)
+	}
+
+	if len(ready) == 0 {
+		discoveryLogger.V(1).Info("no server is ready, listing every server", "namespace", namespace, "cluster", d.zkCluster.Name)
+		return pods, settleAfter, nil
+	}
+	return ready, settleAfter, nil
+}
+
+// podReadySince returns when the pod became ready, or zero if it is gone or not ready.
+func (d *discovery) podReadySince(ctx context.Context, podName string) (time.Time, error) {
+	namespace := d.zkCluster.Namespace
+	var pod corev1.Pod
+	if err := d.client.Get(ctx, ctrlclient.ObjectKey{Namespace: namespace, Name: podName}, &pod); err != nil {
+		if apierrors.IsNotFound(err) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("get pod %s/%s: %w", namespace, podName, err)
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+			return condition.LastTransitionTime.Time, nil
+		}
+	}
+	return time.Time{}, nil
+}
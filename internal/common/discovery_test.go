@@ -0,0 +1,248 @@
+package common_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/zncdatadev/operator-go/pkg/client"
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+	"github.com/zncdatadev/zookeeper-operator/internal/security"
+)
+
+var _ = Describe("Discovery ConfigMap", func() {
+	render := func(clusterConfig *zkv1alpha1.ClusterConfigSpec, format common.DiscoveryFormat) (map[string]string, error) {
+		ctx := context.Background()
+		zkCluster := &zkv1alpha1.ZookeeperCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default"},
+			Spec: zkv1alpha1.ZookeeperClusterSpec{
+				ClusterConfig: clusterConfig,
+				Servers: &zkv1alpha1.ServerSpec{RoleGroups: map[string]zkv1alpha1.RoleGroupSpec{
+					"default": {Replicas: 1},
+				}},
+			},
+		}
+		zkCluster.SetGroupVersionKind(zkv1alpha1.GroupVersion.WithKind("ZookeeperCluster"))
+
+		ctrlClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+		zkSecurity, err := security.NewZookeeperSecurity(ctx, ctrlClient, zkCluster.Name, clusterConfig)
+		Expect(err).NotTo(HaveOccurred())
+		resourceClient := client.NewClient(ctrlClient, zkCluster)
+		znodeInfo := &common.ZNodeInfo{Name: zkCluster.Name, Namespace: zkCluster.Namespace, ZNodePath: "/"}
+		cmBuilder := common.NewDiscoverConfigmapBuilder(
+			resourceClient,
+			zkCluster.Name,
+			common.NewDiscoverer(resourceClient, zkCluster, zkSecurity, znodeInfo, zkv1alpha1.ClusterInternal),
+			format,
+		)
+
+		obj, err := cmBuilder.Build(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return obj.(*corev1.ConfigMap).Data, nil
+	}
+
+	discoveryData := func(clusterConfig *zkv1alpha1.ClusterConfigSpec) map[string]string {
+		data, err := render(clusterConfig, common.GenericDiscoveryFormat)
+		Expect(err).NotTo(HaveOccurred())
+		return data
+	}
+
+	profileData := func(clusterConfig *zkv1alpha1.ClusterConfigSpec, profile zkv1alpha1.DiscoveryProfile) (map[string]string, error) {
+		_, format, err := common.GetDiscoveryFormat(profile)
+		Expect(err).NotTo(HaveOccurred())
+		return render(clusterConfig, format)
+	}
+
+	It("should describe a plaintext cluster", func() {
+		data := discoveryData(&zkv1alpha1.ClusterConfigSpec{ListenerClass: constants.ClusterInternal, ClusterDomain: "example.internal"})
+		Expect(data).To(HaveKeyWithValue("ZOOKEEPER", "simple-server-default-0.simple-server-default.default.svc.example.internal:2181/"))
+		Expect(data).To(HaveKeyWithValue("ZOOKEEPER_CLIENT_TLS", "false"))
+		Expect(data).NotTo(HaveKey("ZOOKEEPER_CLIENT_TLS_SECRET_CLASS"))
+		Expect(data).NotTo(HaveKey("ZOOKEEPER_SASL_MECHANISMS"))
+	})
+
+	It("should describe the server certificates of a TLS cluster", func() {
+		data := discoveryData(&zkv1alpha1.ClusterConfigSpec{
+			ListenerClass: constants.ClusterInternal,
+			ClusterDomain: "example.internal",
+			Tls:           &zkv1alpha1.ZookeeperTls{ServerSecretClass: "tls", ClientAuth: zkv1alpha1.TlsClientAuthNone},
+		})
+		Expect(data).To(HaveKeyWithValue("ZOOKEEPER_PORT", "2282"))
+		Expect(data).To(HaveKeyWithValue("ZOOKEEPER_CLIENT_TLS", "true"))
+		Expect(data).To(HaveKeyWithValue("ZOOKEEPER_CLIENT_TLS_SECRET_CLASS", "tls"))
+		Expect(data).To(HaveKeyWithValue("ZOOKEEPER_CLIENT_TLS_CLIENT_AUTH", "none"))
+	})
+
+	It("should keep the TLS entries when switching from Transition to Tls", func() {
+		tls := &zkv1alpha1.ZookeeperTls{ServerSecretClass: "tls", ClientPortMode: zkv1alpha1.ClientPortModeTransition}
+		clusterConfig := &zkv1alpha1.ClusterConfigSpec{ListenerClass: constants.ClusterInternal, ClusterDomain: "example.internal", Tls: tls}
+		secureHost := "simple-server-default-0.simple-server-default.default.svc.example.internal:2282"
+		data := discoveryData(clusterConfig)
+		Expect(data).To(HaveKeyWithValue("ZOOKEEPER_PORT", "2181"))
+		Expect(data).To(HaveKeyWithValue("ZOOKEEPER_CLIENT_TLS", "false"))
+		Expect(data).To(HaveKeyWithValue("ZOOKEEPER_SECURE", secureHost+"/"))
+		Expect(data).To(HaveKeyWithValue("ZOOKEEPER_SECURE_HOSTS", secureHost))
+		Expect(data).To(HaveKeyWithValue("ZOOKEEPER_SECURE_PORT", "2282"))
+
+		tls.ClientPortMode = zkv1alpha1.ClientPortModeTls
+		data = discoveryData(clusterConfig)
+		Expect(data).To(HaveKeyWithValue("ZOOKEEPER", secureHost+"/"))
+		Expect(data).To(HaveKeyWithValue("ZOOKEEPER_CLIENT_TLS", "true"))
+		Expect(data).To(HaveKeyWithValue("ZOOKEEPER_SECURE", secureHost+"/"))
+		Expect(data).To(HaveKeyWithValue("ZOOKEEPER_SECURE_HOSTS", secureHost))
+		Expect(data).To(HaveKeyWithValue("ZOOKEEPER_SECURE_PORT", "2282"))
+	})
+	It("should render the Kafka profile", func() {
+		data, err := profileData(&zkv1alpha1.ClusterConfigSpec{ListenerClass: constants.ClusterInternal, ClusterDomain: "example.internal"}, zkv1alpha1.DiscoveryProfileKafka)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(Equal(map[string]string{
+			"zookeeper.connect": "simple-server-default-0.simple-server-default.default.svc.example.internal:2181/",
+		}))
+	})
+
+	It("should render the HBase quorum without ports", func() {
+		data, err := profileData(&zkv1alpha1.ClusterConfigSpec{ListenerClass: constants.ClusterInternal, ClusterDomain: "example.internal"}, zkv1alpha1.DiscoveryProfileHBase)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(HaveKeyWithValue("hbase.zookeeper.quorum", "simple-server-default-0.simple-server-default.default.svc.example.internal"))
+		Expect(data).To(HaveKeyWithValue("hbase.zookeeper.property.clientPort", "2181"))
+		Expect(data).To(HaveKeyWithValue("zookeeper.znode.parent", "/"))
+	})
+
+	It("should keep the ports of HBase quorum hosts reached on another port", func() {
+		_, format, err := common.GetDiscoveryFormat(zkv1alpha1.DiscoveryProfileHBase)
+		Expect(err).NotTo(HaveOccurred())
+		data, err := format.Render(&common.ZookeeperConnection{
+			Hosts: []string{"10.0.0.1:31811", "10.0.0.2:2181"},
+			Port:  2181,
+			ZNode: "/",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(HaveKeyWithValue("hbase.zookeeper.quorum", "10.0.0.1:31811,10.0.0.2"))
+		Expect(data).To(HaveKeyWithValue("hbase.zookeeper.property.clientPort", "2181"))
+	})
+
+	It("should enable netty in the client properties of a TLS cluster", func() {
+		data, err := profileData(&zkv1alpha1.ClusterConfigSpec{
+			ListenerClass: constants.ClusterInternal,
+			Tls:           &zkv1alpha1.ZookeeperTls{ServerSecretClass: "tls"},
+		}, zkv1alpha1.DiscoveryProfileClientProperties)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data[common.ClientPropertiesFileName]).To(ContainSubstring("zookeeper.client.secure=true"))
+		Expect(data[common.ClientPropertiesFileName]).To(ContainSubstring("zookeeper.clientCnxnSocket=org.apache.zookeeper.ClientCnxnSocketNetty"))
+	})
+
+	It("should refuse a JAAS profile without SASL authentication", func() {
+		_, err := profileData(&zkv1alpha1.ClusterConfigSpec{ListenerClass: constants.ClusterInternal}, zkv1alpha1.DiscoveryProfileJaas)
+		Expect(err).To(MatchError(ContainSubstring("requires a Kerberos or static authentication class")))
+	})
+
+	It("should reject unknown profiles", func() {
+		_, _, err := common.GetDiscoveryFormat("Solr")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should validate a JAAS profile against the SASL authentication of the cluster", func() {
+		zkSecurity, err := security.NewZookeeperSecurity(context.Background(), nil, "simple", nil)
+		Expect(err).NotTo(HaveOccurred())
+		profiles := []zkv1alpha1.DiscoveryProfile{zkv1alpha1.DiscoveryProfileKafka, zkv1alpha1.DiscoveryProfileJaas}
+		Expect(common.ValidateDiscoveryProfiles(profiles, zkSecurity)).To(MatchError(ContainSubstring("requires SASL client authentication")))
+		Expect(common.ValidateDiscoveryProfiles(profiles[:1], zkSecurity)).To(Succeed())
+		Expect(common.ValidateDiscoveryProfiles([]zkv1alpha1.DiscoveryProfile{"Solr"}, zkSecurity)).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Readiness aware discovery", func() {
+	var zkCluster *zkv1alpha1.ZookeeperCluster
+
+	BeforeEach(func() {
+		zkCluster = &zkv1alpha1.ZookeeperCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default"},
+			Spec: zkv1alpha1.ZookeeperClusterSpec{
+				ClusterConfig: &zkv1alpha1.ClusterConfigSpec{
+					ListenerClass: constants.ClusterInternal,
+					ClusterDomain: "example.internal",
+					Discovery:     &zkv1alpha1.DiscoverySpec{Mode: zkv1alpha1.DiscoveryModeReady, Debounce: "30s"},
+				},
+				Servers: &zkv1alpha1.ServerSpec{RoleGroups: map[string]zkv1alpha1.RoleGroupSpec{
+					"default": {Replicas: 3},
+				}},
+			},
+		}
+		zkCluster.SetGroupVersionKind(zkv1alpha1.GroupVersion.WithKind("ZookeeperCluster"))
+	})
+
+	// readyPod is a server pod that became ready the given time ago
+	readyPod := func(name string, readyFor time.Duration) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Status: corev1.PodStatus{Conditions: []corev1.PodCondition{{
+				Type:               corev1.PodReady,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.NewTime(time.Now().Add(-readyFor)),
+			}}},
+		}
+	}
+	endpoint := func(pod string, serving bool) discoveryv1.Endpoint {
+		return discoveryv1.Endpoint{
+			Addresses:  []string{"10.244.0.1"},
+			Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(true), Serving: ptr.To(serving)},
+			TargetRef:  &corev1.ObjectReference{Kind: "Pod", Name: pod, Namespace: "default"},
+		}
+	}
+
+	connection := func(objs ...ctrlclient.Object) (*common.ZookeeperConnection, time.Duration) {
+		ctx := context.Background()
+		ctrlClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objs...).Build()
+		zkSecurity, err := security.NewZookeeperSecurity(ctx, ctrlClient, zkCluster.Name, zkCluster.Spec.ClusterConfig)
+		Expect(err).NotTo(HaveOccurred())
+		resourceClient := client.NewClient(ctrlClient, zkCluster)
+		znodeInfo := &common.ZNodeInfo{Name: zkCluster.Name, Namespace: zkCluster.Namespace, ZNodePath: "/"}
+		zkconn, err := common.NewDiscoverer(resourceClient, zkCluster, zkSecurity, znodeInfo, zkv1alpha1.ClusterInternal).
+			GetZookeeperConnection(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		settleAfter, err := common.DiscoverySettleAfter(ctx, resourceClient, zkCluster)
+		Expect(err).NotTo(HaveOccurred())
+		return zkconn, settleAfter
+	}
+
+	It("should only list servers that are ready for the debounce period", func() {
+		zkconn, settleAfter := connection(
+			&discoveryv1.EndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "simple-abcde",
+					Namespace: "default",
+					Labels:    map[string]string{discoveryv1.LabelServiceName: "simple"},
+				},
+				AddressType: discoveryv1.AddressTypeIPv4,
+				Endpoints: []discoveryv1.Endpoint{
+					endpoint("simple-server-default-2", true),
+					endpoint("simple-server-default-1", true),
+					endpoint("simple-server-default-0", false),
+				},
+			},
+			readyPod("simple-server-default-1", 5*time.Second),
+			readyPod("simple-server-default-2", time.Minute),
+		)
+		Expect(zkconn.Hosts).To(Equal([]string{"simple-server-default-2.simple-server-default.default.svc.example.internal:2181"}))
+		Expect(settleAfter).To(BeNumerically("~", 25*time.Second, 2*time.Second))
+	})
+
+	It("should list every server while none is ready", func() {
+		zkconn, settleAfter := connection()
+		Expect(zkconn.Hosts).To(HaveLen(3))
+		Expect(settleAfter).To(BeZero())
+	})
+})
@@ -0,0 +1,126 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/security"
+)
+
+// FourLetterWordTimeout bounds a four letter word command, from connecting to reading the response
+const FourLetterWordTimeout = 3 * time.Second
+
+// tlsAddressPrefix marks the addresses of client ports that only accept TLS, see ClientAddress
+const tlsAddressPrefix = "tls://"
+
+// FourLetterWordFunc sends a four letter word command to the server at address and returns the response.
+type FourLetterWordFunc func(ctx context.Context, address, word string) (string, error)
+
+// ClientAddress returns the address of the client port of a server of the cluster in a client TLS phase, e.g. the
+// phase recorded in status that the servers were rolled out with. In the Tls phase the port only accepts TLS, which
+// the address is marked with for NewFourLetterWord.
+func ClientAddress(host string, phase zkv1alpha1.ClientTlsPhase) string {
+	address := fmt.Sprintf("%s:%d", host, security.ClientPortOf(phase))
+	if phase == zkv1alpha1.ClientTlsPhaseTls {
+		return tlsAddressPrefix + address
+	}
+	return address
+}
+
+// FourLetterWord sends a four letter word command over the plaintext client port of a server.
+// The command has to be allowed by `4lw.commands.whitelist`, see RequiredFourLetterWords.
+func FourLetterWord(ctx context.Context, address, word string) (string, error) {
+	return NewFourLetterWord(nil)(ctx, address, word)
+}
+
+// NewFourLetterWord returns a FourLetterWordFunc that sends the commands over TLS to the client ports that only
+// accept TLS, see ClientAddress, with the CA and client certificate of tlsConfig, see LoadClientTLSConfig.
+// Without tlsConfig these servers can not be asked.
+func NewFourLetterWord(tlsConfig *tls.Config) FourLetterWordFunc {
+	return func(ctx context.Context, address, word string) (string, error) {
+		address, secure := strings.CutPrefix(address, tlsAddressPrefix)
+		if secure && tlsConfig == nil {
+			return "", fmt.Errorf("%s only accepts TLS, the operator has no client TLS configured", address)
+		}
+		var connTLS *tls.Config
+		if secure {
+			connTLS = tlsConfig
+		}
+		ctx, cancel := context.WithTimeout(ctx, FourLetterWordTimeout)
+		defer cancel()
+		conn, err := DialClientPort(ctx, address, connTLS)
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = conn.Close() }()
+		if deadline, ok := ctx.Deadline(); ok {
+			if err := conn.SetDeadline(deadline); err != nil {
+				return "", err
+			}
+		}
+		if _, err := conn.Write([]byte(word)); err != nil {
+			return "", fmt.Errorf("send %s to %s: %w", word, address, err)
+		}
+		out, err := io.ReadAll(conn)
+		if err != nil {
+			return "", fmt.Errorf("read %s from %s: %w", word, address, err)
+		}
+		return string(out), nil
+	}
+}
+
+// DialClientPort connects to the client port of a server at address, over TLS with tlsConfig unless it is nil.
+// The certificate of the server has to be issued for its host.
+func DialClientPort(ctx context.Context, address string, tlsConfig *tls.Config) (net.Conn, error) {
+	var dialer interface {
+		DialContext(ctx context.Context, network, address string) (net.Conn, error)
+	} = &net.Dialer{}
+	if tlsConfig != nil {
+		dialer = &tls.Dialer{Config: tlsConfig}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", address, err)
+	}
+	return conn, nil
+}
+
+// ParseMntr parses the tab separated key value lines of the mntr command.
+func ParseMntr(out string) map[string]string {
+	metrics := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		metrics[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return metrics
+}
+
+// ParseConfMembership parses the `server.<id>` lines of the conf command into the role of every server,
+// `participant` or `observer`.
+func ParseConfMembership(out string) map[string]string {
+	roles := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || !strings.HasPrefix(key, "server.") {
+			continue
+		}
+		address, _, _ := strings.Cut(value, ";")
+		roles[key] = "participant"
+		if strings.HasSuffix(address, ":observer") {
+			roles[key] = "observer"
+		}
+	}
+	return roles
+}
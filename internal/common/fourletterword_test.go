@@ -0,0 +1,76 @@
+package common_test
+
+import (
+	"context"
+	"io"
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+var _ = Describe("Four letter words", func() {
+	It("should send the command and read the whole response", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { _ = listener.Close() }()
+		received := make(chan string, 1)
+		go func() {
+			defer GinkgoRecover()
+			conn, err := listener.Accept()
+			Expect(err).NotTo(HaveOccurred())
+			word := make([]byte, 4)
+			_, err = io.ReadFull(conn, word)
+			Expect(err).NotTo(HaveOccurred())
+			received <- string(word)
+			_, _ = conn.Write([]byte("zk_version\t3.9.2\nzk_data_dir_size\t1024\n"))
+			_ = conn.Close()
+		}()
+
+		out, err := common.FourLetterWord(context.Background(), listener.Addr().String(), "mntr")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(<-received).To(Equal("mntr"))
+		Expect(common.ParseMntr(out)).To(Equal(map[string]string{
+			"zk_version":       "3.9.2",
+			"zk_data_dir_size": "1024",
+		}))
+	})
+
+	It("should fail when the server is not reachable", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		address := listener.Addr().String()
+		Expect(listener.Close()).To(Succeed())
+
+		_, err = common.FourLetterWord(context.Background(), address, "mntr")
+		Expect(err).To(MatchError(ContainSubstring("connect to")))
+	})
+
+	It("should skip lines without a value", func() {
+		Expect(common.ParseMntr("This ZooKeeper instance is not currently serving requests\n")).To(BeEmpty())
+	})
+
+	It("should parse the membership of conf", func() {
+		out := "clientPort=2181\nmembership: \n" +
+			"server.1=zk-1:2888:3888:participant;0.0.0.0:2181\n" +
+			"server.4=zk-4:2888:3888:observer;0.0.0.0:2181\n" +
+			"version=0\n"
+		Expect(common.ParseConfMembership(out)).To(Equal(map[string]string{
+			"server.1": "participant",
+			"server.4": "observer",
+		}))
+	})
+
+	It("should address the TLS client port once the port only accepts TLS", func() {
+		Expect(common.ClientAddress("zk-1", zkv1alpha1.ClientTlsPhaseTransition)).To(Equal("zk-1:2181"))
+		Expect(common.ClientAddress("zk-1", zkv1alpha1.ClientTlsPhaseTls)).To(Equal("tls://zk-1:2282"))
+	})
+
+	It("should refuse TLS addresses without client TLS", func() {
+		_, err := common.NewFourLetterWord(nil)(context.Background(), "tls://zk-1:2282", "mntr")
+		Expect(err).To(MatchError(ContainSubstring("only accepts TLS")))
+	})
+})
@@ -0,0 +1,123 @@
+package common
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+)
+
+// ExternalStableEnabled checks if every server is exposed through its own Service.
+func ExternalStableEnabled(clusterConfig *zkv1alpha1.ClusterConfigSpec) bool {
+	return clusterConfig != nil && clusterConfig.ListenerClass == constants.ExternalStable
+}
+
+// ExternalStableServiceType returns the type of the per server Services, LoadBalancer by default.
+func ExternalStableServiceType(clusterConfig *zkv1alpha1.ClusterConfigSpec) corev1.ServiceType {
+	if clusterConfig == nil || clusterConfig.ExternalStable == nil || clusterConfig.ExternalStable.ServiceType == "" {
+		return corev1.ServiceTypeLoadBalancer
+	}
+	return clusterConfig.ExternalStable.ServiceType
+}
+
+// PodServiceName returns the name of the Service that exposes a single server, which is the pod name.
+func PodServiceName(podName string) string {
+	return podName
+}
+
+// NodePortsEnabled checks if clients reach the servers through NodePort services, so discovery publishes node addresses.
+func NodePortsEnabled(clusterConfig *zkv1alpha1.ClusterConfigSpec) bool {
+	if clusterConfig == nil {
+		return false
+	}
+	switch clusterConfig.ListenerClass {
+	case constants.ExternalUnstable:
+		return true
+	case constants.ExternalStable:
+		return ExternalStableServiceType(clusterConfig) == corev1.ServiceTypeNodePort
+	default:
+		return false
+	}
+}
+
+// NodeAddress returns the address of a node selected by the node address spec, InternalIP by default.
+// If the node has no such address, its InternalIP is used, and an empty address means the node has none.
+func NodeAddress(node *corev1.Node, nodeAddress *zkv1alpha1.NodeAddressSpec) string {
+	addressType := zkv1alpha1.NodeAddressInternalIP
+	if nodeAddress != nil && nodeAddress.Type != "" {
+		addressType = nodeAddress.Type
+	}
+
+	if addressType == zkv1alpha1.NodeAddressAnnotation {
+		if address := node.Annotations[nodeAddress.Annotation]; nodeAddress.Annotation != "" && address != "" {
+			return address
+		}
+	} else if address := nodeAddressOfType(node, corev1.NodeAddressType(addressType)); address != "" {
+		return address
+	}
+	return nodeAddressOfType(node, corev1.NodeInternalIP)
+}
+
+func nodeAddressOfType(node *corev1.Node, addressType corev1.NodeAddressType) string {
+	for _, address := range node.Status.Addresses {
+		if address.Type == addressType && address.Address != "" {
+			return address.Address
+		}
+	}
+	return ""
+}
+
+// NodeAddressChanged filters node events down to changes of the addresses or annotations of a node,
+// ignoring the frequent status updates of the kubelet.
+var NodeAddressChanged = predicate.Funcs{
+	CreateFunc: func(event.CreateEvent) bool { return false },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldNode, ok := e.ObjectOld.(*corev1.Node)
+		if !ok {
+			return false
+		}
+		newNode, ok := e.ObjectNew.(*corev1.Node)
+		if !ok {
+			return false
+		}
+		return !slices.Equal(oldNode.Status.Addresses, newNode.Status.Addresses) ||
+			!maps.Equal(oldNode.Annotations, newNode.Annotations)
+	},
+	GenericFunc: func(event.GenericEvent) bool { return false },
+}
+
+// clusterNameLabelValue is the `app.kubernetes.io/name` label of the resources of a ZookeeperCluster
+const clusterNameLabelValue = "zookeepercluster"
+
+// ClusterInstanceOf returns the name of the ZookeeperCluster a resource belongs to, based on its labels.
+func ClusterInstanceOf(obj client.Object) (string, bool) {
+	labels := obj.GetLabels()
+	instance, ok := labels[constants.LabelKubernetesInstance]
+	if !ok || labels[constants.LabelKubernetesName] != clusterNameLabelValue {
+		return "", false
+	}
+	return instance, true
+}
+
+// PodPlacementChanged filters pod events down to the ones that move a server to another node,
+// so discovery is updated without reacting to every status change.
+var PodPlacementChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldPod, ok := e.ObjectOld.(*corev1.Pod)
+		if !ok {
+			return false
+		}
+		newPod, ok := e.ObjectNew.(*corev1.Pod)
+		if !ok {
+			return false
+		}
+		return oldPod.Spec.NodeName != newPod.Spec.NodeName || oldPod.Status.HostIP != newPod.Status.HostIP
+	},
+	GenericFunc: func(event.GenericEvent) bool { return false },
+}
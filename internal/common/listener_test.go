@@ -0,0 +1,197 @@
+package common_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/zncdatadev/operator-go/pkg/client"
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+	"github.com/zncdatadev/zookeeper-operator/internal/security"
+)
+
+func node(name string, annotations map[string]string, addresses ...corev1.NodeAddress) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations},
+		Status:     corev1.NodeStatus{Addresses: addresses},
+	}
+}
+
+func podService(name string, serviceType corev1.ServiceType, nodePort int32, ingress ...corev1.LoadBalancerIngress) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Type:  serviceType,
+			Ports: []corev1.ServicePort{{Name: zkv1alpha1.ClientPortName, Port: zkv1alpha1.ClientPort, NodePort: nodePort}},
+		},
+		Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: ingress}},
+	}
+}
+
+var _ = Describe("External stable listener", func() {
+	var zkCluster *zkv1alpha1.ZookeeperCluster
+
+	BeforeEach(func() {
+		zkCluster = &zkv1alpha1.ZookeeperCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default"},
+			Spec: zkv1alpha1.ZookeeperClusterSpec{
+				ClusterConfig: &zkv1alpha1.ClusterConfigSpec{ListenerClass: constants.ExternalStable},
+				Servers: &zkv1alpha1.ServerSpec{RoleGroups: map[string]zkv1alpha1.RoleGroupSpec{
+					"default": {Replicas: 3},
+				}},
+			},
+		}
+		zkCluster.SetGroupVersionKind(zkv1alpha1.GroupVersion.WithKind("ZookeeperCluster"))
+	})
+
+	connection := func(objs ...ctrlclient.Object) (*common.ZookeeperConnection, error) {
+		ctx := context.Background()
+		builder := fake.NewClientBuilder().WithScheme(scheme.Scheme)
+		for _, obj := range objs {
+			builder = builder.WithObjects(obj)
+		}
+		builder = builder.WithObjects(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "simple-server-default-1", Namespace: "default"},
+			Spec:       corev1.PodSpec{NodeName: "node-b"},
+		})
+		ctrlClient := builder.Build()
+		zkSecurity, err := security.NewZookeeperSecurity(ctx, ctrlClient, zkCluster.Name, zkCluster.Spec.ClusterConfig)
+		Expect(err).NotTo(HaveOccurred())
+		discovery := common.NewDiscoverer(
+			client.NewClient(ctrlClient, zkCluster),
+			zkCluster,
+			zkSecurity,
+			&common.ZNodeInfo{Name: zkCluster.Name, Namespace: zkCluster.Namespace, ZNodePath: "/"},
+			zkv1alpha1.ExternalStable,
+		)
+		return discovery.GetZookeeperConnection(ctx)
+	}
+
+	It("should publish the provisioned load balancer addresses per server", func() {
+		zkconn, err := connection(
+			podService("simple-server-default-0", corev1.ServiceTypeLoadBalancer, 0, corev1.LoadBalancerIngress{IP: "10.0.0.1"}),
+			podService("simple-server-default-1", corev1.ServiceTypeLoadBalancer, 0, corev1.LoadBalancerIngress{Hostname: "zk-1.example.com"}),
+			podService("simple-server-default-2", corev1.ServiceTypeLoadBalancer, 0),
+		)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(zkconn.Hosts).To(Equal([]string{"10.0.0.1:2181", "zk-1.example.com:2181"}))
+	})
+
+	It("should publish the node address of the server for NodePort services", func() {
+		zkCluster.Spec.ClusterConfig.NodeAddress = &zkv1alpha1.NodeAddressSpec{Type: zkv1alpha1.NodeAddressExternalIP}
+		zkconn, err := connection(
+			podService("simple-server-default-1", corev1.ServiceTypeNodePort, 30181),
+			node("node-b", nil,
+				corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.2"},
+				corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: "203.0.113.2"},
+			),
+		)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(zkconn.Hosts).To(Equal([]string{"203.0.113.2:30181"}))
+	})
+
+	It("should fail while no address is known", func() {
+		_, err := connection()
+		Expect(err).To(MatchError(ContainSubstring("no address")))
+	})
+
+	It("should only react to pods moving to another node", func() {
+		pod := &corev1.Pod{Spec: corev1.PodSpec{NodeName: "node-a"}}
+		moved := pod.DeepCopy()
+		moved.Spec.NodeName = "node-b"
+		ready := pod.DeepCopy()
+		ready.Status.Phase = corev1.PodRunning
+
+		Expect(common.PodPlacementChanged.Update(event.UpdateEvent{ObjectOld: pod, ObjectNew: moved})).To(BeTrue())
+		Expect(common.PodPlacementChanged.Update(event.UpdateEvent{ObjectOld: pod, ObjectNew: ready})).To(BeFalse())
+	})
+})
+
+var _ = Describe("Node address", func() {
+	workerNode := node("worker", map[string]string{"example.com/public-ip": "198.51.100.7"},
+		corev1.NodeAddress{Type: corev1.NodeHostName, Address: "worker"},
+		corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.7"},
+		corev1.NodeAddress{Type: corev1.NodeInternalDNS, Address: "worker.internal.example.com"},
+	)
+
+	DescribeTable("should select the address of a node",
+		func(nodeAddress *zkv1alpha1.NodeAddressSpec, expected string) {
+			Expect(common.NodeAddress(workerNode, nodeAddress)).To(Equal(expected))
+		},
+		Entry("InternalIP by default", nil, "10.0.0.7"),
+		Entry("InternalDNS", &zkv1alpha1.NodeAddressSpec{Type: zkv1alpha1.NodeAddressInternalDNS}, "worker.internal.example.com"),
+		Entry("an annotation", &zkv1alpha1.NodeAddressSpec{
+			Type:       zkv1alpha1.NodeAddressAnnotation,
+			Annotation: "example.com/public-ip",
+		}, "198.51.100.7"),
+		Entry("the InternalIP without an ExternalIP", &zkv1alpha1.NodeAddressSpec{Type: zkv1alpha1.NodeAddressExternalIP}, "10.0.0.7"),
+		Entry("the InternalIP without the annotation", &zkv1alpha1.NodeAddressSpec{
+			Type:       zkv1alpha1.NodeAddressAnnotation,
+			Annotation: "example.com/missing",
+		}, "10.0.0.7"),
+	)
+
+	It("should publish the node addresses of the external-unstable service", func() {
+		ctx := context.Background()
+		zkCluster := &zkv1alpha1.ZookeeperCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default"},
+			Spec: zkv1alpha1.ZookeeperClusterSpec{
+				ClusterConfig: &zkv1alpha1.ClusterConfigSpec{ListenerClass: constants.ExternalUnstable},
+				Servers: &zkv1alpha1.ServerSpec{RoleGroups: map[string]zkv1alpha1.RoleGroupSpec{
+					"default": {Replicas: 2},
+				}},
+			},
+		}
+		zkCluster.SetGroupVersionKind(zkv1alpha1.GroupVersion.WithKind("ZookeeperCluster"))
+		nodeName := "worker"
+		ctrlClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+			podService("simple", corev1.ServiceTypeNodePort, 30181),
+			&discoveryv1.EndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "simple-abcde",
+					Namespace: "default",
+					Labels:    map[string]string{discoveryv1.LabelServiceName: "simple"},
+				},
+				AddressType: discoveryv1.AddressTypeIPv4,
+				Endpoints: []discoveryv1.Endpoint{
+					{Addresses: []string{"10.244.0.1"}, NodeName: &nodeName},
+					{Addresses: []string{"10.244.0.2"}, NodeName: &nodeName},
+				},
+			},
+			workerNode.DeepCopy(),
+		).Build()
+		zkSecurity, err := security.NewZookeeperSecurity(ctx, ctrlClient, zkCluster.Name, zkCluster.Spec.ClusterConfig)
+		Expect(err).NotTo(HaveOccurred())
+		discovery := common.NewDiscoverer(
+			client.NewClient(ctrlClient, zkCluster),
+			zkCluster,
+			zkSecurity,
+			&common.ZNodeInfo{Name: zkCluster.Name, Namespace: zkCluster.Namespace, ZNodePath: "/"},
+			zkv1alpha1.ExternalUnstable,
+		)
+
+		zkconn, err := discovery.GetZookeeperConnection(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(zkconn.Hosts).To(Equal([]string{"10.0.0.7:30181"}))
+	})
+
+	It("should only react to changed addresses", func() {
+		changed := workerNode.DeepCopy()
+		changed.Status.Addresses[1].Address = "10.0.0.8"
+		heartbeat := workerNode.DeepCopy()
+		heartbeat.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+
+		Expect(common.NodeAddressChanged.Update(event.UpdateEvent{ObjectOld: workerNode, ObjectNew: changed})).To(BeTrue())
+		Expect(common.NodeAddressChanged.Update(event.UpdateEvent{ObjectOld: workerNode, ObjectNew: heartbeat})).To(BeFalse())
+	})
+})
@@ -0,0 +1,102 @@
+package common
+
+import (
+	"fmt"
+	"slices"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+)
+
+// Migration is the step of a migration the servers are configured for, see MigrationSpec.
+type Migration struct {
+	Phase zkv1alpha1.MigrationPhase
+	// ExternalServers are the external servers that are not retired.
+	ExternalServers []zkv1alpha1.ExternalServerSpec
+}
+
+// Observing tells whether the servers join the ensemble as observers.
+func (m *Migration) Observing() bool {
+	return m != nil && m.Phase == zkv1alpha1.MigrationObserve
+}
+
+// ZooServers returns the `server.<id>` entries of `zoo.cfg` of the external servers.
+func (m *Migration) ZooServers() map[string]string {
+	if m == nil {
+		return nil
+	}
+	servers := make(map[string]string, len(m.ExternalServers))
+	for _, server := range m.ExternalServers {
+		quorumPort, electionPort, clientPort := server.QuorumPort, server.LeaderElectionPort, server.ClientPort
+		if quorumPort == 0 {
+			quorumPort = zkv1alpha1.LeaderPort
+		}
+		if electionPort == 0 {
+			electionPort = zkv1alpha1.ElectionPort
+		}
+		if clientPort == 0 {
+			clientPort = zkv1alpha1.ClientPort
+		}
+		servers[ZooServerKey(server.ID)] = ZooServer(server.Host, quorumPort, electionPort, false, clientPort)
+	}
+	return servers
+}
+
+// ZooServerKey returns the `zoo.cfg` key of the server with the id.
+func ZooServerKey(id int32) string {
+	return fmt.Sprintf("server.%d", id)
+}
+
+// ZooServer returns the `server.<id>` entry of `zoo.cfg` of a server, without client port if it is 0.
+func ZooServer(host string, quorumPort, electionPort int32, observer bool, clientPort int32) string {
+	entry := fmt.Sprintf("%s:%d:%d", host, quorumPort, electionPort)
+	if observer {
+		entry += ":observer"
+	}
+	if clientPort != 0 {
+		entry += fmt.Sprintf(";%d", clientPort)
+	}
+	return entry
+}
+
+// MyidOffset returns the id of the first server of a role group, see ConfigSpec.MyidOffset. The offset of the role
+// group takes precedence over the one of the role.
+func MyidOffset(servers *zkv1alpha1.ServerSpec, roleGroup string) int32 {
+	if servers == nil {
+		return DefaultMyidOffset
+	}
+	if config := servers.RoleGroups[roleGroup].Config; config != nil && config.MyidOffset > 0 {
+		return int32(config.MyidOffset)
+	}
+	if servers.Config != nil && servers.Config.MyidOffset > 0 {
+		return int32(servers.Config.MyidOffset)
+	}
+	return DefaultMyidOffset
+}
+
+// ValidateMigration checks that the servers of the cluster can join the external ensemble.
+func ValidateMigration(clusterConfig *zkv1alpha1.ClusterConfigSpec, servers *zkv1alpha1.ServerSpec) error {
+	if clusterConfig == nil || clusterConfig.Migration == nil {
+		return nil
+	}
+	// the migration plans the membership of one role group
+	if servers == nil || len(servers.RoleGroups) != 1 {
+		return fmt.Errorf("migration requires exactly one server role group")
+	}
+	var replicas, minId int32
+	for name, roleGroup := range servers.RoleGroups {
+		replicas = roleGroup.Replicas
+		minId = MyidOffset(servers, name)
+	}
+	var ids []int32
+	for _, server := range clusterConfig.Migration.ExternalServers {
+		if server.ID >= minId && server.ID < minId+replicas {
+			return fmt.Errorf("external server %d clashes with the ids %d to %d of the servers, set the myidOffset of the role group above the external ids",
+				server.ID, minId, minId+replicas-1)
+		}
+		if slices.Contains(ids, server.ID) {
+			return fmt.Errorf("external server %d is listed more than once", server.ID)
+		}
+		ids = append(ids, server.ID)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package common_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+var _ = Describe("Migration", func() {
+	servers := func(myidOffset int16, replicas ...int32) *zkv1alpha1.ServerSpec {
+		spec := &zkv1alpha1.ServerSpec{
+			Config:     &zkv1alpha1.ConfigSpec{MyidOffset: myidOffset},
+			RoleGroups: map[string]zkv1alpha1.RoleGroupSpec{},
+		}
+		for i, r := range replicas {
+			spec.RoleGroups[string(rune('a'+i))] = zkv1alpha1.RoleGroupSpec{Replicas: r}
+		}
+		return spec
+	}
+	clusterConfig := func(ids ...int32) *zkv1alpha1.ClusterConfigSpec {
+		migration := &zkv1alpha1.MigrationSpec{}
+		for _, id := range ids {
+			migration.ExternalServers = append(migration.ExternalServers, zkv1alpha1.ExternalServerSpec{ID: id, Host: "zk"})
+		}
+		return &zkv1alpha1.ClusterConfigSpec{Migration: migration}
+	}
+
+	It("accepts external servers below the ids of the servers", func() {
+		Expect(common.ValidateMigration(clusterConfig(1, 2, 3), servers(4, 3))).To(Succeed())
+		Expect(common.ValidateMigration(clusterConfig(4, 5), servers(1, 3))).To(Succeed())
+		Expect(common.ValidateMigration(&zkv1alpha1.ClusterConfigSpec{}, servers(0, 3, 3))).To(Succeed())
+	})
+
+	It("refuses ids the servers use", func() {
+		Expect(common.ValidateMigration(clusterConfig(1, 2, 3), servers(0, 3))).To(MatchError(ContainSubstring("external server 1 clashes with the ids 1 to 3")))
+		Expect(common.ValidateMigration(clusterConfig(4), servers(2, 3))).To(HaveOccurred())
+		Expect(common.ValidateMigration(clusterConfig(1, 1), servers(4, 3))).To(MatchError(ContainSubstring("listed more than once")))
+	})
+
+	It("requires a single role group", func() {
+		Expect(common.ValidateMigration(clusterConfig(1), servers(4, 3, 3))).To(MatchError(ContainSubstring("exactly one server role group")))
+	})
+
+	It("numbers the servers from the myidOffset of the role group before the one of the role", func() {
+		spec := servers(4, 3)
+		Expect(common.MyidOffset(spec, "a")).To(Equal(int32(4)))
+		spec.RoleGroups["a"] = zkv1alpha1.RoleGroupSpec{Replicas: 3, Config: &zkv1alpha1.ConfigSpec{MyidOffset: 7}}
+		Expect(common.MyidOffset(spec, "a")).To(Equal(int32(7)))
+		Expect(common.MyidOffset(servers(0, 3), "a")).To(Equal(int32(common.DefaultMyidOffset)))
+	})
+
+	It("lists the external servers with the default ports", func() {
+		migration := &common.Migration{ExternalServers: []zkv1alpha1.ExternalServerSpec{{ID: 1, Host: "zk-1"}, {ID: 2, Host: "zk-2", ClientPort: 2182}}}
+		Expect(migration.ZooServers()).To(Equal(map[string]string{
+			"server.1": "zk-1:2888:3888;2181",
+			"server.2": "zk-2:2888:3888;2182",
+		}))
+	})
+
+	It("leaves out the client port of servers that only serve TLS", func() {
+		Expect(common.ZooServer("zk-4", 2888, 3888, true, 0)).To(Equal("zk-4:2888:3888:observer"))
+	})
+})
@@ -0,0 +1,57 @@
+package common
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/zncdatadev/operator-go/pkg/client"
+	"github.com/zncdatadev/operator-go/pkg/reconciler"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var obsoleteLogger = ctrl.Log.WithName("obsolete-resources")
+
+var _ reconciler.Reconciler = &ObsoleteResourceReconciler{}
+
+// ObsoleteResourceReconciler deletes resources the spec no longer asks for, e.g. after a feature is turned off.
+// Only resources controlled by the owner of the client are deleted, missing ones and ones of the same name created by
+// someone else are skipped.
+type ObsoleteResourceReconciler struct {
+	reconciler.BaseReconciler[reconciler.AnySpec]
+	// objects are named in the namespace of the owner, their type selects the kind
+	objects []ctrlclient.Object
+}
+
+func NewObsoleteResourceReconciler(client *client.Client, objects ...ctrlclient.Object) *ObsoleteResourceReconciler {
+	return &ObsoleteResourceReconciler{
+		BaseReconciler: reconciler.BaseReconciler[reconciler.AnySpec]{Client: client},
+		objects:        objects,
+	}
+}
+
+func (r *ObsoleteResourceReconciler) Reconcile(ctx context.Context) (ctrl.Result, error) {
+	for _, obj := range r.objects {
+		obj.SetNamespace(r.GetNamespace())
+		if err := r.Client.Client.Get(ctx, ctrlclient.ObjectKeyFromObject(obj), obj); err != nil {
+			if ctrlclient.IgnoreNotFound(err) != nil {
+				return ctrl.Result{}, err
+			}
+			continue
+		}
+		if !metav1.IsControlledBy(obj, r.Client.GetOwnerReference()) {
+			continue
+		}
+		if err := r.Client.Client.Delete(ctx, obj); ctrlclient.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, err
+		}
+		obsoleteLogger.Info("deleted obsolete resource", "namespace", obj.GetNamespace(), "name", obj.GetName(),
+			"type", fmt.Sprintf("%T", obj))
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *ObsoleteResourceReconciler) Ready(context.Context) (ctrl.Result, error) {
+	return ctrl.Result{}, nil
+}
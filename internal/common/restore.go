@@ -0,0 +1,97 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	s3v1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/s3/v1alpha1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+)
+
+// RestoreContainerName is the init container that restores the data of a server, see RestoreSpec.
+const RestoreContainerName = "restore"
+
+// backupKeyZxid matches the zxid of the snapshot in the key of a backup archive, `<prefix><time>-<zxid>.tar.gz`
+var backupKeyZxid = regexp.MustCompile(`-([0-9a-f]+)\.tar\.gz$`)
+
+// RestoreSource is the backup archive the servers are restored from.
+type RestoreSource struct {
+	*S3Connection
+	Key string
+	// Zxid of the snapshot in the archive, as hex. Every server checks that it restores this snapshot.
+	Zxid string
+}
+
+// RestoreRecord is written by the restore container to the termination log of every server.
+type RestoreRecord struct {
+	// Restored is false if the data volume held data and was left untouched.
+	Restored bool   `json:"restored"`
+	Key      string `json:"key,omitempty"`
+	Zxid     string `json:"zxid,omitempty"`
+}
+
+// ParseRestoreRecord reads the record of the restore container of a server.
+func ParseRestoreRecord(message string) (*RestoreRecord, error) {
+	record := &RestoreRecord{}
+	if err := json.Unmarshal([]byte(message), record); err != nil {
+		return nil, fmt.Errorf("invalid restore record %q: %w", message, err)
+	}
+	return record, nil
+}
+
+// ResolveRestoreSource returns the archive the cluster is restored from, or nil if it is not restored or every server
+// restored it already. The archive recorded in status is kept over the last backup of a ZookeeperBackup, which
+// changes with every backup; the caller records the resolved archive, see zkv1alpha1.RestoreStatus.
+func ResolveRestoreSource(ctx context.Context, client ctrlclient.Client, zkCluster *zkv1alpha1.ZookeeperCluster) (*RestoreSource, error) {
+	restore := zkCluster.Spec.RestoreFrom
+	if restore == nil || apimeta.IsStatusConditionTrue(zkCluster.Status.Conditions, zkv1alpha1.ConditionRestored) {
+		return nil, nil
+	}
+
+	var bucket *s3v1alpha1.S3BucketSpec
+	key, zxid := restore.Key, ""
+	if pinned := zkCluster.Status.Restore; key == "" && pinned != nil {
+		key, zxid = pinned.Key, pinned.Zxid
+	}
+	switch {
+	case restore.Backup != "":
+		backup := &zkv1alpha1.ZookeeperBackup{}
+		if err := client.Get(ctx, ctrlclient.ObjectKey{Namespace: zkCluster.Namespace, Name: restore.Backup}, backup); err != nil {
+			return nil, fmt.Errorf("get ZookeeperBackup %s/%s to restore from: %w", zkCluster.Namespace, restore.Backup, err)
+		}
+		bucket = backup.Spec.Bucket
+		if key == "" {
+			if backup.Status.LastBackup == nil {
+				return nil, fmt.Errorf("ZookeeperBackup %s/%s has no successful backup to restore from", zkCluster.Namespace, restore.Backup)
+			}
+			key, zxid = backup.Status.LastBackup.Key, backup.Status.LastBackup.Zxid
+		}
+		for _, record := range backup.Status.Backups {
+			if record.Key == key {
+				zxid = record.Zxid
+			}
+		}
+	case restore.Bucket != nil && restore.Key != "":
+		bucket = restore.Bucket
+	default:
+		return nil, fmt.Errorf("restoreFrom needs either a backup or a bucket and a key")
+	}
+
+	if zxid == "" {
+		match := backupKeyZxid.FindStringSubmatch(key)
+		if match == nil {
+			return nil, fmt.Errorf("key %q to restore from is not a backup archive `<prefix><time>-<zxid>.tar.gz`", key)
+		}
+		zxid = match[1]
+	}
+	connection, err := ResolveS3Connection(ctx, client, zkCluster.Namespace, bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &RestoreSource{S3Connection: connection, Key: key, Zxid: zxid}, nil
+}
@@ -0,0 +1,115 @@
+package common_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	commonsv1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/commons/v1alpha1"
+	s3v1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/s3/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+var _ = Describe("Restore source", func() {
+	ctx := context.Background()
+	bucket := &s3v1alpha1.S3BucketSpec{
+		BucketName: "backups",
+		Connection: &s3v1alpha1.S3BucketConnectionSpec{Inline: &s3v1alpha1.S3ConnectionSpec{
+			Host:        "minio",
+			Credentials: &commonsv1alpha1.Credentials{SecretClass: "s3-credentials"},
+		}},
+	}
+
+	newClient := func(objs ...ctrlclient.Object) ctrlclient.Client {
+		s := runtime.NewScheme()
+		Expect(scheme.AddToScheme(s)).To(Succeed())
+		Expect(zkv1alpha1.AddToScheme(s)).To(Succeed())
+		return fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).Build()
+	}
+	newCluster := func(restore *zkv1alpha1.RestoreSpec) *zkv1alpha1.ZookeeperCluster {
+		return &zkv1alpha1.ZookeeperCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "simple", Namespace: "default"},
+			Spec:       zkv1alpha1.ZookeeperClusterSpec{RestoreFrom: restore},
+		}
+	}
+	backup := &zkv1alpha1.ZookeeperBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "default"},
+		Spec:       zkv1alpha1.ZookeeperBackupSpec{ClusterName: "simple", Bucket: bucket},
+		Status: zkv1alpha1.ZookeeperBackupStatus{
+			LastBackup: &zkv1alpha1.BackupRecord{Key: "default/simple/20261002T030000Z-20000000c.tar.gz", Zxid: "20000000c"},
+			Backups: []zkv1alpha1.BackupRecord{
+				{Key: "default/simple/20261002T030000Z-20000000c.tar.gz", Zxid: "20000000c"},
+				{Key: "default/simple/20261001T030000Z-100000009.tar.gz", Zxid: "100000009"},
+			},
+		},
+	}
+
+	It("is nil without restoreFrom", func() {
+		source, err := common.ResolveRestoreSource(ctx, newClient(), newCluster(nil))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(source).To(BeNil())
+	})
+
+	It("restores the last backup of a ZookeeperBackup", func() {
+		source, err := common.ResolveRestoreSource(ctx, newClient(backup), newCluster(&zkv1alpha1.RestoreSpec{Backup: "nightly"}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(source.Bucket).To(Equal("backups"))
+		Expect(source.Key).To(Equal("default/simple/20261002T030000Z-20000000c.tar.gz"))
+		Expect(source.Zxid).To(Equal("20000000c"))
+	})
+
+	It("restores an older backup of a ZookeeperBackup", func() {
+		source, err := common.ResolveRestoreSource(ctx, newClient(backup), newCluster(&zkv1alpha1.RestoreSpec{
+			Backup: "nightly",
+			Key:    "default/simple/20261001T030000Z-100000009.tar.gz",
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(source.Zxid).To(Equal("100000009"))
+	})
+
+	It("keeps restoring the archive recorded in status after later backups", func() {
+		zkCluster := newCluster(&zkv1alpha1.RestoreSpec{Backup: "nightly"})
+		zkCluster.Status.Restore = &zkv1alpha1.RestoreStatus{Key: "default/simple/20261001T030000Z-100000009.tar.gz", Zxid: "100000009"}
+		source, err := common.ResolveRestoreSource(ctx, newClient(backup), zkCluster)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(source.Key).To(Equal("default/simple/20261001T030000Z-100000009.tar.gz"))
+		Expect(source.Zxid).To(Equal("100000009"))
+	})
+
+	It("is nil once every server restored the archive, without the ZookeeperBackup", func() {
+		zkCluster := newCluster(&zkv1alpha1.RestoreSpec{Backup: "nightly"})
+		zkCluster.Status.Restore = &zkv1alpha1.RestoreStatus{Key: "default/simple/20261002T030000Z-20000000c.tar.gz", Zxid: "20000000c"}
+		zkCluster.Status.Conditions = []metav1.Condition{{Type: zkv1alpha1.ConditionRestored, Status: metav1.ConditionTrue, Reason: "Restored"}}
+		source, err := common.ResolveRestoreSource(ctx, newClient(), zkCluster)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(source).To(BeNil())
+	})
+
+	It("fails for a ZookeeperBackup without a backup", func() {
+		empty := backup.DeepCopy()
+		empty.Status = zkv1alpha1.ZookeeperBackupStatus{}
+		_, err := common.ResolveRestoreSource(ctx, newClient(empty), newCluster(&zkv1alpha1.RestoreSpec{Backup: "nightly"}))
+		Expect(err).To(MatchError(ContainSubstring("no successful backup")))
+	})
+
+	It("reads the zxid from the key of an archive in a bucket", func() {
+		source, err := common.ResolveRestoreSource(ctx, newClient(), newCluster(&zkv1alpha1.RestoreSpec{
+			Bucket: bucket,
+			Key:    "other/20260901T030000Z-3000000ff.tar.gz",
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(source.Zxid).To(Equal("3000000ff"))
+	})
+
+	It("rejects keys that are no backup archives", func() {
+		_, err := common.ResolveRestoreSource(ctx, newClient(), newCluster(&zkv1alpha1.RestoreSpec{Bucket: bucket, Key: "snapshot.tar"}))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -54,6 +54,16 @@ const (
 	TICK_TIME  = "tickTime"
 	DATA_DIR   = "dataDir"
 
+	DATA_LOG_DIR = "dataLogDir"
+
+	AUTOPURGE_SNAP_RETAIN_COUNT = "autopurge.snapRetainCount"
+	AUTOPURGE_PURGE_INTERVAL    = "autopurge.purgeInterval"
+	SNAP_COUNT                  = "snapCount"
+	SNAPSHOT_COMPRESSION        = "snapshot.compression.method"
+	PRE_ALLOC_SIZE              = "preAllocSize"
+	// DataLogDir is the mount path of the transaction log volume, see ConfigSpec.DataLogStorage
+	DataLogDir = "/kubedoop/txnlog/"
+
 	MyIdOffset     = "MYID_OFFSET"
 	ServerJvmFlags = "SERVER_JVMFLAGS"
 	ZKServerHeap   = "ZK_SERVER_HEAP"
@@ -63,6 +73,9 @@ const (
 	DefaultSyncLimit   = 2
 	DefaultTickTime    = 3000
 	DefaultMyidOffset  = 1
+
+	DefaultSnapRetainCount = 3
+	DefaultPurgeInterval   = 1
 )
 
 func DefaultServerConfig(clusterName string) ZookeeperConfig {
@@ -173,14 +186,16 @@ func (n *ZookeeperConfig) MergeDefaultConfig(
 		configOverrides = map[string]map[string]string{}
 	}
 	// zoo.cfg
+	zooCfg := n.defaultZooCfg()
+	maps.Copy(zooCfg, timingZooCfg(mergedCfg))
+	if mergedCfg.DataLogStorage != nil {
+		zooCfg[DATA_LOG_DIR] = DataLogDir
+	}
+	maps.Copy(zooCfg, snapshotZooCfg(mergedCfg))
 	if zooCfgExists, ok := configOverrides[zkv1alpha1.ZooCfgFileName]; ok {
-		dist := n.defaultZooCfg()
-		src := zooCfgExists
-		maps.Copy(dist, src)
-		configOverrides[zkv1alpha1.ZooCfgFileName] = dist
-	} else {
-		configOverrides[zkv1alpha1.ZooCfgFileName] = n.defaultZooCfg()
+		maps.Copy(zooCfg, zooCfgExists)
 	}
+	configOverrides[zkv1alpha1.ZooCfgFileName] = zooCfg
 	// security.properties
 	if securityPropsExists, ok := configOverrides[zkv1alpha1.SecurityFileName]; ok {
 		dist := n.securityProps
@@ -196,6 +211,52 @@ func (n *ZookeeperConfig) MergeDefaultConfig(
 	return nil
 }
 
+// timingZooCfg returns the configured tick time and limits, which replace the defaults.
+func timingZooCfg(cfg *zkv1alpha1.ConfigSpec) map[string]string {
+	zooCfg := map[string]string{}
+	if cfg.InitLimit != 0 {
+		zooCfg[INIT_LIMIT] = strconv.Itoa(int(cfg.InitLimit))
+	}
+	if cfg.SyncLimit != 0 {
+		zooCfg[SYNC_LIMIT] = strconv.Itoa(int(cfg.SyncLimit))
+	}
+	if cfg.TickTime != 0 {
+		zooCfg[TICK_TIME] = strconv.Itoa(int(cfg.TickTime))
+	}
+	return zooCfg
+}
+
+// snapshotZooCfg returns the zoo.cfg settings of the snapshots and transaction logs. Autopurge is enabled by default,
+// the other settings are left to ZooKeeper unless configured.
+// Settings ZooKeeper does not know itself, like the compression, become `zookeeper.` system properties.
+func snapshotZooCfg(cfg *zkv1alpha1.ConfigSpec) map[string]string {
+	snapRetainCount := DefaultSnapRetainCount
+	purgeInterval := DefaultPurgeInterval
+	if autopurge := cfg.Autopurge; autopurge != nil {
+		if autopurge.SnapRetainCount != 0 {
+			snapRetainCount = int(autopurge.SnapRetainCount)
+		}
+		if autopurge.PurgeInterval != nil {
+			purgeInterval = int(*autopurge.PurgeInterval)
+		}
+	}
+	zooCfg := map[string]string{
+		AUTOPURGE_SNAP_RETAIN_COUNT: strconv.Itoa(snapRetainCount),
+		AUTOPURGE_PURGE_INTERVAL:    strconv.Itoa(purgeInterval),
+	}
+	if cfg.SnapCount != nil {
+		zooCfg[SNAP_COUNT] = strconv.Itoa(int(*cfg.SnapCount))
+	}
+	if cfg.SnapshotCompression != "" {
+		zooCfg[SNAPSHOT_COMPRESSION] = string(cfg.SnapshotCompression)
+	}
+	if cfg.PreAllocSize != nil {
+		// in kilobytes
+		zooCfg[PRE_ALLOC_SIZE] = strconv.FormatInt(cfg.PreAllocSize.Value()/1024, 10)
+	}
+	return zooCfg
+}
+
 // HeapLimit returns the heap limit for the JVM based on the memory limit
 func HeapLimit(resource *commonsv1alpha1.ResourcesSpec) *string {
 	if resource != nil && resource.Memory != nil {
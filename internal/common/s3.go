@@ -0,0 +1,136 @@
+package common
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"path"
+	"strconv"
+
+	s3v1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/s3/v1alpha1"
+	"github.com/zncdatadev/operator-go/pkg/builder"
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	s3CredentialsVolumeName = "s3-credentials"
+	s3CredentialsDir        = constants.KubedoopSecretDir + s3CredentialsVolumeName
+	s3CaVolumeName          = "s3-tls"
+	s3CaDir                 = constants.KubedoopTlsDir + "s3"
+)
+
+// S3Script defines the shell helpers of the backup and restore scripts: `curl_opts` to sign requests,
+// `object_url` and `zxid_of`. It expects the environment of S3Connection.EnvVars.
+//
+//go:embed s3.sh
+var S3Script string
+
+// S3Connection is the resolved connection of a bucket.
+type S3Connection struct {
+	Bucket string
+	*s3v1alpha1.S3ConnectionSpec
+}
+
+// Endpoint returns the URL of the S3 service, https if TLS is configured.
+func (c *S3Connection) Endpoint() string {
+	scheme := "http"
+	if c.Tls != nil {
+		scheme = "https"
+	}
+	if c.Port == 0 {
+		return fmt.Sprintf("%s://%s", scheme, c.Host)
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, c.Host, c.Port)
+}
+
+// ResolveS3Connection returns the connection of a bucket, reading a referenced S3Connection from the namespace.
+func ResolveS3Connection(ctx context.Context, client ctrlclient.Client, namespace string, bucket *s3v1alpha1.S3BucketSpec) (*S3Connection, error) {
+	if bucket == nil || bucket.BucketName == "" {
+		return nil, fmt.Errorf("no bucket name given")
+	}
+	connection := bucket.Connection
+	var spec *s3v1alpha1.S3ConnectionSpec
+	switch {
+	case connection == nil:
+		return nil, fmt.Errorf("no connection given for bucket %s", bucket.BucketName)
+	case connection.Inline != nil:
+		spec = connection.Inline
+	case connection.Reference != "":
+		s3Connection := &s3v1alpha1.S3Connection{}
+		if err := client.Get(ctx, ctrlclient.ObjectKey{Namespace: namespace, Name: connection.Reference}, s3Connection); err != nil {
+			return nil, fmt.Errorf("get S3Connection %s/%s: %w", namespace, connection.Reference, err)
+		}
+		spec = &s3Connection.Spec
+	default:
+		return nil, fmt.Errorf("neither a reference nor an inline connection given for bucket %s", bucket.BucketName)
+	}
+	if spec.Credentials == nil || spec.Credentials.SecretClass == "" {
+		return nil, fmt.Errorf("no credentials SecretClass given for the connection of bucket %s", bucket.BucketName)
+	}
+	return &S3Connection{Bucket: bucket.BucketName, S3ConnectionSpec: spec}, nil
+}
+
+// EnvVars returns the environment of S3Script.
+func (c *S3Connection) EnvVars() []corev1.EnvVar {
+	env := []corev1.EnvVar{
+		{Name: "S3_ENDPOINT", Value: c.Endpoint()},
+		{Name: "S3_BUCKET", Value: c.Bucket},
+		{Name: "S3_REGION", Value: c.Region},
+		{Name: "S3_PATH_STYLE", Value: strconv.FormatBool(c.PathStyle)},
+		{Name: "S3_CREDENTIALS_DIR", Value: s3CredentialsDir},
+	}
+	switch {
+	case c.verifyNone():
+		env = append(env, corev1.EnvVar{Name: "S3_INSECURE", Value: "true"})
+	case c.caSecretClass() != "":
+		env = append(env, corev1.EnvVar{Name: "S3_CA_CERT", Value: path.Join(s3CaDir, "ca.crt")})
+	}
+	return env
+}
+
+// VolumeMounts returns the mounts of the credentials and, if the server is verified by a SecretClass, of its CA.
+func (c *S3Connection) VolumeMounts() []corev1.VolumeMount {
+	mounts := []corev1.VolumeMount{{Name: s3CredentialsVolumeName, MountPath: s3CredentialsDir}}
+	if c.caSecretClass() != "" {
+		mounts = append(mounts, corev1.VolumeMount{Name: s3CaVolumeName, MountPath: s3CaDir})
+	}
+	return mounts
+}
+
+// Volumes returns the secret-operator volumes of VolumeMounts. The credentials SecretClass has to provide
+// `ACCESS_KEY` and `SECRET_KEY`.
+func (c *S3Connection) Volumes() []corev1.Volume {
+	credentials := c.Credentials
+	credentialsVolume := builder.NewSecretOperatorVolume(s3CredentialsVolumeName, credentials.SecretClass)
+	if scope := credentials.Scope; scope != nil {
+		credentialsVolume.SetScope(&builder.SecretVolumeScope{
+			Pod:            scope.Pod,
+			Node:           scope.Node,
+			Service:        scope.Services,
+			ListenerVolume: scope.ListenerVolumes,
+		})
+	}
+	volumes := []corev1.Volume{*credentialsVolume.Builde()}
+	if secretClass := c.caSecretClass(); secretClass != "" {
+		volumes = append(volumes, *builder.NewSecretOperatorVolume(s3CaVolumeName, secretClass).Builde())
+	}
+	return volumes
+}
+
+func (c *S3Connection) verifyNone() bool {
+	return c.Tls != nil && c.Tls.Verification != nil && c.Tls.Verification.None != nil
+}
+
+// caSecretClass returns the SecretClass of the CA verifying the server. Without one, the CAs of the image are used.
+func (c *S3Connection) caSecretClass() string {
+	if c.Tls == nil || c.Tls.Verification == nil || c.verifyNone() {
+		return ""
+	}
+	server := c.Tls.Verification.Server
+	if server == nil || server.CACert == nil {
+		return ""
+	}
+	return server.CACert.SecretClass
+}
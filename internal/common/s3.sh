@@ -0,0 +1,27 @@
+# Shell helpers to talk to the S3 bucket of a backup, configured by the environment of S3Connection.EnvVars.
+
+access_key=$(cat "${S3_CREDENTIALS_DIR}/ACCESS_KEY")
+secret_key=$(cat "${S3_CREDENTIALS_DIR}/SECRET_KEY")
+curl_opts=(--silent --show-error --fail --aws-sigv4 "aws:amz:${S3_REGION}:s3" --user "${access_key}:${secret_key}")
+if [ -n "${S3_CA_CERT:-}" ]; then
+  curl_opts+=(--cacert "${S3_CA_CERT}")
+fi
+if [ "${S3_INSECURE:-false}" = "true" ]; then
+  curl_opts+=(--insecure)
+fi
+empty_sha256=e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855
+
+# object_url prints the URL of an object, or of the bucket for an empty key
+object_url() {
+  if [ "${S3_PATH_STYLE}" = "true" ]; then
+    echo "${S3_ENDPOINT}/${S3_BUCKET}/$1"
+  else
+    echo "${S3_ENDPOINT/:\/\//://${S3_BUCKET}.}/$1"
+  fi
+}
+
+# zxid_of prints the zxid of a snapshot or log file as decimal, e.g. snapshot.1a2b or snapshot.1a2b.gz
+zxid_of() {
+  local zxid=${1#*.}
+  echo $((16#${zxid%%.*}))
+}
@@ -0,0 +1,109 @@
+package common_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	commonsv1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/commons/v1alpha1"
+	s3v1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/s3/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+var _ = Describe("S3 connection", func() {
+	ctx := context.Background()
+	bucket := &s3v1alpha1.S3BucketSpec{
+		BucketName: "backups",
+		Connection: &s3v1alpha1.S3BucketConnectionSpec{Reference: "minio"},
+	}
+
+	newClient := func(objs ...ctrlclient.Object) ctrlclient.Client {
+		s := runtime.NewScheme()
+		Expect(scheme.AddToScheme(s)).To(Succeed())
+		Expect(s3v1alpha1.AddToScheme(s)).To(Succeed())
+		return fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).Build()
+	}
+
+	It("resolves a referenced connection", func() {
+		client := newClient(&s3v1alpha1.S3Connection{
+			ObjectMeta: metav1.ObjectMeta{Name: "minio", Namespace: "default"},
+			Spec: s3v1alpha1.S3ConnectionSpec{
+				Host:        "minio",
+				Credentials: &commonsv1alpha1.Credentials{SecretClass: "s3-credentials"},
+			},
+		})
+		connection, err := common.ResolveS3Connection(ctx, client, "default", bucket)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(connection.Bucket).To(Equal("backups"))
+		Expect(connection.Endpoint()).To(Equal("http://minio"))
+	})
+
+	It("fails for a missing connection", func() {
+		_, err := common.ResolveS3Connection(ctx, newClient(), "default", bucket)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("requires credentials", func() {
+		inline := &s3v1alpha1.S3BucketSpec{
+			BucketName: "backups",
+			Connection: &s3v1alpha1.S3BucketConnectionSpec{Inline: &s3v1alpha1.S3ConnectionSpec{Host: "minio"}},
+		}
+		_, err := common.ResolveS3Connection(ctx, newClient(), "default", inline)
+		Expect(err).To(MatchError(ContainSubstring("credentials")))
+	})
+})
+
+var _ = Describe("S3 connection environment", func() {
+	newConnection := func(verification *commonsv1alpha1.TLSVerificationSpec) *common.S3Connection {
+		connection := &common.S3Connection{
+			Bucket: "backups",
+			S3ConnectionSpec: &s3v1alpha1.S3ConnectionSpec{
+				Host:        "minio",
+				Port:        9000,
+				Credentials: &commonsv1alpha1.Credentials{SecretClass: "s3-credentials"},
+			},
+		}
+		if verification != nil {
+			connection.Tls = &s3v1alpha1.Tls{Verification: verification}
+		}
+		return connection
+	}
+	envNames := func(env []corev1.EnvVar) []string {
+		names := make([]string, 0, len(env))
+		for _, e := range env {
+			names = append(names, e.Name)
+		}
+		return names
+	}
+
+	It("mounts only the credentials of a plain connection", func() {
+		connection := newConnection(nil)
+		Expect(connection.Endpoint()).To(Equal("http://minio:9000"))
+		Expect(envNames(connection.EnvVars())).NotTo(ContainElements("S3_CA_CERT", "S3_INSECURE"))
+		Expect(connection.Volumes()).To(HaveLen(1))
+		Expect(connection.VolumeMounts()).To(HaveLen(1))
+	})
+
+	It("mounts the CA of a verified server", func() {
+		connection := newConnection(&commonsv1alpha1.TLSVerificationSpec{
+			Server: &commonsv1alpha1.ServerVerification{CACert: &commonsv1alpha1.CACert{SecretClass: "tls"}},
+		})
+		Expect(connection.Endpoint()).To(Equal("https://minio:9000"))
+		Expect(envNames(connection.EnvVars())).To(ContainElement("S3_CA_CERT"))
+		Expect(connection.Volumes()).To(HaveLen(2))
+		Expect(connection.VolumeMounts()).To(HaveLen(2))
+	})
+
+	It("skips the verification of an unverified server", func() {
+		connection := newConnection(&commonsv1alpha1.TLSVerificationSpec{None: &commonsv1alpha1.NoneVerification{}})
+		Expect(envNames(connection.EnvVars())).To(ContainElement("S3_INSECURE"))
+		Expect(connection.Volumes()).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,67 @@
+package common_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	commonsv1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/commons/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/ptr"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+var _ = Describe("Snapshot settings", func() {
+	zooCfg := func(cfg *zkv1alpha1.ConfigSpec, overrides map[string]string) map[string]string {
+		config := common.DefaultServerConfig("simple")
+		spec := &commonsv1alpha1.OverridesSpec{ConfigOverrides: map[string]map[string]string{}}
+		if overrides != nil {
+			spec.ConfigOverrides[zkv1alpha1.ZooCfgFileName] = overrides
+		}
+		Expect(config.MergeDefaultConfig(cfg, spec)).To(Succeed())
+		return spec.ConfigOverrides[zkv1alpha1.ZooCfgFileName]
+	}
+
+	It("should enable autopurge by default", func() {
+		cfg := zooCfg(&zkv1alpha1.ConfigSpec{}, nil)
+		Expect(cfg).To(HaveKeyWithValue("autopurge.snapRetainCount", "3"))
+		Expect(cfg).To(HaveKeyWithValue("autopurge.purgeInterval", "1"))
+		Expect(cfg).NotTo(HaveKey("snapCount"))
+		Expect(cfg).NotTo(HaveKey("snapshot.compression.method"))
+		Expect(cfg).NotTo(HaveKey("preAllocSize"))
+	})
+
+	It("should render the configured settings", func() {
+		preAllocSize := resource.MustParse("16Mi")
+		cfg := zooCfg(&zkv1alpha1.ConfigSpec{
+			Autopurge:           &zkv1alpha1.AutopurgeSpec{SnapRetainCount: 5, PurgeInterval: ptr.To(int32(0))},
+			SnapCount:           ptr.To(int32(50000)),
+			SnapshotCompression: zkv1alpha1.SnapshotCompressionSnappy,
+			PreAllocSize:        &preAllocSize,
+		}, nil)
+		Expect(cfg).To(HaveKeyWithValue("autopurge.snapRetainCount", "5"))
+		Expect(cfg).To(HaveKeyWithValue("autopurge.purgeInterval", "0"))
+		Expect(cfg).To(HaveKeyWithValue("snapCount", "50000"))
+		Expect(cfg).To(HaveKeyWithValue("snapshot.compression.method", "snappy"))
+		Expect(cfg).To(HaveKeyWithValue("preAllocSize", "16384"))
+	})
+
+	It("should let zoo.cfg overrides win", func() {
+		cfg := zooCfg(&zkv1alpha1.ConfigSpec{SnapCount: ptr.To(int32(50000))}, map[string]string{"snapCount": "1000"})
+		Expect(cfg).To(HaveKeyWithValue("snapCount", "1000"))
+		Expect(cfg).To(HaveKeyWithValue("autopurge.purgeInterval", "1"))
+	})
+})
+
+var _ = Describe("Timing settings", func() {
+	It("should render the configured tick time and limits", func() {
+		config := common.DefaultServerConfig("simple")
+		spec := &commonsv1alpha1.OverridesSpec{ConfigOverrides: map[string]map[string]string{}}
+		Expect(config.MergeDefaultConfig(&zkv1alpha1.ConfigSpec{InitLimit: 20, TickTime: 2000}, spec)).To(Succeed())
+
+		cfg := spec.ConfigOverrides[zkv1alpha1.ZooCfgFileName]
+		Expect(cfg).To(HaveKeyWithValue("initLimit", "20"))
+		Expect(cfg).To(HaveKeyWithValue("tickTime", "2000"))
+		Expect(cfg).To(HaveKeyWithValue("syncLimit", "2"))
+	})
+})
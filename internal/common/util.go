@@ -5,12 +5,37 @@ import (
 	"strings"
 
 	"github.com/zncdatadev/operator-go/pkg/reconciler"
+	oputil "github.com/zncdatadev/operator-go/pkg/util"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/util"
+	zkversion "github.com/zncdatadev/zookeeper-operator/internal/util/version"
 )
 
 const (
 	ZkServerContainerName = "zookeeper"
 )
 
+// ClusterImage returns the image of the servers, which is also used by the jobs of the cluster.
+func ClusterImage(imageSpec *zkv1alpha1.ImageSpec) *oputil.Image {
+	image := oputil.NewImage(
+		zkv1alpha1.DefaultProductName,
+		zkversion.BuildVersion,
+		ProductVersion(imageSpec),
+		func(options *oputil.ImageOptions) {
+			options.Custom = imageSpec.Custom
+			options.Repo = imageSpec.Repo
+			options.PullPolicy = *imageSpec.PullPolicy
+		},
+	)
+
+	if imageSpec.KubedoopVersion != "" {
+		image.KubedoopVersion = imageSpec.KubedoopVersion
+	}
+
+	return image
+}
+
 func ClusterServiceName(instanceName string) string {
 	return instanceName
 }
@@ -27,15 +52,23 @@ func RoleGroupServiceName(roleGroupInfo *reconciler.RoleGroupInfo) string {
 	return roleGroupInfo.GetFullName()
 }
 
-func PodFQDN(podName, svcName, namespace string) string {
-	return fmt.Sprintf("%s.%s.%s.svc.cluster.local", podName, svcName, namespace)
+// ClusterDomain returns the DNS domain of the cluster, the operator wide domain unless the cluster overrides it.
+func ClusterDomain(clusterConfig *zkv1alpha1.ClusterConfigSpec) string {
+	if clusterConfig != nil && clusterConfig.ClusterDomain != "" {
+		return strings.Trim(clusterConfig.ClusterDomain, ".")
+	}
+	return util.ClusterDomain()
+}
+
+func PodFQDN(podName, svcName, namespace, clusterDomain string) string {
+	return fmt.Sprintf("%s.%s.%s.svc.%s", podName, svcName, namespace, clusterDomain)
 }
 
-func CreateClientConnectionString(statefulSetName string, replicates, clientPort int32, svcName string, ns string) string {
+func CreateClientConnectionString(statefulSetName string, replicates, clientPort int32, svcName string, ns string, clusterDomain string) string {
 	var clientCollections []string
 	for i := int32(0); i < replicates; i++ {
 		podName := fmt.Sprintf("%s-%d", statefulSetName, i)
-		podFQDN := PodFQDN(podName, svcName, ns)
+		podFQDN := PodFQDN(podName, svcName, ns, clusterDomain)
 		clientCollections = append(clientCollections, fmt.Sprintf("%s:%d", podFQDN, clientPort))
 	}
 	return strings.Join(clientCollections, ",")
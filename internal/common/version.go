@@ -0,0 +1,119 @@
+package common
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	oputil "github.com/zncdatadev/operator-go/pkg/util"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+)
+
+// SupportedProductVersions are the ZooKeeper versions the operator runs, oldest first.
+var SupportedProductVersions = []string{"3.8.4", "3.9.2", "3.9.3"}
+
+// ProductVersions are the ZooKeeper versions of role groups that do not run the version of the image spec yet,
+// while an upgrade is rolled out one role group at a time.
+type ProductVersions map[string]string
+
+// UpgradePartitions are the partitions of the rolling updates of role groups, see
+// RollingUpdateStatefulSetStrategy.Partition. While a role group is upgraded, only its servers from the partition
+// ordinal on run the new version, and the partition steps down one server at a time.
+type UpgradePartitions map[string]int32
+
+// Partition returns the partition of a role group, nil if its servers are updated without one.
+func (p UpgradePartitions) Partition(roleGroup string) *int32 {
+	partition, ok := p[roleGroup]
+	if !ok {
+		return nil
+	}
+	return &partition
+}
+
+// Image returns the image of a role group.
+func (v ProductVersions) Image(image *oputil.Image, roleGroup string) *oputil.Image {
+	version, ok := v[roleGroup]
+	if !ok || version == image.ProductVersion {
+		return image
+	}
+	pinned := *image
+	pinned.ProductVersion = version
+	return &pinned
+}
+
+// ProductVersion returns the ZooKeeper version requested by the image spec.
+func ProductVersion(imageSpec *zkv1alpha1.ImageSpec) string {
+	if imageSpec == nil || imageSpec.ProductVersion == "" {
+		return zkv1alpha1.DefaultProductVersion
+	}
+	return imageSpec.ProductVersion
+}
+
+// ImageProductVersion returns the ZooKeeper version of an image built by ClusterImage,
+// e.g. `3.9.3` of `quay.io/zncdatadev/zookeeper:3.9.3-kubedoop0.0.0-dev`, and empty for other images.
+func ImageProductVersion(image string) string {
+	_, tag, ok := strings.Cut(image[strings.LastIndex(image, "/")+1:], ":")
+	if !ok {
+		return ""
+	}
+	version, _, ok := strings.Cut(tag, "-kubedoop")
+	if !ok {
+		return ""
+	}
+	return version
+}
+
+// ValidateUpgrade checks that the servers can be upgraded from one version to the other in a rolling restart.
+// ZooKeeper supports rolling upgrades to newer patch releases and to the next minor release. Downgrades are refused,
+// as older servers may not read the snapshots and transaction logs written by newer ones.
+func ValidateUpgrade(from, to string) error {
+	if !slices.Contains(SupportedProductVersions, to) {
+		return fmt.Errorf("version %s is not supported, supported versions are %s", to, strings.Join(SupportedProductVersions, ", "))
+	}
+	fromVersion, err := parseProductVersion(from)
+	if err != nil {
+		return err
+	}
+	toVersion, err := parseProductVersion(to)
+	if err != nil {
+		return err
+	}
+	switch {
+	case slices.Compare(toVersion, fromVersion) < 0:
+		return fmt.Errorf("downgrade from %s to %s is not supported", from, to)
+	case toVersion[0] != fromVersion[0]:
+		return fmt.Errorf("upgrade from %s to %s changes the major version, which is not supported in a rolling restart", from, to)
+	case toVersion[1] > fromVersion[1]+1:
+		return fmt.Errorf("upgrade from %s to %s skips a release, upgrade to %d.%d first", from, to, fromVersion[0], fromVersion[1]+1)
+	}
+	return nil
+}
+
+// parseProductVersion parses a `major.minor.patch` version.
+func parseProductVersion(version string) ([]int, error) {
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid version %q, expected major.minor.patch", version)
+	}
+	numbers := make([]int, 0, len(parts))
+	for _, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q, expected major.minor.patch", version)
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, nil
+}
+
+// CompareProductVersions orders versions from oldest to newest. Invalid versions are compared as strings.
+func CompareProductVersions(a, b string) int {
+	aVersion, aErr := parseProductVersion(a)
+	bVersion, bErr := parseProductVersion(b)
+	if aErr != nil || bErr != nil {
+		return strings.Compare(a, b)
+	}
+	return slices.Compare(aVersion, bVersion)
+}
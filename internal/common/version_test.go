@@ -0,0 +1,50 @@
+package common_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
+)
+
+var _ = Describe("Product versions", func() {
+	It("should allow upgrades to newer patch and the next minor releases", func() {
+		Expect(common.ValidateUpgrade("3.9.2", "3.9.3")).To(Succeed())
+		Expect(common.ValidateUpgrade("3.8.4", "3.9.3")).To(Succeed())
+		Expect(common.ValidateUpgrade("3.8.1", "3.8.4")).To(Succeed())
+	})
+
+	It("should refuse downgrades, skipped releases and unsupported versions", func() {
+		Expect(common.ValidateUpgrade("3.9.3", "3.9.2")).To(MatchError(ContainSubstring("downgrade")))
+		Expect(common.ValidateUpgrade("3.9.3", "3.8.4")).To(MatchError(ContainSubstring("downgrade")))
+		Expect(common.ValidateUpgrade("3.7.2", "3.9.3")).To(MatchError(ContainSubstring("upgrade to 3.8 first")))
+		Expect(common.ValidateUpgrade("2.9.3", "3.9.3")).To(MatchError(ContainSubstring("major version")))
+		Expect(common.ValidateUpgrade("3.9.3", "3.10.0")).To(MatchError(ContainSubstring("not supported")))
+		Expect(common.ValidateUpgrade("latest", "3.9.3")).To(MatchError(ContainSubstring("invalid version")))
+	})
+
+	It("should read the version of an image", func() {
+		Expect(common.ImageProductVersion("quay.io/zncdatadev/zookeeper:3.9.3-kubedoop0.0.0-dev")).To(Equal("3.9.3"))
+		Expect(common.ImageProductVersion("registry:5000/zookeeper:3.8.4-kubedoop0.1.0")).To(Equal("3.8.4"))
+		Expect(common.ImageProductVersion("registry:5000/zookeeper")).To(BeEmpty())
+		Expect(common.ImageProductVersion("zookeeper:3.9")).To(BeEmpty())
+	})
+
+	It("should pin the image of a role group", func() {
+		image := common.ClusterImage(&zkv1alpha1.ImageSpec{ProductVersion: "3.9.3", PullPolicy: ptr.To(corev1.PullIfNotPresent)})
+		versions := common.ProductVersions{"secondary": "3.9.2"}
+		Expect(versions.Image(image, "default")).To(BeIdenticalTo(image))
+		pinned := versions.Image(image, "secondary")
+		Expect(pinned.ProductVersion).To(Equal("3.9.2"))
+		Expect(image.ProductVersion).To(Equal("3.9.3"))
+		Expect(common.ProductVersions(nil).Image(image, "secondary")).To(BeIdenticalTo(image))
+	})
+
+	It("should default the requested version", func() {
+		Expect(common.ProductVersion(&zkv1alpha1.ImageSpec{})).To(Equal(zkv1alpha1.DefaultProductVersion))
+		Expect(common.ProductVersion(&zkv1alpha1.ImageSpec{ProductVersion: "3.8.4"})).To(Equal("3.8.4"))
+	})
+})
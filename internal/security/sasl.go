@@ -0,0 +1,311 @@
+package security
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/zncdatadev/operator-go/pkg/constants"
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/util"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// volume name and mount path
+	KerberosVolumeName     string = "kerberos"
+	DigestVolumeName       string = "digest"
+	QuorumDigestVolumeName string = "quorum-digest"
+
+	KerberosDir          string = "/kubedoop/kerberos"
+	DigestMountDir       string = "/kubedoop/digest_mount"
+	QuorumDigestMountDir string = "/kubedoop/quorum_digest_mount"
+
+	JaasFileName         string = "jaas.conf"
+	KerberosServiceName  string = "zookeeper"
+	KerberosKeytabName   string = "keytab"
+	KerberosKrb5ConfName string = "krb5.conf"
+
+	// QuorumDigestUser is the user the servers authenticate each other with,
+	// its password is stored under QuorumDigestPasswordKey in the quorum digest secret.
+	QuorumDigestUser        string = "zookeeper"
+	QuorumDigestPasswordKey string = "password"
+
+	// JAAS login contexts
+	ServerLoginContext        string = "Server"
+	QuorumServerLoginContext  string = "QuorumServer"
+	QuorumLearnerLoginContext string = "QuorumLearner"
+
+	// SASL
+	SASLAuthProvider                 string = "authProvider.sasl"
+	SASLAuthProviderClass            string = "org.apache.zookeeper.server.auth.SASLAuthenticationProvider"
+	KerberosRemoveHostFromPrincipal  string = "kerberos.removeHostFromPrincipal"
+	KerberosRemoveRealmFromPrincipal string = "kerberos.removeRealmFromPrincipal"
+
+	// Quorum SASL
+	QuorumAuthEnableSasl          string = "quorum.auth.enableSasl"
+	QuorumAuthLearnerRequireSasl  string = "quorum.auth.learnerRequireSasl"
+	QuorumAuthServerRequireSasl   string = "quorum.auth.serverRequireSasl"
+	QuorumAuthLearnerLoginContext string = "quorum.auth.learner.saslLoginContext"
+	QuorumAuthServerLoginContext  string = "quorum.auth.server.saslLoginContext"
+	QuorumAuthKerberosPrincipal   string = "quorum.auth.kerberos.servicePrincipal"
+	QuorumCnxnThreadsSize         string = "quorum.cnxn.threads.size"
+	DefaultQuorumCnxnThreadsSize  string = "20"
+	FalseString                   string = "false"
+
+	// SASL mechanisms clients authenticate with
+	SaslMechanismGssapi    string = "GSSAPI"
+	SaslMechanismDigestMd5 string = "DIGEST-MD5"
+
+	krb5LoginModule         string = "com.sun.security.auth.module.Krb5LoginModule"
+	digestLoginModule       string = "org.apache.zookeeper.server.auth.DigestLoginModule"
+	kerberosRealmEnv        string = "KERBEROS_REALM"
+	quorumDigestPasswordEnv string = "QUORUM_DIGEST_PASSWORD"
+	// xtraceStateEnv holds the `set -o xtrace` state of the script, restored once the JAAS config is written
+	xtraceStateEnv string = "JAAS_XTRACE_STATE"
+)
+
+// QuorumSaslStageAnnotation is set on the pod template, so that every stage change rolls the ensemble.
+const QuorumSaslStageAnnotation = "zookeeper.kubedoop.dev/quorum-sasl-stage"
+
+// QuorumDigestSecretName returns the name of the secret holding the operator generated quorum digest credentials.
+func QuorumDigestSecretName(clusterName string) string {
+	return clusterName + "-quorum-digest"
+}
+
+// SaslEnabled checks if clients may authenticate with SASL, either with Kerberos or digest credentials.
+func (z *ZookeeperSecurity) SaslEnabled() bool {
+	return z.resolvedAuthenticationClasses.GetKerberosAuthenticationClass() != nil ||
+		len(z.resolvedAuthenticationClasses.GetStaticAuthenticationClasses()) > 0
+}
+
+// SaslMechanisms returns the SASL mechanisms clients may authenticate with.
+func (z *ZookeeperSecurity) SaslMechanisms() []string {
+	mechanisms := make([]string, 0, 2)
+	if z.resolvedAuthenticationClasses.GetKerberosAuthenticationClass() != nil {
+		mechanisms = append(mechanisms, SaslMechanismGssapi)
+	}
+	if len(z.resolvedAuthenticationClasses.GetStaticAuthenticationClasses()) > 0 {
+		mechanisms = append(mechanisms, SaslMechanismDigestMd5)
+	}
+	return mechanisms
+}
+
+// ClientKerberosSecretClass returns the Kerberos SecretClass of the client authentication class, empty without Kerberos.
+func (z *ZookeeperSecurity) ClientKerberosSecretClass() string {
+	if kerberosAuthClass := z.resolvedAuthenticationClasses.GetKerberosAuthenticationClass(); kerberosAuthClass != nil {
+		return kerberosAuthClass.Spec.AuthenticationProvider.Kerberos.KerberosStorageClass
+	}
+	return ""
+}
+
+// KerberosServicePrincipal returns the principal pattern of the servers, `_HOST` stands for the server FQDN
+// and the realm is the default realm of the SecretClass.
+func (z *ZookeeperSecurity) KerberosServicePrincipal() string {
+	return KerberosServiceName + "/_HOST"
+}
+
+// DigestAuthenticationClasses returns the names of the static authentication classes that hold the digest users.
+func (z *ZookeeperSecurity) DigestAuthenticationClasses() []string {
+	names := make([]string, 0)
+	for _, staticAuthClass := range z.resolvedAuthenticationClasses.GetStaticAuthenticationClasses() {
+		names = append(names, staticAuthClass.Name)
+	}
+	return names
+}
+
+// QuorumSaslEnabled checks if servers authenticate each other with SASL.
+func (z *ZookeeperSecurity) QuorumSaslEnabled() bool {
+	return z.quorumAuthentication != nil &&
+		z.quorumAuthentication.Stage != "" &&
+		z.quorumAuthentication.Stage != zkv1alpha1.QuorumSaslDisabled
+}
+
+// PodAnnotations returns the pod template annotations derived from the security settings.
+func (z *ZookeeperSecurity) PodAnnotations() map[string]string {
+	if z.quorumAuthentication == nil {
+		return nil
+	}
+	stage := z.quorumAuthentication.Stage
+	if stage == "" {
+		stage = zkv1alpha1.QuorumSaslDisabled
+	}
+	return map[string]string{QuorumSaslStageAnnotation: string(stage)}
+}
+
+// QuorumDigestEnabled checks if quorum SASL uses the digest credentials managed by the operator.
+func (z *ZookeeperSecurity) QuorumDigestEnabled() bool {
+	return z.QuorumSaslEnabled() && z.quorumAuthentication.KerberosSecretClass == ""
+}
+
+// kerberosSecretClass returns the secret class of the server keytab, shared by client and quorum SASL.
+func (z *ZookeeperSecurity) kerberosSecretClass() string {
+	if clientSecretClass := z.ClientKerberosSecretClass(); clientSecretClass != "" {
+		return clientSecretClass
+	}
+	if z.QuorumSaslEnabled() {
+		return z.quorumAuthentication.KerberosSecretClass
+	}
+	return ""
+}
+
+// validateQuorumSasl checks that client and quorum SASL can share one keytab.
+func (z *ZookeeperSecurity) validateQuorumSasl() error {
+	kerberosAuthClass := z.resolvedAuthenticationClasses.GetKerberosAuthenticationClass()
+	if kerberosAuthClass == nil || z.quorumAuthentication == nil || z.quorumAuthentication.KerberosSecretClass == "" {
+		return nil
+	}
+	clientSecretClass := kerberosAuthClass.Spec.AuthenticationProvider.Kerberos.KerberosStorageClass
+	if clientSecretClass != z.quorumAuthentication.KerberosSecretClass {
+		return fmt.Errorf("quorum kerberos secret class %s must match the kerberos secret class %s of authentication class %s",
+			z.quorumAuthentication.KerberosSecretClass, clientSecretClass, kerberosAuthClass.Name)
+	}
+	return nil
+}
+
+// JvmArgs returns the JVM arguments required by the SASL settings.
+func (z *ZookeeperSecurity) JvmArgs() []string {
+	if !z.SaslEnabled() && !z.QuorumSaslEnabled() {
+		return nil
+	}
+	args := []string{
+		fmt.Sprintf("-Djava.security.auth.login.config=%s", path.Join(constants.KubedoopConfigDir, JaasFileName)),
+	}
+	if z.kerberosSecretClass() != "" {
+		args = append(args, fmt.Sprintf("-Djava.security.krb5.conf=%s", path.Join(KerberosDir, KerberosKrb5ConfName)))
+	}
+	return args
+}
+
+// saslConfigSettings returns the `zoo.cfg` settings required for SASL client and quorum authentication.
+func (z *ZookeeperSecurity) saslConfigSettings() map[string]string {
+	config := make(map[string]string)
+	if z.SaslEnabled() {
+		config[SASLAuthProvider] = SASLAuthProviderClass
+		if z.resolvedAuthenticationClasses.GetKerberosAuthenticationClass() != nil {
+			config[KerberosRemoveHostFromPrincipal] = TrueString
+			config[KerberosRemoveRealmFromPrincipal] = TrueString
+		}
+	}
+
+	if z.QuorumSaslEnabled() {
+		stage := z.quorumAuthentication.Stage
+		config[QuorumAuthEnableSasl] = TrueString
+		config[QuorumAuthLearnerRequireSasl] = FalseString
+		config[QuorumAuthServerRequireSasl] = FalseString
+		if stage == zkv1alpha1.QuorumSaslLearnerRequired || stage == zkv1alpha1.QuorumSaslRequired {
+			config[QuorumAuthLearnerRequireSasl] = TrueString
+		}
+		if stage == zkv1alpha1.QuorumSaslRequired {
+			config[QuorumAuthServerRequireSasl] = TrueString
+		}
+		config[QuorumAuthLearnerLoginContext] = QuorumLearnerLoginContext
+		config[QuorumAuthServerLoginContext] = QuorumServerLoginContext
+		config[QuorumCnxnThreadsSize] = DefaultQuorumCnxnThreadsSize
+		if !z.QuorumDigestEnabled() {
+			config[QuorumAuthKerberosPrincipal] = z.KerberosServicePrincipal()
+		}
+	}
+	return config
+}
+
+// addSaslVolumeMounts adds the Kerberos keytab and the digest credentials to the pod
+func (z *ZookeeperSecurity) addSaslVolumeMounts(podBuilder *corev1.PodTemplateSpec, zkContainer *corev1.Container) {
+	if kerberosSecretClass := z.kerberosSecretClass(); kerberosSecretClass != "" {
+		z.addVolumeMount(zkContainer, KerberosVolumeName, KerberosDir)
+		z.addVolume(podBuilder, util.CreateKerberosVolume(KerberosVolumeName, kerberosSecretClass, KerberosServiceName))
+	}
+
+	for i, staticAuthClass := range z.resolvedAuthenticationClasses.GetStaticAuthenticationClasses() {
+		volumeName := fmt.Sprintf("%s-%d", DigestVolumeName, i)
+		z.addVolumeMount(zkContainer, volumeName, path.Join(DigestMountDir, staticAuthClass.Name))
+		z.addVolume(podBuilder, secretVolume(volumeName, staticAuthClass.Spec.AuthenticationProvider.Static.UserCredentialsSecret.Name))
+	}
+
+	if z.QuorumDigestEnabled() {
+		z.addVolumeMount(zkContainer, QuorumDigestVolumeName, QuorumDigestMountDir)
+		z.addVolume(podBuilder, secretVolume(QuorumDigestVolumeName, QuorumDigestSecretName(z.clusterName)))
+	}
+}
+
+func secretVolume(volumeName, secretName string) corev1.Volume {
+	return corev1.Volume{
+		Name: volumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: secretName,
+			},
+		},
+	}
+}
+
+// jaasConfigCommand renders the JAAS login contexts on startup.
+// Passwords are read from the mounted secrets, so tracing is turned off while they are written and restored after.
+func (z *ZookeeperSecurity) jaasConfigCommand() string {
+	jaasFile := path.Join(constants.KubedoopConfigDir, JaasFileName)
+	lines := []string{
+		fmt.Sprintf(`{ %s=$(set +o | grep xtrace); set +x; } 2>/dev/null`, xtraceStateEnv),
+		fmt.Sprintf(`rm -f %s`, jaasFile),
+	}
+	if z.kerberosSecretClass() != "" {
+		lines = append(lines, fmt.Sprintf(`%s=$(grep -oP 'default_realm\s*=\s*\K\S+' %s)`,
+			kerberosRealmEnv, path.Join(KerberosDir, KerberosKrb5ConfName)))
+	}
+
+	if z.SaslEnabled() {
+		lines = append(lines, fmt.Sprintf(`echo "%s {" >> %s`, ServerLoginContext, jaasFile))
+		if z.resolvedAuthenticationClasses.GetKerberosAuthenticationClass() != nil {
+			lines = append(lines, krb5LoginModuleCommand(jaasFile))
+		}
+		if len(z.resolvedAuthenticationClasses.GetStaticAuthenticationClasses()) > 0 {
+			lines = append(lines,
+				fmt.Sprintf(`echo "  %s required" >> %s`, digestLoginModule, jaasFile),
+				fmt.Sprintf(`for user in %s/*/*; do
+  echo "  user_$(basename "$user")=\"$(cat "$user")\"" >> %s
+done`, DigestMountDir, jaasFile),
+				fmt.Sprintf(`echo "  ;" >> %s`, jaasFile),
+			)
+		}
+		lines = append(lines, fmt.Sprintf(`echo "};" >> %s`, jaasFile))
+	}
+
+	if z.QuorumDigestEnabled() {
+		lines = append(lines,
+			fmt.Sprintf(`%s=$(cat %s)`, quorumDigestPasswordEnv, path.Join(QuorumDigestMountDir, QuorumDigestPasswordKey)),
+			fmt.Sprintf(`cat >> %[1]s <<EOF
+%[2]s {
+  %[4]s required
+  user_%[5]s="${%[6]s}";
+};
+%[3]s {
+  %[4]s required
+  username="%[5]s"
+  password="${%[6]s}";
+};
+EOF`, jaasFile, QuorumServerLoginContext, QuorumLearnerLoginContext, digestLoginModule, QuorumDigestUser, quorumDigestPasswordEnv),
+		)
+	} else if z.QuorumSaslEnabled() {
+		for _, loginContext := range []string{QuorumServerLoginContext, QuorumLearnerLoginContext} {
+			lines = append(lines,
+				fmt.Sprintf(`echo "%s {" >> %s`, loginContext, jaasFile),
+				krb5LoginModuleCommand(jaasFile),
+				fmt.Sprintf(`echo "};" >> %s`, jaasFile),
+			)
+		}
+	}
+
+	lines = append(lines, fmt.Sprintf(`eval "${%s}"`, xtraceStateEnv))
+	return strings.Join(lines, "\n")
+}
+
+// krb5LoginModuleCommand appends a Kerberos login module entry for the `zookeeper/<pod fqdn>` principal.
+func krb5LoginModuleCommand(jaasFile string) string {
+	return fmt.Sprintf(`cat >> %s <<EOF
+  %s required
+  useKeyTab=true
+  keyTab="%s"
+  storeKey=true
+  useTicketCache=false
+  principal="%s/$(hostname -f)@${%s}";
+EOF`, jaasFile, krb5LoginModule, path.Join(KerberosDir, KerberosKeytabName), KerberosServiceName, kerberosRealmEnv)
+}
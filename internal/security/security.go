@@ -15,47 +15,86 @@ type ResolvedAuthenticationClasses struct {
 	authenticationClasses []authv1alpha1.AuthenticationClass
 }
 
-// GetTLSAuthenticationClass returns the first TLS AuthenticationClass if available
-func (r *ResolvedAuthenticationClasses) GetTLSAuthenticationClass() *authv1alpha1.AuthenticationClass {
+// GetTLSAuthenticationClasses returns all TLS AuthenticationClasses in the order they were provided
+func (r *ResolvedAuthenticationClasses) GetTLSAuthenticationClasses() []*authv1alpha1.AuthenticationClass {
+	classes := make([]*authv1alpha1.AuthenticationClass, 0)
 	for i := range r.authenticationClasses {
 		if r.authenticationClasses[i].Spec.AuthenticationProvider != nil &&
 			r.authenticationClasses[i].Spec.AuthenticationProvider.TLS != nil {
+			classes = append(classes, &r.authenticationClasses[i])
+		}
+	}
+	return classes
+}
+
+// GetKerberosAuthenticationClass returns the Kerberos AuthenticationClass if available
+func (r *ResolvedAuthenticationClasses) GetKerberosAuthenticationClass() *authv1alpha1.AuthenticationClass {
+	for i := range r.authenticationClasses {
+		if r.authenticationClasses[i].Spec.AuthenticationProvider != nil &&
+			r.authenticationClasses[i].Spec.AuthenticationProvider.Kerberos != nil {
 			return &r.authenticationClasses[i]
 		}
 	}
 	return nil
 }
 
+// GetStaticAuthenticationClasses returns all static AuthenticationClasses, used as SASL digest credentials
+func (r *ResolvedAuthenticationClasses) GetStaticAuthenticationClasses() []*authv1alpha1.AuthenticationClass {
+	classes := make([]*authv1alpha1.AuthenticationClass, 0)
+	for i := range r.authenticationClasses {
+		if r.authenticationClasses[i].Spec.AuthenticationProvider != nil &&
+			r.authenticationClasses[i].Spec.AuthenticationProvider.Static != nil {
+			classes = append(classes, &r.authenticationClasses[i])
+		}
+	}
+	return classes
+}
+
 // Validate validates the resolved AuthenticationClasses
 // Currently errors out if:
-// - More than one AuthenticationClass was provided
-// - AuthenticationClass mechanism was not supported (only TLS is supported)
+// - The same AuthenticationClass was provided more than once
+// - More than one Kerberos AuthenticationClass was provided
+// - AuthenticationClass mechanism was not supported (only TLS, Kerberos and static are supported)
 func (r *ResolvedAuthenticationClasses) Validate() error {
-	if len(r.authenticationClasses) > 1 {
-		return fmt.Errorf("multiple authentication classes provided, only one is supported")
-	}
+	seen := make(map[string]bool, len(r.authenticationClasses))
+	kerberosClasses := 0
 
 	for _, authClass := range r.authenticationClasses {
+		if seen[authClass.Name] {
+			return fmt.Errorf("authentication class %s is provided more than once", authClass.Name)
+		}
+		seen[authClass.Name] = true
+
 		if authClass.Spec.AuthenticationProvider == nil {
 			return fmt.Errorf("authentication class %s has no provider configured", authClass.Name)
 		}
 
 		provider := authClass.Spec.AuthenticationProvider
-		// Only TLS is supported for ZooKeeper
-		if provider.TLS == nil {
-			if provider.LDAP != nil {
-				return fmt.Errorf("LDAP authentication is not supported for ZooKeeper, authentication class: %s", authClass.Name)
+		switch {
+		case provider.TLS != nil:
+		case provider.Kerberos != nil:
+			kerberosClasses++
+			if provider.Kerberos.KerberosStorageClass == "" {
+				return fmt.Errorf("kerberos authentication class %s has no kerberosStorageClass configured", authClass.Name)
 			}
-			if provider.OIDC != nil {
-				return fmt.Errorf("OIDC authentication is not supported for ZooKeeper, authentication class: %s", authClass.Name)
-			}
-			if provider.Static != nil {
-				return fmt.Errorf("static authentication is not supported for ZooKeeper, authentication class: %s", authClass.Name)
+		case provider.Static != nil:
+			if provider.Static.UserCredentialsSecret == nil || provider.Static.UserCredentialsSecret.Name == "" {
+				return fmt.Errorf("static authentication class %s has no userCredentialsSecret configured", authClass.Name)
 			}
+		case provider.LDAP != nil:
+			return fmt.Errorf("LDAP authentication is not supported for ZooKeeper, authentication class: %s", authClass.Name)
+		case provider.OIDC != nil:
+			return fmt.Errorf("OIDC authentication is not supported for ZooKeeper, authentication class: %s", authClass.Name)
+		default:
 			return fmt.Errorf("unsupported authentication method in class: %s", authClass.Name)
 		}
 	}
 
+	// A ZooKeeper server can only log in with a single Kerberos principal
+	if kerberosClasses > 1 {
+		return fmt.Errorf("multiple kerberos authentication classes provided, only one is supported")
+	}
+
 	return nil
 }
 
@@ -92,6 +131,7 @@ func ResolveAuthenticationClasses(
 func NewZookeeperSecurity(
 	ctx context.Context,
 	k8sClient client.Client,
+	clusterName string,
 	clusterConfig *zkv1alpha1.ClusterConfigSpec,
 ) (*ZookeeperSecurity, error) {
 	var resolvedAuthenticationClasses *ResolvedAuthenticationClasses
@@ -113,22 +153,48 @@ func NewZookeeperSecurity(
 	sslStorePassword := "changeit"
 	serverSecretClass := ""
 	quorumSecretClass := ""
+	clientPortMode := zkv1alpha1.ClientPortModeTls
+	tls := zkv1alpha1.ZookeeperTls{}
 	if clusterConfig != nil && clusterConfig.Tls != nil {
+		tls = *clusterConfig.Tls
 		serverSecretClass = clusterConfig.Tls.ServerSecretClass
 		quorumSecretClass = clusterConfig.Tls.QuorumSecretClass
+		if clusterConfig.Tls.ClientPortMode != "" {
+			clientPortMode = clusterConfig.Tls.ClientPortMode
+		}
+	}
+
+	var quorumAuthentication *zkv1alpha1.QuorumAuthenticationSpec
+	if clusterConfig != nil {
+		quorumAuthentication = clusterConfig.QuorumAuthentication
 	}
 
-	return &ZookeeperSecurity{
+	zkSecurity := &ZookeeperSecurity{
+		clusterName:                   clusterName,
 		resolvedAuthenticationClasses: resolvedAuthenticationClasses,
 		serverSecretClass:             serverSecretClass,
 		quorumSecretClass:             quorumSecretClass,
+		clientPortMode:                clientPortMode,
+		tls:                           tls,
+		quorumAuthentication:          quorumAuthentication,
 		sslStorePassword:              sslStorePassword,
-	}, nil
+	}
+	if err := zkSecurity.validateQuorumSasl(); err != nil {
+		return nil, err
+	}
+	if err := zkSecurity.validateTls(); err != nil {
+		return nil, err
+	}
+	return zkSecurity, nil
 }
 
 type ZookeeperSecurity struct {
+	clusterName                   string
 	resolvedAuthenticationClasses *ResolvedAuthenticationClasses
 	serverSecretClass             string
 	quorumSecretClass             string
+	clientPortMode                zkv1alpha1.ClientPortMode
+	tls                           zkv1alpha1.ZookeeperTls
+	quorumAuthentication          *zkv1alpha1.QuorumAuthenticationSpec
 	sslStorePassword              string
 }
@@ -0,0 +1,222 @@
+package security
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	authv1alpha1 "github.com/zncdatadev/operator-go/pkg/apis/authentication/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+)
+
+func tlsAuthClass(name, clientCertSecretClass string) authv1alpha1.AuthenticationClass {
+	return authv1alpha1.AuthenticationClass{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: authv1alpha1.AuthenticationClassSpec{
+			AuthenticationProvider: &authv1alpha1.AuthenticationProvider{
+				TLS: &authv1alpha1.TLSProvider{ClientCertSecretClass: clientCertSecretClass},
+			},
+		},
+	}
+}
+
+func staticAuthClass(name, secretName string) authv1alpha1.AuthenticationClass {
+	return authv1alpha1.AuthenticationClass{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: authv1alpha1.AuthenticationClassSpec{
+			AuthenticationProvider: &authv1alpha1.AuthenticationProvider{
+				Static: &authv1alpha1.StaticProvider{
+					UserCredentialsSecret: &authv1alpha1.StaticCredentialsSecret{Name: secretName},
+				},
+			},
+		},
+	}
+}
+
+var _ = Describe("ResolvedAuthenticationClasses", func() {
+	It("should accept several TLS and static classes", func() {
+		resolved := &ResolvedAuthenticationClasses{authenticationClasses: []authv1alpha1.AuthenticationClass{
+			tlsAuthClass("old-pki", "old-ca"),
+			tlsAuthClass("new-pki", "new-ca"),
+			staticAuthClass("digest", "digest-users"),
+		}}
+		Expect(resolved.Validate()).To(Succeed())
+		Expect(resolved.GetTLSAuthenticationClasses()).To(HaveLen(2))
+		Expect(resolved.GetStaticAuthenticationClasses()).To(HaveLen(1))
+	})
+
+	It("should reject a class listed twice", func() {
+		resolved := &ResolvedAuthenticationClasses{authenticationClasses: []authv1alpha1.AuthenticationClass{
+			tlsAuthClass("tls", "ca"),
+			tlsAuthClass("tls", "ca"),
+		}}
+		Expect(resolved.Validate()).To(MatchError(ContainSubstring("more than once")))
+	})
+
+	It("should reject LDAP classes", func() {
+		resolved := &ResolvedAuthenticationClasses{authenticationClasses: []authv1alpha1.AuthenticationClass{{
+			ObjectMeta: metav1.ObjectMeta{Name: "ldap"},
+			Spec: authv1alpha1.AuthenticationClassSpec{
+				AuthenticationProvider: &authv1alpha1.AuthenticationProvider{LDAP: &authv1alpha1.LDAPProvider{}},
+			},
+		}}}
+		Expect(resolved.Validate()).To(MatchError(ContainSubstring("LDAP")))
+	})
+})
+
+var _ = Describe("ZookeeperSecurity", func() {
+	It("should merge client CAs and enforce both mechanisms", func() {
+		zkSecurity := &ZookeeperSecurity{
+			resolvedAuthenticationClasses: &ResolvedAuthenticationClasses{authenticationClasses: []authv1alpha1.AuthenticationClass{
+				tlsAuthClass("old-pki", "old-ca"),
+				tlsAuthClass("new-pki", "new-ca"),
+				tlsAuthClass("new-pki-copy", "new-ca"),
+				staticAuthClass("digest", "digest-users"),
+			}},
+			serverSecretClass: "tls",
+			sslStorePassword:  "changeit",
+		}
+
+		Expect(zkSecurity.ClientTrustSecretClasses()).To(Equal([]string{"old-ca", "new-ca"}))
+		Expect(zkSecurity.AuthenticationMechanisms()).To(Equal([]string{AuthMechanismX509, AuthMechanismSASL}))
+
+		config := zkSecurity.ConfigSettings()
+		Expect(config).To(HaveKeyWithValue(SSLClientAuth, "want"))
+		Expect(config).To(HaveKeyWithValue(SSLTrustStoreLocation, ClientTLSDir+"/truststore.p12"))
+		Expect(config).To(HaveKeyWithValue(EnforceAuthEnabled, TrueString))
+		Expect(config).To(HaveKeyWithValue(EnforceAuthSchemes, "x509,sasl"))
+		Expect(config).To(HaveKeyWithValue(SASLAuthProvider, SASLAuthProviderClass))
+		Expect(zkSecurity.PrepareCommands()).To(HaveLen(2))
+	})
+
+	It("should keep requiring client certificates when only TLS is used", func() {
+		zkSecurity := &ZookeeperSecurity{
+			resolvedAuthenticationClasses: &ResolvedAuthenticationClasses{authenticationClasses: []authv1alpha1.AuthenticationClass{
+				tlsAuthClass("tls", "client-ca"),
+			}},
+			sslStorePassword: "changeit",
+		}
+
+		config := zkSecurity.ConfigSettings()
+		Expect(config).To(HaveKeyWithValue(SSLClientAuth, "need"))
+		Expect(config).NotTo(HaveKey(EnforceAuthEnabled))
+	})
+
+	It("should only require quorum SASL at the last stage", func() {
+		zkSecurity := &ZookeeperSecurity{
+			clusterName:                   "simple",
+			resolvedAuthenticationClasses: &ResolvedAuthenticationClasses{},
+			quorumAuthentication:          &zkv1alpha1.QuorumAuthenticationSpec{Stage: zkv1alpha1.QuorumSaslLearnerRequired},
+		}
+
+		config := zkSecurity.ConfigSettings()
+		Expect(config).To(HaveKeyWithValue(QuorumAuthEnableSasl, TrueString))
+		Expect(config).To(HaveKeyWithValue(QuorumAuthLearnerRequireSasl, TrueString))
+		Expect(config).To(HaveKeyWithValue(QuorumAuthServerRequireSasl, FalseString))
+		Expect(config).NotTo(HaveKey(QuorumAuthKerberosPrincipal))
+		Expect(config).NotTo(HaveKey(SASLAuthProvider))
+		Expect(zkSecurity.PrepareCommands()).To(ConsistOf(ContainSubstring(QuorumLearnerLoginContext)))
+		Expect(zkSecurity.PodAnnotations()).To(HaveKeyWithValue(QuorumSaslStageAnnotation, "LearnerRequired"))
+	})
+
+	It("should restore the tracing state after writing the JAAS config", func() {
+		zkSecurity := &ZookeeperSecurity{
+			resolvedAuthenticationClasses: &ResolvedAuthenticationClasses{},
+			quorumAuthentication:          &zkv1alpha1.QuorumAuthenticationSpec{Stage: zkv1alpha1.QuorumSaslRequired},
+		}
+
+		command := zkSecurity.jaasConfigCommand()
+		Expect(command).To(HavePrefix("{ JAAS_XTRACE_STATE=$(set +o | grep xtrace); set +x; } 2>/dev/null\n"))
+		Expect(command).To(HaveSuffix("\neval \"${JAAS_XTRACE_STATE}\""))
+		Expect(command).NotTo(ContainSubstring("set -x"))
+	})
+
+	It("should not configure quorum SASL when disabled", func() {
+		zkSecurity := &ZookeeperSecurity{
+			resolvedAuthenticationClasses: &ResolvedAuthenticationClasses{},
+			quorumAuthentication:          &zkv1alpha1.QuorumAuthenticationSpec{Stage: zkv1alpha1.QuorumSaslDisabled},
+		}
+
+		Expect(zkSecurity.ConfigSettings()).NotTo(HaveKey(QuorumAuthEnableSasl))
+		Expect(zkSecurity.JvmArgs()).To(BeEmpty())
+		Expect(zkSecurity.PrepareCommands()).To(BeEmpty())
+	})
+
+	It("should listen on both client ports while migrating to TLS", func() {
+		zkSecurity := &ZookeeperSecurity{
+			resolvedAuthenticationClasses: &ResolvedAuthenticationClasses{},
+			serverSecretClass:             "tls",
+			clientPortMode:                zkv1alpha1.ClientPortModeTransition,
+			sslStorePassword:              "changeit",
+		}
+
+		Expect(zkSecurity.ClientTlsPhase()).To(Equal(zkv1alpha1.ClientTlsPhaseTransition))
+		Expect(zkSecurity.ClientPort()).To(BeEquivalentTo(zkv1alpha1.ClientPort))
+		Expect(zkSecurity.SecureClientPort()).To(BeEquivalentTo(zkv1alpha1.SecureClientPort))
+		Expect(zkSecurity.ClientContainerPorts()).To(HaveLen(2))
+
+		config := zkSecurity.ConfigSettings()
+		Expect(config).To(HaveKeyWithValue(ZkClientPortConfigItem, "2181"))
+		Expect(config).To(HaveKeyWithValue(ZkSecureClientPortConfigItem, "2282"))
+		Expect(config).To(HaveKeyWithValue(ZkPortUnification, TrueString))
+
+		zkSecurity.clientPortMode = zkv1alpha1.ClientPortModeTls
+		Expect(zkSecurity.ClientTlsPhase()).To(Equal(zkv1alpha1.ClientTlsPhaseTls))
+		config = zkSecurity.ConfigSettings()
+		Expect(config).To(HaveKeyWithValue(ZkSecureClientPortConfigItem, "2282"))
+		Expect(config).NotTo(HaveKey(ZkPortUnification))
+		// the plaintext port is left to the probes of the server
+		Expect(config).To(HaveKeyWithValue(ZkClientPortConfigItem, "2181"))
+		Expect(config).To(HaveKeyWithValue(ZkClientPortAddress, "127.0.0.1"))
+		Expect(zkSecurity.ClientContainerPorts()).To(ConsistOf(HaveField("ContainerPort", int32(zkv1alpha1.SecureClientPort))))
+	})
+
+	It("should render the FIPS preset for client and quorum TLS alike", func() {
+		hostnameVerification := false
+		zkSecurity := &ZookeeperSecurity{
+			resolvedAuthenticationClasses: &ResolvedAuthenticationClasses{},
+			serverSecretClass:             "tls",
+			quorumSecretClass:             "tls",
+			tls: zkv1alpha1.ZookeeperTls{
+				Preset:               zkv1alpha1.TlsPresetFips,
+				ClientAuth:           zkv1alpha1.TlsClientAuthNone,
+				HostnameVerification: &hostnameVerification,
+			},
+		}
+		Expect(zkSecurity.validateTls()).To(Succeed())
+
+		config := zkSecurity.ConfigSettings()
+		for _, prefix := range []string{"ssl.", "ssl.quorum."} {
+			Expect(config).To(HaveKeyWithValue(prefix+"protocol", "TLSv1.3"))
+			Expect(config).To(HaveKeyWithValue(prefix+"enabledProtocols", "TLSv1.3,TLSv1.2"))
+			Expect(config).To(HaveKeyWithValue(prefix+"ciphersuites", ContainSubstring("TLS_AES_256_GCM_SHA384")))
+			Expect(config).To(HaveKeyWithValue(prefix+"hostnameVerification", "false"))
+		}
+		Expect(config).To(HaveKeyWithValue(SSLClientAuth, "none"))
+		Expect(config).To(HaveKeyWithValue(SSLQuorumClientAuth, "need"))
+		Expect(config).To(HaveKeyWithValue(FipsMode, TrueString))
+	})
+
+	It("should reject a client auth mode that contradicts the authentication classes", func() {
+		zkSecurity := &ZookeeperSecurity{
+			resolvedAuthenticationClasses: &ResolvedAuthenticationClasses{authenticationClasses: []authv1alpha1.AuthenticationClass{
+				tlsAuthClass("tls", "client-ca"),
+			}},
+			tls: zkv1alpha1.ZookeeperTls{ClientAuth: zkv1alpha1.TlsClientAuthNone},
+		}
+		Expect(zkSecurity.validateTls()).To(MatchError(ContainSubstring("conflicts")))
+	})
+
+	It("should describe the digest users and the default client certificate mode", func() {
+		zkSecurity := &ZookeeperSecurity{
+			resolvedAuthenticationClasses: &ResolvedAuthenticationClasses{authenticationClasses: []authv1alpha1.AuthenticationClass{
+				staticAuthClass("digest", "digest-users"),
+			}},
+			serverSecretClass: "tls",
+		}
+		Expect(zkSecurity.SaslMechanisms()).To(Equal([]string{SaslMechanismDigestMd5}))
+		Expect(zkSecurity.DigestAuthenticationClasses()).To(Equal([]string{"digest"}))
+		Expect(zkSecurity.ClientKerberosSecretClass()).To(BeEmpty())
+		Expect(zkSecurity.ClientCertificateMode()).To(Equal("need"))
+	})
+})
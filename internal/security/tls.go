@@ -2,7 +2,11 @@ package security
 
 import (
 	"fmt"
+	"maps"
+	"path"
+	"slices"
 	"strconv"
+	"strings"
 
 	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
 	"github.com/zncdatadev/zookeeper-operator/internal/util"
@@ -10,7 +14,10 @@ import (
 )
 
 const (
-	ZkClientPortConfigItem string = "clientPort"
+	ZkClientPortConfigItem       string = "clientPort"
+	ZkSecureClientPortConfigItem string = "secureClientPort"
+	ZkClientPortAddress          string = "clientPortAddress"
+	ZkPortUnification            string = "client.portUnification"
 
 	// volume name and mount path
 	ServerTlsVolumeName string = "server-tls"
@@ -22,6 +29,7 @@ const (
 	ServerTLSDir        string = "/kubedoop/server_tls"
 	ServerTLSMountDir   string = "/kubedoop/server_tls_mount"
 	ClientTLSDir        string = "/kubedoop/client_tls"
+	ClientTLSMountDir   string = "/kubedoop/client_tls_mount"
 	SystemTrustStoreDir string = "/etc/pki/java/cacerts"
 
 	// Quorum TLS
@@ -45,6 +53,14 @@ const (
 	SSLAuthProviderX509 string = "authProvider.x509"
 	ServerCnxnFactory   string = "serverCnxnFactory"
 
+	// auth enforcement
+	EnforceAuthEnabled string = "enforce.auth.enabled"
+	EnforceAuthSchemes string = "enforce.auth.schemes"
+
+	// authentication mechanisms, named after the ZooKeeper auth scheme they are enforced with
+	AuthMechanismX509 string = "x509"
+	AuthMechanismSASL string = "sasl"
+
 	// mis
 	StorePasswordEnv string = "STORE_PASSWORD"
 
@@ -56,17 +72,113 @@ const (
 
 // TLSEnabled checks if TLS encryption is enabled based on server SecretClass or client AuthenticationClass.
 func (z *ZookeeperSecurity) TLSEnabled() bool {
-	return z.serverSecretClass != "" || z.resolvedAuthenticationClasses.GetTLSAuthenticationClass() != nil
+	return z.serverSecretClass != "" || len(z.resolvedAuthenticationClasses.GetTLSAuthenticationClasses()) > 0
+}
+
+// ServerSecretClass returns the SecretClass that issues the server certificates clients have to trust.
+func (z *ZookeeperSecurity) ServerSecretClass() string {
+	return z.serverSecretClass
+}
+
+// QuorumTLSEnabled checks if servers talk to each other over mutual TLS.
+func (z *ZookeeperSecurity) QuorumTLSEnabled() bool {
+	return z.quorumSecretClass != ""
+}
+
+// PlaintextTransition checks if the plaintext client port keeps listening next to the TLS port,
+// see ZookeeperTls.ClientPortMode.
+func (z *ZookeeperSecurity) PlaintextTransition() bool {
+	return z.TLSEnabled() && z.clientPortMode == zkv1alpha1.ClientPortModeTransition
+}
+
+// ClientTlsPhase returns the client TLS phase of the current settings.
+func (z *ZookeeperSecurity) ClientTlsPhase() zkv1alpha1.ClientTlsPhase {
+	switch {
+	case !z.TLSEnabled():
+		return zkv1alpha1.ClientTlsPhasePlaintext
+	case z.PlaintextTransition():
+		return zkv1alpha1.ClientTlsPhaseTransition
+	default:
+		return zkv1alpha1.ClientTlsPhaseTls
+	}
 }
 
 // ClientPort returns the ZooKeeper (secure) client port depending on TLS or authentication settings.
+// While migrating to TLS, this is still the plaintext port, which accepts TLS connections as well.
 func (z *ZookeeperSecurity) ClientPort() uint16 {
-	if z.TLSEnabled() {
+	return ClientPortOf(z.ClientTlsPhase())
+}
+
+// ClientPortOf returns the client port the servers listen on in a client TLS phase, e.g. the phase recorded in status
+// that the servers were rolled out with. In the Tls phase it only accepts TLS, also for four letter word commands,
+// see common.ClientAddress.
+func ClientPortOf(phase zkv1alpha1.ClientTlsPhase) uint16 {
+	if phase == zkv1alpha1.ClientTlsPhaseTls {
 		return zkv1alpha1.SecureClientPort
 	}
 	return zkv1alpha1.ClientPort
 }
 
+// ZooServerClientPortOf returns the client port of the `server.<id>` lines of `zoo.cfg` in a client TLS phase, 0 to
+// leave it out. ZooKeeper serves the plaintext clientPort on the client port of the line, which would bind the
+// secureClientPort a second time in the Tls phase, see https://issues.apache.org/jira/browse/ZOOKEEPER-4276.
+func ZooServerClientPortOf(phase zkv1alpha1.ClientTlsPhase) int32 {
+	if phase == zkv1alpha1.ClientTlsPhaseTls {
+		return 0
+	}
+	return zkv1alpha1.ClientPort
+}
+
+// SecureClientPort returns the additional TLS only client port while migrating to TLS, 0 otherwise.
+func (z *ZookeeperSecurity) SecureClientPort() uint16 {
+	if z.PlaintextTransition() {
+		return zkv1alpha1.SecureClientPort
+	}
+	return 0
+}
+
+// ClientContainerPorts returns the client ports the servers listen on.
+func (z *ZookeeperSecurity) ClientContainerPorts() []corev1.ContainerPort {
+	ports := []corev1.ContainerPort{
+		{
+			Name:          zkv1alpha1.ClientPortName,
+			ContainerPort: int32(z.ClientPort()),
+		},
+	}
+	if securePort := z.SecureClientPort(); securePort != 0 {
+		ports = append(ports, corev1.ContainerPort{
+			Name:          zkv1alpha1.SecureClientPortName,
+			ContainerPort: int32(securePort),
+		})
+	}
+	return ports
+}
+
+// ClientTrustSecretClasses returns the distinct client CA SecretClasses of all TLS AuthenticationClasses.
+// The CAs of all of them are merged into a single truststore.
+func (z *ZookeeperSecurity) ClientTrustSecretClasses() []string {
+	secretClasses := make([]string, 0)
+	for _, tlsAuthClass := range z.resolvedAuthenticationClasses.GetTLSAuthenticationClasses() {
+		secretClass := tlsAuthClass.Spec.AuthenticationProvider.TLS.ClientCertSecretClass
+		if secretClass != "" && !slices.Contains(secretClasses, secretClass) {
+			secretClasses = append(secretClasses, secretClass)
+		}
+	}
+	return secretClasses
+}
+
+// AuthenticationMechanisms returns the client authentication mechanisms accepted by the servers.
+func (z *ZookeeperSecurity) AuthenticationMechanisms() []string {
+	mechanisms := make([]string, 0, 2)
+	if len(z.resolvedAuthenticationClasses.GetTLSAuthenticationClasses()) > 0 {
+		mechanisms = append(mechanisms, AuthMechanismX509)
+	}
+	if z.SaslEnabled() {
+		mechanisms = append(mechanisms, AuthMechanismSASL)
+	}
+	return mechanisms
+}
+
 // AddVolumeMounts adds required volumes and volume mounts to the pod and container builders depending on TLS and authentication settings.
 func (z *ZookeeperSecurity) AddVolumeMounts(podBuilder *corev1.PodTemplateSpec, zkContainer *corev1.Container) {
 	// Server Identity (KeyStore)
@@ -76,13 +188,11 @@ func (z *ZookeeperSecurity) AddVolumeMounts(podBuilder *corev1.PodTemplateSpec,
 		z.addVolume(podBuilder, tlsVolume)
 	}
 
-	// Client Trust (TrustStore) from AuthenticationClass
-	if tlsAuthClass := z.resolvedAuthenticationClasses.GetTLSAuthenticationClass(); tlsAuthClass != nil {
-		if clientCertSecretClass := tlsAuthClass.Spec.AuthenticationProvider.TLS.ClientCertSecretClass; clientCertSecretClass != "" {
-			z.addVolumeMount(zkContainer, ClientTlsVolumeName, ClientTLSDir)
-			tlsVolume := util.CreateTlsKeystoreVolume(ClientTlsVolumeName, clientCertSecretClass, z.sslStorePassword)
-			z.addVolume(podBuilder, tlsVolume)
-		}
+	// Client Trust (TrustStore) from AuthenticationClasses, merged into ClientTLSDir on startup
+	for i, clientCertSecretClass := range z.ClientTrustSecretClasses() {
+		volumeName := fmt.Sprintf("%s-%d", ClientTlsVolumeName, i)
+		z.addVolumeMount(zkContainer, volumeName, path.Join(ClientTLSMountDir, clientCertSecretClass))
+		z.addVolume(podBuilder, util.CreateTlsPemVolume(volumeName, clientCertSecretClass))
 	}
 
 	if z.quorumSecretClass != "" {
@@ -90,6 +200,34 @@ func (z *ZookeeperSecurity) AddVolumeMounts(podBuilder *corev1.PodTemplateSpec,
 		quorumTLSVolume := util.CreateTlsKeystoreVolume(QuorumTlsVolumeName, z.quorumSecretClass, z.sslStorePassword)
 		z.addVolume(podBuilder, quorumTLSVolume)
 	}
+
+	z.addSaslVolumeMounts(podBuilder, zkContainer)
+}
+
+// PrepareCommands returns the shell commands that must run in the main container before ZooKeeper starts,
+// e.g. to merge the client truststore or to render the JAAS configuration.
+func (z *ZookeeperSecurity) PrepareCommands() []string {
+	commands := make([]string, 0, 2)
+	if len(z.ClientTrustSecretClasses()) > 0 {
+		commands = append(commands, z.clientTrustStoreCommand())
+	}
+	if z.SaslEnabled() || z.QuorumSaslEnabled() {
+		commands = append(commands, z.jaasConfigCommand())
+	}
+	return commands
+}
+
+// clientTrustStoreCommand imports every CA certificate of all client CA SecretClasses into one truststore
+func (z *ZookeeperSecurity) clientTrustStoreCommand() string {
+	trustStore := path.Join(ClientTLSDir, "truststore.p12")
+	return fmt.Sprintf(`mkdir --parents %[1]s
+rm -f %[2]s
+for ca in %[3]s/*/ca.crt; do
+  csplit --quiet --elide-empty-files --prefix "/tmp/client-ca-$(basename "$(dirname "$ca")")-" "$ca" '/-----BEGIN CERTIFICATE-----/' '{*}'
+done
+for cert in /tmp/client-ca-*; do
+  keytool -importcert -noprompt -alias "$(basename "$cert")" -file "$cert" -keystore %[2]s -storetype pkcs12 -storepass %[4]s
+done`, ClientTLSDir, trustStore, ClientTLSMountDir, z.sslStorePassword)
 }
 
 // statefulset add tls volumes
@@ -110,8 +248,8 @@ func (z *ZookeeperSecurity) ConfigSettings() map[string]string {
 		authNeeded := "need"
 		// Quorum TLS
 		config[SSLQuorum] = TrueString
-		config[SSLQuorumHostNameVerification] = TrueString
 		config[SSLQuorumClientAuth] = authNeeded
+		maps.Copy(config, z.tlsProtocolSettings(sslQuorumPrefix))
 		config[ServerCnxnFactory] = "org.apache.zookeeper.server.NettyServerCnxnFactory"
 		config[SSLAuthProviderX509] = "org.apache.zookeeper.server.auth.X509AuthenticationProvider"
 		config[SSLQuorumKeyStoreLocation] = fmt.Sprintf("%s/keystore.p12", QuorumTLSDir)
@@ -124,21 +262,27 @@ func (z *ZookeeperSecurity) ConfigSettings() map[string]string {
 
 	// Server TLS
 	if z.TLSEnabled() {
-		// We set only the clientPort and portUnification here because otherwise there is a port bind exception
-		// See: https://issues.apache.org/jira/browse/ZOOKEEPER-4276
-		config[ZkClientPortConfigItem] = strconv.FormatUint(uint64(z.ClientPort()), 10)
-		config["client.portUnification"] = TrueString
-		config[SSLHostNameVerification] = TrueString
+		// Clients connect to the secureClientPort, which only accepts TLS. While migrating to TLS, the plaintext
+		// clientPort accepts TLS as well. Once only TLS is served, the clientPort listens on the loopback interface
+		// only, for the probes and the preStop hook of the server, which have no client certificate.
+		config[ZkClientPortConfigItem] = strconv.Itoa(zkv1alpha1.ClientPort)
+		config[ZkSecureClientPortConfigItem] = strconv.Itoa(zkv1alpha1.SecureClientPort)
+		if z.PlaintextTransition() {
+			config[ZkPortUnification] = TrueString
+		} else {
+			config[ZkClientPortAddress] = "127.0.0.1"
+		}
+		maps.Copy(config, z.tlsProtocolSettings(sslPrefix))
 
 		config[SSLKeyStoreLocation] = fmt.Sprintf("%s/keystore.p12", ServerTLSDir)
 
 		trustStoreDir := ServerTLSDir
 		// Auth TLS
-		if tlsAuthClass := z.resolvedAuthenticationClasses.GetTLSAuthenticationClass(); tlsAuthClass != nil {
-			config[SSLClientAuth] = "need"
-			if tlsAuthClass.Spec.AuthenticationProvider.TLS.ClientCertSecretClass != "" {
-				trustStoreDir = ClientTLSDir
-			}
+		if len(z.ClientTrustSecretClasses()) > 0 {
+			trustStoreDir = ClientTLSDir
+		}
+		if clientAuth := z.clientAuth(); clientAuth != "" {
+			config[SSLClientAuth] = clientAuth
 		}
 
 		config[SSLTrustStoreLocation] = fmt.Sprintf("%s/truststore.p12", trustStoreDir)
@@ -152,5 +296,20 @@ func (z *ZookeeperSecurity) ConfigSettings() map[string]string {
 		config[ZkClientPortConfigItem] = strconv.FormatUint(uint64(z.ClientPort()), 10)
 	}
 
+	maps.Copy(config, z.saslConfigSettings())
+
+	if z.TLSEnabled() || z.quorumSecretClass != "" {
+		if z.tls.Preset == zkv1alpha1.TlsPresetFips {
+			config[FipsMode] = TrueString
+		}
+	}
+
+	// Authentication is enforced per session unless the client certificate alone is required, see derivedClientAuth
+	mechanisms := z.AuthenticationMechanisms()
+	if len(mechanisms) > 1 || (len(mechanisms) == 1 && mechanisms[0] != AuthMechanismX509) {
+		config[EnforceAuthEnabled] = TrueString
+		config[EnforceAuthSchemes] = strings.Join(mechanisms, ",")
+	}
+
 	return config
 }
@@ -0,0 +1,132 @@
+package security
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+)
+
+const (
+	// protocol and cipher settings, rendered with the client (`ssl.`) and quorum (`ssl.quorum.`) prefix.
+	// The AdminServer reads the quorum settings.
+	sslPrefix                     string = "ssl."
+	sslQuorumPrefix               string = "ssl.quorum."
+	sslEnabledProtocolsSuffix     string = "enabledProtocols"
+	sslProtocolSuffix             string = "protocol"
+	sslCipherSuitesSuffix         string = "ciphersuites"
+	sslHostnameVerificationSuffix string = "hostnameVerification"
+
+	// FipsMode makes ZooKeeper use the FIPS compliant hostname verification of the JVM
+	FipsMode string = "fips-mode"
+
+	clientAuthNone string = "none"
+	clientAuthWant string = "want"
+	clientAuthNeed string = "need"
+)
+
+var (
+	modernProtocols = []string{"TLSv1.3"}
+	fipsProtocols   = []string{"TLSv1.3", "TLSv1.2"}
+	// FIPS 140 approved AES-GCM cipher suites
+	fipsCipherSuites = []string{
+		"TLS_AES_256_GCM_SHA384",
+		"TLS_AES_128_GCM_SHA256",
+		"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+		"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+		"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+		"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	}
+)
+
+// enabledProtocols returns the enabled TLS protocols, empty for the JVM defaults.
+func (z *ZookeeperSecurity) enabledProtocols() []string {
+	switch {
+	case len(z.tls.Protocols) > 0:
+		return z.tls.Protocols
+	case z.tls.Preset == zkv1alpha1.TlsPresetModern:
+		return modernProtocols
+	case z.tls.Preset == zkv1alpha1.TlsPresetFips:
+		return fipsProtocols
+	default:
+		return nil
+	}
+}
+
+// cipherSuites returns the enabled cipher suites, empty for the JVM defaults.
+func (z *ZookeeperSecurity) cipherSuites() []string {
+	switch {
+	case len(z.tls.CipherSuites) > 0:
+		return z.tls.CipherSuites
+	case z.tls.Preset == zkv1alpha1.TlsPresetFips:
+		return fipsCipherSuites
+	default:
+		return nil
+	}
+}
+
+// hostnameVerification checks if peer certificates must match the host name, which is the default.
+func (z *ZookeeperSecurity) hostnameVerification() bool {
+	return z.tls.HostnameVerification == nil || *z.tls.HostnameVerification
+}
+
+// tlsProtocolSettings returns the protocol, cipher and hostname verification settings for the given prefix,
+// so client, quorum and AdminServer TLS are configured alike.
+func (z *ZookeeperSecurity) tlsProtocolSettings(prefix string) map[string]string {
+	config := map[string]string{
+		prefix + sslHostnameVerificationSuffix: strconv.FormatBool(z.hostnameVerification()),
+	}
+	if protocols := z.enabledProtocols(); len(protocols) > 0 {
+		// the protocol of the SSLContext, the most recent enabled one
+		config[prefix+sslProtocolSuffix] = protocols[0]
+		config[prefix+sslEnabledProtocolsSuffix] = strings.Join(protocols, ",")
+	}
+	if cipherSuites := z.cipherSuites(); len(cipherSuites) > 0 {
+		config[prefix+sslCipherSuitesSuffix] = strings.Join(cipherSuites, ",")
+	}
+	return config
+}
+
+// derivedClientAuth returns the client auth mode the authentication classes require, empty if they require none.
+// When more than one mechanism is accepted, clients without a certificate must still be able to
+// connect over TLS, so the certificate is only requested and authentication is enforced per session instead.
+func (z *ZookeeperSecurity) derivedClientAuth() string {
+	if len(z.resolvedAuthenticationClasses.GetTLSAuthenticationClasses()) == 0 {
+		return ""
+	}
+	if len(z.AuthenticationMechanisms()) > 1 {
+		return clientAuthWant
+	}
+	return clientAuthNeed
+}
+
+// clientAuth returns the effective `ssl.clientAuth`, empty to keep the ZooKeeper default.
+func (z *ZookeeperSecurity) clientAuth() string {
+	if z.tls.ClientAuth != "" {
+		return strings.ToLower(string(z.tls.ClientAuth))
+	}
+	return z.derivedClientAuth()
+}
+
+// ClientCertificateMode returns whether the servers require (`need`), request (`want`) or ignore (`none`)
+// client certificates, the ZooKeeper default is `need`.
+func (z *ZookeeperSecurity) ClientCertificateMode() string {
+	if clientAuth := z.clientAuth(); clientAuth != "" {
+		return clientAuth
+	}
+	return clientAuthNeed
+}
+
+// validateTls checks that the TLS settings do not contradict the authentication classes.
+func (z *ZookeeperSecurity) validateTls() error {
+	if z.tls.ClientAuth == "" {
+		return nil
+	}
+	clientAuth := strings.ToLower(string(z.tls.ClientAuth))
+	derived := z.derivedClientAuth()
+	if (derived != "" && clientAuth == clientAuthNone) || (derived == clientAuthWant && clientAuth == clientAuthNeed) {
+		return fmt.Errorf("tls clientAuth %s conflicts with the authentication classes, which require %s", z.tls.ClientAuth, derived)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package util
+
+import (
+	"os"
+	"strings"
+)
+
+// serviceAccountNamespaceFile is mounted into every pod with a service account token
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// OperatorNamespace returns the namespace the operator runs in.
+// It is false if the operator does not run in a pod, e.g. during development.
+func OperatorNamespace() (string, bool) {
+	data, err := os.ReadFile(serviceAccountNamespaceFile)
+	if err != nil {
+		return "", false
+	}
+	namespace := strings.TrimSpace(string(data))
+	return namespace, namespace != ""
+}
@@ -1,7 +1,75 @@
 package util
 
-import "fmt"
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
 
-func CreateDnsAccess(podName string, namespace string) string {
-	return fmt.Sprintf("%s.%s.svc.cluster.local", podName, namespace)
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// DefaultClusterDomain is used if the cluster domain can not be detected
+	DefaultClusterDomain = "cluster.local"
+	// ClusterDomainEnv overrides the detected cluster domain of the operator
+	ClusterDomainEnv = "KUBERNETES_CLUSTER_DOMAIN"
+
+	resolvConfPath = "/etc/resolv.conf"
+)
+
+var (
+	clusterDomainOverride string
+	detectClusterDomain   = sync.OnceValue(func() string {
+		domain, err := ClusterDomainFromResolvConf(resolvConfPath)
+		if err != nil || domain == "" {
+			ctrl.Log.WithName("util").Info("Could not detect the cluster domain, using the default",
+				"resolvConf", resolvConfPath, "default", DefaultClusterDomain, "error", err)
+			return DefaultClusterDomain
+		}
+		return domain
+	})
+)
+
+// SetClusterDomain overrides the detected cluster domain, e.g. from an operator flag. An empty domain is ignored.
+func SetClusterDomain(domain string) {
+	clusterDomainOverride = strings.Trim(domain, ".")
+}
+
+// ClusterDomain returns the operator wide cluster domain: the override if set, otherwise the domain
+// detected from `/etc/resolv.conf`, falling back to `cluster.local`.
+func ClusterDomain() string {
+	if clusterDomainOverride != "" {
+		return clusterDomainOverride
+	}
+	return detectClusterDomain()
+}
+
+// ClusterDomainFromResolvConf detects the cluster domain from the search domains kubelet writes into pods,
+// e.g. `search default.svc.cluster.local svc.cluster.local cluster.local` yields `cluster.local`.
+func ClusterDomainFromResolvConf(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = file.Close() }()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "search" {
+			continue
+		}
+		for _, search := range fields[1:] {
+			if domain, ok := strings.CutPrefix(strings.TrimSuffix(search, "."), "svc."); ok && domain != "" {
+				return domain, nil
+			}
+		}
+	}
+	return "", scanner.Err()
+}
+
+func CreateDnsAccess(podName string, namespace string, clusterDomain string) string {
+	return fmt.Sprintf("%s.%s.svc.%s", podName, namespace, clusterDomain)
 }
@@ -0,0 +1,35 @@
+package util_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/zncdatadev/zookeeper-operator/internal/util"
+)
+
+var _ = Describe("ClusterDomain", func() {
+	writeResolvConf := func(content string) string {
+		path := filepath.Join(GinkgoT().TempDir(), "resolv.conf")
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+		return path
+	}
+
+	It("should detect a custom domain from the search domains", func() {
+		path := writeResolvConf("nameserver 10.96.0.10\nsearch kafka.svc.example.internal svc.example.internal example.internal\noptions ndots:5\n")
+		Expect(ClusterDomainFromResolvConf(path)).To(Equal("example.internal"))
+	})
+
+	It("should not detect a domain outside of a pod", func() {
+		path := writeResolvConf("nameserver 192.168.1.1\nsearch lan\n")
+		Expect(ClusterDomainFromResolvConf(path)).To(BeEmpty())
+	})
+
+	It("should prefer the operator override", func() {
+		SetClusterDomain("example.internal.")
+		DeferCleanup(SetClusterDomain, "")
+		Expect(ClusterDomain()).To(Equal("example.internal"))
+		Expect(CreateDnsAccess("simple", "default", ClusterDomain())).To(Equal("simple.default.svc.example.internal"))
+	})
+})
@@ -2,6 +2,7 @@ package util
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/zncdatadev/operator-go/pkg/constants"
 	corev1 "k8s.io/api/core/v1"
@@ -72,3 +73,26 @@ func CreateTlsKeystoreVolume(volumeName, secretClass, sslStorePassword string) c
 	}
 	return builder.Build()
 }
+
+// CreateTlsPemVolume creates ephemeral volumes to mount the SecretClass into the Pods as PEM files
+func CreateTlsPemVolume(volumeName, secretClass string) corev1.Volume {
+	builder := SecretVolumeBuilder{VolumeName: volumeName}
+	builder.SetAnnotations(map[string]string{
+		constants.AnnotationSecretsClass:  secretClass,
+		constants.AnnotationSecretsScope:  fmt.Sprintf("%s,%s", constants.PodScope, constants.NodeScope),
+		constants.AnnotationSecretsFormat: string(constants.TLSPEM),
+	})
+	return builder.Build()
+}
+
+// CreateKerberosVolume creates ephemeral volumes to mount the keytab and krb5.conf of the SecretClass into the Pods
+func CreateKerberosVolume(volumeName, secretClass string, serviceNames ...string) corev1.Volume {
+	builder := SecretVolumeBuilder{VolumeName: volumeName}
+	builder.SetAnnotations(map[string]string{
+		constants.AnnotationSecretsClass:                secretClass,
+		constants.AnnotationSecretsScope:                string(constants.PodScope),
+		constants.AnnotationSecretsFormat:               string(constants.Kerberos),
+		constants.AnnotationSecretsKerberosServiceNames: strings.Join(serviceNames, constants.KerberosServiceNamesDelimiter),
+	})
+	return builder.Build()
+}
@@ -2,7 +2,9 @@ package znodecontroller
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"strings"
 	"time"
 
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -10,11 +12,13 @@ import (
 
 	"github.com/zncdatadev/operator-go/pkg/builder"
 	"github.com/zncdatadev/operator-go/pkg/client"
+	"github.com/zncdatadev/operator-go/pkg/constants"
 	"github.com/zncdatadev/operator-go/pkg/reconciler"
 	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
 	"github.com/zncdatadev/zookeeper-operator/internal/common"
 	"github.com/zncdatadev/zookeeper-operator/internal/security"
 	"github.com/zncdatadev/zookeeper-operator/internal/util"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
@@ -27,6 +31,7 @@ type ZNodeReconciler struct {
 	instance   *zkv1alpha1.ZookeeperZnode
 	client     ctrlclient.Client
 	zkSecurity *security.ZookeeperSecurity
+	connection *clusterConnection
 }
 
 // NewZNodeReconciler new a ZNodeReconciler
@@ -35,17 +40,22 @@ func NewZNodeReconciler(
 	instance *zkv1alpha1.ZookeeperZnode,
 	client ctrlclient.Client,
 	zkSecurity *security.ZookeeperSecurity,
+	connection *clusterConnection,
 ) *ZNodeReconciler {
 	return &ZNodeReconciler{
 		scheme:     scheme,
 		instance:   instance,
 		client:     client,
 		zkSecurity: zkSecurity,
+		connection: connection,
 	}
 }
 
 // reconcile
 func (z *ZNodeReconciler) reconcile(ctx context.Context, cluster *zkv1alpha1.ZookeeperCluster) (ctrl.Result, string, error) {
+	if err := common.ValidateDiscoveryProfiles(z.instance.Spec.DiscoveryProfiles, z.zkSecurity); err != nil {
+		return ctrl.Result{}, "", err
+	}
 	// 1. create znode in zookeeper
 	znodePath := z.createZnodePath()
 	znodeLogger.Info("create znode in zookeeper", "znode path", znodePath)
@@ -59,7 +69,7 @@ func (z *ZNodeReconciler) reconcile(ctx context.Context, cluster *zkv1alpha1.Zoo
 	client := client.NewClient(z.client, z.instance)
 	gvk := z.instance.GetObjectKind().GroupVersionKind()
 	clusterInfo := reconciler.ClusterInfo{GVK: &metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind}, ClusterName: z.instance.Name}
-	discoveryReconcilers := common.NewDiscoveryReconcilers(
+	discoveryReconcilers, err := common.NewDiscoveryReconcilers(
 		ctx,
 		client,
 		cluster,
@@ -68,12 +78,17 @@ func (z *ZNodeReconciler) reconcile(ctx context.Context, cluster *zkv1alpha1.Zoo
 			Name:      z.instance.Name,
 			Namespace: z.instance.Namespace,
 			ZNodePath: znodePath,
+
+			DiscoveryProfiles: z.instance.Spec.DiscoveryProfiles,
 		},
 		func(o *builder.Options) {
 			o.Labels = clusterInfo.GetLabels()
 			o.Annotations = clusterInfo.GetAnnotations()
 		},
 	)
+	if err != nil {
+		return ctrl.Result{}, "", err
+	}
 	res, err := z.reconcileDiscovery(ctx, discoveryReconcilers)
 	if err != nil {
 		znodeLogger.Error(err, "create configmap for zookeeper discovery error",
@@ -96,6 +111,16 @@ func (z *ZNodeReconciler) reconcile(ctx context.Context, cluster *zkv1alpha1.Zoo
 		return res, znodePath, nil
 	}
 
+	// servers that recently became ready are added to discovery once they are debounced
+	settleAfter, err := common.DiscoverySettleAfter(ctx, client, cluster)
+	if err != nil {
+		return ctrl.Result{}, "", err
+	}
+	if settleAfter > 0 {
+		znodeLogger.V(1).Info("waiting for servers to settle in discovery", "requeueAfter", settleAfter)
+		return ctrl.Result{RequeueAfter: settleAfter}, znodePath, nil
+	}
+
 	znodeLogger.V(1).Info("znode reconciled successfully", "namespace", z.instance.Namespace, "name", z.instance.Name, "znode path", znodePath)
 	return ctrl.Result{}, znodePath, nil
 }
@@ -122,12 +147,12 @@ func (z *ZNodeReconciler) createZnodePath() string {
 
 // create zookeeper znode
 func (z *ZNodeReconciler) createZookeeperZnode(path string, cluster *zkv1alpha1.ZookeeperCluster) error {
-	svcDns := getClusterSvcUrl(cluster, int32(z.zkSecurity.ClientPort()))
+	svcDns := strings.Join(z.connection.servers, ",")
 	znodeLogger.V(1).Info("zookeeper cluster service client dns url", "dns", svcDns)
 	// for local testing, you must add the zk service to your hosts, and then create port forwarding.
 	// example:
 	//    127.0.0.1       zookeepercluster-sample-cluster.default.svc.cluster.local
-	zkCli, err := NewZkClient(svcDns)
+	zkCli, err := z.connection.connect()
 	if err != nil {
 		return err
 	}
@@ -185,25 +210,68 @@ func (z *ZNodeReconciler) updateZnodeStatus(ctx context.Context, znodePath strin
 	return ctrl.Result{RequeueAfter: time.Second}, nil
 }
 
-// get custer service url
-func getClusterSvcUrl(cluster *zkv1alpha1.ZookeeperCluster, clientProt int32) string {
-	svcHost := common.ClusterServiceName(cluster.Name)
-	dns := util.CreateDnsAccess(svcHost, cluster.Namespace)
-	return fmt.Sprintf("%s:%d", dns, clientProt)
+// clusterConnection is how the operator connects to the servers of a cluster.
+type clusterConnection struct {
+	servers []string
+	// tlsConfig is set once the client port only accepts TLS
+	tlsConfig *tls.Config
+}
+
+// newClusterConnection connects to the client port of the client TLS phase the servers were rolled out with, through
+// the cluster service. Once the port only accepts TLS, the servers are connected to by their pod addresses, which their
+// certificates are issued for, with the client TLS settings of the operator.
+func newClusterConnection(
+	ctx context.Context,
+	k8sClient ctrlclient.Client,
+	cluster *zkv1alpha1.ZookeeperCluster,
+	clientTLS *tls.Config,
+) (*clusterConnection, error) {
+	clusterDomain := common.ClusterDomain(cluster.Spec.ClusterConfig)
+	phase := cluster.Status.ClientTlsPhase
+	if phase != zkv1alpha1.ClientTlsPhaseTls {
+		svcHost := util.CreateDnsAccess(common.ClusterServiceName(cluster.Name), cluster.Namespace, clusterDomain)
+		return &clusterConnection{servers: []string{fmt.Sprintf("%s:%d", svcHost, security.ClientPortOf(phase))}}, nil
+	}
+	if clientTLS == nil {
+		return nil, fmt.Errorf("the servers of cluster %s only accept TLS, the operator has no client TLS configured", cluster.Name)
+	}
+	pods := &corev1.PodList{}
+	if err := k8sClient.List(ctx, pods,
+		ctrlclient.InNamespace(cluster.Namespace),
+		ctrlclient.MatchingLabels{
+			constants.LabelKubernetesInstance:  cluster.Name,
+			constants.LabelKubernetesComponent: string(common.Server),
+		},
+	); err != nil {
+		return nil, err
+	}
+	connection := &clusterConnection{tlsConfig: clientTLS}
+	for _, pod := range pods.Items {
+		host := common.PodFQDN(pod.Name, pod.Spec.Subdomain, pod.Namespace, clusterDomain)
+		connection.servers = append(connection.servers, fmt.Sprintf("%s:%d", host, zkv1alpha1.SecureClientPort))
+	}
+	if len(connection.servers) == 0 {
+		return nil, fmt.Errorf("cluster %s has no servers", cluster.Name)
+	}
+	return connection, nil
+}
+
+func (c *clusterConnection) connect() (*ZkClient, error) {
+	return NewZkClient(c.servers, c.tlsConfig)
 }
 
 const ZNodeDeleteFinalizer = "znode.kubedoop.dev/delete-znode"
 
 type ZnodeDeleteFinalizer struct {
-	clientPort int32
+	connection *clusterConnection
 	Chroot     string
 	ZkCluster  *zkv1alpha1.ZookeeperCluster
 }
 
 func (z ZnodeDeleteFinalizer) Finalize(context.Context, ctrlclient.Object) (finalizer.Result, error) {
-	zkAddress := getClusterSvcUrl(z.ZkCluster, z.clientPort)
+	zkAddress := strings.Join(z.connection.servers, ",")
 	// remove znode from zookeeper cluster
-	zkCli, err := NewZkClient(zkAddress)
+	zkCli, err := z.connection.connect()
 	if err != nil {
 		return finalizer.Result{}, err
 	}
@@ -1,12 +1,19 @@
 package znodecontroller
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/samuel/go-zookeeper/zk"
 	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
 )
 
 var logger = ctrl.Log.WithName("zk-client")
@@ -33,20 +40,32 @@ type ZkClient struct {
 	Client *zk.Conn
 }
 
-// NewZkClient new zk client
-func NewZkClient(address string) (*ZkClient, error) {
-	conn, err := GetConnect([]string{address})
+// NewZkClient new zk client, connecting over TLS with tlsConfig unless it is nil
+func NewZkClient(addresses []string, tlsConfig *tls.Config) (*ZkClient, error) {
+	conn, err := GetConnect(addresses, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
 	return &ZkClient{
-		Address: address,
+		Address: strings.Join(addresses, ","),
 		Client:  conn,
 	}, nil
 }
 
-func GetConnect(zkList []string) (conn *zk.Conn, err error) {
-	conn, _, err = zk.Connect(zkList, 10*time.Second)
+func GetConnect(zkList []string, tlsConfig *tls.Config) (conn *zk.Conn, err error) {
+	if tlsConfig == nil {
+		conn, _, err = zk.Connect(zkList, 10*time.Second)
+	} else {
+		// the certificates are issued for the host names, which the default host provider resolves to addresses
+		conn, _, err = zk.Connect(zkList, 10*time.Second,
+			zk.WithHostProvider(&staticHostProvider{}),
+			zk.WithDialer(func(_, address string, timeout time.Duration) (net.Conn, error) {
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				defer cancel()
+				return common.DialClientPort(ctx, address, tlsConfig)
+			}),
+		)
+	}
 	if err != nil {
 		logger.Error(err, "failed to connect to zookeeper")
 		return nil, err
@@ -115,3 +134,47 @@ func (z ZkClient) Exists(path string) (bool, error) {
 	}
 	return exists, nil
 }
+
+// staticHostProvider connects to the servers by the addresses it is given, one after the other.
+type staticHostProvider struct {
+	mu      sync.Mutex
+	servers []string
+	curr    int
+	// last is the server of the last connection, -1 before the first one
+	last int
+}
+
+var _ zk.HostProvider = &staticHostProvider{}
+
+func (p *staticHostProvider) Init(servers []string) error {
+	if len(servers) == 0 {
+		return errors.New("no zookeeper servers")
+	}
+	p.servers = servers
+	p.curr = -1
+	p.last = -1
+	return nil
+}
+
+func (p *staticHostProvider) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.servers)
+}
+
+func (p *staticHostProvider) Next() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.curr = (p.curr + 1) % len(p.servers)
+	retryStart := p.curr == p.last
+	if p.last == -1 {
+		p.last = 0
+	}
+	return p.servers[p.curr], retryStart
+}
+
+func (p *staticHostProvider) Connected() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.last = p.curr
+}
@@ -18,19 +18,26 @@ package znodecontroller
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/zncdatadev/operator-go/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/finalizer"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	zkv1alpha1 "github.com/zncdatadev/zookeeper-operator/api/v1alpha1"
+	"github.com/zncdatadev/zookeeper-operator/internal/common"
 	"github.com/zncdatadev/zookeeper-operator/internal/security"
 )
 
@@ -41,6 +48,8 @@ type ZookeeperZnodeReconciler struct {
 	ctrlclient.Client
 	Scheme *runtime.Scheme
 	Log    logr.Logger
+	// ClientTLS connects to the servers of clusters whose client port only accepts TLS, see common.LoadClientTLSConfig
+	ClientTLS *tls.Config
 }
 
 // +kubebuilder:rbac:groups=zookeeper.kubedoop.dev,resources=zookeeperznodes,verbs=get;list;watch;create;update;patch;delete
@@ -49,6 +58,7 @@ type ZookeeperZnodeReconciler struct {
 // +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
 // +kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
 // +kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings,verbs=get;list;watch
@@ -76,15 +86,19 @@ func (r *ZookeeperZnodeReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		}
 		return ctrl.Result{}, err
 	}
-	zkSecurity, err := security.NewZookeeperSecurity(ctx, r.Client, zkCluster.Spec.ClusterConfig)
+	zkSecurity, err := security.NewZookeeperSecurity(ctx, r.Client, zkCluster.Name, zkCluster.Spec.ClusterConfig)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	connection, err := newClusterConnection(ctx, r.Client, zkCluster, r.ClientTLS)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 	// reconcile order by "cluster -> role -> role-group -> resource"
-	result, chroot, err := NewZNodeReconciler(r.Scheme, znode, r.Client, zkSecurity).reconcile(ctx, zkCluster)
+	result, chroot, err := NewZNodeReconciler(r.Scheme, znode, r.Client, zkSecurity, connection).reconcile(ctx, zkCluster)
 
 	// setup finalizer
-	if err := r.setupFinalizer(znode, zkCluster, ctx, chroot, int32(zkSecurity.ClientPort())); err != nil {
+	if err := r.setupFinalizer(znode, zkCluster, ctx, chroot, connection); err != nil {
 		return ctrl.Result{}, err
 	}
 
@@ -123,9 +137,9 @@ func (r *ZookeeperZnodeReconciler) getClusterInstance(znode *zkv1alpha1.Zookeepe
 }
 
 func (r *ZookeeperZnodeReconciler) setupFinalizer(cr *zkv1alpha1.ZookeeperZnode, zkCluster *zkv1alpha1.ZookeeperCluster,
-	ctx context.Context, chroot string, clientPort int32) error {
+	ctx context.Context, chroot string, connection *clusterConnection) error {
 	finalizers := finalizer.NewFinalizers()
-	err := finalizers.Register(ZNodeDeleteFinalizer, ZnodeDeleteFinalizer{Chroot: chroot, ZkCluster: zkCluster, clientPort: clientPort})
+	err := finalizers.Register(ZNodeDeleteFinalizer, ZnodeDeleteFinalizer{Chroot: chroot, ZkCluster: zkCluster, connection: connection})
 	if err != nil {
 		return err
 	}
@@ -144,5 +158,79 @@ func (r *ZookeeperZnodeReconciler) setupFinalizer(cr *zkv1alpha1.ZookeeperZnode,
 func (r *ZookeeperZnodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&zkv1alpha1.ZookeeperZnode{}).
+		// discovery of external listeners follows the servers and their services
+		Watches(
+			&corev1.Pod{},
+			handler.EnqueueRequestsFromMapFunc(r.clusterResourceToZnodes),
+			builder.WithPredicates(common.PodPlacementChanged),
+		).
+		Watches(
+			&corev1.Service{},
+			handler.EnqueueRequestsFromMapFunc(r.clusterResourceToZnodes),
+		).
+		Watches(
+			&discoveryv1.EndpointSlice{},
+			handler.EnqueueRequestsFromMapFunc(r.clusterResourceToZnodes),
+			builder.WithPredicates(common.EndpointReadinessChanged),
+		).
+		Watches(
+			&corev1.Node{},
+			handler.EnqueueRequestsFromMapFunc(r.nodeToZnodes),
+			builder.WithPredicates(common.NodeAddressChanged),
+		).
 		Complete(r)
 }
+
+// clusterResourceToZnodes maps a resource of a ZookeeperCluster to the znodes of that cluster.
+func (r *ZookeeperZnodeReconciler) clusterResourceToZnodes(ctx context.Context, obj ctrlclient.Object) []reconcile.Request {
+	instance, ok := common.ClusterInstanceOf(obj)
+	if !ok {
+		return nil
+	}
+	return r.znodesOf(ctx, func(cluster ctrlclient.ObjectKey) bool {
+		return cluster == ctrlclient.ObjectKey{Namespace: obj.GetNamespace(), Name: instance}
+	})
+}
+
+// nodeToZnodes maps a node to the znodes of the ZookeeperClusters that publish node addresses in discovery.
+func (r *ZookeeperZnodeReconciler) nodeToZnodes(ctx context.Context, _ ctrlclient.Object) []reconcile.Request {
+	clusters := &zkv1alpha1.ZookeeperClusterList{}
+	if err := r.List(ctx, clusters); err != nil {
+		r.Log.Error(err, "unable to list zookeeper clusters")
+		return nil
+	}
+	nodePortClusters := make(map[ctrlclient.ObjectKey]bool)
+	for _, cluster := range clusters.Items {
+		if common.NodePortsEnabled(cluster.Spec.ClusterConfig) {
+			nodePortClusters[ctrlclient.ObjectKeyFromObject(&cluster)] = true
+		}
+	}
+	if len(nodePortClusters) == 0 {
+		return nil
+	}
+	return r.znodesOf(ctx, func(cluster ctrlclient.ObjectKey) bool { return nodePortClusters[cluster] })
+}
+
+// znodesOf returns the requests of the znodes whose cluster matches.
+func (r *ZookeeperZnodeReconciler) znodesOf(ctx context.Context, match func(cluster ctrlclient.ObjectKey) bool) []reconcile.Request {
+	znodes := &zkv1alpha1.ZookeeperZnodeList{}
+	if err := r.List(ctx, znodes); err != nil {
+		r.Log.Error(err, "unable to list znodes")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0)
+	for _, znode := range znodes.Items {
+		clusterRef := znode.Spec.ClusterRef
+		if clusterRef == nil {
+			continue
+		}
+		namespace := clusterRef.Namespace
+		if namespace == "" {
+			namespace = znode.Namespace
+		}
+		if match(ctrlclient.ObjectKey{Namespace: namespace, Name: clusterRef.Name}) {
+			requests = append(requests, reconcile.Request{NamespacedName: ctrlclient.ObjectKeyFromObject(&znode)})
+		}
+	}
+	return requests
+}